@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -34,20 +35,27 @@ func performDeviceClaim(serverURL, apiKeyPath, pubKey string) (string, error) {
 	}
 
 	var startClaimResp struct {
-		Token    string `json:"token"`
-		ClaimURL string `json:"claim_url"`
+		Token     string `json:"token"`
+		UserCode  string `json:"user_code"`
+		ClaimURL  string `json:"claim_url"`
+		ExpiresIn int    `json:"expires_in"`
 	}
 	if err := json.Unmarshal(resp.Body(), &startClaimResp); err != nil {
 		return "", fmt.Errorf("invalid response: %v", err)
 	}
 
 	fmt.Printf("\nAction Required:\n\n👉  Visit this URL to approve this device:\n    %s\n\n", startClaimResp.ClaimURL)
+	fmt.Printf("    and enter the code:  %s\n\n", startClaimResp.UserCode)
 	fmt.Print("Waiting for approval...")
 
-	// 2. Poll for Status
+	// 2. Poll for Status until the claim expires on the server
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
-	timeout := time.After(5 * time.Minute)
+	wait := time.Duration(startClaimResp.ExpiresIn) * time.Second
+	if wait <= 0 {
+		wait = 5 * time.Minute
+	}
+	timeout := time.After(wait)
 
 	for {
 		select {
@@ -74,6 +82,12 @@ func performDeviceClaim(serverURL, apiKeyPath, pubKey string) (string, error) {
 				return statusResp.APIKey, nil
 			} else if statusResp.Status == "rejected" {
 				return "", fmt.Errorf("device claim rejected by user")
+			} else if statusResp.Status == "completed" {
+				return "", fmt.Errorf("device claim already used, start a new claim")
+			} else if statusResp.Status == "failed" {
+				return "", fmt.Errorf("device claim failed: this key is already registered to an agent")
+			} else if statusResp.Status == "expired" {
+				return "", fmt.Errorf("device claim expired before it was approved")
 			}
 
 			fmt.Print(".")
@@ -81,8 +95,52 @@ func performDeviceClaim(serverURL, apiKeyPath, pubKey string) (string, error) {
 	}
 }
 
+// performEnrollment registers the agent with a pre-authorized enrollment key,
+// for machines where nobody can approve a claim in the browser
+func performEnrollment(serverURL, enrollKey, pubKey string) (string, error) {
+	client := resty.New()
+	client.SetBaseURL(serverURL)
+	client.SetTimeout(10 * time.Second)
+
+	hostname := getAgentHostname()
+	fmt.Printf("Enrolling device '%s' with %s\n", hostname, serverURL)
+
+	resp, err := client.R().
+		SetBody(map[string]string{
+			"enroll_key": enrollKey,
+			"public_key": pubKey,
+			"hostname":   hostname,
+		}).
+		Post("/api/v1/enroll")
+	if err != nil {
+		return "", fmt.Errorf("failed to enroll: %v", err)
+	}
+
+	var enrollResp struct {
+		APIKey string `json:"api_key"`
+		IP     string `json:"ip"`
+		Error  string `json:"error"`
+	}
+	json.Unmarshal(resp.Body(), &enrollResp)
+
+	if resp.IsError() {
+		if enrollResp.Error != "" {
+			return "", fmt.Errorf("server error: %s", enrollResp.Error)
+		}
+		return "", fmt.Errorf("server error: %s", resp.Status())
+	}
+	if enrollResp.APIKey == "" {
+		return "", fmt.Errorf("server did not return an API key")
+	}
+
+	fmt.Printf("✅ Enrolled with overlay IP %s\n", enrollResp.IP)
+	return enrollResp.APIKey, nil
+}
+
 func getAgentHostname() string {
-	// Basic implementation, real one would use os.Hostname()
-	// but main package already likely has this or similar imports
-	return "agent-device" // Placeholder, caller should pass real hostname
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "agent-device"
+	}
+	return hostname
 }
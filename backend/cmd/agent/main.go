@@ -16,6 +16,8 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -26,40 +28,123 @@ import (
 	"golang.zx2c4.com/wireguard/tun/netstack"
 )
 
+const defaultServerURL = "http://127.0.0.1:3000"
+
+// version is reported to the server, which posture profiles can require a
+// minimum of. Set at build time with -ldflags "-X main.version=1.2.3".
+var version = "0.0.0-dev"
+
 func main() {
-	apiKey := flag.String("key", "", "API Key for authentication")
-	serverURL := flag.String("server", "http://127.0.0.1:3000", "Control Server URL")
-	tunnelMode := flag.String("tunnel", "", "Tunnel mode: 'ws' for WebSocket (firewall bypass)")
-	tunnelURL := flag.String("tunnel-url", "", "WebSocket tunnel URL (default: derives from server URL but uses port 443)")
-	insecureFlag := flag.Bool("insecure", false, "Skip TLS verification (dev only)")
+	stateDir := flag.String("state-dir", defaultStateDir(), "Directory holding the agent's keys and settings (env ZTA_STATE_DIR)")
+	apiKey := flag.String("key", "", "API Key for authentication (env ZTA_API_KEY)")
+	enrollKey := flag.String("enroll-key", "", "Enrollment key for unattended registration (env ZTA_ENROLL_KEY)")
+	serverURL := flag.String("server", defaultServerURL, "Control Server URL (env ZTA_SERVER_URL)")
+	tunnelMode := flag.String("tunnel", "", "Tunnel mode: 'ws' for WebSocket (firewall bypass) (env ZTA_TUNNEL)")
+	tunnelURL := flag.String("tunnel-url", "", "WebSocket tunnel URL (default: derives from server URL but uses port 443) (env ZTA_TUNNEL_URL)")
+	insecureFlag := flag.Bool("insecure", false, "Skip TLS verification (dev only) (env ZTA_INSECURE)")
+	advertiseRoutes := flag.String("advertise-routes", "", "Comma-separated local subnets to route for the overlay once an admin approves them, e.g. 192.168.1.0/24 (env ZTA_ADVERTISE_ROUTES)")
+	advertiseExit := flag.Bool("advertise-exit-node", false, "Offer to forward other agents' internet traffic once an admin approves it (env ZTA_ADVERTISE_EXIT_NODE)")
+	useExit := flag.Bool("use-exit-node", false, "Send internet-bound traffic through the overlay's exit node (env ZTA_USE_EXIT_NODE)")
+	socksAddr := flag.String("socks5", "", "Serve a SOCKS5 proxy into the overlay on this local address, e.g. 127.0.0.1:1080; \"off\" disables it (env ZTA_SOCKS5)")
 	flag.Parse()
 
 	interfaceName := "wg0"
 	fmt.Printf("Starting Zero ZTA Agent on interface %s...\n", interfaceName)
 
-	// Generate Ephemeral Private Key (once per session, or rotate on reconnect? let's keep it for now)
-	privKey, pubKey := generateKeyPair()
+	// Saved settings apply unless overridden by a flag or the environment
+	state, err := loadState(*stateDir)
+	if err != nil {
+		log.Fatalf("Failed to load agent state: %v", err)
+	}
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	savedServerURL := state.ServerURL
+	override(&state.ServerURL, *serverURL, setFlags["server"], "ZTA_SERVER_URL")
+	override(&state.TunnelMode, *tunnelMode, setFlags["tunnel"], "ZTA_TUNNEL")
+	override(&state.TunnelURL, *tunnelURL, setFlags["tunnel-url"], "ZTA_TUNNEL_URL")
+	if err := overrideBool(&state.Insecure, *insecureFlag, setFlags["insecure"], "ZTA_INSECURE"); err != nil {
+		log.Fatalf("Invalid ZTA_INSECURE: %v", err)
+	}
+	if err := overrideBool(&state.AdvertiseExitNode, *advertiseExit, setFlags["advertise-exit-node"], "ZTA_ADVERTISE_EXIT_NODE"); err != nil {
+		log.Fatalf("Invalid ZTA_ADVERTISE_EXIT_NODE: %v", err)
+	}
+	if err := overrideBool(&state.UseExitNode, *useExit, setFlags["use-exit-node"], "ZTA_USE_EXIT_NODE"); err != nil {
+		log.Fatalf("Invalid ZTA_USE_EXIT_NODE: %v", err)
+	}
+	override(&state.SOCKS5Addr, *socksAddr, setFlags["socks5"], "ZTA_SOCKS5")
+	if state.SOCKS5Addr == "off" {
+		state.SOCKS5Addr = ""
+	}
+
+	// Routes are only sent once configured here, so agents that never
+	// advertise keep the routes set in the dashboard. An empty list
+	// withdraws them.
+	if setFlags["advertise-routes"] {
+		state.AdvertiseRoutes = advertiseRoutes
+	} else if v, ok := os.LookupEnv("ZTA_ADVERTISE_ROUTES"); ok {
+		state.AdvertiseRoutes = &v
+	}
+	routes, err := parseRouteList(state.AdvertiseRoutes)
+	if err != nil {
+		log.Fatalf("Invalid advertised routes: %v", err)
+	}
+	offer := connectOffer{
+		Routes:            routes,
+		AdvertiseExitNode: state.AdvertiseExitNode,
+		UseExitNode:       state.UseExitNode,
+	}
+	if state.ServerURL == "" {
+		state.ServerURL = defaultServerURL
+	}
+
+	// A key issued by one server is useless against another
+	if savedServerURL != "" && savedServerURL != state.ServerURL && state.APIKey != "" {
+		log.Printf("Server changed from %s to %s, discarding saved API key", savedServerURL, state.ServerURL)
+		state.APIKey = ""
+	}
+	override(&state.APIKey, *apiKey, setFlags["key"], "ZTA_API_KEY")
+
+	privKey, pubKey, err := loadOrCreatePrivateKey(*stateDir)
+	if err != nil {
+		log.Fatalf("Failed to load WireGuard key: %v", err)
+	}
 	log.Printf("Agent Public Key: %s", pubKey)
 
-	// Check if API Key is provided, if not, start interactive claiming flow
-	if *apiKey == "" {
+	// Enrollment keys are only needed once and are never saved
+	var enrollment string
+	override(&enrollment, *enrollKey, setFlags["enroll-key"], "ZTA_ENROLL_KEY")
+
+	// Check if API Key is known, if not, enroll or start interactive claiming flow
+	if state.APIKey == "" && enrollment != "" {
+		key, err := performEnrollment(state.ServerURL, enrollment, pubKey)
+		if err != nil {
+			log.Fatalf("Enrollment failed: %v", err)
+		}
+		state.APIKey = key
+	} else if state.APIKey == "" {
 		fmt.Println("No API Key provided. Starting Device Claiming Workflow...")
-		key, err := performDeviceClaim(*serverURL, "", pubKey)
+		key, err := performDeviceClaim(state.ServerURL, "", pubKey)
 		if err != nil {
 			log.Fatalf("Device claiming failed: %v", err)
 		}
-		*apiKey = key
+		state.APIKey = key
 		fmt.Println("Got API Key! Connecting...")
 	}
 
+	if err := state.save(*stateDir); err != nil {
+		log.Fatalf("Failed to save agent state: %v", err)
+	}
+	log.Printf("Agent state saved in %s", *stateDir)
+
 	// Wait for interrupt signal to cleanup
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	// Main Agent Loop
 	for {
-		log.Printf("Connecting to %s...", *serverURL)
-		err := runAgent(*serverURL, *tunnelURL, *apiKey, privKey, pubKey, interfaceName, *tunnelMode, *insecureFlag, c)
+		log.Printf("Connecting to %s...", state.ServerURL)
+		err := runAgent(state.ServerURL, state.TunnelURL, state.APIKey, privKey, pubKey, interfaceName, state.TunnelMode, state.Insecure, offer, state.SOCKS5Addr, c)
 		if err != nil {
 			log.Printf("Agent disconnected or failed: %v", err)
 		}
@@ -75,9 +160,9 @@ func main() {
 	}
 }
 
-func runAgent(serverURL, tunnelURL, apiKey, privKey, pubKey, interfaceName, tunnelMode string, insecure bool, sigChan chan os.Signal) error {
+func runAgent(serverURL, tunnelURL, apiKey, privKey, pubKey, interfaceName, tunnelMode string, insecure bool, offer connectOffer, socksAddr string, sigChan chan os.Signal) error {
 	// Connect to control server to get VPN config
-	vpnConfig, err := connectToServer(serverURL, apiKey, pubKey)
+	vpnConfig, err := connectToServer(serverURL, apiKey, pubKey, offer)
 	if err != nil {
 		return fmt.Errorf("connect failed: %v", err)
 	}
@@ -91,43 +176,72 @@ func runAgent(serverURL, tunnelURL, apiKey, privKey, pubKey, interfaceName, tunn
 	}
 	tunAddr := prefix.Addr()
 
+	// Names resolve through the hub's overlay DNS; servers that do not run
+	// one leave the agent with a public resolver
+	dnsServers := []netip.Addr{netip.MustParseAddr("8.8.8.8")}
+	if len(vpnConfig.DNS) > 0 {
+		dnsServers = dnsServers[:0]
+		for _, s := range vpnConfig.DNS {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return fmt.Errorf("invalid DNS server %q: %v", s, err)
+			}
+			dnsServers = append(dnsServers, addr)
+		}
+	}
+
 	// Create userspace TUN
 	tun, tnet, err := netstack.CreateNetTUN(
 		[]netip.Addr{tunAddr},
-		[]netip.Addr{netip.MustParseAddr("8.8.8.8")},
+		dnsServers,
 		device.DefaultMTU,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create TUN: %v", err)
 	}
 
+	overlay, err := netip.ParsePrefix(vpnConfig.AllowedIPs)
+	if err != nil {
+		tun.Close()
+		return fmt.Errorf("failed to parse overlay network %s: %v", vpnConfig.AllowedIPs, err)
+	}
+
+	// Traffic for the subnets this agent was approved to route, and for the
+	// internet if it is the exit node, goes to the host's network instead of
+	// the agent's own netstack
+	router, err := NewSubnetRouter(tun, device.DefaultMTU, overlay)
+	if err != nil {
+		tun.Close()
+		return fmt.Errorf("failed to create subnet router: %v", err)
+	}
+	router.SetRoutes(vpnConfig.ApprovedRoutes)
+	router.SetExitNode(vpnConfig.ExitNode)
+
 	// Logging
 	logger := device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", interfaceName))
 
-	// Start Internal Service
-	// We need a done channel to stop this goroutine if runAgent returns
-	// But netstack ListenTCP listeners are closed when tnet is gone?
-	// Actually tnet isn't closed explicitly, but the device close might help.
-	go func() {
-		tcpAddr, _ := net.ResolveTCPAddr("tcp", ":80")
-		listener, err := tnet.ListenTCP(tcpAddr)
+	// Status page on port 80, used by the dashboard's ping unless a
+	// registered service takes the port over
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"message": "Hello from Agent", "ip": "%s", "time": "%s"}`, vpnConfig.AssignedIP, time.Now().Format(time.RFC3339))
+	})
+	services := NewServiceProxy(tnet, mux)
+	defer services.Close()
+
+	if socksAddr != "" {
+		listener, err := net.Listen("tcp", socksAddr)
 		if err != nil {
-			log.Printf("Failed to listen on internal port 80: %v", err)
-			return
+			return fmt.Errorf("failed to start SOCKS5 proxy: %v", err)
 		}
 		defer listener.Close()
-
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			fmt.Fprintf(w, `{"message": "Hello from Agent", "ip": "%s", "time": "%s"}`, vpnConfig.AssignedIP, time.Now().Format(time.RFC3339))
-		})
-
-		http.Serve(listener, mux)
-	}()
+		go serveSOCKS5(listener, tnet, vpnConfig.DNSSearchDomains)
+		log.Printf("SOCKS5 proxy into the overlay listening on %s", listener.Addr())
+	}
 
 	// WireGuard Device
-	dev := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	dev := device.NewDevice(router, conn.NewDefaultBind(), logger)
 
 	// Determine WireGuard endpoint
 	wgEndpoint := vpnConfig.Endpoint
@@ -198,6 +312,12 @@ persistent_keepalive_interval=25
 		dev.Close()
 		return fmt.Errorf("failed to configure device: %v", err)
 	}
+	hubRoutes := vpnConfig.Routes
+	if err := setHubRoutes(dev, vpnConfig, hubRoutes); err != nil {
+		dev.Close()
+		return fmt.Errorf("failed to configure routes: %v", err)
+	}
+	logHubRoutes(hubRoutes, offer.UseExitNode)
 
 	if err := dev.Up(); err != nil {
 		dev.Close()
@@ -205,6 +325,16 @@ persistent_keepalive_interval=25
 	}
 
 	log.Printf("VPN Tunnel Established. IP: %s", vpnConfig.AssignedIP)
+	if vpnConfig.DNSDomain != "" {
+		log.Printf("Overlay names resolve as <agent>.%s", vpnConfig.DNSDomain)
+	}
+	if len(vpnConfig.DNSSplitDomains) > 0 {
+		log.Printf("Split DNS domains resolved by the hub's forwarders: %s", strings.Join(vpnConfig.DNSSplitDomains, ", "))
+	}
+
+	if err := services.Sync(serverURL, apiKey); err != nil {
+		log.Printf("Failed to fetch services: %v", err)
+	}
 
 	// Heartbeat Loop
 	heartbeatTicker := time.NewTicker(5 * time.Second)
@@ -216,15 +346,43 @@ persistent_keepalive_interval=25
 	go func() {
 		failedCount := 0
 		for range heartbeatTicker.C {
-			if err := sendHeartbeat(serverURL, apiKey); err != nil {
+			reply, err := sendHeartbeat(serverURL, apiKey)
+			if err != nil {
 				log.Printf("Heartbeat failed: %v", err)
 				failedCount++
 				if failedCount > 5 {
 					errChan <- fmt.Errorf("too many heartbeat failures")
 					return
 				}
-			} else {
-				failedCount = 0
+				continue
+			}
+			failedCount = 0
+			if reply == nil {
+				continue // older servers send no feedback
+			}
+
+			// Services were added or removed on the server
+			if reply.ServicesRevision != "" && reply.ServicesRevision != services.Revision() {
+				if err := services.Sync(serverURL, apiKey); err != nil {
+					log.Printf("Failed to fetch services: %v", err)
+				}
+			}
+
+			// Subnet routes were approved or withdrawn, or policies changed
+			// which of them this agent may reach
+			if reply.Routes != nil && !slices.Equal(reply.Routes, hubRoutes) {
+				if err := setHubRoutes(dev, vpnConfig, reply.Routes); err != nil {
+					log.Printf("Failed to update routes: %v", err)
+				} else {
+					hubRoutes = reply.Routes
+					logHubRoutes(hubRoutes, offer.UseExitNode)
+				}
+			}
+			if reply.ApprovedRoutes != nil {
+				router.SetRoutes(reply.ApprovedRoutes)
+			}
+			if reply.ExitNode != nil {
+				router.SetExitNode(*reply.ExitNode)
 			}
 		}
 	}()
@@ -241,7 +399,25 @@ persistent_keepalive_interval=25
 
 var lastHeartbeatLatency int64
 
-func sendHeartbeat(serverURL, apiKey string) error {
+// lastComplianceReport is the posture feedback last printed, so it is only
+// repeated when it changes
+var lastComplianceReport string
+
+// heartbeatReply is what the server tells the agent in return for a heartbeat
+type heartbeatReply struct {
+	ServicesRevision string   `json:"services_revision"`
+	Routes           []string `json:"routes"`
+	ApprovedRoutes   []string `json:"approved_routes"`
+	ExitNode         *bool    `json:"exit_node"`
+	Compliance       []struct {
+		Profile  string   `json:"profile"`
+		Failures []string `json:"failures"`
+	} `json:"compliance"`
+}
+
+// sendHeartbeat reports the agent's status and returns the server's reply,
+// which is nil if the server does not send one
+func sendHeartbeat(serverURL, apiKey string) (*heartbeatReply, error) {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
@@ -249,7 +425,6 @@ func sendHeartbeat(serverURL, apiKey string) error {
 	posture := CollectDevicePosture()
 
 	payload := map[string]interface{}{
-		"api_key":              apiKey,
 		"heartbeat_latency_ms": lastHeartbeatLatency,
 		"bytes_sent":           0, // TODO: Get from device stats if possible
 		"bytes_received":       0,
@@ -266,17 +441,22 @@ func sendHeartbeat(serverURL, apiKey string) error {
 			"firewall_enabled":    posture.FirewallEnabled,
 			"disk_encrypted":      posture.DiskEncrypted,
 			"screen_lock_enabled": posture.ScreenLockEnabled,
-			"posture_score":       posture.PostureScore,
+			"agent_version":       version,
 		},
 	}
 
 	jsonBody, _ := json.Marshal(payload)
 	client := &http.Client{Timeout: 3 * time.Second}
 
+	req, err := newAgentRequest(serverURL+"/api/v1/agents/heartbeat", apiKey, jsonBody)
+	if err != nil {
+		return nil, err
+	}
+
 	start := time.Now()
-	resp, err := client.Post(serverURL+"/api/v1/agents/heartbeat", "application/json", bytes.NewBuffer(jsonBody))
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -284,9 +464,30 @@ func sendHeartbeat(serverURL, apiKey string) error {
 	lastHeartbeatLatency = time.Since(start).Milliseconds()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("status %d", resp.StatusCode)
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
 	}
-	return nil
+
+	var result heartbeatReply
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil // older servers send no feedback
+	}
+
+	var report strings.Builder
+	for _, c := range result.Compliance {
+		fmt.Fprintf(&report, "\n  Posture profile %q is not met:", c.Profile)
+		for _, f := range c.Failures {
+			fmt.Fprintf(&report, "\n    - %s", f)
+		}
+	}
+	if report.String() != lastComplianceReport {
+		if report.Len() == 0 {
+			log.Println("Device meets all posture requirements")
+		} else {
+			log.Printf("Device does not meet posture requirements, access is restricted until fixed:%s", report.String())
+		}
+		lastComplianceReport = report.String()
+	}
+	return &result, nil
 }
 
 type VPNConfig struct {
@@ -294,12 +495,42 @@ type VPNConfig struct {
 	ServerPubKey string `json:"server_pub_key"`
 	AllowedIPs   string `json:"allowed_ips"`
 	AssignedIP   string `json:"assigned_ip"`
+
+	// Subnets other agents route that this agent may reach, and the
+	// subnets this agent routes itself
+	Routes         []string `json:"routes"`
+	ApprovedRoutes []string `json:"approved_routes"`
+
+	// Whether this agent forwards internet traffic for the overlay
+	ExitNode bool `json:"exit_node"`
+
+	// Resolvers for the netstack and the domain overlay names live under
+	DNS       []string `json:"dns"`
+	DNSDomain string   `json:"dns_domain"`
+
+	// Domains tried for names without a dot, and domains the hub forwards
+	// to resolvers of their own (split DNS)
+	DNSSearchDomains []string `json:"dns_search_domains"`
+	DNSSplitDomains  []string `json:"dns_split_domains"`
+}
+
+// connectOffer is what the agent tells the server it offers and wants when
+// connecting
+type connectOffer struct {
+	Routes            []string // subnets the agent advertises; nil leaves them unchanged
+	AdvertiseExitNode bool
+	UseExitNode       bool
 }
 
-func connectToServer(baseURL, apiKey, pubKey string) (*VPNConfig, error) {
-	reqBody := map[string]string{
-		"key":        apiKey,
-		"public_key": pubKey,
+// connectToServer registers the agent's key and gets its tunnel settings
+func connectToServer(baseURL, apiKey, pubKey string, offer connectOffer) (*VPNConfig, error) {
+	reqBody := map[string]interface{}{
+		"public_key":          pubKey,
+		"advertise_exit_node": offer.AdvertiseExitNode,
+		"use_exit_node":       offer.UseExitNode,
+	}
+	if offer.Routes != nil {
+		reqBody["routes"] = offer.Routes
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -311,13 +542,24 @@ func connectToServer(baseURL, apiKey, pubKey string) (*VPNConfig, error) {
 		Timeout: 5 * time.Second,
 	}
 
-	resp, err := client.Post(baseURL+"/api/v1/agent/connect", "application/json", bytes.NewBuffer(jsonBody))
+	req, err := newAgentRequest(baseURL+"/api/v1/agent/connect", apiKey, jsonBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+			return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, body.Error)
+		}
 		return nil, fmt.Errorf("server returned status: %d", resp.StatusCode)
 	}
 
@@ -337,6 +579,71 @@ func connectToServer(baseURL, apiKey, pubKey string) (*VPNConfig, error) {
 	return apiResp.VPN, nil
 }
 
+// setHubRoutes sends the overlay network and the subnets routed by other
+// agents to the hub, replacing the routes set before
+func setHubRoutes(dev *device.Device, vpn *VPNConfig, routes []string) error {
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "public_key=%s\nreplace_allowed_ips=true\nallowed_ip=%s\n", hexKey(vpn.ServerPubKey), vpn.AllowedIPs)
+	for _, r := range routes {
+		fmt.Fprintf(&conf, "allowed_ip=%s\n", r)
+	}
+	return dev.IpcSet(conf.String())
+}
+
+// logHubRoutes reports what the agent reaches through the hub besides the
+// overlay network
+func logHubRoutes(routes []string, useExit bool) {
+	var subnets []string
+	exit := false
+	for _, r := range routes {
+		if r == "0.0.0.0/0" {
+			exit = true
+			continue
+		}
+		subnets = append(subnets, r)
+	}
+	if len(subnets) > 0 {
+		log.Printf("Subnets reachable through the overlay: %s", strings.Join(subnets, ", "))
+	}
+	switch {
+	case exit:
+		log.Println("Sending internet traffic through the exit node")
+	case useExit:
+		log.Println("Not using the exit node: none is approved, or no policy allows this agent to use it")
+	}
+}
+
+// parseRouteList splits a comma-separated list of subnets. A nil setting
+// yields nil, meaning the agent does not manage its routes.
+func parseRouteList(setting *string) ([]string, error) {
+	if setting == nil {
+		return nil, nil
+	}
+	routes := []string{}
+	for _, s := range strings.Split(*setting, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return nil, fmt.Errorf("%q is not a subnet like 192.168.1.0/24", s)
+		}
+		routes = append(routes, s)
+	}
+	return routes, nil
+}
+
+// newAgentRequest builds a JSON POST authenticated with the agent's API key
+func newAgentRequest(url, apiKey string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	return req, nil
+}
+
 func generateKeyPair() (string, string) {
 	var privateKey [32]byte
 	_, err := rand.Read(privateKey[:])
@@ -17,7 +17,6 @@ type DevicePostureInfo struct {
 	FirewallEnabled   bool   `json:"firewall_enabled"`
 	DiskEncrypted     bool   `json:"disk_encrypted"`
 	ScreenLockEnabled bool   `json:"screen_lock_enabled"`
-	PostureScore      int    `json:"posture_score"`
 }
 
 // CollectDevicePosture gathers security posture information from the local device
@@ -51,8 +50,7 @@ func CollectDevicePosture() DevicePostureInfo {
 		posture.AntivirusEnabled = false
 	}
 
-	// Calculate posture score (0-100)
-	posture.PostureScore = calculatePostureScore(posture)
+	// The server scores these facts with its own weights
 
 	return posture
 }
@@ -149,33 +147,3 @@ func checkLinuxFirewall() bool {
 
 	return false
 }
-
-// Calculate a security posture score (0-100)
-func calculatePostureScore(p DevicePostureInfo) int {
-	score := 0
-
-	// Base points for having an OS (validated connection)
-	score += 20
-
-	// Firewall enabled: +25 points
-	if p.FirewallEnabled {
-		score += 25
-	}
-
-	// Disk encryption: +25 points
-	if p.DiskEncrypted {
-		score += 25
-	}
-
-	// Antivirus enabled: +20 points
-	if p.AntivirusEnabled {
-		score += 20
-	}
-
-	// Screen lock: +10 points
-	if p.ScreenLockEnabled {
-		score += 10
-	}
-
-	return score
-}
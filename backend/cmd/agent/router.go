@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// SubnetRouter wraps the agent's netstack TUN device and forwards overlay
+// traffic for the subnets the server approved this agent to route onto the
+// real network, and for the internet while the agent is the exit node.
+// Packets for those destinations go to a second, promiscuous netstack that
+// terminates TCP and UDP and opens the same connections from the host, so
+// no kernel routing or NAT is needed; everything else passes through to the
+// agent's own netstack.
+type SubnetRouter struct {
+	tun.Device
+	stack   *stack.Stack
+	ep      *channel.Endpoint
+	overlay netip.Prefix
+
+	mu     sync.RWMutex
+	routes []netip.Prefix
+	exit   bool
+
+	outbound chan []byte
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// NewSubnetRouter wraps dev; it routes nothing until SetRoutes or
+// SetExitNode is called. overlay is the overlay network, which is never
+// routed.
+func NewSubnetRouter(dev tun.Device, mtu int, overlay netip.Prefix) (*SubnetRouter, error) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	sack := tcpip.TCPSACKEnabled(true)
+	if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &sack); err != nil {
+		return nil, fmt.Errorf("enable TCP SACK: %v", err)
+	}
+
+	ep := channel.New(1024, uint32(mtu), "")
+	if err := s.CreateNIC(1, ep); err != nil {
+		return nil, fmt.Errorf("create NIC: %v", err)
+	}
+	// Accept packets for any address and answer from it
+	if err := s.SetPromiscuousMode(1, true); err != nil {
+		return nil, fmt.Errorf("enable promiscuous mode: %v", err)
+	}
+	if err := s.SetSpoofing(1, true); err != nil {
+		return nil, fmt.Errorf("enable spoofing: %v", err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: 1},
+		{Destination: header.IPv6EmptySubnet, NIC: 1},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &SubnetRouter{
+		Device:   dev,
+		stack:    s,
+		ep:       ep,
+		overlay:  overlay,
+		outbound: make(chan []byte, 256),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcp.NewForwarder(s, 0, 1024, r.forwardTCP).HandlePacket)
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, udp.NewForwarder(s, r.forwardUDP).HandlePacket)
+
+	go r.pumpDevice()
+	go r.pumpStack()
+	return r, nil
+}
+
+// SetRoutes replaces the subnets this agent forwards. Connections already
+// forwarded are left alone.
+func (r *SubnetRouter) SetRoutes(routes []string) {
+	var prefixes []netip.Prefix
+	for _, s := range routes {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			log.Printf("Ignoring invalid route %q: %v", s, err)
+			continue
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slices.Equal(prefixes, r.routes) {
+		return
+	}
+	r.routes = prefixes
+	if len(prefixes) == 0 {
+		log.Println("Not routing any subnets")
+	} else {
+		log.Printf("Routing subnets for the overlay: %s", strings.Join(routes, ", "))
+	}
+}
+
+// SetExitNode turns forwarding of internet traffic on or off
+func (r *SubnetRouter) SetExitNode(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if enabled == r.exit {
+		return
+	}
+	r.exit = enabled
+	if enabled {
+		log.Println("Acting as exit node: forwarding internet traffic for the overlay")
+	} else {
+		log.Println("No longer acting as exit node")
+	}
+}
+
+func (r *SubnetRouter) routed(addr netip.Addr) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.routes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return r.exit && r.exitable(addr)
+}
+
+// exitable reports whether the exit node forwards traffic for addr. Only
+// public addresses qualify, so overlay clients cannot reach the host's
+// loopback or local networks through it; those need approved routes.
+func (r *SubnetRouter) exitable(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !r.overlay.Contains(addr)
+}
+
+// Write takes decrypted packets from WireGuard, handing those for routed
+// subnets to the router's netstack
+func (r *SubnetRouter) Write(bufs [][]byte, offset int) (int, error) {
+	local := bufs[:0:0]
+	for _, buf := range bufs {
+		packet := buf[offset:]
+		dst, proto, ok := packetDestination(packet)
+		if !ok || !r.routed(dst) {
+			local = append(local, buf)
+			continue
+		}
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(packet)})
+		r.ep.InjectInbound(proto, pkt)
+		pkt.DecRef()
+	}
+
+	if len(local) > 0 {
+		if _, err := r.Device.Write(local, offset); err != nil {
+			return 0, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// Read returns the next packet from either netstack
+func (r *SubnetRouter) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	for {
+		select {
+		case packet := <-r.outbound:
+			if len(packet) > len(bufs[0])-offset {
+				continue // larger than the device MTU, drop
+			}
+			sizes[0] = copy(bufs[0][offset:], packet)
+			return 1, nil
+		case <-r.ctx.Done():
+			return 0, os.ErrClosed
+		}
+	}
+}
+
+// BatchSize is one since Read hands out a single packet at a time
+func (r *SubnetRouter) BatchSize() int {
+	return 1
+}
+
+// Close stops forwarding and closes the wrapped device
+func (r *SubnetRouter) Close() error {
+	r.cancel()
+	r.stack.Close()
+	r.ep.Close()
+	return r.Device.Close()
+}
+
+// pumpDevice moves packets from the agent's own netstack onto the outbound queue
+func (r *SubnetRouter) pumpDevice() {
+	defer r.cancel()
+
+	bufs := [][]byte{make([]byte, 65535)}
+	sizes := make([]int, 1)
+	for {
+		n, err := r.Device.Read(bufs, sizes, 0)
+		if err != nil {
+			return
+		}
+		if n < 1 || sizes[0] < 1 {
+			continue
+		}
+		packet := make([]byte, sizes[0])
+		copy(packet, bufs[0][:sizes[0]])
+		select {
+		case r.outbound <- packet:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// pumpStack moves replies from the routed subnets onto the outbound queue
+func (r *SubnetRouter) pumpStack() {
+	for {
+		pkt := r.ep.ReadContext(r.ctx)
+		if pkt == nil {
+			return
+		}
+		view := pkt.ToView()
+		packet := make([]byte, view.Size())
+		copy(packet, view.AsSlice())
+		view.Release()
+		pkt.DecRef()
+
+		select {
+		case r.outbound <- packet:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// forwardTCP completes a connection to a routed address only once the real
+// host accepted it, so clients see refusals and timeouts as they are
+func (r *SubnetRouter) forwardTCP(req *tcp.ForwarderRequest) {
+	id := req.ID()
+	target := endpointAddr(id.LocalAddress, id.LocalPort)
+	if !r.routed(target.Addr()) {
+		req.Complete(true)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", target.String(), 5*time.Second)
+	if err != nil {
+		req.Complete(true)
+		return
+	}
+	var wq waiter.Queue
+	ep, tcpErr := req.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		upstream.Close()
+		req.Complete(true)
+		return
+	}
+	req.Complete(false)
+
+	client := gonet.NewTCPConn(&wq, ep)
+	defer client.Close()
+	defer upstream.Close()
+	relayTCP(client, upstream)
+}
+
+// forwardUDP relays a UDP session to a routed address. The session ends once
+// the overlay client has been silent for udpIdleTimeout.
+func (r *SubnetRouter) forwardUDP(req *udp.ForwarderRequest) {
+	id := req.ID()
+	target := endpointAddr(id.LocalAddress, id.LocalPort)
+	if !r.routed(target.Addr()) {
+		return
+	}
+
+	upstream, err := net.Dial("udp", target.String())
+	if err != nil {
+		return
+	}
+	var wq waiter.Queue
+	ep, tcpErr := req.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		upstream.Close()
+		return
+	}
+	client := gonet.NewUDPConn(&wq, ep)
+
+	go func() {
+		defer client.Close()
+		defer upstream.Close()
+		buf := make([]byte, 64*1024)
+		for {
+			client.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+			n, err := client.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := upstream.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer client.Close()
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := upstream.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := client.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// packetDestination returns the destination address and network protocol of
+// an IP packet
+func packetDestination(packet []byte) (netip.Addr, tcpip.NetworkProtocolNumber, bool) {
+	if len(packet) == 0 {
+		return netip.Addr{}, 0, false
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < header.IPv4MinimumSize {
+			return netip.Addr{}, 0, false
+		}
+		return netip.AddrFrom4([4]byte(packet[16:20])), ipv4.ProtocolNumber, true
+	case 6:
+		if len(packet) < header.IPv6MinimumSize {
+			return netip.Addr{}, 0, false
+		}
+		return netip.AddrFrom16([16]byte(packet[24:40])), ipv6.ProtocolNumber, true
+	}
+	return netip.Addr{}, 0, false
+}
+
+func endpointAddr(addr tcpip.Address, port uint16) netip.AddrPort {
+	ip, _ := netip.AddrFromSlice(addr.AsSlice())
+	return netip.AddrPortFrom(ip, port)
+}
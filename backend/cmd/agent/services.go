@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// udpIdleTimeout is how long a UDP session may go without a reply before
+// its upstream socket is released; the next datagram opens a new one
+const udpIdleTimeout = 2 * time.Minute
+
+// AgentService is a service the server asks this agent to expose on its
+// overlay address
+type AgentService struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	LocalAddr string `json:"local_addr"`
+}
+
+func (s AgentService) key() string {
+	return fmt.Sprintf("%s/%d", s.Protocol, s.Port)
+}
+
+// ServiceProxy listens on the overlay address for every exposed service and
+// forwards what arrives to the service's local address. The built-in status
+// page answers on tcp/80 while no service claims that port.
+type ServiceProxy struct {
+	tnet   *netstack.Net
+	status *connListener
+
+	mu       sync.Mutex
+	revision string
+	active   map[string]*exposure
+	failing  map[string]bool // ports that could not be opened yet
+}
+
+// exposure is one listening port and the connections it has opened
+type exposure struct {
+	stop   func()
+	status *connListener
+
+	mu      sync.Mutex
+	service AgentService
+	closed  bool
+	conns   map[net.Conn]struct{}
+}
+
+// NewServiceProxy starts the status page; services follow with Sync
+func NewServiceProxy(tnet *netstack.Net, status http.Handler) *ServiceProxy {
+	p := &ServiceProxy{
+		tnet:    tnet,
+		status:  newConnListener(&net.TCPAddr{Port: 80}),
+		active:  make(map[string]*exposure),
+		failing: make(map[string]bool),
+	}
+	go http.Serve(p.status, status)
+	p.Apply(nil, "")
+	return p
+}
+
+// Revision returns the revision of the service list last applied
+func (p *ServiceProxy) Revision() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.revision
+}
+
+// Sync fetches the service list from the server and applies it
+func (p *ServiceProxy) Sync(serverURL, apiKey string) error {
+	services, revision, err := fetchServices(serverURL, apiKey)
+	if err != nil {
+		return err
+	}
+	p.Apply(services, revision)
+	return nil
+}
+
+// Apply opens listeners for new services and closes those of removed ones.
+// A service whose local address changed keeps its listener, and new
+// connections go to the new address. Port 80 is never closed; it passes
+// between a service and the status page.
+//
+// A TCP port that was just closed stays in use while its connections linger
+// in TIME_WAIT. If a port cannot be opened the revision is not recorded, so
+// the next heartbeat retries.
+func (p *ServiceProxy) Apply(services []AgentService, revision string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := make(map[string]AgentService)
+	for _, s := range services {
+		if s.Protocol == "" {
+			s.Protocol = "tcp"
+		}
+		if s.LocalAddr == "" {
+			s.LocalAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port))
+		}
+		if s.Protocol != "tcp" && s.Protocol != "udp" {
+			log.Printf("Skipping service %q: unsupported protocol %q", s.Name, s.Protocol)
+			continue
+		}
+		wanted[s.key()] = s
+	}
+	if _, ok := wanted["tcp/80"]; !ok {
+		wanted["tcp/80"] = AgentService{Name: "status page", Port: 80, Protocol: "tcp"}
+	}
+
+	for key, e := range p.active {
+		current := e.current()
+		s, ok := wanted[key]
+		switch {
+		case !ok:
+			e.stop()
+			delete(p.active, key)
+			log.Printf("Stopped exposing service %q on %s", current.Name, key)
+		case s == current:
+		case s.ID == 0:
+			// The status page takes the port back
+			e.update(s)
+			e.dropConns()
+			log.Printf("Stopped exposing service %q on %s", current.Name, key)
+		default:
+			e.update(s)
+			log.Printf("Exposing service %q on %s -> %s", s.Name, key, s.LocalAddr)
+		}
+	}
+
+	complete := true
+	for key := range p.failing {
+		if _, ok := wanted[key]; !ok {
+			delete(p.failing, key)
+		}
+	}
+	for key, s := range wanted {
+		if _, ok := p.active[key]; ok {
+			continue
+		}
+		e, err := p.expose(s)
+		if err != nil {
+			if !p.failing[key] {
+				log.Printf("Failed to expose service %q on %s, will retry: %v", s.Name, key, err)
+				p.failing[key] = true
+			}
+			complete = false
+			continue
+		}
+		delete(p.failing, key)
+		p.active[key] = e
+		if s.ID != 0 {
+			log.Printf("Exposing service %q on %s -> %s", s.Name, key, s.LocalAddr)
+		}
+	}
+
+	p.revision = revision
+	if !complete {
+		p.revision = ""
+	}
+}
+
+// Close stops every listener and drops their connections
+func (p *ServiceProxy) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, e := range p.active {
+		e.stop()
+		delete(p.active, key)
+	}
+	p.status.Close()
+}
+
+// expose opens the overlay listener of a service. A TCP listener hands its
+// connections to the status page while its service has no ID.
+func (p *ServiceProxy) expose(s AgentService) (*exposure, error) {
+	e := &exposure{service: s, status: p.status, conns: make(map[net.Conn]struct{})}
+
+	switch s.Protocol {
+	case "tcp":
+		listener, err := p.tnet.ListenTCP(&net.TCPAddr{Port: s.Port})
+		if err != nil {
+			return nil, err
+		}
+		go e.serveTCP(listener)
+		e.stop = func() {
+			listener.Close()
+			e.closeAll()
+		}
+
+	default:
+		conn, err := p.tnet.ListenUDP(&net.UDPAddr{Port: s.Port})
+		if err != nil {
+			return nil, err
+		}
+		go e.serveUDP(conn)
+		e.stop = func() {
+			conn.Close()
+			e.closeAll()
+		}
+	}
+	return e, nil
+}
+
+func (e *exposure) current() AgentService {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.service
+}
+
+func (e *exposure) update(s AgentService) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.service = s
+}
+
+// track registers a connection so it is closed with the exposure. It
+// returns false if the exposure has already stopped.
+func (e *exposure) track(c net.Conn) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return false
+	}
+	e.conns[c] = struct{}{}
+	return true
+}
+
+func (e *exposure) untrack(c net.Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.conns, c)
+}
+
+func (e *exposure) closeAll() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	e.dropConns()
+}
+
+// dropConns closes the connections opened so far
+func (e *exposure) dropConns() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c := range e.conns {
+		c.Close()
+		delete(e.conns, c)
+	}
+}
+
+func (e *exposure) serveTCP(listener net.Listener) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go e.proxyTCP(client)
+	}
+}
+
+func (e *exposure) proxyTCP(client net.Conn) {
+	s := e.current()
+	if s.ID == 0 {
+		e.status.deliver(client)
+		return
+	}
+
+	defer client.Close()
+	if !e.track(client) {
+		return
+	}
+	defer e.untrack(client)
+
+	upstream, err := net.DialTimeout("tcp", s.LocalAddr, 5*time.Second)
+	if err != nil {
+		log.Printf("Service %q: %v", s.Name, err)
+		return
+	}
+	defer upstream.Close()
+	if !e.track(upstream) {
+		return
+	}
+	defer e.untrack(upstream)
+
+	relayTCP(client, upstream)
+}
+
+// relayTCP copies between two connections until both directions finish.
+// Each direction is half-closed on its own so request/response protocols
+// that shut down writing still get their answer.
+func relayTCP(client, upstream net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, client)
+		closeWrite(upstream)
+		close(done)
+	}()
+	io.Copy(client, upstream)
+	closeWrite(client)
+	<-done
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		c.Close()
+	}
+}
+
+// serveUDP relays datagrams between each overlay client and its own upstream
+// socket, so replies reach the client that asked
+func (e *exposure) serveUDP(conn net.PacketConn) {
+	var mu sync.Mutex
+	sessions := make(map[string]net.Conn)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		key := addr.String()
+		mu.Lock()
+		upstream, ok := sessions[key]
+		if !ok {
+			s := e.current()
+			upstream, err = net.Dial("udp", s.LocalAddr)
+			if err != nil {
+				mu.Unlock()
+				log.Printf("Service %q: %v", s.Name, err)
+				continue
+			}
+			if !e.track(upstream) {
+				mu.Unlock()
+				upstream.Close()
+				return
+			}
+			sessions[key] = upstream
+
+			go func() {
+				defer func() {
+					mu.Lock()
+					delete(sessions, key)
+					mu.Unlock()
+					e.untrack(upstream)
+					upstream.Close()
+				}()
+				reply := make([]byte, 64*1024)
+				for {
+					upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+					n, err := upstream.Read(reply)
+					if err != nil {
+						return
+					}
+					if _, err := conn.WriteTo(reply[:n], addr); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		mu.Unlock()
+
+		upstream.Write(buf[:n])
+	}
+}
+
+// fetchServices asks the server which services this agent exposes
+func fetchServices(serverURL, apiKey string) ([]AgentService, string, error) {
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/api/v1/agent/services", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("X-API-Key", apiKey)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("server returned status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Revision string         `json:"revision"`
+		Services []AgentService `json:"services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("failed to decode services: %v", err)
+	}
+	return result.Services, result.Revision, nil
+}
+
+// connListener hands connections accepted on a shared port to an
+// http.Server
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) deliver(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// SOCKS5 protocol values (RFC 1928)
+const (
+	socksVersion       = 5
+	socksNoAuth        = 0
+	socksNoAcceptable  = 0xff
+	socksConnect       = 1
+	socksAddrIPv4      = 1
+	socksAddrDomain    = 3
+	socksAddrIPv6      = 4
+	socksSucceeded     = 0
+	socksHostUnreach   = 4
+	socksCmdUnsupp     = 7
+	socksAddrUnsupp    = 8
+	socksHandshakeTime = 10 * time.Second
+)
+
+// serveSOCKS5 lets programs on the host open TCP connections through the
+// overlay: to other agents, to approved subnets and, while the agent uses
+// the exit node, to the internet. The netstack has no presence in the host's
+// routing table, so this proxy is how host traffic reaches the tunnel. Only
+// CONNECT without authentication is supported, so the listener should be on
+// a loopback address. Host names are resolved by the overlay DNS, so agent
+// names work as well as public ones; names without a dot are tried in each
+// of the search domains first.
+func serveSOCKS5(listener net.Listener, tnet *netstack.Net, search []string) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handleSOCKS5(client, tnet, search)
+	}
+}
+
+func handleSOCKS5(client net.Conn, tnet *netstack.Net, search []string) {
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(socksHandshakeTime))
+	target, err := readSOCKS5Request(client)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socksHandshakeTime)
+	defer cancel()
+	addr, err := resolveSOCKS5Target(ctx, tnet, target, search)
+	if err != nil {
+		writeSOCKS5Reply(client, socksHostUnreach)
+		return
+	}
+	upstream, err := tnet.DialContextTCPAddrPort(ctx, addr)
+	if err != nil {
+		log.Printf("SOCKS5: %s: %v", target, err)
+		writeSOCKS5Reply(client, socksHostUnreach)
+		return
+	}
+	defer upstream.Close()
+
+	if err := writeSOCKS5Reply(client, socksSucceeded); err != nil {
+		return
+	}
+	client.SetDeadline(time.Time{})
+	relayTCP(client, upstream)
+}
+
+// readSOCKS5Request negotiates the method and returns the host:port the
+// client asks to connect to. Unsupported requests are answered before the
+// error is returned.
+func readSOCKS5Request(client net.Conn) (string, error) {
+	var greeting [2]byte
+	if _, err := io.ReadFull(client, greeting[:]); err != nil {
+		return "", err
+	}
+	if greeting[0] != socksVersion {
+		return "", errors.New("not a SOCKS5 client")
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(client, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := client.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptable {
+		return "", errors.New("client requires authentication")
+	}
+
+	var header [4]byte // version, command, reserved, address type
+	if _, err := io.ReadFull(client, header[:]); err != nil {
+		return "", err
+	}
+	if header[1] != socksConnect {
+		writeSOCKS5Reply(client, socksCmdUnsupp)
+		return "", errors.New("unsupported command")
+	}
+
+	var host string
+	switch header[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := 4
+		if header[3] == socksAddrIPv6 {
+			size = 16
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(client, ip); err != nil {
+			return "", err
+		}
+		addr, _ := netip.AddrFromSlice(ip)
+		host = addr.String()
+	case socksAddrDomain:
+		var size [1]byte
+		if _, err := io.ReadFull(client, size[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(client, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		writeSOCKS5Reply(client, socksAddrUnsupp)
+		return "", errors.New("unsupported address type")
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(client, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// resolveSOCKS5Target turns host:port into an address, looking names up
+// with the netstack's resolver
+func resolveSOCKS5Target(ctx context.Context, tnet *netstack.Net, target string, search []string) (netip.AddrPort, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return netip.AddrPortFrom(addr, uint16(port)), nil
+	}
+
+	names := []string{host}
+	if !strings.Contains(strings.TrimSuffix(host, "."), ".") {
+		names = names[:0]
+		for _, domain := range search {
+			names = append(names, host+"."+domain)
+		}
+		names = append(names, host)
+	}
+	for _, name := range names {
+		addrs, err := tnet.LookupContextHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, s := range addrs {
+			if addr, err := netip.ParseAddr(s); err == nil && addr.Is4() {
+				return netip.AddrPortFrom(addr, uint16(port)), nil
+			}
+		}
+	}
+	return netip.AddrPort{}, errors.New("no IPv4 address for " + host)
+}
+
+// writeSOCKS5Reply answers a request; the bound address is not reported
+func writeSOCKS5Reply(client net.Conn, code byte) error {
+	_, err := client.Write([]byte{socksVersion, code, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
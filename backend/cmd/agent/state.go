@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	stateFileName      = "state.json"
+	privateKeyFileName = "private.key"
+)
+
+// agentState is what the agent keeps across restarts. The WireGuard private
+// key lives in its own file next to it.
+type agentState struct {
+	ServerURL  string `json:"server_url"`
+	APIKey     string `json:"api_key,omitempty"`
+	TunnelMode string `json:"tunnel_mode,omitempty"`
+	TunnelURL  string `json:"tunnel_url,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
+
+	// Subnets offered for routing, comma-separated; nil if never configured
+	AdvertiseRoutes *string `json:"advertise_routes,omitempty"`
+
+	AdvertiseExitNode bool   `json:"advertise_exit_node,omitempty"`
+	UseExitNode       bool   `json:"use_exit_node,omitempty"`
+	SOCKS5Addr        string `json:"socks5_addr,omitempty"`
+}
+
+// defaultStateDir returns the system location when running as root and the
+// user's config directory otherwise
+func defaultStateDir() string {
+	if dir := os.Getenv("ZTA_STATE_DIR"); dir != "" {
+		return dir
+	}
+	if runtime.GOOS == "linux" && os.Geteuid() == 0 {
+		return "/var/lib/zero-zta"
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "zero-zta")
+	}
+	return ".zero-zta"
+}
+
+// loadState reads the saved state from dir. A missing directory or file is
+// not an error and yields an empty state.
+func loadState(dir string) (*agentState, error) {
+	st := &agentState{}
+	path := filepath.Join(dir, stateFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	checkPermissions(path)
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// save writes the state file, readable by the agent's user only
+func (s *agentState) save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(dir, stateFileName, data)
+}
+
+// override applies a setting given on the command line or, failing that,
+// in the environment
+func override(dst *string, flagValue string, flagSet bool, envKey string) {
+	if flagSet {
+		*dst = flagValue
+		return
+	}
+	if v := os.Getenv(envKey); v != "" {
+		*dst = v
+	}
+}
+
+func overrideBool(dst *bool, flagValue, flagSet bool, envKey string) error {
+	if flagSet {
+		*dst = flagValue
+		return nil
+	}
+	if v := os.Getenv(envKey); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*dst = b
+	}
+	return nil
+}
+
+// loadOrCreatePrivateKey returns the agent's WireGuard keypair, generating
+// and storing a new one on first start so the agent keeps its identity
+func loadOrCreatePrivateKey(dir string) (string, string, error) {
+	path := filepath.Join(dir, privateKeyFileName)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		checkPermissions(path)
+		privKey := strings.TrimSpace(string(data))
+		pubKey, err := publicKeyFor(privKey)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid private key in %s: %w", path, err)
+		}
+		return privKey, pubKey, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", "", fmt.Errorf("read %s: %w", path, err)
+	}
+
+	privKey, pubKey := generateKeyPair()
+	if err := writePrivateFile(dir, privateKeyFileName, []byte(privKey+"\n")); err != nil {
+		return "", "", err
+	}
+	log.Printf("Generated new WireGuard key in %s", path)
+	return privKey, pubKey, nil
+}
+
+// writePrivateFile atomically replaces dir/name with mode 0600, creating the
+// directory with mode 0700 if needed
+func writePrivateFile(dir, name string, data []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil && runtime.GOOS != "windows" {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// checkPermissions tightens secrets that are readable by other users
+func checkPermissions(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm()&0o077 == 0 {
+		return
+	}
+	log.Printf("Warning: %s is accessible by other users (mode %o), restricting to 0600", path, info.Mode().Perm())
+	if err := os.Chmod(path, 0o600); err != nil {
+		log.Printf("Failed to restrict %s: %v", path, err)
+	}
+}
+
+func publicKeyFor(privKey string) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(privKey)
+	if err != nil {
+		return "", err
+	}
+	if len(k) != 32 {
+		return "", fmt.Errorf("expected 32 bytes, got %d", len(k))
+	}
+	pub, err := curve25519.X25519(k, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
@@ -3,41 +3,73 @@ package main
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/netip"
+	"os"
 	"time"
 
 	"github.com/cubetiq/zero-zta/backend/internal/api/handlers"
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/config"
+	"github.com/cubetiq/zero-zta/backend/internal/dataplane"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/geoip"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
 	"github.com/cubetiq/zero-zta/backend/internal/service"
 	"github.com/cubetiq/zero-zta/backend/internal/tunnel"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/cors"
+	"github.com/gofiber/fiber/v3/middleware/limiter"
 	"github.com/gorilla/websocket"
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/device"
 	"golang.zx2c4.com/wireguard/tun/netstack"
 )
 
-// Hardcoded keys for demonstration
-const (
-	ServerPrivateKey = "OIfjl8wL+duLGyDoV8jS+/EFmzGWpa0tKBo+ThwKE2E="
-	ServerPublicKey  = "8Jxz+AhkWA1ul56CSK5E2UtBMsBuLFARuTAiovNTOg4="
-)
-
 func main() {
+	// Load configuration (file, env, flags) and the WireGuard identity
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
 	// Initialize Database
-	if err := db.Init("zero-zta.db"); err != nil {
+	if err := db.Init(cfg.DatabaseDSN); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
 	// Auto migrate models
-	if err := db.AutoMigrate(&models.Agent{}, &models.Group{}, &models.Policy{}, &models.Service{}, &models.AuditLog{}, &models.AccessLog{}, &models.AgentMetrics{}, &models.DevicePosture{}, &models.User{}, &models.DeviceClaim{}); err != nil {
+	if err := db.AutoMigrate(&models.Agent{}, &models.Group{}, &models.Policy{}, &models.Service{}, &models.AuditLog{}, &models.AccessLog{}, &models.AgentMetrics{}, &models.DevicePosture{}, &models.User{}, &models.DeviceClaim{}, &models.IPAllocation{}, &models.AgentCredential{}, &models.EnrollmentKey{}, &models.PostureSettings{}, &models.PostureProfile{}, &models.PostureCompliance{}, &models.Schedule{}, &models.DNSRecord{}, &models.DNSForwarder{}); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	if err := auth.MigrateLegacyAPIKeys(); err != nil {
+		log.Fatalf("Failed to migrate agent API keys: %v", err)
+	}
+
+	// Dashboard sessions and the initial admin account
+	auth.Init(cfg.Auth.Secret, cfg.TokenTTL())
+	if err := auth.EnsureAdmin(cfg.Auth.AdminEmail, cfg.Auth.AdminPassword); err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+	auth.InitOIDCProviders(cfg.Auth.OIDC, cfg.PublicURL)
+	go auth.StartLoginExpiry()
+
+	// Country lookups for region-restricted policies
+	if err := geoip.Init(cfg.GeoIP.DatabaseFile, cfg.GeoIP.CIDRFile); err != nil {
+		log.Fatalf("Failed to initialize geo-IP lookup: %v", err)
+	}
+
+	// Overlay address management; the hub keeps the first host address
+	if err := ipam.Init(cfg.OverlayPrefix(), cfg.HubAddr()); err != nil {
+		log.Fatalf("Failed to initialize IPAM: %v", err)
+	}
 
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
@@ -56,129 +88,307 @@ func main() {
 	api := app.Group("/api")
 	v1 := api.Group("/v1")
 
+	// Policies saved before a syntax change are reported rather than guessed at
+	if err := service.CheckPolicyPorts(); err != nil {
+		log.Printf("Failed to check policy ports: %v", err)
+	}
+
+	// Load policy snapshot before any peer traffic is accepted
+	service.SetPolicyEngine(policy.NewEngine(policy.Mode(cfg.PolicyMode)))
+	go service.StartPolicyReloader()
+	go service.StartPostureMonitor()
+	go service.StartPolicyExpiry()
+
 	// Start Wireguard Server
-	go startWireguardServer()
+	go startWireguardServer(cfg)
 
 	// Start Agent Monitor
 	go service.StartAgentMonitor()
 
+	// Keep WireGuard peers in sync with the agents table
+	go service.StartPeerReconciler()
+
 	// =====================
-	// Auth & Claim Routes
+	// Public Routes (device claiming & login)
 	// =====================
-	v1.Post("/start-claim", handlers.StartClaim)
+	// Each claim or enrollment creates rows, so limit them per client
+	registrationLimit := limiter.New(limiter.Config{
+		Max:        10,
+		Expiration: time.Minute,
+		LimitReached: func(c fiber.Ctx) error {
+			return c.Status(429).JSON(fiber.Map{"error": "Too many registration requests, try again later"})
+		},
+	})
+	// Every SSO login is held in memory until the IdP redirects back
+	loginLimit := limiter.New(limiter.Config{
+		Max:        20,
+		Expiration: time.Minute,
+		LimitReached: func(c fiber.Ctx) error {
+			return c.Status(429).JSON(fiber.Map{"error": "Too many sign-in attempts, try again later"})
+		},
+	})
+	v1.Post("/start-claim", registrationLimit, handlers.StartClaim)
+	v1.Post("/enroll", registrationLimit, handlers.Enroll)
 	v1.Get("/claim-status", handlers.GetClaimStatus)
-	v1.Get("/claim-details", handlers.GetClaimDetails)
-	v1.Post("/approve-claim", handlers.ApproveClaim)
-	v1.Post("/auth/login", handlers.MockLogin)
+	v1.Post("/auth/login", handlers.Login)
+	v1.Get("/auth/providers", handlers.ListAuthProviders)
+	v1.Get("/auth/oidc/:provider/login", loginLimit, handlers.SSOLogin)
+	v1.Get("/auth/oidc/:provider/callback", handlers.SSOCallback)
+
+	// =====================
+	// Agent Routes (authenticated by agent API key)
+	// =====================
+	v1.Post("/agents/heartbeat", middleware.RequireAgent(), handlers.UpdateAgentStatus)
+	v1.Get("/agent/services", middleware.RequireAgent(), handlers.AgentServices)
+	v1.Post("/agent/connect", middleware.RequireAgent(), func(c fiber.Ctx) error {
+		type ConnectRequest struct {
+			PublicKey string    `json:"public_key"`
+			Routes    *[]string `json:"routes"` // subnets the agent offers to route, if it says
+
+			// Exit node settings, left alone by agents that do not send them
+			AdvertiseExitNode *bool `json:"advertise_exit_node"`
+			UseExitNode       *bool `json:"use_exit_node"`
+		}
+
+		var req ConnectRequest
+		if err := c.Bind().Body(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
+		}
+		// The key is written straight into the WireGuard peer configuration
+		if !config.ValidPublicKey(req.PublicKey) {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid public key"})
+		}
+
+		agent := middleware.CurrentAgent(c)
+
+		// Agents enrolled without an address get one on first connect
+		peerChanged := false
+		if agent.IP == "" {
+			addr, err := ipam.Pool.Allocate(agent.ID)
+			if err != nil {
+				return c.Status(503).JSON(fiber.Map{"error": "no overlay address available"})
+			}
+			agent.IP = addr.String()
+			peerChanged = true
+		}
+
+		// Region-restricted policies follow the address the agent connects from
+		locationChanged := service.RecordAgentLocation(agent, c.IP())
+
+		// Advertised subnets wait for approval; withdrawn ones stop routing
+		if req.Routes != nil {
+			routes, err := ipam.NormalizeRoutes(*req.Routes)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid routes: " + err.Error()})
+			}
+			previous := agent.Routes
+			approvedChanged, err := service.AdvertiseRoutes(agent, routes)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			peerChanged = peerChanged || approvedChanged
+			if agent.Routes != previous {
+				handlers.LogAudit(&agent.ID, "routes_advertised", map[string]interface{}{
+					"routes":          ipam.RouteStrings(routes),
+					"approved_routes": ipam.RouteStrings(ipam.ParseRoutes(agent.ApprovedRoutes)),
+				}, c)
+			}
+		}
+
+		// Offering to be the exit node needs an admin's approval; clients opt
+		// in to using it themselves
+		exitChanged, err := service.SetExitNodeOptions(agent, req.AdvertiseExitNode, req.UseExitNode)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if exitChanged {
+			peerChanged = true
+			handlers.LogAudit(&agent.ID, "exit_node_updated", map[string]interface{}{
+				"advertise_exit_node": agent.AdvertiseExitNode,
+				"exit_node":           agent.ExitNode,
+				"use_exit_node":       agent.UseExitNode,
+			}, c)
+		}
+
+		// Update agent with public key (the reconciler replaces the old peer on rotation)
+		now := time.Now()
+		peerChanged = peerChanged || agent.PublicKey != req.PublicKey
+		agent.PublicKey = req.PublicKey
+		agent.Status = "online"
+		agent.LastSeen = &now
+		db.DB.Save(agent)
+
+		if peerChanged || locationChanged {
+			service.ReloadPolicies()
+		}
+		if peerChanged {
+			service.RequestPeerSync()
+		}
+
+		dnsSearch, dnsSplit, err := service.DNSSettingsFor(agent, cfg.DNS.Domain)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{
+			"status": "connected",
+			"vpn": fiber.Map{
+				"endpoint":       cfg.WireGuard.PublicEndpoint,
+				"server_pub_key": cfg.WireGuard.PublicKey,
+				"allowed_ips":    cfg.OverlayPrefix().String(),
+				"assigned_ip":    hostPrefix(agent.IP),
+				// Subnets reachable through the hub, and those this agent forwards
+				"routes":          service.RoutesFor(agent),
+				"approved_routes": ipam.RouteStrings(ipam.ParseRoutes(agent.ApprovedRoutes)),
+				"exit_node":       agent.ExitNode,
+				// Overlay DNS on the hub, which also forwards other names; split
+				// domains go to the forwarders admins configured
+				"dns":                []string{cfg.HubAddr().String()},
+				"dns_domain":         cfg.DNS.Domain,
+				"dns_search_domains": dnsSearch,
+				"dns_split_domains":  dnsSplit,
+			},
+		})
+	})
+
+	// The debug proxy opens in a new browser tab, so its token comes in the
+	// query string; registered ahead of admin so the header-only check is skipped
+	v1.Get("/debug/proxy", middleware.RequireUserFromLink(), handlers.ProxyToAgent)
+
+	// Everything registered on admin requires a dashboard access token. Fiber
+	// matches routes in registration order, so the public and agent routes
+	// above are served before this middleware is reached.
+	admin := v1.Group("", middleware.RequireUser())
+
+	// =====================
+	// Auth & Claim Routes
+	// =====================
+	admin.Get("/auth/me", handlers.Me)
+	admin.Get("/claim-details", handlers.GetClaimDetails)
+	admin.Post("/approve-claim", handlers.ApproveClaim)
+	admin.Post("/reject-claim", handlers.RejectClaim)
+	admin.Get("/enrollment-keys", handlers.ListEnrollmentKeys)
+	admin.Post("/enrollment-keys", handlers.CreateEnrollmentKey)
+	admin.Delete("/enrollment-keys/:id", handlers.RevokeEnrollmentKey)
 
 	// =====================
 	// Agent CRUD Routes
 	// =====================
-	v1.Get("/agents", handlers.ListAgents)
-	v1.Post("/agents", handlers.CreateAgent)
-	v1.Get("/agents/:id", handlers.GetAgent)
-	v1.Put("/agents/:id", handlers.UpdateAgent)
-	v1.Delete("/agents/:id", handlers.DeleteAgent)
-	v1.Post("/agents/heartbeat", handlers.UpdateAgentStatus)
-	v1.Put("/agents/:id/group", handlers.AssignGroup)
-	v1.Get("/agents/:id/metrics", handlers.GetAgentMetrics)
-	v1.Get("/agents/:id/access-logs", handlers.GetAccessLogs)
+	admin.Get("/agents", handlers.ListAgents)
+	admin.Post("/agents", handlers.CreateAgent)
+	admin.Get("/agents/:id", handlers.GetAgent)
+	admin.Put("/agents/:id", handlers.UpdateAgent)
+	admin.Delete("/agents/:id", handlers.DeleteAgent)
+	admin.Put("/agents/:id/group", handlers.AssignGroup)
+	admin.Get("/agents/:id/metrics", handlers.GetAgentMetrics)
+	admin.Get("/agents/:id/access-logs", handlers.GetAccessLogs)
 
 	// =====================
 	// Group CRUD Routes
 	// =====================
-	v1.Get("/groups", handlers.ListGroups)
-	v1.Post("/groups", handlers.CreateGroup)
-	v1.Get("/groups/:id", handlers.GetGroup)
-	v1.Put("/groups/:id", handlers.UpdateGroup)
-	v1.Delete("/groups/:id", handlers.DeleteGroup)
+	admin.Get("/groups", handlers.ListGroups)
+	admin.Post("/groups", handlers.CreateGroup)
+	admin.Get("/groups/:id", handlers.GetGroup)
+	admin.Put("/groups/:id", handlers.UpdateGroup)
+	admin.Put("/groups/:id/posture-profile", handlers.SetGroupPostureProfile)
+	admin.Put("/groups/:id/dns", handlers.SetGroupDNS)
+	admin.Delete("/groups/:id", handlers.DeleteGroup)
 
 	// =====================
 	// Policy CRUD Routes
 	// =====================
-	v1.Get("/policies", handlers.ListPolicies)
-	v1.Post("/policies", handlers.CreatePolicy)
-	v1.Get("/policies/:id", handlers.GetPolicy)
-	v1.Put("/policies/:id", handlers.UpdatePolicy)
-	v1.Delete("/policies/:id", handlers.DeletePolicy)
+	admin.Get("/policies", handlers.ListPolicies)
+	admin.Post("/policies", handlers.CreatePolicy)
+	admin.Post("/policies/evaluate", handlers.EvaluatePolicy)
+	admin.Get("/policies/conflicts", handlers.PolicyConflicts)
+	admin.Get("/policies/:id", handlers.GetPolicy)
+	admin.Put("/policies/:id", handlers.UpdatePolicy)
+	admin.Put("/policies/:id/posture-profile", handlers.SetPolicyPostureProfile)
+	admin.Put("/policies/:id/schedule", handlers.SetPolicySchedule)
+	admin.Delete("/policies/:id", handlers.DeletePolicy)
+
+	// =====================
+	// Policy Schedules
+	// =====================
+	admin.Get("/schedules", handlers.ListSchedules)
+	admin.Post("/schedules", handlers.CreateSchedule)
+	admin.Put("/schedules/:id", handlers.UpdateSchedule)
+	admin.Delete("/schedules/:id", handlers.DeleteSchedule)
+
+	// =====================
+	// Overlay DNS Records & Forwarders
+	// =====================
+	admin.Get("/dns/records", handlers.ListDNSRecords)
+	admin.Post("/dns/records", handlers.CreateDNSRecord)
+	admin.Put("/dns/records/:id", handlers.UpdateDNSRecord)
+	admin.Delete("/dns/records/:id", handlers.DeleteDNSRecord)
+	admin.Get("/dns/forwarders", handlers.ListDNSForwarders)
+	admin.Post("/dns/forwarders", handlers.CreateDNSForwarder)
+	admin.Put("/dns/forwarders/:id", handlers.UpdateDNSForwarder)
+	admin.Delete("/dns/forwarders/:id", handlers.DeleteDNSForwarder)
 
 	// =====================
 	// Service Routes
 	// =====================
-	v1.Get("/agents/:id/services", handlers.ListServices)
-	v1.Post("/agents/:id/services", handlers.CreateService)
-	v1.Delete("/agents/:id/services/:serviceId", handlers.DeleteService)
+	admin.Get("/agents/:id/services", handlers.ListServices)
+	admin.Post("/agents/:id/services", handlers.CreateService)
+	admin.Delete("/agents/:id/services/:serviceId", handlers.DeleteService)
 
 	// =====================
 	// Agent Management Routes
 	// =====================
-	v1.Post("/agents/:id/regenerate-key", handlers.RegenerateAgentKey)
-	v1.Put("/agents/:id/routes", handlers.UpdateAgentRoutes)
-	v1.Get("/agents/:id/audit-logs", handlers.GetAgentAuditLogs)
+	admin.Post("/agents/:id/regenerate-key", handlers.RegenerateAgentKey)
+	admin.Get("/agents/:id/credentials", handlers.ListAgentCredentials)
+	admin.Post("/agents/:id/credentials", handlers.CreateAgentCredential)
+	admin.Delete("/agents/:id/credentials/:credentialId", handlers.RevokeAgentCredential)
+	admin.Put("/agents/:id/routes", handlers.UpdateAgentRoutes)
+	admin.Put("/agents/:id/routes/approved", handlers.ApproveAgentRoutes)
+	admin.Put("/agents/:id/exit-node", handlers.ApproveExitNode)
+	admin.Get("/agents/:id/audit-logs", handlers.GetAgentAuditLogs)
+	admin.Get("/agents/:id/posture", handlers.GetAgentPosture)
+
+	// =====================
+	// Device Posture Settings & Profiles
+	// =====================
+	admin.Get("/posture/settings", handlers.GetPostureSettings)
+	admin.Put("/posture/settings", handlers.UpdatePostureSettings)
+	admin.Get("/posture/profiles", handlers.ListPostureProfiles)
+	admin.Post("/posture/profiles", handlers.CreatePostureProfile)
+	admin.Put("/posture/profiles/:id", handlers.UpdatePostureProfile)
+	admin.Delete("/posture/profiles/:id", handlers.DeletePostureProfile)
 
 	// =====================
 	// Audit & Access Log Routes
 	// =====================
-	v1.Get("/audit-logs", handlers.ListAuditLogs)
-	v1.Get("/access-logs", handlers.GetAllAccessLogs)
+	admin.Get("/audit-logs", handlers.ListAuditLogs)
+	admin.Get("/access-logs", handlers.GetAllAccessLogs)
 
 	// =====================
 	// Debug Tools
 	// =====================
-	v1.Post("/debug/ping", handlers.PingAgent)
-	v1.Post("/debug/port-check", handlers.CheckPort)
-	v1.Post("/debug/traceroute", handlers.Traceroute)
-	v1.Post("/debug/dns", handlers.DNSLookup)
-	v1.Post("/debug/http", handlers.HTTPCheck)
-
-	// Agent Connect (for Wireguard handshake)
-	v1.Post("/agent/connect", func(c fiber.Ctx) error {
-		type ConnectRequest struct {
-			Key       string `json:"key"`
-			PublicKey string `json:"public_key"`
-		}
-
-		var req ConnectRequest
-		if err := c.Bind().Body(&req); err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
-		}
-
-		// Find agent by API key
-		var agent models.Agent
-		if err := db.DB.Where("api_key = ?", req.Key).First(&agent).Error; err != nil {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid key"})
-		}
-
-		// Update agent with public key
-		now := time.Now()
-		if agent.PublicKey != req.PublicKey {
-			if agent.PublicKey != "" {
-				// Key rotation - remove old peer
-				removePeerFromWireguard(agent.PublicKey)
-			}
-			agent.PublicKey = req.PublicKey
-			addPeerToWireguard(req.PublicKey, agent.IP)
-		}
-		agent.Status = "online"
-		agent.LastSeen = &now
-		db.DB.Save(&agent)
+	admin.Post("/debug/ping", handlers.PingAgent)
+	admin.Post("/debug/port-check", handlers.CheckPort)
+	admin.Post("/debug/traceroute", handlers.Traceroute)
+	admin.Post("/debug/dns", handlers.DNSLookup)
+	admin.Post("/debug/http", handlers.HTTPCheck)
 
-		return c.JSON(fiber.Map{
-			"status": "connected",
-			"vpn": fiber.Map{
-				"endpoint":       "127.0.0.1:51820",
-				"server_pub_key": ServerPublicKey,
-				"allowed_ips":    "10.0.0.0/24",
-				"assigned_ip":    agent.IP + "/32",
-			},
-		})
-	})
+	// =====================
+	// User Management Routes
+	// =====================
+	admin.Get("/users", handlers.ListUsers)
+	admin.Post("/users", handlers.CreateUser)
+	admin.Put("/users/:id", handlers.UpdateUser)
+	admin.Delete("/users/:id", handlers.DeleteUser)
 
-	// Debug Proxy Endpoint
-	v1.Get("/debug/proxy", handlers.ProxyToAgent)
+	// =====================
+	// IPAM Routes
+	// =====================
+	admin.Get("/ipam", handlers.GetIPAM)
+	admin.Post("/ipam/reservations", handlers.CreateIPReservation)
+	admin.Delete("/ipam/reservations/:id", handlers.DeleteIPReservation)
 
 	// Initialize WebSocket Tunnel Server for firewall bypass
-	wsTunnelServer, err := tunnel.NewWSTunnelServer("127.0.0.1", 51820)
+	wsTunnelServer, err := tunnel.NewWSTunnelServer("127.0.0.1", cfg.WireGuard.ListenPort)
 	if err != nil {
 		log.Printf("Warning: Failed to initialize WebSocket tunnel: %v", err)
 	}
@@ -191,7 +401,7 @@ func main() {
 	}
 
 	// Start HTTPS server with WebSocket tunnel endpoint
-	certFile, keyFile := GenerateSelfSignedCert()
+	certFile, keyFile := GenerateSelfSignedCert(cfg.TLSCertFile, cfg.TLSKeyFile)
 	go func() {
 		mux := http.NewServeMux()
 
@@ -208,12 +418,20 @@ func main() {
 			}
 
 			// Validate agent
-			var agent models.Agent
-			if err := db.DB.Where("api_key = ?", apiKey).First(&agent).Error; err != nil {
+			agent, err := auth.AgentByAPIKey(apiKey)
+			if errors.Is(err, auth.ErrAgentDisabled) {
+				http.Error(w, "Agent disabled", http.StatusForbidden)
+				return
+			}
+			if err != nil {
 				http.Error(w, "Invalid API key", http.StatusUnauthorized)
 				return
 			}
 
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && service.RecordAgentLocation(agent, host) {
+				service.ReloadPolicies()
+			}
+
 			// Upgrade to WebSocket
 			conn, err := upgrader.Upgrade(w, r, nil)
 			if err != nil {
@@ -232,33 +450,42 @@ func main() {
 			w.Write([]byte("OK"))
 		})
 
-		log.Println("Starting HTTPS/WebSocket tunnel server on :443...")
-		if err := http.ListenAndServeTLS(":443", certFile, keyFile, mux); err != nil {
+		log.Printf("Starting HTTPS/WebSocket tunnel server on %s...", cfg.TunnelListenAddr)
+		if err := http.ListenAndServeTLS(cfg.TunnelListenAddr, certFile, keyFile, mux); err != nil {
 			log.Printf("HTTPS server failed: %v (try running with sudo)", err)
 		}
 	}()
 
-	log.Fatal(app.Listen(":3000"))
+	log.Fatal(app.Listen(cfg.ListenAddr))
 }
 
 var serverDev *device.Device
 
 // var serverTNet *netstack.Net // Moved to service.VPNNet
 
-func startWireguardServer() {
+func startWireguardServer(cfg *config.Config) {
 	var err error
 	// Using type inference for simplicity or correct interface if imported
 	// netstack.CreateNetTUN returns (tun.Device, *Net, error)
 
+	// The hub resolves through its own overlay DNS server like the agents
+	hubAddrs := []netip.Addr{cfg.HubAddr()}
 	devTun, tnet, err := netstack.CreateNetTUN(
-		[]netip.Addr{netip.MustParseAddr("10.0.0.1")},
-		[]netip.Addr{netip.MustParseAddr("8.8.8.8")},
+		hubAddrs,
+		hubAddrs,
 		device.DefaultMTU,
 	)
 	if err != nil {
 		log.Panicf("Failed to create server TUN: %v", err)
 	}
 	service.SetVPNNet(tnet)
+	if err := service.StartOverlayDNS(tnet, cfg.HubAddr(), cfg.DNS.Domain, cfg.DNS.Upstream); err != nil {
+		log.Panicf("Failed to start overlay DNS: %v", err)
+	}
+
+	// Enforce policies between the WireGuard device and the netstack, and
+	// route agent-to-agent traffic back out through the hub
+	filteredTun := dataplane.NewFilter(devTun, service.PolicyEngine, hubAddrs, dataplane.NewFlowLogger())
 
 	// Config string (normally generated via ipc/UAPI or wgtypes, but for netstack/device we can use UAPI string format)
 	// Format:
@@ -272,11 +499,12 @@ func startWireguardServer() {
 	logger := device.NewLogger(device.LogLevelVerbose, "(SERVER) ")
 
 	// Create device with real UDP bind
-	serverDev = device.NewDevice(devTun, conn.NewDefaultBind(), logger)
+	serverDev = device.NewDevice(filteredTun, conn.NewDefaultBind(), logger)
 
 	// Construct UAPI config
-	uapiConfig := fmt.Sprintf("private_key=%s\nlisten_port=51820\n",
-		hexKey(ServerPrivateKey),
+	uapiConfig := fmt.Sprintf("private_key=%s\nlisten_port=%d\n",
+		hexKey(cfg.WireGuard.PrivateKey),
+		cfg.WireGuard.ListenPort,
 	)
 
 	// Configure device
@@ -289,37 +517,19 @@ func startWireguardServer() {
 		log.Panicf("Failed to bring up server device: %v", err)
 	}
 
-	logger.Verbosef("Wireguard server started on :51820")
-}
+	// Restore peers for every known agent so tunnels survive a restart
+	service.SetWireGuardDevice(serverDev)
 
-func addPeerToWireguard(pubKeyB64, authorizedIP string) {
-	// Add peer via UAPI
-	// needs hex encoded key
-	pubKeyHex := hexKey(pubKeyB64)
-
-	// Config change to add peer
-	conf := fmt.Sprintf("public_key=%s\nallowed_ip=%s/32\n", pubKeyHex, authorizedIP)
-
-	if serverDev != nil {
-		if err := serverDev.IpcSet(conf); err != nil {
-			log.Printf("Failed to add peer: %v", err)
-		} else {
-			log.Printf("Added peer %s with IP %s", pubKeyB64, authorizedIP)
-		}
-	}
+	logger.Verbosef("Wireguard server started on :%d", cfg.WireGuard.ListenPort)
 }
 
-func removePeerFromWireguard(pubKeyB64 string) {
-	pubKeyHex := hexKey(pubKeyB64)
-	conf := fmt.Sprintf("public_key=%s\nremove=true\n", pubKeyHex)
-
-	if serverDev != nil {
-		if err := serverDev.IpcSet(conf); err != nil {
-			log.Printf("Failed to remove peer %s: %v", pubKeyB64, err)
-		} else {
-			log.Printf("Removed peer %s", pubKeyB64)
-		}
+// hostPrefix formats an overlay address as a single-host prefix (/32 or /128)
+func hostPrefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip + "/32"
 	}
+	return netip.PrefixFrom(addr, addr.BitLen()).String()
 }
 
 func hexKey(b64 string) string {
@@ -15,9 +15,7 @@ import (
 
 // GenerateSelfSignedCert checks for existing cert/key files and generates them if missing.
 // It returns the paths to the cert and key files.
-func GenerateSelfSignedCert() (string, string) {
-	certPath := "server.crt"
-	keyPath := "server.key"
+func GenerateSelfSignedCert(certPath, keyPath string) (string, string) {
 
 	if _, err := os.Stat(certPath); err == nil {
 		if _, err := os.Stat(keyPath); err == nil {
@@ -82,6 +80,6 @@ func GenerateSelfSignedCert() (string, string) {
 		log.Fatalf("Error closing %s: %v", keyPath, err)
 	}
 
-	log.Println("Generated server.crt and server.key")
+	log.Printf("Generated %s and %s", certPath, keyPath)
 	return certPath, keyPath
 }
@@ -5,10 +5,13 @@ go 1.25.5
 require (
 	github.com/gofiber/fiber/v3 v3.0.0-rc.3
 	github.com/gorilla/websocket v1.5.3
+	github.com/oschwald/maxminddb-golang/v2 v2.1.1
 	golang.org/x/crypto v0.46.0
+	golang.org/x/net v0.48.0
 	golang.zx2c4.com/wireguard v0.0.0-20250521234502-f333402bd9cb
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.31.1
+	gvisor.dev/gvisor v0.0.0-20250503011706-39ed1f5ac29c
 )
 
 require (
@@ -28,10 +31,8 @@ require (
 	github.com/tinylib/msgp v1.6.3 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasthttp v1.69.0 // indirect
-	golang.org/x/net v0.48.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
 	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2 // indirect
-	gvisor.dev/gvisor v0.0.0-20250503011706-39ed1f5ac29c // indirect
 )
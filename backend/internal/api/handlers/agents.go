@@ -1,21 +1,29 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"fmt"
+	"log"
 	"strconv"
 	"time"
 
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/posture"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
 	"github.com/gofiber/fiber/v3"
 )
 
-// ListAgents returns all agents
+// ListAgents returns all agents visible to the signed-in user
 func ListAgents(c fiber.Ctx) error {
+	query, err := scopeAgents(c, db.DB.Preload("Group").Preload("Services"), auth.PermViewAgents)
+	if err != nil {
+		return forbidden(c)
+	}
+
 	var agents []models.Agent
-	if err := db.DB.Preload("Group").Preload("Services").Find(&agents).Error; err != nil {
+	if err := query.Find(&agents).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(agents)
@@ -23,6 +31,10 @@ func ListAgents(c fiber.Ctx) error {
 
 // CreateAgent creates a new agent with generated API key
 func CreateAgent(c fiber.Ctx) error {
+	if !can(c, auth.PermOwnAgents) {
+		return forbidden(c)
+	}
+
 	type CreateRequest struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
@@ -37,39 +49,52 @@ func CreateAgent(c fiber.Ctx) error {
 	if req.Name == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
 	}
-
-	// Generate API key
-	keyBytes := make([]byte, 16)
-	rand.Read(keyBytes)
-	apiKey := fmt.Sprintf("sk_live_%s", hex.EncodeToString(keyBytes))
-
-	// Get next IP
-	var count int64
-	db.DB.Model(&models.Agent{}).Count(&count)
-	ip := fmt.Sprintf("10.0.0.%d", count+2)
+	if req.GroupID != nil && !can(c, auth.PermManageAgents) {
+		return forbidden(c)
+	}
 
 	agent := models.Agent{
 		Name:        req.Name,
 		Description: req.Description,
-		APIKey:      apiKey,
-		IP:          ip,
 		Status:      "offline",
 		GroupID:     req.GroupID,
+		UserID:      &middleware.CurrentUser(c).ID,
 	}
 
 	if err := db.DB.Create(&agent).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.Status(201).JSON(agent)
+	// Assign overlay IP from the pool
+	if err := assignAgentIP(&agent); err != nil {
+		db.DB.Unscoped().Delete(&agent)
+		return c.Status(503).JSON(fiber.Map{"error": "Failed to allocate IP: " + err.Error()})
+	}
+
+	apiKey, _, err := auth.IssueCredential(agent.ID, "default", nil)
+	if err != nil {
+		db.DB.Unscoped().Delete(&agent)
+		ipam.Pool.Release(agent.ID)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue API key"})
+	}
+	service.ReloadPolicies()
+	service.RequestPeerSync()
+
+	// The key is only ever returned here
+	return c.Status(201).JSON(agentWithKey{Agent: agent, APIKey: apiKey})
+}
+
+// agentWithKey is the agent response carrying a newly issued API key
+type agentWithKey struct {
+	models.Agent
+	APIKey string `json:"api_key"`
 }
 
 // GetAgent returns agent by ID
 func GetAgent(c fiber.Ctx) error {
-	id := c.Params("id")
-	var agent models.Agent
-	if err := db.DB.Preload("Group").Preload("Services").First(&agent, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	agent, err := findAgent(c, db.DB.Preload("Group").Preload("Services"), c.Params("id"), auth.PermViewAgents)
+	if err != nil {
+		return agentLookupError(c, err)
 	}
 	return c.JSON(agent)
 }
@@ -77,15 +102,16 @@ func GetAgent(c fiber.Ctx) error {
 // UpdateAgent updates agent details
 func UpdateAgent(c fiber.Ctx) error {
 	id := c.Params("id")
-	var agent models.Agent
-	if err := db.DB.First(&agent, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	agent, err := findAgent(c, db.DB, id, auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
 	}
 
 	type UpdateRequest struct {
 		Name        *string `json:"name"`
 		Description *string `json:"description"`
 		GroupID     *uint   `json:"group_id"`
+		Disabled    *bool   `json:"disabled"`
 	}
 
 	var req UpdateRequest
@@ -100,21 +126,54 @@ func UpdateAgent(c fiber.Ctx) error {
 		agent.Description = *req.Description
 	}
 	if req.GroupID != nil {
+		// Group membership decides policy, so members cannot move their own agents
+		if !can(c, auth.PermManageAgents) {
+			return forbidden(c)
+		}
 		agent.GroupID = req.GroupID
 	}
 
-	if err := db.DB.Save(&agent).Error; err != nil {
+	disabledChanged := req.Disabled != nil && *req.Disabled != agent.Disabled
+	if disabledChanged {
+		// Disabled agents are cut off by an admin, so members cannot re-enable them
+		if !can(c, auth.PermManageAgents) {
+			return forbidden(c)
+		}
+		agent.Disabled = *req.Disabled
+		if agent.Disabled {
+			agent.Status = "offline"
+		}
+	}
+
+	if err := db.DB.Save(agent).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	service.ReloadPolicies()
+
+	if disabledChanged {
+		service.RequestPeerSync()
+
+		action := "agent_enabled"
+		if agent.Disabled {
+			action = "agent_disabled"
+		}
+		LogAudit(&agent.ID, action, map[string]interface{}{
+			"name": agent.Name,
+		}, c)
+	}
 
 	// Load group for response
-	db.DB.Preload("Group").First(&agent, id)
+	db.DB.Preload("Group").First(agent, id)
 
 	return c.JSON(agent)
 }
 
 // AssignGroup assigns an agent to a group
 func AssignGroup(c fiber.Ctx) error {
+	if !can(c, auth.PermManageAgents) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	var agent models.Agent
 	if err := db.DB.First(&agent, id).Error; err != nil {
@@ -134,6 +193,7 @@ func AssignGroup(c fiber.Ctx) error {
 	if err := db.DB.Save(&agent).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	service.ReloadPolicies()
 
 	// Reload with group
 	db.DB.Preload("Group").First(&agent, id)
@@ -141,31 +201,57 @@ func AssignGroup(c fiber.Ctx) error {
 	return c.JSON(agent)
 }
 
-// DeleteAgent soft deletes an agent
+// DeleteAgent soft deletes an agent, or permanently removes it and releases
+// its overlay IP when called with ?hard=true
 func DeleteAgent(c fiber.Ctx) error {
 	id := c.Params("id")
-	if err := db.DB.Delete(&models.Agent{}, id).Error; err != nil {
+
+	if c.Query("hard") == "true" {
+		agent, err := findAgent(c, db.DB.Unscoped(), id, auth.PermManageAgents)
+		if err != nil {
+			return agentLookupError(c, err)
+		}
+		if err := db.DB.Unscoped().Delete(agent).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		db.DB.Unscoped().Where("agent_id = ?", agent.ID).Delete(&models.AgentCredential{})
+		if err := ipam.Pool.Release(agent.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		service.ReloadPolicies()
+		service.RequestPeerSync()
+		return c.SendStatus(204)
+	}
+
+	agent, err := findAgent(c, db.DB, id, auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
+	}
+	if err := db.DB.Delete(agent).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	service.ReloadPolicies()
+	service.RequestPeerSync()
 	return c.SendStatus(204)
 }
 
 // UpdateAgentStatus updates agent online status (called by heartbeat)
 func UpdateAgentStatus(c fiber.Ctx) error {
 	type PostureData struct {
-		OSName            string `json:"os_name"`
-		OSVersion         string `json:"os_version"`
-		Hostname          string `json:"hostname"`
-		AntivirusEnabled  bool   `json:"antivirus_enabled"`
-		AntivirusName     string `json:"antivirus_name"`
-		FirewallEnabled   bool   `json:"firewall_enabled"`
-		DiskEncrypted     bool   `json:"disk_encrypted"`
-		ScreenLockEnabled bool   `json:"screen_lock_enabled"`
-		PostureScore      int    `json:"posture_score"`
+		OSName            string     `json:"os_name"`
+		OSVersion         string     `json:"os_version"`
+		Hostname          string     `json:"hostname"`
+		AntivirusEnabled  bool       `json:"antivirus_enabled"`
+		AntivirusName     string     `json:"antivirus_name"`
+		FirewallEnabled   bool       `json:"firewall_enabled"`
+		DiskEncrypted     bool       `json:"disk_encrypted"`
+		ScreenLockEnabled bool       `json:"screen_lock_enabled"`
+		PendingPatches    *int       `json:"pending_patches"` // omitted by agents that cannot tell
+		LastPatchDate     *time.Time `json:"last_patch_date"`
+		AgentVersion      string     `json:"agent_version"`
 	}
 
 	type StatusRequest struct {
-		APIKey            string       `json:"api_key"`
 		HeartbeatLatency  int          `json:"heartbeat_latency_ms"`
 		BytesSent         int64        `json:"bytes_sent"`
 		BytesReceived     int64        `json:"bytes_received"`
@@ -182,14 +268,11 @@ func UpdateAgentStatus(c fiber.Ctx) error {
 
 	now := time.Now()
 
-	// Find agent
-	var agent models.Agent
-	if err := db.DB.Where("api_key = ?", req.APIKey).First(&agent).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
-	}
+	// Agent authenticated by middleware.RequireAgent
+	agent := middleware.CurrentAgent(c)
 
 	// Update agent status
-	db.DB.Model(&agent).Updates(map[string]interface{}{
+	db.DB.Model(agent).Updates(map[string]interface{}{
 		"status":    "online",
 		"last_seen": now,
 	})
@@ -206,9 +289,15 @@ func UpdateAgentStatus(c fiber.Ctx) error {
 	}
 	db.DB.Create(&metrics)
 
-	// Store device posture if provided (Zero Trust)
+	// Store device posture if provided (Zero Trust). The score is computed
+	// here from the reported facts, never taken from the agent.
 	if req.Posture != nil {
-		posture := models.DevicePosture{
+		settings, err := posture.LoadSettings()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		report := models.DevicePosture{
 			AgentID:           agent.ID,
 			OSName:            req.Posture.OSName,
 			OSVersion:         req.Posture.OSVersion,
@@ -218,20 +307,101 @@ func UpdateAgentStatus(c fiber.Ctx) error {
 			FirewallEnabled:   req.Posture.FirewallEnabled,
 			DiskEncrypted:     req.Posture.DiskEncrypted,
 			ScreenLockEnabled: req.Posture.ScreenLockEnabled,
-			PostureScore:      req.Posture.PostureScore,
+			PendingPatches:    -1,
+			LastPatchDate:     req.Posture.LastPatchDate,
+			AgentVersion:      req.Posture.AgentVersion,
 			LastChecked:       &now,
 		}
+		if req.Posture.PendingPatches != nil {
+			report.PendingPatches = *req.Posture.PendingPatches
+		}
+		posture.Assess(&report, settings, now)
+
+		var previous models.DevicePosture
+		found := db.DB.Where("agent_id = ?", agent.ID).Limit(1).Find(&previous).RowsAffected > 0
+
+		// Upsert posture (update if exists, create if not). Assign with a
+		// map so checks that turned false are written too.
+		db.DB.Where("agent_id = ?", agent.ID).Assign(map[string]interface{}{
+			"os_name":             report.OSName,
+			"os_version":          report.OSVersion,
+			"hostname":            report.Hostname,
+			"antivirus_enabled":   report.AntivirusEnabled,
+			"antivirus_name":      report.AntivirusName,
+			"firewall_enabled":    report.FirewallEnabled,
+			"disk_encrypted":      report.DiskEncrypted,
+			"screen_lock_enabled": report.ScreenLockEnabled,
+			"pending_patches":     report.PendingPatches,
+			"last_patch_date":     report.LastPatchDate,
+			"agent_version":       report.AgentVersion,
+			"posture_score":       report.PostureScore,
+			"status":              report.Status,
+			"failed_checks":       report.FailedChecks,
+			"last_checked":        now,
+		}).FirstOrCreate(&report)
+
+		if found && previous.Status != report.Status {
+			LogAudit(&agent.ID, "posture_status_changed", map[string]interface{}{
+				"from":          previous.Status,
+				"to":            report.Status,
+				"posture_score": report.PostureScore,
+				"failed_checks": report.FailedChecks,
+				"quarantined":   settings.Quarantine && report.Status != posture.StatusCompliant,
+			}, c)
+		}
+
+		// Policies may require a minimum score, compliance or a profile
+		profilesChanged, err := posture.EvaluateAgent(agent.ID)
+		if err != nil {
+			log.Printf("Failed to evaluate posture profiles of agent %d: %v", agent.ID, err)
+		}
+		if profilesChanged || !found || previous.PostureScore != report.PostureScore || previous.Status != report.Status {
+			service.ReloadPolicies()
+		}
+	}
 
-		// Upsert posture (update if exists, create if not)
-		db.DB.Where("agent_id = ?", agent.ID).Assign(posture).FirstOrCreate(&posture)
+	// Tell the agent what its device has to fix
+	var compliance []models.PostureCompliance
+	db.DB.Preload("Profile").Where("agent_id = ?", agent.ID).Find(&compliance)
+
+	// A changed revision makes the agent refetch the services it exposes
+	_, revision, err := exposedServices(agent.ID)
+	if err != nil {
+		log.Printf("Failed to load services of agent %d: %v", agent.ID, err)
 	}
+	return c.JSON(fiber.Map{
+		"status":            "ok",
+		"compliance":        complianceSummary(compliance),
+		"services_revision": revision,
+		// Subnet routes and the exit node follow approvals and policy changes
+		// without a reconnect
+		"routes":          service.RoutesFor(agent),
+		"approved_routes": ipam.RouteStrings(ipam.ParseRoutes(agent.ApprovedRoutes)),
+		"exit_node":       agent.ExitNode,
+	})
+}
 
-	return c.JSON(fiber.Map{"status": "ok"})
+// complianceSummary lists the posture profiles an agent fails and why
+func complianceSummary(results []models.PostureCompliance) []fiber.Map {
+	summary := []fiber.Map{}
+	for _, r := range results {
+		if r.Compliant || r.Profile == nil {
+			continue
+		}
+		summary = append(summary, fiber.Map{
+			"profile":  r.Profile.Name,
+			"failures": r.Failures,
+		})
+	}
+	return summary
 }
 
 // GetAgentMetrics returns metrics for an agent
 func GetAgentMetrics(c fiber.Ctx) error {
 	id := c.Params("id")
+	if _, err := findAgent(c, db.DB, id, auth.PermViewAgents); err != nil {
+		return agentLookupError(c, err)
+	}
 	limitStr := c.Query("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
@@ -246,6 +416,9 @@ func GetAgentMetrics(c fiber.Ctx) error {
 // GetAccessLogs returns access logs for an agent
 func GetAccessLogs(c fiber.Ctx) error {
 	id := c.Params("id")
+	if !canReadAgentLogs(c, id) {
+		return forbidden(c)
+	}
 	limitStr := c.Query("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
@@ -3,6 +3,7 @@ package handlers
 import (
 	"strconv"
 
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
 	"github.com/gofiber/fiber/v3"
@@ -10,12 +11,16 @@ import (
 
 // ListAuditLogs returns audit logs, optionally filtered by agent
 func ListAuditLogs(c fiber.Ctx) error {
+	if !can(c, auth.PermViewLogs) {
+		return forbidden(c)
+	}
+
 	agentID := c.Query("agent_id")
 	action := c.Query("action")
 	limitStr := c.Query("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
-	query := db.DB.Preload("Agent").Order("created_at DESC").Limit(limit)
+	query := db.DB.Preload("Agent").Preload("User").Order("created_at DESC").Limit(limit)
 
 	if agentID != "" {
 		query = query.Where("agent_id = ?", agentID)
@@ -36,6 +41,9 @@ func ListAuditLogs(c fiber.Ctx) error {
 // GetAgentAuditLogs returns audit logs for a specific agent
 func GetAgentAuditLogs(c fiber.Ctx) error {
 	agentID := c.Params("id")
+	if !canReadAgentLogs(c, agentID) {
+		return forbidden(c)
+	}
 	limitStr := c.Query("limit", "50")
 	limit, _ := strconv.Atoi(limitStr)
 
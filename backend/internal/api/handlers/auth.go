@@ -2,15 +2,30 @@ package handlers
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"net/url"
+	"strings"
+	"time"
 
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/config"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
 	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
 )
 
+// maxClaimCodeAttempts is how many wrong user codes reject a claim
+const maxClaimCodeAttempts = 5
+
+// userCodeAlphabet leaves out characters that are easy to confuse (0/O, 1/I/L)
+const userCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
 // StartClaim initiates a device claiming process (Agent -> Server)
 func StartClaim(c fiber.Ctx) error {
 	type StartClaimRequest struct {
@@ -22,37 +37,59 @@ func StartClaim(c fiber.Ctx) error {
 	if err := c.Bind().Body(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
+	if req.PublicKey == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Public key is required"})
+	}
+	// Public keys are not secret, so a claim never takes over an existing agent
+	if publicKeyRegistered(db.DB, req.PublicKey) {
+		return c.Status(409).JSON(fiber.Map{"error": "An agent with this public key is already registered"})
+	}
 
 	// Generate random token
 	tokenBytes := make([]byte, 32)
-	rand.Read(tokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create claim"})
+	}
 	token := hex.EncodeToString(tokenBytes)
+	userCode, err := newUserCode()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create claim"})
+	}
 
+	now := time.Now()
+	ttl := config.Get().ClaimTTL()
 	claim := models.DeviceClaim{
 		Token:     token,
+		UserCode:  userCode,
 		PublicKey: req.PublicKey,
 		Hostname:  req.Hostname,
 		IP:        c.IP(),
 		Status:    "pending",
+		ExpiresAt: now.Add(ttl),
 	}
 
 	if err := db.DB.Create(&claim).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create claim"})
 	}
 
+	// Old claims are only kept around for a day to answer late polls
+	db.DB.Where("expires_at < ?", now.Add(-24*time.Hour)).Delete(&models.DeviceClaim{})
+
 	// Construct claim URL (pointing to frontend)
-	// Assuming frontend is at referrer or configured origin, but for now hardcoded or derived
-	dashboardURL := "http://localhost:3001" // TODO: Make configurable
-	claimURL := fmt.Sprintf("%s/claim?token=%s", dashboardURL, token)
+	claimURL := fmt.Sprintf("%s/claim?token=%s", config.Get().DashboardURL, token)
 
 	return c.JSON(fiber.Map{
-		"token":     token,
-		"claim_url": claimURL,
-		"status":    "pending",
+		"token":      token,
+		"user_code":  userCode,
+		"claim_url":  claimURL,
+		"status":     "pending",
+		"expires_at": claim.ExpiresAt,
+		"expires_in": int(ttl.Seconds()),
 	})
 }
 
-// GetClaimStatus checks the status of a claim (Agent -> Server polling)
+// GetClaimStatus checks the status of a claim (Agent -> Server polling).
+// The API key is returned by the first poll after approval only.
 func GetClaimStatus(c fiber.Ctx) error {
 	token := c.Query("token")
 	if token == "" {
@@ -64,49 +101,96 @@ func GetClaimStatus(c fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "Claim not found"})
 	}
 
-	if claim.Status == "approved" {
-		// If approved, we need to return the API key.
-		// In a real flow, we'd generate a long-lived API Key bound to the user here.
-		// For now, let's look up if an agent exists or create one.
-
-		// Check if agent already exists with this public key
-		var agent models.Agent
-		if err := db.DB.Where("public_key = ?", claim.PublicKey).First(&agent).Error; err != nil {
-			// Create new agent
-			apiKeyBytes := make([]byte, 32)
-			rand.Read(apiKeyBytes)
-			apiKey := "sk_live_" + hex.EncodeToString(apiKeyBytes)
-
-			agent = models.Agent{
-				Name:      claim.Hostname,
-				PublicKey: claim.PublicKey,
-				APIKey:    apiKey,
-				Status:    "offline", // Will propagate to online on connect
-				UserID:    claim.UserID,
-				// Assign IP later on connect
-			}
-			db.DB.Create(&agent)
-		} else {
-			// Update user binding if needed
-			if agent.UserID == nil && claim.UserID != nil {
-				agent.UserID = claim.UserID
-				db.DB.Save(&agent)
-			}
-		}
-
+	if expireClaim(&claim) || claim.Status != "approved" {
 		return c.JSON(fiber.Map{
-			"status":  "approved",
-			"api_key": agent.APIKey,
+			"status": claim.Status,
 		})
 	}
 
+	// Consume the claim before issuing a key so concurrent polls cannot both
+	// receive one; failures below put it back for the agent to retry
+	result := db.DB.Model(&claim).Where("status = ?", "approved").Update("status", "completed")
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to complete claim"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(fiber.Map{"status": "completed"})
+	}
+	retry := func() {
+		db.DB.Model(&claim).Update("status", "approved")
+	}
+
+	// The key may have been registered since the claim was approved; issuing
+	// a credential for that agent would hand it to whoever started the claim
+	if publicKeyRegistered(db.DB, claim.PublicKey) {
+		db.DB.Model(&claim).Update("status", "failed")
+		LogAudit(nil, "claim_failed", map[string]interface{}{
+			"token_prefix": claim.Token[:8],
+			"hostname":     claim.Hostname,
+			"reason":       "public key already registered",
+		}, c)
+		return c.Status(409).JSON(fiber.Map{
+			"status": "failed",
+			"error":  "An agent with this public key is already registered",
+		})
+	}
+
+	agent := models.Agent{
+		Name:      claim.Hostname,
+		PublicKey: claim.PublicKey,
+		Status:    "offline", // Will propagate to online on connect
+		UserID:    claim.UserID,
+	}
+	if err := db.DB.Create(&agent).Error; err != nil {
+		retry()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create agent"})
+	}
+
+	// Undo the agent before retrying, or its public key would make the next
+	// poll fail the claim and its address would stay allocated
+	undo := func() {
+		db.DB.Unscoped().Delete(&agent)
+		ipam.Pool.Release(agent.ID)
+		retry()
+	}
+
+	if err := assignAgentIP(&agent); err != nil {
+		undo()
+		return c.Status(503).JSON(fiber.Map{"error": "Failed to allocate IP: " + err.Error()})
+	}
+
+	// Keys are stored hashed, so a fresh credential is issued for the handoff
+	apiKey, cred, err := auth.IssueCredential(agent.ID, "device-claim", nil)
+	if err != nil {
+		undo()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue API key"})
+	}
+
+	now := time.Now()
+	db.DB.Model(&claim).Updates(map[string]interface{}{
+		"agent_id":     agent.ID,
+		"completed_at": now,
+	})
+	service.ReloadPolicies()
+
+	LogAudit(&agent.ID, "claim_completed", map[string]interface{}{
+		"token_prefix": claim.Token[:8],
+		"hostname":     claim.Hostname,
+		"key_prefix":   cred.Prefix,
+	}, c)
+
 	return c.JSON(fiber.Map{
-		"status": claim.Status,
+		"status":  "approved",
+		"api_key": apiKey,
 	})
 }
 
 // GetClaimDetails returns claim info for the user approval page (Frontend -> Server)
 func GetClaimDetails(c fiber.Ctx) error {
+	if !can(c, auth.PermOwnAgents) {
+		return forbidden(c)
+	}
+
 	token := c.Query("token")
 	if token == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Token required"})
@@ -116,15 +200,22 @@ func GetClaimDetails(c fiber.Ctx) error {
 	if err := db.DB.Where("token = ?", token).First(&claim).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Claim not found"})
 	}
+	expireClaim(&claim)
 
 	return c.JSON(claim)
 }
 
-// ApproveClaim allows a user to approve a device claim (Frontend -> Server)
+// ApproveClaim allows the signed-in user to approve a device claim (Frontend -> Server).
+// The user code displayed by the agent must be entered to prove the
+// approver is looking at the device that started the claim.
 func ApproveClaim(c fiber.Ctx) error {
+	if !can(c, auth.PermOwnAgents) {
+		return forbidden(c)
+	}
+
 	type ApproveRequest struct {
-		Token string `json:"token"`
-		Email string `json:"email"` // Mock identity for now
+		Token    string `json:"token"`
+		UserCode string `json:"user_code"`
 	}
 
 	var req ApproveRequest
@@ -132,20 +223,41 @@ func ApproveClaim(c fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	// 1. Find or Create User (Mock User Auth)
-	// In real OIDC, the user ID would come from the session/token context
-	var user models.User
-	if err := db.DB.Where("email = ?", req.Email).FirstOrCreate(&user, models.User{
-		Email:    req.Email,
-		Provider: "mock",
-		Role:     "user",
-	}).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to resolve user"})
+	claim, err := pendingClaim(req.Token)
+	if err != nil {
+		return claimLookupError(c, err)
+	}
+
+	// The claim is bound to the authenticated user
+	user := middleware.CurrentUser(c)
+
+	if subtle.ConstantTimeCompare([]byte(normalizeUserCode(req.UserCode)), []byte(normalizeUserCode(claim.UserCode))) != 1 {
+		claim.FailedAttempts++
+		updates := map[string]interface{}{"failed_attempts": claim.FailedAttempts}
+		if claim.FailedAttempts >= maxClaimCodeAttempts {
+			updates["status"] = "rejected"
+		}
+		db.DB.Model(claim).Where("status = ?", "pending").Updates(updates)
+
+		LogAudit(nil, "claim_code_mismatch", map[string]interface{}{
+			"token_prefix": claim.Token[:8],
+			"attempts":     claim.FailedAttempts,
+			"user":         user.Email,
+		}, c)
+		if claim.FailedAttempts >= maxClaimCodeAttempts {
+			return c.Status(410).JSON(fiber.Map{"error": "Too many wrong codes, the claim was rejected"})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": "Code does not match the one shown on the device"})
+	}
+
+	if publicKeyRegistered(db.DB, claim.PublicKey) {
+		db.DB.Model(claim).Where("status = ?", "pending").Update("status", "failed")
+		return c.Status(409).JSON(fiber.Map{"error": "An agent with this public key is already registered"})
 	}
 
-	// 2. Update Claim
+	// Update Claim
 	result := db.DB.Model(&models.DeviceClaim{}).
-		Where("token = ? AND status = 'pending'", req.Token).
+		Where("id = ? AND status = 'pending'", claim.ID).
 		Updates(map[string]interface{}{
 			"status":  "approved",
 			"user_id": user.ID,
@@ -155,28 +267,200 @@ func ApproveClaim(c fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "Claim invalid or already processed"})
 	}
 
+	LogAudit(nil, "claim_approved", map[string]interface{}{
+		"token_prefix": claim.Token[:8],
+		"hostname":     claim.Hostname,
+		"approved_by":  user.Email,
+		"provider":     user.Provider,
+	}, c)
+
 	return c.JSON(fiber.Map{
 		"status": "approved",
 		"user":   user.Email,
 	})
 }
 
-// MockLogin performs a dev-mode login (Frontend -> Server)
-func MockLogin(c fiber.Ctx) error {
+// RejectClaim lets the signed-in user refuse a device claim (Frontend -> Server)
+func RejectClaim(c fiber.Ctx) error {
+	if !can(c, auth.PermOwnAgents) {
+		return forbidden(c)
+	}
+
+	type RejectRequest struct {
+		Token string `json:"token"`
+	}
+
+	var req RejectRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	claim, err := pendingClaim(req.Token)
+	if err != nil {
+		return claimLookupError(c, err)
+	}
+
+	user := middleware.CurrentUser(c)
+	result := db.DB.Model(&models.DeviceClaim{}).
+		Where("id = ? AND status = 'pending'", claim.ID).
+		Updates(map[string]interface{}{
+			"status":  "rejected",
+			"user_id": user.ID,
+		})
+
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Claim invalid or already processed"})
+	}
+
+	LogAudit(nil, "claim_rejected", map[string]interface{}{
+		"token_prefix": claim.Token[:8],
+		"hostname":     claim.Hostname,
+		"ip":           claim.IP,
+		"rejected_by":  user.Email,
+	}, c)
+
+	return c.JSON(fiber.Map{
+		"status": "rejected",
+	})
+}
+
+var (
+	errClaimNotFound  = errors.New("claim not found")
+	errClaimExpired   = errors.New("claim expired")
+	errClaimProcessed = errors.New("claim already processed")
+)
+
+// publicKeyRegistered reports whether an agent already uses publicKey
+func publicKeyRegistered(tx *gorm.DB, publicKey string) bool {
+	var count int64
+	tx.Model(&models.Agent{}).Where("public_key = ?", publicKey).Count(&count)
+	return count > 0
+}
+
+// pendingClaim loads a claim that is still awaiting a decision
+func pendingClaim(token string) (*models.DeviceClaim, error) {
+	var claim models.DeviceClaim
+	if token == "" || db.DB.Where("token = ?", token).First(&claim).Error != nil {
+		return nil, errClaimNotFound
+	}
+	if expireClaim(&claim) || claim.Status == "expired" {
+		return nil, errClaimExpired
+	}
+	if claim.Status != "pending" {
+		return nil, errClaimProcessed
+	}
+	return &claim, nil
+}
+
+// claimLookupError maps a pendingClaim error to a response
+func claimLookupError(c fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errClaimExpired):
+		return c.Status(410).JSON(fiber.Map{"error": "Claim has expired, start a new one on the device"})
+	case errors.Is(err, errClaimProcessed):
+		return c.Status(409).JSON(fiber.Map{"error": "Claim already processed"})
+	default:
+		return c.Status(404).JSON(fiber.Map{"error": "Claim not found"})
+	}
+}
+
+// expireClaim marks a pending or approved claim past its TTL as expired and
+// reports whether it did
+func expireClaim(claim *models.DeviceClaim) bool {
+	if claim.Status != "pending" && claim.Status != "approved" {
+		return false
+	}
+	if time.Now().Before(claim.ExpiresAt) {
+		return false
+	}
+	db.DB.Model(claim).Where("status = ?", claim.Status).Update("status", "expired")
+	claim.Status = "expired"
+	return true
+}
+
+// newUserCode returns a short code such as "KQ7M-3XPD" for the user to
+// compare between device and dashboard
+func newUserCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 9)
+	for i, b := range buf {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, userCodeAlphabet[int(b)%len(userCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode makes codes comparable regardless of case and separators
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// Login exchanges email and password for a signed access token (Frontend -> Server)
+func Login(c fiber.Ctx) error {
 	type LoginRequest struct {
-		Email string `json:"email"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
 	}
 	var req LoginRequest
 	if err := c.Bind().Body(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	// Just return success for dev mode
+	var user models.User
+	if err := db.DB.Where("email = ?", strings.ToLower(strings.TrimSpace(req.Email))).First(&user).Error; err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid email or password"})
+	}
+	if !auth.CheckPassword(user.PasswordHash, req.Password) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid email or password"})
+	}
+
+	return issueSession(c, &user)
+}
+
+// Me returns the currently authenticated user and what their role allows
+func Me(c fiber.Ctx) error {
+	user := middleware.CurrentUser(c)
+	return c.JSON(fiber.Map{
+		"user":        user,
+		"permissions": auth.Permissions(user.Role),
+	})
+}
+
+// issueSession records the login and returns a fresh access token for user
+func issueSession(c fiber.Ctx, user *models.User) error {
+	token, expires, err := newSession(user)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue token"})
+	}
+
 	return c.JSON(fiber.Map{
-		"token": "dev-token-" + url.QueryEscape(req.Email),
+		"token":      token,
+		"expires_at": expires,
 		"user": fiber.Map{
-			"email": req.Email,
-			"role":  "admin",
+			"id":    user.ID,
+			"email": user.Email,
+			"role":  user.Role,
 		},
 	})
 }
+
+// newSession issues an access token for user and records the login time
+func newSession(user *models.User) (string, time.Time, error) {
+	token, expires, err := auth.IssueToken(user.ID, user.Email, user.Role)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	db.DB.Model(user).Update("last_login_at", time.Now())
+	return token, expires, nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// withPool replaces the global address pool for one test
+func withPool(t *testing.T, prefix string) {
+	t.Helper()
+	previous := ipam.Pool
+	if err := ipam.Init(netip.MustParsePrefix(prefix)); err != nil {
+		t.Fatalf("ipam.Init: %v", err)
+	}
+	t.Cleanup(func() { ipam.Pool = previous })
+}
+
+func TestGetClaimStatus(t *testing.T) {
+	app := newTestApp(t, &models.DeviceClaim{}, &models.Agent{}, &models.AgentCredential{}, &models.IPAllocation{})
+	app.Get("/claim/status", GetClaimStatus)
+
+	const publicKey = "cGVuZGluZy1jbGFpbS1wdWJsaWMta2V5LTAxMjM0NTY="
+	claim := models.DeviceClaim{
+		Token:     "0123456789abcdef",
+		PublicKey: publicKey,
+		Hostname:  "laptop",
+		Status:    "approved",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	dbtest.Create(t, &claim)
+	poll := func() (int, map[string]interface{}) {
+		return call(t, app, "GET", "/claim/status?token="+claim.Token, "", nil)
+	}
+	agents := func() int64 {
+		var count int64
+		db.DB.Unscoped().Model(&models.Agent{}).Where("public_key = ?", publicKey).Count(&count)
+		return count
+	}
+
+	// A /31 has no address to hand out, so setting up the agent fails
+	withPool(t, "10.0.0.0/31")
+	if status, body := poll(); status != 503 {
+		t.Fatalf("poll with an exhausted pool = %d %v, want 503", status, body)
+	}
+	if n := agents(); n != 0 {
+		t.Fatalf("failed claim left %d agent rows behind", n)
+	}
+	db.DB.First(&claim, claim.ID)
+	if claim.Status != "approved" {
+		t.Fatalf("claim status after failure = %q, want approved", claim.Status)
+	}
+
+	// Once addresses are available the agent's next poll succeeds
+	withPool(t, "10.0.0.0/24")
+	status, body := poll()
+	if status != 200 || body["status"] != "approved" || body["api_key"] == nil {
+		t.Fatalf("retried poll = %d %v, want the API key", status, body)
+	}
+	var agent models.Agent
+	if err := db.DB.Where("public_key = ?", publicKey).First(&agent).Error; err != nil {
+		t.Fatalf("agent not created: %v", err)
+	}
+	if agent.IP != "10.0.0.1" {
+		t.Errorf("agent IP = %q, want 10.0.0.1", agent.IP)
+	}
+	db.DB.First(&claim, claim.ID)
+	if claim.Status != "completed" || claim.AgentID == nil || *claim.AgentID != agent.ID {
+		t.Errorf("claim = %s for agent %v, want completed for %d", claim.Status, claim.AgentID, agent.ID)
+	}
+
+	// The key is handed out once
+	if status, body := poll(); status != 200 || body["status"] != "completed" || body["api_key"] != nil {
+		t.Errorf("poll after completion = %d %v", status, body)
+	}
+	if n := agents(); n != 1 {
+		t.Errorf("%d agents with the claimed key, want 1", n)
+	}
+}
+
+func TestGetClaimStatusRegisteredKey(t *testing.T) {
+	app := newTestApp(t, &models.DeviceClaim{}, &models.Agent{}, &models.AgentCredential{}, &models.IPAllocation{})
+	app.Get("/claim/status", GetClaimStatus)
+	withPool(t, "10.0.0.0/24")
+
+	const publicKey = "cmVnaXN0ZXJlZC1hZ2VudC1wdWJsaWMta2V5LTAxMjM="
+	dbtest.Create(t, &models.Agent{Name: "existing", PublicKey: publicKey})
+	claim := models.DeviceClaim{Token: "fedcba9876543210", PublicKey: publicKey, Hostname: "laptop",
+		Status: "approved", ExpiresAt: time.Now().Add(time.Hour)}
+	dbtest.Create(t, &claim)
+
+	status, body := call(t, app, "GET", "/claim/status?token="+claim.Token, "", nil)
+	if status != 409 || body["api_key"] != nil {
+		t.Fatalf("poll = %d %v, want 409 without a key", status, body)
+	}
+	db.DB.First(&claim, claim.ID)
+	if claim.Status != "failed" {
+		t.Errorf("claim status = %q, want failed", claim.Status)
+	}
+}
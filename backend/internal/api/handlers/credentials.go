@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/gofiber/fiber/v3"
+)
+
+// ListAgentCredentials returns an agent's API keys (prefixes and metadata only)
+func ListAgentCredentials(c fiber.Ctx) error {
+	agent, err := findAgent(c, db.DB, c.Params("id"), auth.PermViewAgents)
+	if err != nil {
+		return agentLookupError(c, err)
+	}
+
+	var creds []models.AgentCredential
+	if err := db.DB.Where("agent_id = ?", agent.ID).Order("created_at DESC").Find(&creds).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(creds)
+}
+
+// CreateAgentCredential issues an additional named API key for an agent
+func CreateAgentCredential(c fiber.Ctx) error {
+	agent, err := findAgent(c, db.DB, c.Params("id"), auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
+	}
+
+	type CreateRequest struct {
+		Name      string `json:"name"`
+		ExpiresIn string `json:"expires_in"` // Go duration, empty for no expiry
+	}
+
+	var req CreateRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid expires_in"})
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	key, cred, err := auth.IssueCredential(agent.ID, req.Name, expiresAt)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(&agent.ID, "credential_created", map[string]interface{}{
+		"name":   cred.Name,
+		"prefix": cred.Prefix,
+	}, c)
+
+	// The key is only ever returned here
+	return c.Status(201).JSON(fiber.Map{
+		"api_key":    key,
+		"credential": cred,
+	})
+}
+
+// RevokeAgentCredential deletes one of an agent's API keys
+func RevokeAgentCredential(c fiber.Ctx) error {
+	agent, err := findAgent(c, db.DB, c.Params("id"), auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
+	}
+
+	var cred models.AgentCredential
+	if err := db.DB.Where("id = ? AND agent_id = ?", c.Params("credentialId"), agent.ID).First(&cred).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Credential not found"})
+	}
+	if err := db.DB.Delete(&cred).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(&agent.ID, "credential_revoked", map[string]interface{}{
+		"name":   cred.Name,
+		"prefix": cred.Prefix,
+	}, c)
+
+	return c.SendStatus(204)
+}
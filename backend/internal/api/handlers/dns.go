@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/dns"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+// ListDNSRecords returns all custom DNS records
+func ListDNSRecords(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	var records []models.DNSRecord
+	if err := db.DB.Order("name ASC, type ASC").Find(&records).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(records)
+}
+
+// CreateDNSRecord adds a custom A, CNAME or SRV record to the overlay DNS
+func CreateDNSRecord(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var record models.DNSRecord
+	if err := c.Bind().Body(&record); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	record.ID = 0
+	if status, msg := checkDNSRecord(&record); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := db.DB.Create(&record).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadDNS()
+
+	LogAudit(nil, "dns_record_created", map[string]interface{}{
+		"record_id": record.ID,
+		"record":    record,
+	}, c)
+	return c.Status(201).JSON(record)
+}
+
+// UpdateDNSRecord replaces a custom DNS record
+func UpdateDNSRecord(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var record models.DNSRecord
+	if err := db.DB.First(&record, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "DNS record not found"})
+	}
+
+	// The body is the complete record
+	var updated models.DNSRecord
+	if err := c.Bind().Body(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	updated.ID = record.ID
+	updated.CreatedAt = record.CreatedAt
+	if status, msg := checkDNSRecord(&updated); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := db.DB.Save(&updated).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadDNS()
+
+	LogAudit(nil, "dns_record_updated", map[string]interface{}{
+		"record_id": record.ID,
+		"before":    record,
+		"after":     updated,
+	}, c)
+	return c.JSON(updated)
+}
+
+// DeleteDNSRecord removes a custom DNS record
+func DeleteDNSRecord(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var record models.DNSRecord
+	if err := db.DB.First(&record, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "DNS record not found"})
+	}
+	if err := db.DB.Delete(&record).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadDNS()
+
+	LogAudit(nil, "dns_record_deleted", map[string]interface{}{
+		"record_id": record.ID,
+		"name":      record.Name,
+		"type":      record.Type,
+		"value":     record.Value,
+	}, c)
+	return c.SendStatus(204)
+}
+
+// checkDNSRecord validates a record and checks it against the records
+// already visible to the same agents. A CNAME must be the only record of
+// its name. It returns the status and message of the response to send, or
+// zero if the record is fine.
+func checkDNSRecord(record *models.DNSRecord) (int, string) {
+	if err := dns.NormalizeRecord(record); err != nil {
+		return 400, "Invalid DNS record: " + err.Error()
+	}
+	if record.GroupID != nil && !groupExists(*record.GroupID) {
+		return 400, "Group not found"
+	}
+
+	var existing []models.DNSRecord
+	if err := overlappingScope(db.DB, record.GroupID).
+		Where("name = ? AND id <> ?", record.Name, record.ID).Find(&existing).Error; err != nil {
+		return 500, err.Error()
+	}
+	for _, other := range existing {
+		if other.Type == dns.TypeCNAME || record.Type == dns.TypeCNAME {
+			return 409, "A CNAME must be the only record named " + record.Name
+		}
+		if other.Type == record.Type && other.Value == record.Value && other.Port == record.Port {
+			return 409, "An identical record already exists"
+		}
+	}
+	return 0, ""
+}
+
+// ListDNSForwarders returns all split DNS forwarders
+func ListDNSForwarders(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	var forwarders []models.DNSForwarder
+	if err := db.DB.Order("domain ASC").Find(&forwarders).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(forwarders)
+}
+
+// CreateDNSForwarder sends queries for a domain to the given resolvers
+func CreateDNSForwarder(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var forwarder models.DNSForwarder
+	if err := c.Bind().Body(&forwarder); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	forwarder.ID = 0
+	if status, msg := checkDNSForwarder(&forwarder); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := db.DB.Create(&forwarder).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadDNS()
+
+	LogAudit(nil, "dns_forwarder_created", map[string]interface{}{
+		"forwarder_id": forwarder.ID,
+		"forwarder":    forwarder,
+	}, c)
+	return c.Status(201).JSON(forwarder)
+}
+
+// UpdateDNSForwarder replaces a split DNS forwarder
+func UpdateDNSForwarder(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var forwarder models.DNSForwarder
+	if err := db.DB.First(&forwarder, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "DNS forwarder not found"})
+	}
+
+	// The body is the complete forwarder
+	var updated models.DNSForwarder
+	if err := c.Bind().Body(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	updated.ID = forwarder.ID
+	updated.CreatedAt = forwarder.CreatedAt
+	if status, msg := checkDNSForwarder(&updated); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := db.DB.Save(&updated).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadDNS()
+
+	LogAudit(nil, "dns_forwarder_updated", map[string]interface{}{
+		"forwarder_id": forwarder.ID,
+		"before":       forwarder,
+		"after":        updated,
+	}, c)
+	return c.JSON(updated)
+}
+
+// DeleteDNSForwarder removes a split DNS forwarder; its domain is resolved
+// upstream again
+func DeleteDNSForwarder(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var forwarder models.DNSForwarder
+	if err := db.DB.First(&forwarder, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "DNS forwarder not found"})
+	}
+	if err := db.DB.Delete(&forwarder).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadDNS()
+
+	LogAudit(nil, "dns_forwarder_deleted", map[string]interface{}{
+		"forwarder_id": forwarder.ID,
+		"domain":       forwarder.Domain,
+		"servers":      forwarder.Servers,
+	}, c)
+	return c.SendStatus(204)
+}
+
+// checkDNSForwarder validates a forwarder; each domain has at most one
+// forwarder per group and one for everybody
+func checkDNSForwarder(forwarder *models.DNSForwarder) (int, string) {
+	if err := dns.NormalizeForwarder(forwarder, service.OverlayDomain()); err != nil {
+		return 400, "Invalid DNS forwarder: " + err.Error()
+	}
+	if forwarder.GroupID != nil && !groupExists(*forwarder.GroupID) {
+		return 400, "Group not found"
+	}
+
+	query := db.DB.Model(&models.DNSForwarder{}).Where("domain = ? AND id <> ?", forwarder.Domain, forwarder.ID)
+	if forwarder.GroupID == nil {
+		query = query.Where("group_id IS NULL")
+	} else {
+		query = query.Where("group_id = ?", *forwarder.GroupID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 500, err.Error()
+	}
+	if count > 0 {
+		return 409, "A forwarder for " + forwarder.Domain + " already exists"
+	}
+	return 0, ""
+}
+
+// SetGroupDNS sets the search domains of a group's agents
+func SetGroupDNS(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var group models.Group
+	if err := db.DB.First(&group, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Group not found"})
+	}
+	var req struct {
+		SearchDomains []string `json:"search_domains"`
+	}
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	domains, err := dns.NormalizeSearchDomains(req.SearchDomains, service.OverlayDomain())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid search domains: " + err.Error()})
+	}
+
+	previous := group.DNSSearchDomains
+	if err := db.DB.Model(&group).Select("dns_search_domains").
+		Updates(models.Group{DNSSearchDomains: domains}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	group.DNSSearchDomains = domains
+
+	LogAudit(nil, "group_dns_updated", map[string]interface{}{
+		"group_id":       group.ID,
+		"before":         previous,
+		"search_domains": domains,
+	}, c)
+	return c.JSON(group)
+}
+
+// overlappingScope limits a query to records visible to some of the agents
+// that would see a record scoped to group
+func overlappingScope(query *gorm.DB, group *uint) *gorm.DB {
+	if group == nil {
+		return query
+	}
+	return query.Where("group_id IS NULL OR group_id = ?", *group)
+}
+
+func groupExists(id uint) bool {
+	var count int64
+	db.DB.Model(&models.Group{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
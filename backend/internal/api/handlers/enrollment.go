@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+var errAgentExists = errors.New("agent already registered")
+
+// ListEnrollmentKeys returns all enrollment keys (prefixes and metadata only)
+func ListEnrollmentKeys(c fiber.Ctx) error {
+	if !can(c, auth.PermManageAgents) {
+		return forbidden(c)
+	}
+
+	var keys []models.EnrollmentKey
+	if err := db.DB.Preload("Group").Order("created_at DESC").Find(&keys).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(keys)
+}
+
+// CreateEnrollmentKey issues a key agents can enroll themselves with
+func CreateEnrollmentKey(c fiber.Ctx) error {
+	if !can(c, auth.PermManageAgents) {
+		return forbidden(c)
+	}
+
+	type CreateRequest struct {
+		Name      string   `json:"name"`
+		Reusable  bool     `json:"reusable"`
+		MaxUses   int      `json:"max_uses"`   // reusable keys only, 0 for unlimited
+		ExpiresIn string   `json:"expires_in"` // Go duration, empty for no expiry
+		GroupID   *uint    `json:"group_id"`
+		Tags      []string `json:"tags"`
+	}
+
+	var req CreateRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+	if req.MaxUses < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "max_uses cannot be negative"})
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid expires_in"})
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	if req.GroupID != nil {
+		var group models.Group
+		if err := db.DB.First(&group, *req.GroupID).Error; err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Group not found"})
+		}
+	}
+
+	tags := make([]string, 0, len(req.Tags))
+	for _, t := range req.Tags {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	tagsJSON, _ := json.Marshal(tags)
+
+	key := models.EnrollmentKey{
+		Name:        req.Name,
+		Reusable:    req.Reusable,
+		MaxUses:     req.MaxUses,
+		ExpiresAt:   expiresAt,
+		GroupID:     req.GroupID,
+		Tags:        string(tagsJSON),
+		CreatedByID: &middleware.CurrentUser(c).ID,
+	}
+	plaintext, err := auth.IssueEnrollmentKey(&key)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "enrollment_key_created", map[string]interface{}{
+		"enrollment_key_id": key.ID,
+		"name":              key.Name,
+		"prefix":            key.Prefix,
+		"reusable":          key.Reusable,
+		"max_uses":          key.MaxUses,
+		"group_id":          key.GroupID,
+		"tags":              tags,
+	}, c)
+
+	// The key is only ever returned here
+	return c.Status(201).JSON(fiber.Map{
+		"enroll_key":     plaintext,
+		"enrollment_key": key,
+	})
+}
+
+// RevokeEnrollmentKey stops a key from enrolling further agents. Agents
+// already enrolled with it keep working.
+func RevokeEnrollmentKey(c fiber.Ctx) error {
+	if !can(c, auth.PermManageAgents) {
+		return forbidden(c)
+	}
+
+	var key models.EnrollmentKey
+	if err := db.DB.First(&key, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Enrollment key not found"})
+	}
+	if err := db.DB.Delete(&key).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "enrollment_key_revoked", map[string]interface{}{
+		"enrollment_key_id": key.ID,
+		"name":              key.Name,
+		"prefix":            key.Prefix,
+		"uses":              key.Uses,
+	}, c)
+
+	return c.SendStatus(204)
+}
+
+// Enroll registers an agent using an enrollment key (Agent -> Server). The
+// agent is created with the key's group and tags and receives its API key.
+func Enroll(c fiber.Ctx) error {
+	type EnrollRequest struct {
+		EnrollKey string `json:"enroll_key"`
+		PublicKey string `json:"public_key"`
+		Hostname  string `json:"hostname"`
+	}
+
+	var req EnrollRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.EnrollKey == "" || req.PublicKey == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Enrollment key and public key are required"})
+	}
+	if req.Hostname == "" {
+		req.Hostname = "agent"
+	}
+
+	var agent models.Agent
+	var key *models.EnrollmentKey
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Public keys are not secret, so never attach to an existing agent
+		if publicKeyRegistered(tx, req.PublicKey) {
+			return errAgentExists
+		}
+
+		var err error
+		if key, err = auth.RedeemEnrollmentKey(tx, req.EnrollKey); err != nil {
+			return err
+		}
+
+		agent = models.Agent{
+			Name:            req.Hostname,
+			PublicKey:       req.PublicKey,
+			Status:          "offline", // Will propagate to online on connect
+			GroupID:         key.GroupID,
+			Tags:            key.Tags,
+			UserID:          key.CreatedByID,
+			EnrollmentKeyID: &key.ID,
+		}
+		return tx.Create(&agent).Error
+	})
+	switch {
+	case errors.Is(err, auth.ErrInvalidEnrollmentKey):
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid, expired or used up enrollment key"})
+	case errors.Is(err, errAgentExists):
+		return c.Status(409).JSON(fiber.Map{"error": "An agent with this public key is already registered"})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to enroll agent"})
+	}
+
+	// Undo the agent and give the use back if the agent cannot be set up
+	undo := func() {
+		db.DB.Unscoped().Delete(&agent)
+		ipam.Pool.Release(agent.ID)
+		db.DB.Model(key).UpdateColumn("uses", gorm.Expr("uses - 1"))
+	}
+
+	if err := assignAgentIP(&agent); err != nil {
+		undo()
+		return c.Status(503).JSON(fiber.Map{"error": "Failed to allocate IP: " + err.Error()})
+	}
+
+	apiKey, cred, err := auth.IssueCredential(agent.ID, "enrollment", nil)
+	if err != nil {
+		undo()
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue API key"})
+	}
+	service.ReloadPolicies()
+	service.RequestPeerSync()
+
+	LogAudit(&agent.ID, "agent_enrolled", map[string]interface{}{
+		"enrollment_key_id":   key.ID,
+		"enrollment_key_name": key.Name,
+		"enrollment_key":      key.Prefix,
+		"hostname":            agent.Name,
+		"group_id":            agent.GroupID,
+		"key_prefix":          cred.Prefix,
+	}, c)
+
+	return c.Status(201).JSON(fiber.Map{
+		"status":   "enrolled",
+		"agent_id": agent.ID,
+		"ip":       agent.IP,
+		"api_key":  apiKey,
+	})
+}
@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
 	"github.com/gofiber/fiber/v3"
 )
 
 // ListGroups returns all groups
 func ListGroups(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
 	var groups []models.Group
 	if err := db.DB.Preload("Agents").Find(&groups).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -17,6 +23,10 @@ func ListGroups(c fiber.Ctx) error {
 
 // CreateGroup creates a new group
 func CreateGroup(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
 	var group models.Group
 	if err := c.Bind().Body(&group); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
@@ -35,6 +45,10 @@ func CreateGroup(c fiber.Ctx) error {
 
 // GetGroup returns group by ID with agents
 func GetGroup(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	var group models.Group
 	if err := db.DB.Preload("Agents").First(&group, id).Error; err != nil {
@@ -45,6 +59,10 @@ func GetGroup(c fiber.Ctx) error {
 
 // UpdateGroup updates a group
 func UpdateGroup(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	var group models.Group
 	if err := db.DB.First(&group, id).Error; err != nil {
@@ -56,15 +74,23 @@ func UpdateGroup(c fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
+	// Search domains are validated by SetGroupDNS
+	updates.DNSSearchDomains = nil
+
 	db.DB.Model(&group).Updates(updates)
 	return c.JSON(group)
 }
 
 // DeleteGroup soft deletes a group
 func DeleteGroup(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	if err := db.DB.Delete(&models.Group{}, id).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	service.ReloadPolicies()
 	return c.SendStatus(204)
 }
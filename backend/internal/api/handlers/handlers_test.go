@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/gofiber/fiber/v3"
+)
+
+// newTestApp returns an empty app backed by a fresh database with the
+// user and audit tables plus the given models migrated
+func newTestApp(t *testing.T, tables ...interface{}) *fiber.App {
+	t.Helper()
+	dbtest.Open(t, append([]interface{}{&models.User{}, &models.AuditLog{}}, tables...)...)
+	auth.Init([]byte("handler-test-signing-key"), time.Hour)
+	return fiber.New()
+}
+
+// signIn creates a user with role and returns an access token for it
+func signIn(t *testing.T, role string) string {
+	t.Helper()
+	user := models.User{Email: role + "@example.com", Role: role}
+	dbtest.Create(t, &user)
+	token, _, err := auth.IssueToken(user.ID, user.Email, user.Role)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	return token
+}
+
+// call sends a request with an optional JSON body and bearer token and
+// returns the status code and decoded JSON response
+func call(t *testing.T, app *fiber.App, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var out map[string]interface{}
+	if raw, _ := io.ReadAll(resp.Body); bytes.HasPrefix(raw, []byte("{")) {
+		if err := json.Unmarshal(raw, &out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, path, raw, err)
+		}
+	}
+	return resp.StatusCode, out
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/gofiber/fiber/v3"
+)
+
+// GetIPAM returns overlay pool usage and all allocations
+func GetIPAM(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	var allocations []models.IPAllocation
+	if err := db.DB.Preload("Agent").Order("id ASC").Find(&allocations).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"stats":       ipam.Pool.Stats(),
+		"allocations": allocations,
+	})
+}
+
+// CreateIPReservation reserves a static overlay address, optionally for an agent
+func CreateIPReservation(c fiber.Ctx) error {
+	if !can(c, auth.PermManageIPAM) {
+		return forbidden(c)
+	}
+
+	type ReservationRequest struct {
+		Address     string `json:"address"`
+		AgentID     *uint  `json:"agent_id"`
+		Description string `json:"description"`
+	}
+
+	var req ReservationRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	addr, err := netip.ParseAddr(req.Address)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid address"})
+	}
+
+	if req.AgentID != nil {
+		var agent models.Agent
+		if err := db.DB.First(&agent, *req.AgentID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+		}
+	}
+
+	alloc, err := ipam.Pool.Reserve(addr, req.AgentID, req.Description)
+	if err != nil {
+		status := 409
+		if errors.Is(err, ipam.ErrOutOfRange) || errors.Is(err, ipam.ErrUnavailable) {
+			status = 400
+		}
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(req.AgentID, "ip_reserved", map[string]interface{}{
+		"address":     alloc.Address,
+		"description": alloc.Description,
+	}, c)
+
+	return c.Status(201).JSON(alloc)
+}
+
+// DeleteIPReservation removes a static reservation not bound to an agent
+func DeleteIPReservation(c fiber.Ctx) error {
+	if !can(c, auth.PermManageIPAM) {
+		return forbidden(c)
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid id"})
+	}
+
+	if err := ipam.Pool.Unreserve(uint(id)); err != nil {
+		if errors.Is(err, ipam.ErrNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "Reservation not found"})
+		}
+		if errors.Is(err, ipam.ErrAgentBinding) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "ip_unreserved", map[string]interface{}{
+		"reservation_id": id,
+	}, c)
+
+	return c.SendStatus(204)
+}
+
+// assignAgentIP allocates an overlay address for an agent that has none
+func assignAgentIP(agent *models.Agent) error {
+	if agent.IP != "" {
+		return nil
+	}
+	addr, err := ipam.Pool.Allocate(agent.ID)
+	if err != nil {
+		return err
+	}
+	agent.IP = addr.String()
+	return db.DB.Model(agent).Update("ip", agent.IP).Error
+}
@@ -1,15 +1,31 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/geoip"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
 	"github.com/gofiber/fiber/v3"
 )
 
 // ListPolicies returns all policies
 func ListPolicies(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
 	var policies []models.Policy
-	if err := db.DB.Preload("SourceGroup").Preload("DestGroup").Find(&policies).Error; err != nil {
+	if err := db.DB.Preload("SourceGroup").Preload("DestGroup").Order("priority ASC, id ASC").Find(&policies).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(policies)
@@ -17,6 +33,10 @@ func ListPolicies(c fiber.Ctx) error {
 
 // CreatePolicy creates a new policy
 func CreatePolicy(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
 	var policy models.Policy
 	if err := c.Bind().Body(&policy); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
@@ -25,16 +45,50 @@ func CreatePolicy(c fiber.Ctx) error {
 	if policy.Name == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
 	}
+	if policy.Action == "" {
+		policy.Action = "allow"
+	}
+	if policy.Priority == 0 {
+		policy.Priority = defaultPolicyPriority
+	}
+	if err := checkPolicyOrder(&policy); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid policy: " + err.Error()})
+	}
+	if policy.ValidFrom != nil && policy.ValidUntil != nil && !policy.ValidUntil.After(*policy.ValidFrom) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid policy: valid_until must be after valid_from"})
+	}
+
+	ports, err := normalizeAllowedPorts(policy.AllowedPorts)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid allowed_ports: " + err.Error()})
+	}
+	policy.AllowedPorts = ports
+	regions, err := normalizeRegions(policy.AllowedRegions)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid allowed_regions: " + err.Error()})
+	}
+	policy.AllowedRegions = regions
+	if policy.PostureProfileID != nil && !postureProfileExists(*policy.PostureProfileID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Posture profile not found"})
+	}
+	if policy.ScheduleID != nil && !scheduleExists(*policy.ScheduleID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Schedule not found"})
+	}
 
 	if err := db.DB.Create(&policy).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	service.ReloadPolicies()
 
 	return c.Status(201).JSON(policy)
 }
 
 // GetPolicy returns policy by ID
 func GetPolicy(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	var policy models.Policy
 	if err := db.DB.Preload("SourceGroup").Preload("DestGroup").First(&policy, id).Error; err != nil {
@@ -45,26 +99,292 @@ func GetPolicy(c fiber.Ctx) error {
 
 // UpdatePolicy updates a policy
 func UpdatePolicy(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	var policy models.Policy
 	if err := db.DB.First(&policy, id).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Policy not found"})
 	}
 
-	var updates models.Policy
-	if err := c.Bind().Body(&updates); err != nil {
+	// The body is decoded over the stored policy so fields that are left out
+	// keep their value while false, "" and null clear the ones that are sent
+	var sent map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &sent); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
+	updated := policy
+	if err := json.Unmarshal(c.Body(), &updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	var columns []string
+	for _, column := range policyColumns {
+		if _, ok := sent[column]; ok {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No fields to update"})
+	}
 
-	db.DB.Model(&policy).Updates(updates)
+	if strings.TrimSpace(updated.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is required"})
+	}
+	if updated.Action == "" {
+		updated.Action = "allow"
+	}
+	if updated.Priority == 0 {
+		updated.Priority = defaultPolicyPriority
+	}
+	if err := checkPolicyOrder(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid policy: " + err.Error()})
+	}
+	if updated.ValidFrom != nil && updated.ValidUntil != nil && !updated.ValidUntil.After(*updated.ValidFrom) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid policy: valid_until must be after valid_from"})
+	}
+	ports, err := normalizeAllowedPorts(updated.AllowedPorts)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid allowed_ports: " + err.Error()})
+	}
+	updated.AllowedPorts = ports
+	regions, err := normalizeRegions(updated.AllowedRegions)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid allowed_regions: " + err.Error()})
+	}
+	updated.AllowedRegions = regions
+	if updated.PostureProfileID != nil && !postureProfileExists(*updated.PostureProfileID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Posture profile not found"})
+	}
+	if updated.ScheduleID != nil && !scheduleExists(*updated.ScheduleID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+
+	if err := db.DB.Model(&policy).Select(columns).Updates(&updated).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadPolicies()
+
+	if err := db.DB.Preload("SourceGroup").Preload("DestGroup").First(&policy, policy.ID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 	return c.JSON(policy)
 }
 
+// policyColumns are the policy fields UpdatePolicy may change, named as in
+// both the JSON body and the table
+var policyColumns = []string{
+	"name", "description", "source_group_id", "dest_group_id", "allowed_ports",
+	"action", "priority", "enabled", "valid_from", "valid_until", "schedule_id",
+	"allowed_regions", "min_posture_score", "posture_profile_id",
+}
+
+const (
+	defaultPolicyPriority = 100
+	maxPolicyPriority     = 10000
+)
+
+// checkPolicyOrder normalizes and validates the fields deciding how a
+// policy ranks against others
+func checkPolicyOrder(p *models.Policy) error {
+	p.Action = strings.ToLower(strings.TrimSpace(p.Action))
+	if p.Action != "" && p.Action != "allow" && p.Action != "deny" {
+		return errors.New("action must be allow or deny")
+	}
+	if p.Priority < 0 || p.Priority > maxPolicyPriority {
+		return fmt.Errorf("priority must be between 0 and %d (0 = default)", maxPolicyPriority)
+	}
+	return nil
+}
+
+// normalizeAllowedPorts validates an AllowedPorts value, checks that the
+// services it references exist and returns the normalized form
+func normalizeAllowedPorts(value string) (string, error) {
+	rules, err := policy.ParsePorts(value)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range policy.ServiceNames(rules) {
+		var count int64
+		db.DB.Model(&models.Service{}).Where("LOWER(name) = ?", name).Count(&count)
+		if count == 0 {
+			return "", fmt.Errorf("unknown service %q", name)
+		}
+	}
+	return policy.FormatPorts(rules), nil
+}
+
+// normalizeRegions validates a comma-separated list of country codes and
+// returns it upper-cased without duplicates
+func normalizeRegions(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	var codes []string
+	for _, term := range strings.Split(value, ",") {
+		code, ok := geoip.NormalizeCountry(term)
+		if !ok {
+			return "", fmt.Errorf("%q is not a two-letter country code", strings.TrimSpace(term))
+		}
+		if !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	return strings.Join(codes, ","), nil
+}
+
 // DeletePolicy soft deletes a policy
 func DeletePolicy(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
 	id := c.Params("id")
 	if err := db.DB.Delete(&models.Policy{}, id).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	service.ReloadPolicies()
 	return c.SendStatus(204)
 }
+
+// EvaluatePolicy is a dry run telling whether a flow would be allowed under
+// the current policies, which policy decides it and why the others don't match
+func EvaluatePolicy(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	type EvaluateRequest struct {
+		SourceAgentID uint       `json:"source_agent_id"`
+		DestAgentID   uint       `json:"dest_agent_id"`
+		DestIP        string     `json:"dest_ip"` // alternative to dest_agent_id
+		Port          int        `json:"port"`
+		Protocol      string     `json:"protocol"`      // tcp (default), udp or icmp
+		At            *time.Time `json:"at"`            // evaluate at another time than now
+		SourceRegion  string     `json:"source_region"` // assume the source connects from this country, not its resolved one
+	}
+
+	var req EvaluateRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	req.Protocol = strings.ToLower(req.Protocol)
+	if req.Protocol == "" {
+		req.Protocol = "tcp"
+	}
+	switch req.Protocol {
+	case "tcp", "udp":
+		if req.Port < 1 || req.Port > 65535 {
+			return c.Status(400).JSON(fiber.Map{"error": "Port must be between 1 and 65535"})
+		}
+	case "icmp":
+		req.Port = 0
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Protocol must be tcp, udp or icmp"})
+	}
+
+	var src models.Agent
+	if err := db.DB.First(&src, req.SourceAgentID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Source agent not found"})
+	}
+
+	var dstAddr netip.Addr
+	switch {
+	case req.DestAgentID != 0:
+		var dst models.Agent
+		if err := db.DB.First(&dst, req.DestAgentID).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Destination agent not found"})
+		}
+		if dst.Disabled {
+			return c.JSON(denied("destination agent is disabled"))
+		}
+		addr, err := netip.ParseAddr(dst.IP)
+		if err != nil {
+			return c.JSON(denied("destination agent has no overlay address"))
+		}
+		dstAddr = addr
+	case req.DestIP != "":
+		addr, err := netip.ParseAddr(req.DestIP)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid dest_ip"})
+		}
+		dstAddr = addr
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "dest_agent_id or dest_ip is required"})
+	}
+
+	if src.Disabled {
+		return c.JSON(denied("source agent is disabled"))
+	}
+	srcAddr, err := netip.ParseAddr(src.IP)
+	if err != nil {
+		return c.JSON(denied("source agent has no overlay address"))
+	}
+
+	if service.PolicyEngine == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Policy engine not running"})
+	}
+
+	opts := policy.EvalOptions{SourceRegion: strings.ToUpper(req.SourceRegion)}
+	if req.At != nil {
+		opts.At = *req.At
+	}
+	result := service.PolicyEngine.Explain(policy.Flow{
+		Src:      srcAddr,
+		Dst:      dstAddr,
+		Protocol: req.Protocol,
+		Port:     uint16(req.Port),
+	}, opts)
+
+	// The engine only knows enforced policies; list the others after them
+	evaluated := make(map[uint]bool, len(result.Policies))
+	for _, p := range result.Policies {
+		evaluated[p.PolicyID] = true
+	}
+	var all []models.Policy
+	db.DB.Order("priority ASC, id ASC").Find(&all)
+	for _, p := range all {
+		if evaluated[p.ID] {
+			continue
+		}
+		reason := "policy references a deleted group"
+		if !p.Enabled {
+			reason = "policy is disabled"
+		}
+		result.Policies = append(result.Policies, policy.PolicyResult{
+			PolicyID: p.ID,
+			Name:     p.Name,
+			Action:   p.Action,
+			Priority: p.Priority,
+			Reason:   reason,
+		})
+	}
+
+	return c.JSON(result)
+}
+
+// PolicyConflicts reports enforced policies that are shadowed or redundant
+// because of an earlier one, or that contradict each other
+func PolicyConflicts(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+	if service.PolicyEngine == nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Policy engine not running"})
+	}
+
+	return c.JSON(fiber.Map{
+		"mode":      service.PolicyEngine.Mode(),
+		"conflicts": service.PolicyEngine.Conflicts(),
+	})
+}
+
+// denied is a dry-run result for flows rejected before policies are consulted
+func denied(reason string) policy.Explanation {
+	return policy.Explanation{
+		Decision: policy.Decision{Reason: reason},
+		Policies: []policy.PolicyResult{},
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+func TestUpdatePolicy(t *testing.T) {
+	app := newTestApp(t, &models.Group{}, &models.Policy{}, &models.Service{},
+		&models.PostureProfile{}, &models.Schedule{})
+	app.Put("/policies/:id", middleware.RequireUser(), UpdatePolicy)
+	admin := signIn(t, "admin")
+	auditor := signIn(t, "auditor")
+
+	profile := models.PostureProfile{Name: "managed"}
+	schedule := models.Schedule{Name: "office"}
+	dbtest.Create(t, &profile)
+	dbtest.Create(t, &schedule)
+	from := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	until := from.AddDate(0, 1, 0)
+
+	// restricted creates a policy with every condition set
+	restricted := func(t *testing.T) models.Policy {
+		t.Helper()
+		p := models.Policy{
+			Name: "contractors", SourceGroupID: 1, DestGroupID: 2, Action: "allow", Priority: 50, Enabled: true,
+			AllowedPorts: "tcp/22", AllowedRegions: "DE", ValidFrom: &from, ValidUntil: &until,
+			MinPostureScore: 60, PostureProfileID: &profile.ID, ScheduleID: &schedule.ID,
+		}
+		dbtest.Create(t, &p)
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		body   interface{}
+		status int
+		check  func(t *testing.T, p models.Policy)
+	}{
+		{
+			name: "disable", token: admin, body: map[string]interface{}{"enabled": false}, status: 200,
+			check: func(t *testing.T, p models.Policy) {
+				if p.Enabled {
+					t.Error("policy still enabled")
+				}
+				if p.AllowedPorts != "tcp/22" || p.Priority != 50 || p.ScheduleID == nil {
+					t.Errorf("fields that were not sent changed: %+v", p)
+				}
+			},
+		},
+		{
+			name: "clear every restriction", token: admin, status: 200,
+			body: map[string]interface{}{
+				"allowed_ports": "", "allowed_regions": "", "valid_from": nil, "valid_until": nil,
+				"min_posture_score": 0, "posture_profile_id": nil, "schedule_id": nil,
+			},
+			check: func(t *testing.T, p models.Policy) {
+				// No allowed ports are stored as any port, as on create
+				if p.AllowedPorts != "*" || p.AllowedRegions != "" || p.ValidFrom != nil || p.ValidUntil != nil ||
+					p.MinPostureScore != 0 || p.PostureProfileID != nil || p.ScheduleID != nil {
+					t.Errorf("restrictions left in place: %+v", p)
+				}
+				if !p.Enabled {
+					t.Error("policy disabled")
+				}
+			},
+		},
+		{
+			name: "normalized values", token: admin, status: 200,
+			body: map[string]interface{}{"allowed_regions": "de, us,DE", "action": " DENY "},
+			check: func(t *testing.T, p models.Policy) {
+				if p.AllowedRegions != "DE,US" || p.Action != "deny" {
+					t.Errorf("regions %q action %q, want DE,US deny", p.AllowedRegions, p.Action)
+				}
+			},
+		},
+		{
+			name: "one end of the window", token: admin, status: 400,
+			body: map[string]interface{}{"valid_until": from.Add(-time.Hour)},
+		},
+		{name: "bad ports", token: admin, body: map[string]interface{}{"allowed_ports": "tcp/70000"}, status: 400},
+		{name: "unknown schedule", token: admin, body: map[string]interface{}{"schedule_id": 999}, status: 400},
+		{name: "empty name", token: admin, body: map[string]interface{}{"name": " "}, status: 400},
+		{name: "nothing to update", token: admin, body: map[string]interface{}{"id": 999}, status: 400},
+		{name: "read-only role", token: auditor, body: map[string]interface{}{"enabled": false}, status: 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := restricted(t)
+			status, body := call(t, app, "PUT", fmt.Sprintf("/policies/%d", before.ID), tt.token, tt.body)
+			if status != tt.status {
+				t.Fatalf("status = %d %v, want %d", status, body, tt.status)
+			}
+
+			var stored models.Policy
+			if err := db.DB.First(&stored, before.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if tt.check == nil {
+				if !stored.UpdatedAt.Equal(before.UpdatedAt) || !stored.Enabled || stored.AllowedPorts != before.AllowedPorts {
+					t.Errorf("rejected update changed the policy: %+v", stored)
+				}
+				return
+			}
+			tt.check(t, stored)
+			if body["enabled"] != stored.Enabled || body["allowed_ports"] != stored.AllowedPorts {
+				t.Errorf("response %v does not match the stored policy %+v", body, stored)
+			}
+		})
+	}
+}
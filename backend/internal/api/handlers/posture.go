@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/posture"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+var errPostureProfileNotFound = errors.New("posture profile not found")
+
+// GetPostureSettings returns the weights and required checks used to score devices
+func GetPostureSettings(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	settings, err := posture.LoadSettings()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(settings)
+}
+
+// UpdatePostureSettings changes how devices are scored and rescores every
+// device right away
+func UpdatePostureSettings(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	settings, err := posture.LoadSettings()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	previous := *settings
+
+	// Fields missing from the body keep their current values
+	if err := c.Bind().Body(settings); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := posture.Validate(settings); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid posture settings: " + err.Error()})
+	}
+	if err := db.DB.Save(settings).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "posture_settings_updated", map[string]interface{}{
+		"before": previous,
+		"after":  settings,
+	}, c)
+
+	if err := service.ReassessPostures(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadPolicies() // quarantine may have been switched
+	return c.JSON(settings)
+}
+
+// GetAgentPosture returns the last posture report of an agent with its
+// server-computed score, and its results against the posture profiles that
+// apply to it
+func GetAgentPosture(c fiber.Ctx) error {
+	agent, err := findAgent(c, db.DB, c.Params("id"), auth.PermViewAgents)
+	if err != nil {
+		return agentLookupError(c, err)
+	}
+
+	var report *models.DevicePosture
+	var found models.DevicePosture
+	err = db.DB.Where("agent_id = ?", agent.ID).First(&found).Error
+	switch {
+	case err == nil:
+		report = &found
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	compliance := []models.PostureCompliance{}
+	if err := db.DB.Preload("Profile").Where("agent_id = ?", agent.ID).Order("profile_id ASC").Find(&compliance).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"posture":    report, // null until the agent reports
+		"compliance": compliance,
+	})
+}
+
+// ListPostureProfiles returns all posture profiles
+func ListPostureProfiles(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	var profiles []models.PostureProfile
+	if err := db.DB.Order("name ASC").Find(&profiles).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(profiles)
+}
+
+// CreatePostureProfile creates a posture profile
+func CreatePostureProfile(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var profile models.PostureProfile
+	if err := c.Bind().Body(&profile); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := checkPostureProfile(&profile); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid posture profile: " + err.Error()})
+	}
+	if err := db.DB.Create(&profile).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "posture_profile_created", map[string]interface{}{
+		"posture_profile_id": profile.ID,
+		"profile":            profile,
+	}, c)
+	return c.Status(201).JSON(profile)
+}
+
+// UpdatePostureProfile replaces the requirements of a posture profile and
+// re-evaluates the agents it applies to
+func UpdatePostureProfile(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var profile models.PostureProfile
+	if err := db.DB.First(&profile, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Posture profile not found"})
+	}
+
+	// The body is the complete profile; omitted requirements are removed
+	var updated models.PostureProfile
+	if err := c.Bind().Body(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := checkPostureProfile(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid posture profile: " + err.Error()})
+	}
+	updated.ID = profile.ID
+	updated.CreatedAt = profile.CreatedAt
+	if err := db.DB.Save(&updated).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadPolicies()
+
+	LogAudit(nil, "posture_profile_updated", map[string]interface{}{
+		"posture_profile_id": profile.ID,
+		"before":             profile,
+		"after":              updated,
+	}, c)
+	return c.JSON(updated)
+}
+
+// DeletePostureProfile deletes a posture profile no group or policy uses.
+// Detaching it would relax access for their agents, so that is left to the admin.
+func DeletePostureProfile(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var profile models.PostureProfile
+	if err := db.DB.First(&profile, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Posture profile not found"})
+	}
+
+	var groups, policies int64
+	if err := db.DB.Model(&models.Group{}).Where("posture_profile_id = ?", profile.ID).Count(&groups).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.DB.Model(&models.Policy{}).Where("posture_profile_id = ?", profile.ID).Count(&policies).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if groups > 0 || policies > 0 {
+		return c.Status(409).JSON(fiber.Map{
+			"error": fmt.Sprintf("Posture profile is used by %d groups and %d policies", groups, policies),
+		})
+	}
+
+	// Deleted groups and policies may still point at it. The row is removed
+	// for good so its name can be used again.
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Group{}).Where("posture_profile_id = ?", profile.ID).
+			Update("posture_profile_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Policy{}).Where("posture_profile_id = ?", profile.ID).
+			Update("posture_profile_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("profile_id = ?", profile.ID).Delete(&models.PostureCompliance{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&profile).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadPolicies()
+
+	LogAudit(nil, "posture_profile_deleted", map[string]interface{}{
+		"posture_profile_id": profile.ID,
+		"name":               profile.Name,
+	}, c)
+	return c.SendStatus(204)
+}
+
+// SetGroupPostureProfile attaches a posture profile to a group, or detaches
+// it when posture_profile_id is null
+func SetGroupPostureProfile(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var group models.Group
+	if err := db.DB.First(&group, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Group not found"})
+	}
+	profileID, err := bindPostureProfileID(c)
+	if errors.Is(err, errPostureProfileNotFound) {
+		return c.Status(400).JSON(fiber.Map{"error": "Posture profile not found"})
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if err := db.DB.Model(&group).Update("posture_profile_id", profileID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	group.PostureProfileID = profileID
+	service.ReloadPolicies()
+
+	LogAudit(nil, "group_posture_profile_set", map[string]interface{}{
+		"group_id":           group.ID,
+		"posture_profile_id": profileID,
+	}, c)
+	return c.JSON(group)
+}
+
+// SetPolicyPostureProfile attaches a posture profile to a policy, or
+// detaches it when posture_profile_id is null
+func SetPolicyPostureProfile(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var policy models.Policy
+	if err := db.DB.First(&policy, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Policy not found"})
+	}
+	profileID, err := bindPostureProfileID(c)
+	if errors.Is(err, errPostureProfileNotFound) {
+		return c.Status(400).JSON(fiber.Map{"error": "Posture profile not found"})
+	}
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if err := db.DB.Model(&policy).Update("posture_profile_id", profileID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	policy.PostureProfileID = profileID
+	service.ReloadPolicies()
+
+	LogAudit(nil, "policy_posture_profile_set", map[string]interface{}{
+		"policy_id":          policy.ID,
+		"posture_profile_id": profileID,
+	}, c)
+	return c.JSON(policy)
+}
+
+// bindPostureProfileID reads {"posture_profile_id": n} and checks that the
+// profile exists. A null ID is returned as nil.
+func bindPostureProfileID(c fiber.Ctx) (*uint, error) {
+	var req struct {
+		PostureProfileID *uint `json:"posture_profile_id"`
+	}
+	if err := c.Bind().Body(&req); err != nil {
+		return nil, err
+	}
+	if req.PostureProfileID != nil && !postureProfileExists(*req.PostureProfileID) {
+		return nil, errPostureProfileNotFound
+	}
+	return req.PostureProfileID, nil
+}
+
+func postureProfileExists(id uint) bool {
+	var count int64
+	db.DB.Model(&models.PostureProfile{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// checkPostureProfile validates a profile and normalizes OS names
+func checkPostureProfile(p *models.PostureProfile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	versions := make(map[string]string, len(p.MinOSVersions))
+	for os, version := range p.MinOSVersions {
+		os = strings.ToLower(strings.TrimSpace(os))
+		version = strings.TrimSpace(version)
+		if os == "" || !posture.ValidVersion(version) {
+			return fmt.Errorf("minimum OS version %q for %q is not a version number", version, os)
+		}
+		versions[os] = version
+	}
+	p.MinOSVersions = versions
+	// No agent reports pending patches yet, so every device would fail a limit
+	if p.MaxPendingPatches != nil {
+		return errors.New("max_pending_patches is not supported until agents report pending patches")
+	}
+	p.MinAgentVersion = strings.TrimSpace(p.MinAgentVersion)
+	if p.MinAgentVersion != "" && !posture.ValidVersion(p.MinAgentVersion) {
+		return fmt.Errorf("minimum agent version %q is not a version number", p.MinAgentVersion)
+	}
+	return nil
+}
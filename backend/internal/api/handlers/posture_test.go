@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+func TestDeletePostureProfile(t *testing.T) {
+	app := newTestApp(t, &models.Group{}, &models.Policy{}, &models.Agent{},
+		&models.PostureProfile{}, &models.PostureCompliance{})
+	app.Post("/posture/profiles", middleware.RequireUser(), CreatePostureProfile)
+	app.Delete("/posture/profiles/:id", middleware.RequireUser(), DeletePostureProfile)
+	admin := signIn(t, "admin")
+
+	create := func() uint {
+		t.Helper()
+		status, body := call(t, app, "POST", "/posture/profiles", admin, map[string]interface{}{
+			"name": "managed", "require_firewall": true,
+		})
+		if status != 201 {
+			t.Fatalf("create profile = %d %v", status, body)
+		}
+		return uint(body["id"].(float64))
+	}
+
+	id := create()
+	group := models.Group{Name: "contractors", PostureProfileID: &id}
+	policy := models.Policy{Name: "contractors", Enabled: true, PostureProfileID: &id}
+	dbtest.Create(t, &group)
+	dbtest.Create(t, &policy)
+	path := fmt.Sprintf("/posture/profiles/%d", id)
+
+	// Each reference on its own keeps the profile
+	for _, holder := range []interface{}{&group, &policy} {
+		if status, body := call(t, app, "DELETE", path, admin, nil); status != 409 {
+			t.Fatalf("delete while in use = %d %v, want 409", status, body)
+		}
+		db.DB.Delete(holder)
+	}
+	if status, body := call(t, app, "DELETE", path, admin, nil); status != 204 {
+		t.Fatalf("delete = %d %v, want 204", status, body)
+	}
+	db.DB.Unscoped().First(&policy, policy.ID)
+	if policy.PostureProfileID != nil {
+		t.Errorf("deleted policy still points at profile %d", *policy.PostureProfileID)
+	}
+
+	// The name is free again
+	create()
+}
+
+func TestCreatePostureProfile(t *testing.T) {
+	app := newTestApp(t, &models.PostureProfile{})
+	app.Post("/posture/profiles", middleware.RequireUser(), CreatePostureProfile)
+	admin := signIn(t, "admin")
+
+	tests := []struct {
+		name   string
+		body   map[string]interface{}
+		status int
+	}{
+		{"requirements", map[string]interface{}{"name": "managed", "require_firewall": true, "min_os_versions": map[string]string{"Darwin": "14.0"}}, 201},
+		{"no name", map[string]interface{}{"name": " ", "require_firewall": true}, 400},
+		{"bad os version", map[string]interface{}{"name": "os", "min_os_versions": map[string]string{"darwin": "latest"}}, 400},
+		{"bad agent version", map[string]interface{}{"name": "agent", "min_agent_version": "new"}, 400},
+		// Agents never report it, so no device could comply
+		{"pending patches", map[string]interface{}{"name": "patched", "max_pending_patches": 5}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := call(t, app, "POST", "/posture/profiles", admin, tt.body); status != tt.status {
+				t.Errorf("status = %d %v, want %d", status, body, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+var errForbidden = errors.New("forbidden")
+
+// can reports whether the signed-in user's role grants perm
+func can(c fiber.Ctx, perm auth.Permission) bool {
+	user := middleware.CurrentUser(c)
+	return user != nil && auth.HasPermission(user.Role, perm)
+}
+
+// forbidden writes the standard 403 response
+func forbidden(c fiber.Ctx) error {
+	return c.Status(403).JSON(fiber.Map{"error": "Insufficient permissions"})
+}
+
+// scopeAgents restricts an agents query to what the signed-in user may access.
+// Users holding the global permission see every agent; members are limited
+// to the agents they own. errForbidden is returned for roles that cannot
+// access agents at all.
+func scopeAgents(c fiber.Ctx, query *gorm.DB, global auth.Permission) (*gorm.DB, error) {
+	if can(c, global) {
+		return query, nil
+	}
+	if !can(c, auth.PermOwnAgents) {
+		return nil, errForbidden
+	}
+	return query.Where("agents.user_id = ?", middleware.CurrentUser(c).ID), nil
+}
+
+// findAgent loads an agent by ID if the signed-in user may access it.
+// Agents owned by someone else are reported as not found.
+func findAgent(c fiber.Ctx, query *gorm.DB, id string, global auth.Permission) (*models.Agent, error) {
+	scoped, err := scopeAgents(c, query, global)
+	if err != nil {
+		return nil, err
+	}
+	var agent models.Agent
+	if err := scoped.First(&agent, id).Error; err != nil {
+		return nil, err
+	}
+	return &agent, nil
+}
+
+// agentLookupError maps a findAgent error to a response
+func agentLookupError(c fiber.Ctx, err error) error {
+	if errors.Is(err, errForbidden) {
+		return forbidden(c)
+	}
+	return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+}
+
+// canReadAgentLogs reports whether the user may read the logs of agent id,
+// either through the logs permission or by owning the agent
+func canReadAgentLogs(c fiber.Ctx, id string) bool {
+	if can(c, auth.PermViewLogs) {
+		return true
+	}
+	_, err := findAgent(c, db.DB, id, auth.PermViewAgents)
+	return err == nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
+	"github.com/cubetiq/zero-zta/backend/internal/service"
+	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
+)
+
+// ListSchedules returns all policy schedules
+func ListSchedules(c fiber.Ctx) error {
+	if !can(c, auth.PermViewNetwork) {
+		return forbidden(c)
+	}
+
+	var schedules []models.Schedule
+	if err := db.DB.Order("name ASC").Find(&schedules).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(schedules)
+}
+
+// CreateSchedule creates a schedule policies can be attached to
+func CreateSchedule(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var schedule models.Schedule
+	if err := c.Bind().Body(&schedule); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := policy.NormalizeSchedule(&schedule); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid schedule: " + err.Error()})
+	}
+	if err := db.DB.Create(&schedule).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "schedule_created", map[string]interface{}{
+		"schedule_id": schedule.ID,
+		"schedule":    schedule,
+	}, c)
+	return c.Status(201).JSON(schedule)
+}
+
+// UpdateSchedule replaces the windows of a schedule; policies using it
+// follow immediately
+func UpdateSchedule(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var schedule models.Schedule
+	if err := db.DB.First(&schedule, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+
+	// The body is the complete schedule
+	var updated models.Schedule
+	if err := c.Bind().Body(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := policy.NormalizeSchedule(&updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid schedule: " + err.Error()})
+	}
+	updated.ID = schedule.ID
+	updated.CreatedAt = schedule.CreatedAt
+	if err := db.DB.Save(&updated).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadPolicies()
+
+	LogAudit(nil, "schedule_updated", map[string]interface{}{
+		"schedule_id": schedule.ID,
+		"before":      schedule,
+		"after":       updated,
+	}, c)
+	return c.JSON(updated)
+}
+
+// DeleteSchedule deletes a schedule that no policy uses. Detaching it
+// would make those policies apply at all times, so that is left to the admin.
+func DeleteSchedule(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var schedule models.Schedule
+	if err := db.DB.First(&schedule, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+
+	var inUse int64
+	if err := db.DB.Model(&models.Policy{}).Where("schedule_id = ?", schedule.ID).Count(&inUse).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if inUse > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Schedule is used by %d policies", inUse)})
+	}
+
+	// Deleted policies may still point at it. The row is removed for good so
+	// its name can be used again.
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Policy{}).Where("schedule_id = ?", schedule.ID).
+			Update("schedule_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&schedule).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	service.ReloadPolicies()
+
+	LogAudit(nil, "schedule_deleted", map[string]interface{}{
+		"schedule_id": schedule.ID,
+		"name":        schedule.Name,
+	}, c)
+	return c.SendStatus(204)
+}
+
+// SetPolicySchedule attaches a schedule to a policy, or detaches it when
+// schedule_id is null
+func SetPolicySchedule(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var p models.Policy
+	if err := db.DB.First(&p, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Policy not found"})
+	}
+	var req struct {
+		ScheduleID *uint `json:"schedule_id"`
+	}
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.ScheduleID != nil && !scheduleExists(*req.ScheduleID) {
+		return c.Status(400).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+
+	if err := db.DB.Model(&p).Update("schedule_id", req.ScheduleID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	p.ScheduleID = req.ScheduleID
+	service.ReloadPolicies()
+
+	LogAudit(nil, "policy_schedule_set", map[string]interface{}{
+		"policy_id":   p.ID,
+		"schedule_id": req.ScheduleID,
+	}, c)
+	return c.JSON(p)
+}
+
+func scheduleExists(id uint) bool {
+	var count int64
+	db.DB.Model(&models.Schedule{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+func TestDeleteSchedule(t *testing.T) {
+	app := newTestApp(t, &models.Policy{}, &models.Schedule{})
+	app.Post("/schedules", middleware.RequireUser(), CreateSchedule)
+	app.Delete("/schedules/:id", middleware.RequireUser(), DeleteSchedule)
+	admin := signIn(t, "admin")
+
+	hours := map[string]interface{}{
+		"name":    "business hours",
+		"windows": []map[string]interface{}{{"days": []string{"mon"}, "start": "09:00", "end": "17:00"}},
+	}
+	create := func() uint {
+		t.Helper()
+		status, body := call(t, app, "POST", "/schedules", admin, hours)
+		if status != 201 {
+			t.Fatalf("create schedule = %d %v", status, body)
+		}
+		return uint(body["id"].(float64))
+	}
+
+	id := create()
+	policy := models.Policy{Name: "contractors", Enabled: true, ScheduleID: &id}
+	dbtest.Create(t, &policy)
+
+	// Detaching the schedule would let the policy apply around the clock
+	path := fmt.Sprintf("/schedules/%d", id)
+	if status, body := call(t, app, "DELETE", path, admin, nil); status != 409 {
+		t.Fatalf("delete while in use = %d %v, want 409", status, body)
+	}
+	db.DB.First(&policy, policy.ID)
+	if policy.ScheduleID == nil || *policy.ScheduleID != id {
+		t.Fatalf("policy schedule = %v, want %d", policy.ScheduleID, id)
+	}
+
+	// Deleted policies do not hold on to it
+	db.DB.Delete(&policy)
+	if status, body := call(t, app, "DELETE", path, admin, nil); status != 204 {
+		t.Fatalf("delete = %d %v, want 204", status, body)
+	}
+	var count int64
+	db.DB.Unscoped().Model(&models.Schedule{}).Where("id = ?", id).Count(&count)
+	if count != 0 {
+		t.Error("schedule row kept after delete")
+	}
+	db.DB.Unscoped().First(&policy, policy.ID)
+	if policy.ScheduleID != nil {
+		t.Errorf("deleted policy still points at schedule %d", *policy.ScheduleID)
+	}
+
+	// The name is free again
+	create()
+}
@@ -1,19 +1,34 @@
 package handlers
 
 import (
-	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
 	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
 	"github.com/cubetiq/zero-zta/backend/internal/models"
+	svc "github.com/cubetiq/zero-zta/backend/internal/service"
 	"github.com/gofiber/fiber/v3"
+	"gorm.io/gorm"
 )
 
 // ListServices returns all services for an agent
 func ListServices(c fiber.Ctx) error {
 	agentID := c.Params("id")
+	if _, err := findAgent(c, db.DB, agentID, auth.PermViewAgents); err != nil {
+		return agentLookupError(c, err)
+	}
+
 	var services []models.Service
 	if err := db.DB.Where("agent_id = ?", agentID).Find(&services).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -30,10 +45,9 @@ func CreateService(c fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	// Parse agent ID
-	var agent models.Agent
-	if err := db.DB.First(&agent, agentID).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	agent, err := findAgent(c, db.DB, agentID, auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
 	}
 
 	service.AgentID = agent.ID
@@ -41,15 +55,28 @@ func CreateService(c fiber.Ctx) error {
 	if service.Name == "" || service.Port == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "Name and port are required"})
 	}
+	if err := checkService(&service); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid service: " + err.Error()})
+	}
+
+	// The agent listens on the overlay port, so it can only be taken once
+	var existing models.Service
+	if err := db.DB.Where("agent_id = ? AND protocol = ? AND port = ?", agent.ID, service.Protocol, service.Port).
+		First(&existing).Error; err == nil {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("%s/%d is already used by service %q", service.Protocol, service.Port, existing.Name)})
+	}
 
 	if err := db.DB.Create(&service).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	svc.ReloadPolicies()
 
 	// Log audit
 	LogAudit(&agent.ID, "service_added", map[string]interface{}{
 		"service_name": service.Name,
 		"port":         service.Port,
+		"protocol":     service.Protocol,
+		"local_addr":   service.LocalAddr,
 	}, c)
 
 	return c.Status(201).JSON(service)
@@ -59,6 +86,9 @@ func CreateService(c fiber.Ctx) error {
 func DeleteService(c fiber.Ctx) error {
 	serviceID := c.Params("serviceId")
 	agentID := c.Params("id")
+	if _, err := findAgent(c, db.DB, agentID, auth.PermManageAgents); err != nil {
+		return agentLookupError(c, err)
+	}
 
 	var service models.Service
 	if err := db.DB.Where("id = ? AND agent_id = ?", serviceID, agentID).First(&service).Error; err != nil {
@@ -76,51 +106,123 @@ func DeleteService(c fiber.Ctx) error {
 	if err := db.DB.Delete(&service).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	svc.ReloadPolicies()
 
 	return c.SendStatus(204)
 }
 
-// RegenerateAgentKey revokes old key and generates new one
-func RegenerateAgentKey(c fiber.Ctx) error {
-	agentID := c.Params("id")
+// AgentServices returns the enabled services the calling agent exposes on
+// the overlay. Agents refetch the list when the revision reported in the
+// heartbeat response changes.
+func AgentServices(c fiber.Ctx) error {
+	agent := middleware.CurrentAgent(c)
 
-	var agent models.Agent
-	if err := db.DB.First(&agent, agentID).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	services, revision, err := exposedServices(agent.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	return c.JSON(fiber.Map{
+		"revision": revision,
+		"services": services,
+	})
+}
 
-	oldKey := agent.APIKey
+// exposedServices loads the enabled services of an agent together with a
+// revision derived from the fields the agent acts on
+func exposedServices(agentID uint) ([]models.Service, string, error) {
+	var services []models.Service
+	if err := db.DB.Where("agent_id = ? AND enabled = ?", agentID, true).Order("id ASC").Find(&services).Error; err != nil {
+		return nil, "", err
+	}
 
-	// Generate new key
-	keyBytes := make([]byte, 16)
-	rand.Read(keyBytes)
-	newKey := fmt.Sprintf("sk_live_%s", hex.EncodeToString(keyBytes))
+	h := sha256.New()
+	for _, s := range services {
+		fmt.Fprintf(h, "%d %s %d %s %s\n", s.ID, s.Protocol, s.Port, s.LocalAddr, s.Name)
+	}
+	return services, hex.EncodeToString(h.Sum(nil))[:16], nil
+}
 
-	agent.APIKey = newKey
-	agent.PublicKey = "" // Clear public key to force re-auth
+// checkService normalizes the protocol and local address of a service. The
+// local address defaults to the same port on the agent's loopback interface.
+func checkService(s *models.Service) error {
+	s.Protocol = strings.ToLower(strings.TrimSpace(s.Protocol))
+	if s.Protocol == "" {
+		s.Protocol = "tcp"
+	}
+	if s.Protocol != "tcp" && s.Protocol != "udp" {
+		return errors.New("protocol must be tcp or udp")
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		return errors.New("port must be between 1 and 65535")
+	}
 
-	if err := db.DB.Save(&agent).Error; err != nil {
+	s.LocalAddr = strings.TrimSpace(s.LocalAddr)
+	if s.LocalAddr == "" {
+		s.LocalAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port))
+		return nil
+	}
+	host, port, err := net.SplitHostPort(s.LocalAddr)
+	if err != nil || host == "" {
+		return fmt.Errorf("local_addr %q must be host:port", s.LocalAddr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("local_addr %q has an invalid port", s.LocalAddr)
+	}
+	return nil
+}
+
+// RegenerateAgentKey issues a new API key. Existing keys keep working for a
+// grace period (?grace=24h by default) so the agent can be updated without
+// downtime; ?grace=0 revokes them immediately.
+func RegenerateAgentKey(c fiber.Ctx) error {
+	agentID := c.Params("id")
+
+	agent, err := findAgent(c, db.DB, agentID, auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
+	}
+
+	grace, err := time.ParseDuration(c.Query("grace", "24h"))
+	if err != nil || grace < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid grace period"})
+	}
+
+	newKey, cred, err := auth.RotateCredentials(agent.ID, "rotated "+time.Now().UTC().Format("2006-01-02"), grace)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	if grace == 0 {
+		// Hard cut: drop the WireGuard peer until the agent reconnects with the new key
+		agent.PublicKey = ""
+		if err := db.DB.Save(agent).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		svc.RequestPeerSync()
+	}
+
 	// Log audit
 	LogAudit(&agent.ID, "key_regenerated", map[string]interface{}{
-		"old_key_prefix": oldKey[:20] + "...",
+		"new_key_prefix": cred.Prefix,
+		"grace_period":   grace.String(),
 	}, c)
 
 	return c.JSON(fiber.Map{
-		"message": "Key regenerated successfully",
-		"api_key": newKey,
+		"message":            "Key regenerated successfully",
+		"api_key":            newKey,
+		"credential":         cred,
+		"old_keys_expire_at": time.Now().Add(grace),
 	})
 }
 
-// UpdateAgentRoutes updates agent's local network routes
+// UpdateAgentRoutes sets the local subnets an agent offers to route for the
+// overlay. They take effect once approved with ApproveAgentRoutes.
 func UpdateAgentRoutes(c fiber.Ctx) error {
 	agentID := c.Params("id")
 
-	var agent models.Agent
-	if err := db.DB.First(&agent, agentID).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	agent, err := findAgent(c, db.DB, agentID, auth.PermManageAgents)
+	if err != nil {
+		return agentLookupError(c, err)
 	}
 
 	type RoutesRequest struct {
@@ -131,24 +233,123 @@ func UpdateAgentRoutes(c fiber.Ctx) error {
 	if err := c.Bind().Body(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
 	}
+	routes, err := ipam.NormalizeRoutes(req.Routes)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid routes: " + err.Error()})
+	}
 
-	// Store as JSON string
-	routesJSON, _ := json.Marshal(req.Routes)
-	agent.Routes = string(routesJSON)
-
-	if err := db.DB.Save(&agent).Error; err != nil {
+	approvedChanged, err := svc.AdvertiseRoutes(agent, routes)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if approvedChanged {
+		svc.RequestPeerSync()
+		svc.ReloadPolicies()
+	}
 
 	// Log audit
 	LogAudit(&agent.ID, "routes_updated", map[string]interface{}{
-		"routes": req.Routes,
+		"routes":          ipam.RouteStrings(routes),
+		"approved_routes": ipam.RouteStrings(ipam.ParseRoutes(agent.ApprovedRoutes)),
 	}, c)
 
 	return c.JSON(agent)
 }
 
-// Helper to log audit events
+// ApproveAgentRoutes sets which of an agent's advertised subnets it routes
+// for the overlay. A subnet can only be routed by one agent.
+func ApproveAgentRoutes(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var agent models.Agent
+	if err := db.DB.First(&agent, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	}
+
+	var req struct {
+		Routes []string `json:"routes"`
+	}
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	approved, err := ipam.NormalizeRoutes(req.Routes)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid routes: " + err.Error()})
+	}
+	advertised := ipam.ParseRoutes(agent.Routes)
+	for _, p := range approved {
+		if !slices.Contains(advertised, p) {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Invalid routes: %s is not advertised by the agent", p)})
+		}
+	}
+	if err := ipam.CheckRouteConflicts(agent.ID, approved); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Route conflict: " + err.Error()})
+	}
+
+	before := ipam.RouteStrings(ipam.ParseRoutes(agent.ApprovedRoutes))
+	if err := db.DB.Model(&agent).Update("approved_routes", ipam.EncodeRoutes(approved)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	svc.RequestPeerSync()
+	svc.ReloadPolicies()
+
+	LogAudit(&agent.ID, "routes_approved", map[string]interface{}{
+		"before": before,
+		"after":  ipam.RouteStrings(approved),
+	}, c)
+	return c.JSON(agent)
+}
+
+// ApproveExitNode makes an agent the exit node, which forwards the
+// internet-bound traffic of agents that use it, or withdraws the approval.
+// The agent must offer to be one, and only one agent can be the exit node.
+func ApproveExitNode(c fiber.Ctx) error {
+	if !can(c, auth.PermManageNetwork) {
+		return forbidden(c)
+	}
+
+	var agent models.Agent
+	if err := db.DB.First(&agent, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Agent not found"})
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Enabled && !agent.AdvertiseExitNode {
+		return c.Status(400).JSON(fiber.Map{"error": "Agent does not offer to be an exit node"})
+	}
+	if req.Enabled {
+		var current models.Agent
+		err := db.DB.Where("exit_node = ? AND id <> ?", true, agent.ID).First(&current).Error
+		if err == nil {
+			return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("Agent %q is already the exit node", current.Name)})
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	if err := db.DB.Model(&agent).Update("exit_node", req.Enabled).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	svc.RequestPeerSync()
+	svc.ReloadPolicies()
+
+	action := "exit_node_approved"
+	if !req.Enabled {
+		action = "exit_node_revoked"
+	}
+	LogAudit(&agent.ID, action, map[string]interface{}{"name": agent.Name}, c)
+	return c.JSON(agent)
+}
+
+// Helper to log audit events, attributed to the signed-in user if any
 func LogAudit(agentID *uint, action string, details map[string]interface{}, c fiber.Ctx) {
 	detailsJSON, _ := json.Marshal(details)
 
@@ -159,6 +360,9 @@ func LogAudit(agentID *uint, action string, details map[string]interface{}, c fi
 		IPAddress: c.IP(),
 		UserAgent: c.Get("User-Agent"),
 	}
+	if user := middleware.CurrentUser(c); user != nil {
+		log.UserID = &user.ID
+	}
 
 	db.DB.Create(&log)
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/config"
+	"github.com/gofiber/fiber/v3"
+)
+
+// ssoStateCookie binds a pending SSO login to the browser that started it
+const ssoStateCookie = "zta_sso_state"
+
+// ListAuthProviders returns the SSO providers offered on the login page
+func ListAuthProviders(c fiber.Ctx) error {
+	list := []fiber.Map{}
+	for _, p := range auth.Providers() {
+		list = append(list, fiber.Map{
+			"name":         p.Name(),
+			"display_name": p.DisplayName(),
+			"login_url":    "/api/v1/auth/oidc/" + p.Name() + "/login",
+		})
+	}
+	return c.JSON(fiber.Map{"providers": list})
+}
+
+// SSOLogin redirects the browser to the identity provider
+func SSOLogin(c fiber.Ctx) error {
+	p, ok := auth.Provider(c.Params("provider"))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Unknown identity provider"})
+	}
+
+	// Fiber reuses request buffers, so copy the value kept beyond this request
+	returnTo := strings.Clone(safeReturnPath(c.Query("return_to")))
+	state, authURL, err := auth.BeginLogin(p, returnTo)
+	if errors.Is(err, auth.ErrTooManyLogins) {
+		return c.Status(503).JSON(fiber.Map{"error": "Too many sign-ins in progress, try again later"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start login"})
+	}
+
+	setStateCookie(c, state, int((10 * time.Minute).Seconds()))
+	return c.Redirect().To(authURL)
+}
+
+// SSOCallback completes the login when the identity provider redirects back
+// and hands the access token to the dashboard in the URL fragment
+func SSOCallback(c fiber.Ctx) error {
+	providerName := c.Params("provider")
+	state := c.Query("state")
+	cookieState := c.Cookies(ssoStateCookie)
+	setStateCookie(c, "", -1)
+
+	if c.Query("error") != "" {
+		return loginFailed(c, "Sign-in was cancelled or denied by the identity provider")
+	}
+	if state == "" || state != cookieState {
+		return loginFailed(c, "Sign-in session expired, please try again")
+	}
+
+	id, returnTo, err := auth.CompleteLogin(c.Context(), providerName, state, c.Query("code"))
+	if err != nil {
+		log.Printf("SSO login via %s failed: %v", providerName, err)
+		if errors.Is(err, auth.ErrInvalidState) {
+			return loginFailed(c, "Sign-in session expired, please try again")
+		}
+		return loginFailed(c, "Sign-in failed")
+	}
+
+	p, _ := auth.Provider(providerName)
+	user, err := auth.UserForIdentity(p, id)
+	if err != nil {
+		log.Printf("SSO login via %s rejected for %q: %v", providerName, id.Email, err)
+		LogAudit(nil, "sso_login_denied", map[string]interface{}{
+			"provider": providerName,
+			"email":    id.Email,
+		}, c)
+		return loginFailed(c, "Your account is not allowed to sign in")
+	}
+
+	token, expires, err := newSession(user)
+	if err != nil {
+		return loginFailed(c, "Sign-in failed")
+	}
+
+	fragment := url.Values{}
+	fragment.Set("token", token)
+	fragment.Set("expires_at", expires.Format(time.RFC3339))
+	fragment.Set("return_to", returnTo)
+	return c.Redirect().To(config.Get().DashboardURL + "/login/callback#" + fragment.Encode())
+}
+
+// setStateCookie sets or (with a negative maxAge) clears the SSO state cookie
+func setStateCookie(c fiber.Ctx, value string, maxAge int) {
+	c.Cookie(&fiber.Cookie{
+		Name:     ssoStateCookie,
+		Value:    value,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   maxAge,
+		HTTPOnly: true,
+		Secure:   strings.HasPrefix(config.Get().PublicURL, "https://"),
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+func loginFailed(c fiber.Ctx, message string) error {
+	return c.Redirect().To(config.Get().DashboardURL + "/login?error=" + url.QueryEscape(message))
+}
+
+// safeReturnPath only allows same-site relative paths as post-login targets
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/api/middleware"
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/gofiber/fiber/v3"
+)
+
+// ListUsers returns all dashboard users
+func ListUsers(c fiber.Ctx) error {
+	if !can(c, auth.PermManageUsers) {
+		return forbidden(c)
+	}
+
+	var users []models.User
+	if err := db.DB.Order("id ASC").Find(&users).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(users)
+}
+
+// CreateUser adds a local dashboard user with the given role
+func CreateUser(c fiber.Ctx) error {
+	if !can(c, auth.PermManageUsers) {
+		return forbidden(c)
+	}
+
+	type CreateRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+
+	var req CreateRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" || req.Password == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Email and password are required"})
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleMember
+	}
+	if !auth.ValidRole(req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid role"})
+	}
+	if req.Role == auth.RoleOwner && !isOwner(c) {
+		return forbidden(c)
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	user := models.User{
+		Email:        email,
+		Provider:     "local",
+		Role:         req.Role,
+		PasswordHash: hash,
+	}
+	if err := db.DB.Create(&user).Error; err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "User already exists"})
+	}
+
+	LogAudit(nil, "user_created", map[string]interface{}{
+		"email": user.Email,
+		"role":  user.Role,
+	}, c)
+
+	return c.Status(201).JSON(user)
+}
+
+// UpdateUser changes a user's role or resets their password
+func UpdateUser(c fiber.Ctx) error {
+	if !can(c, auth.PermManageUsers) {
+		return forbidden(c)
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	type UpdateRequest struct {
+		Role     *string `json:"role"`
+		Password *string `json:"password"`
+	}
+
+	var req UpdateRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	// Only owners may touch owner accounts or hand out ownership
+	if user.Role == auth.RoleOwner || (req.Role != nil && *req.Role == auth.RoleOwner) {
+		if !isOwner(c) {
+			return forbidden(c)
+		}
+	}
+
+	oldRole := user.Role
+	if req.Role != nil {
+		if !auth.ValidRole(*req.Role) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid role"})
+		}
+		if oldRole == auth.RoleOwner && *req.Role != auth.RoleOwner && lastOwner() {
+			return c.Status(409).JSON(fiber.Map{"error": "Cannot demote the last owner"})
+		}
+		user.Role = *req.Role
+	}
+	if req.Password != nil {
+		if *req.Password == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Password cannot be empty"})
+		}
+		hash, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		user.PasswordHash = hash
+	}
+
+	if err := db.DB.Save(&user).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if user.Role != oldRole {
+		LogAudit(nil, "user_role_changed", map[string]interface{}{
+			"email":    user.Email,
+			"old_role": oldRole,
+			"new_role": user.Role,
+		}, c)
+	}
+	if req.Password != nil {
+		LogAudit(nil, "user_password_reset", map[string]interface{}{
+			"email": user.Email,
+		}, c)
+	}
+
+	return c.JSON(user)
+}
+
+// DeleteUser removes a dashboard user. Their agents are kept but unowned.
+func DeleteUser(c fiber.Ctx) error {
+	if !can(c, auth.PermManageUsers) {
+		return forbidden(c)
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if user.ID == middleware.CurrentUser(c).ID {
+		return c.Status(409).JSON(fiber.Map{"error": "Cannot delete your own account"})
+	}
+	if user.Role == auth.RoleOwner {
+		if !isOwner(c) {
+			return forbidden(c)
+		}
+		if lastOwner() {
+			return c.Status(409).JSON(fiber.Map{"error": "Cannot delete the last owner"})
+		}
+	}
+
+	db.DB.Model(&models.Agent{}).Where("user_id = ?", user.ID).Update("user_id", nil)
+
+	// Hard delete so the email can be invited again
+	if err := db.DB.Unscoped().Delete(&user).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	LogAudit(nil, "user_deleted", map[string]interface{}{
+		"email": user.Email,
+	}, c)
+
+	return c.SendStatus(204)
+}
+
+func isOwner(c fiber.Ctx) bool {
+	user := middleware.CurrentUser(c)
+	return user != nil && user.Role == auth.RoleOwner
+}
+
+// lastOwner reports whether exactly one owner account remains
+func lastOwner() bool {
+	var count int64
+	db.DB.Model(&models.User{}).Where("role = ?", auth.RoleOwner).Count(&count)
+	return count <= 1
+}
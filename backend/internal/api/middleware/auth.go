@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/auth"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/gofiber/fiber/v3"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	agentKey
+)
+
+// RequireUser validates the dashboard access token on admin routes. The token
+// is read from the Authorization bearer header.
+func RequireUser() fiber.Handler {
+	return requireUser(false)
+}
+
+// RequireUserFromLink is RequireUser for pages opened directly in the browser
+// (the debug proxy), which cannot set headers and pass the token in the
+// access_token query parameter instead. Query strings end up in logs and
+// browser history, so no other route accepts it.
+func RequireUserFromLink() fiber.Handler {
+	return requireUser(true)
+}
+
+func requireUser(allowQuery bool) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		token := bearerToken(c)
+		if token == "" && allowQuery {
+			token = c.Query("access_token")
+		}
+		if token == "" {
+			return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		claims, err := auth.ParseToken(token)
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Re-read the user so deleted accounts and role changes take effect immediately
+		var user models.User
+		if err := db.DB.First(&user, claims.UserID).Error; err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "User no longer exists"})
+		}
+
+		fiber.Locals(c, userKey, &user)
+		return c.Next()
+	}
+}
+
+// RequireAgent validates agent credentials on agent-facing routes. The API
+// key is read from the X-API-Key header, the Authorization bearer header, or
+// the "api_key"/"key" field of a JSON body (used by older agents).
+func RequireAgent() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if key == "" {
+			key = bearerToken(c)
+		}
+		if key == "" {
+			key = bodyKey(c.Body())
+		}
+
+		agent, err := auth.AgentByAPIKey(key)
+		if errors.Is(err, auth.ErrAgentDisabled) {
+			return c.Status(403).JSON(fiber.Map{"error": "Agent disabled"})
+		}
+		if err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid API key"})
+		}
+
+		fiber.Locals(c, agentKey, agent)
+		return c.Next()
+	}
+}
+
+// CurrentUser returns the user authenticated by RequireUser
+func CurrentUser(c fiber.Ctx) *models.User {
+	return fiber.Locals[*models.User](c, userKey)
+}
+
+// CurrentAgent returns the agent authenticated by RequireAgent
+func CurrentAgent(c fiber.Ctx) *models.Agent {
+	return fiber.Locals[*models.Agent](c, agentKey)
+}
+
+func bearerToken(c fiber.Ctx) string {
+	header := c.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+func bodyKey(body []byte) string {
+	var payload struct {
+		APIKey string `json:"api_key"`
+		Key    string `json:"key"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &payload) != nil {
+		return ""
+	}
+	if payload.APIKey != "" {
+		return payload.APIKey
+	}
+	return payload.Key
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAgentDisabled = errors.New("agent disabled")
+)
+
+// Agent API keys look like sk_live_<prefix>_<secret>. The prefix is stored
+// in clear text to find the credential; the whole key is hashed.
+const (
+	apiKeyScheme    = "sk_live_"
+	apiKeyPrefixLen = 12
+)
+
+// lastUsedResolution limits last_used_at writes to one per credential per minute,
+// since agents authenticate on every heartbeat
+const lastUsedResolution = time.Minute
+
+// AgentByAPIKey resolves the agent owning an API key. Disabled agents are
+// returned together with ErrAgentDisabled so callers can respond accordingly.
+func AgentByAPIKey(key string) (*models.Agent, error) {
+	prefix, ok := keyPrefix(key, apiKeyScheme)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var cred models.AgentCredential
+	if err := db.DB.Where("prefix = ?", prefix).First(&cred).Error; err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(cred.Salt, key)), []byte(cred.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if cred.ExpiresAt != nil && now.After(*cred.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var agent models.Agent
+	if err := db.DB.First(&agent, cred.AgentID).Error; err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if cred.LastUsedAt == nil || now.Sub(*cred.LastUsedAt) > lastUsedResolution {
+		db.DB.Model(&cred).UpdateColumn("last_used_at", now)
+	}
+
+	if agent.Disabled {
+		return &agent, ErrAgentDisabled
+	}
+	return &agent, nil
+}
+
+// IssueCredential creates a new API key for an agent and returns the
+// plaintext key, which is not stored and cannot be retrieved later
+func IssueCredential(agentID uint, name string, expiresAt *time.Time) (string, *models.AgentCredential, error) {
+	return issueCredential(db.DB, agentID, name, expiresAt)
+}
+
+func issueCredential(tx *gorm.DB, agentID uint, name string, expiresAt *time.Time) (string, *models.AgentCredential, error) {
+	key, prefix, salt, hash, err := newHashedKey(apiKeyScheme)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cred := models.AgentCredential{
+		AgentID:   agentID,
+		Name:      name,
+		Prefix:    prefix,
+		Salt:      salt,
+		Hash:      hash,
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.Create(&cred).Error; err != nil {
+		return "", nil, fmt.Errorf("store credential: %w", err)
+	}
+	return key, &cred, nil
+}
+
+// RotateCredentials issues a new API key and lets the agent's existing keys
+// expire after grace, so the agent can switch over without a hard cut.
+// A zero grace revokes the old keys immediately.
+func RotateCredentials(agentID uint, name string, grace time.Duration) (string, *models.AgentCredential, error) {
+	var key string
+	var cred *models.AgentCredential
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if grace <= 0 {
+			if err := tx.Where("agent_id = ?", agentID).Delete(&models.AgentCredential{}).Error; err != nil {
+				return err
+			}
+		} else {
+			// Never extend a key that already expires sooner
+			cutoff := now.Add(grace)
+			if err := tx.Model(&models.AgentCredential{}).
+				Where("agent_id = ? AND (expires_at IS NULL OR expires_at > ?)", agentID, cutoff).
+				Update("expires_at", cutoff).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		key, cred, err = issueCredential(tx, agentID, name, nil)
+		return err
+	})
+	return key, cred, err
+}
+
+// MigrateLegacyAPIKeys moves plaintext keys from the old agents.api_key
+// column into hashed credentials and drops the column. Existing agents keep
+// working with their current key.
+func MigrateLegacyAPIKeys() error {
+	if !db.DB.Migrator().HasColumn(&models.Agent{}, "api_key") {
+		return nil
+	}
+
+	var rows []struct {
+		ID     uint
+		APIKey string
+	}
+	if err := db.DB.Table("agents").Select("id, api_key").Where("api_key IS NOT NULL AND api_key <> ''").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			prefix, ok := keyPrefix(row.APIKey, apiKeyScheme)
+			if !ok {
+				log.Printf("Skipping malformed legacy API key for agent %d", row.ID)
+				continue
+			}
+			salt, err := randomHex(16)
+			if err != nil {
+				return err
+			}
+			cred := models.AgentCredential{
+				AgentID: row.ID,
+				Name:    "legacy",
+				Prefix:  prefix,
+				Salt:    salt,
+				Hash:    hashAPIKey(salt, row.APIKey),
+			}
+			if err := tx.Create(&cred).Error; err != nil {
+				return fmt.Errorf("migrate API key for agent %d: %w", row.ID, err)
+			}
+		}
+
+		migrator := tx.Migrator()
+		if migrator.HasIndex(&models.Agent{}, "idx_agents_api_key") {
+			if err := migrator.DropIndex(&models.Agent{}, "idx_agents_api_key"); err != nil {
+				return err
+			}
+		}
+		if err := migrator.DropColumn(&models.Agent{}, "api_key"); err != nil {
+			return err
+		}
+		log.Printf("Migrated %d legacy agent API keys to hashed credentials", len(rows))
+		return nil
+	})
+}
+
+// newHashedKey generates a key of the form <scheme><prefix>_<secret> and
+// returns it together with the values to store
+func newHashedKey(scheme string) (key, prefix, salt, hash string, err error) {
+	if prefix, err = randomHex(apiKeyPrefixLen / 2); err != nil {
+		return
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return
+	}
+	if salt, err = randomHex(16); err != nil {
+		return
+	}
+	key = scheme + prefix + "_" + secret
+	hash = hashAPIKey(salt, key)
+	return
+}
+
+// keyPrefix extracts the lookup prefix. Keys issued before hashing was
+// introduced (sk_live_<hex>) use the first characters of their hex part.
+func keyPrefix(key, scheme string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, scheme)
+	if !ok || len(rest) < apiKeyPrefixLen {
+		return "", false
+	}
+	return rest[:apiKeyPrefixLen], true
+}
+
+func hashAPIKey(salt, key string) string {
+	sum := sha256.Sum256([]byte(salt + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidEnrollmentKey covers unknown, expired, revoked and used up keys
+// alike so callers cannot probe which keys exist
+var ErrInvalidEnrollmentKey = errors.New("invalid enrollment key")
+
+// enrollKeyScheme distinguishes enrollment keys from agent API keys
+const enrollKeyScheme = "ek_"
+
+// IssueEnrollmentKey stores key with a freshly generated secret and returns
+// the plaintext, which is not stored and cannot be retrieved later
+func IssueEnrollmentKey(key *models.EnrollmentKey) (string, error) {
+	plaintext, prefix, salt, hash, err := newHashedKey(enrollKeyScheme)
+	if err != nil {
+		return "", err
+	}
+	key.Prefix = prefix
+	key.Salt = salt
+	key.Hash = hash
+	if !key.Reusable {
+		key.MaxUses = 1
+	}
+	if err := db.DB.Create(key).Error; err != nil {
+		return "", fmt.Errorf("store enrollment key: %w", err)
+	}
+	return plaintext, nil
+}
+
+// RedeemEnrollmentKey validates an enrollment key and counts one use of it.
+// It runs on tx so the use is rolled back if enrollment fails later on.
+func RedeemEnrollmentKey(tx *gorm.DB, plaintext string) (*models.EnrollmentKey, error) {
+	prefix, ok := keyPrefix(plaintext, enrollKeyScheme)
+	if !ok {
+		return nil, ErrInvalidEnrollmentKey
+	}
+
+	var key models.EnrollmentKey
+	if err := tx.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, ErrInvalidEnrollmentKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key.Salt, plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidEnrollmentKey
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, ErrInvalidEnrollmentKey
+	}
+
+	// Conditional increment so concurrent enrollments cannot exceed the limit
+	result := tx.Model(&key).
+		Where("max_uses = 0 OR uses < max_uses").
+		Updates(map[string]interface{}{
+			"uses":         gorm.Expr("uses + 1"),
+			"last_used_at": now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidEnrollmentKey
+	}
+	key.Uses++
+	key.LastUsedAt = &now
+	return &key, nil
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/config"
+)
+
+// clockSkew is the leeway allowed when checking ID token timestamps
+const clockSkew = time.Minute
+
+// OIDCProvider is a generic OpenID Connect identity provider using the
+// authorization code flow with PKCE
+type OIDCProvider struct {
+	cfg         config.OIDCConfig
+	redirectURL string
+	client      *http.Client
+
+	authEndpoint     string
+	tokenEndpoint    string
+	userinfoEndpoint string
+	authMethods      []string
+	keys             *keySet
+}
+
+// discoveryDocument is the subset of OpenID Provider Metadata we use
+type discoveryDocument struct {
+	Issuer            string   `json:"issuer"`
+	AuthEndpoint      string   `json:"authorization_endpoint"`
+	TokenEndpoint     string   `json:"token_endpoint"`
+	UserinfoEndpoint  string   `json:"userinfo_endpoint"`
+	JWKSURI           string   `json:"jwks_uri"`
+	TokenAuthMethods  []string `json:"token_endpoint_auth_methods_supported"`
+	ChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	SigningAlgorithms []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// idTokenClaims are the ID token claims checked during login
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified any      `json:"email_verified"` // some IdPs send "true" as a string
+	Name          string   `json:"name"`
+}
+
+// audience accepts both the single string and array forms of "aud"
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// NewOIDCProvider discovers the provider's endpoints from its issuer URL.
+// redirectURL is the callback registered with the IdP.
+func NewOIDCProvider(ctx context.Context, cfg config.OIDCConfig, redirectURL string) (*OIDCProvider, error) {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = RoleMember
+	}
+	if !ValidRole(cfg.DefaultRole) || cfg.DefaultRole == RoleOwner {
+		return nil, fmt.Errorf("invalid default role %q for provider %s", cfg.DefaultRole, cfg.Name)
+	}
+
+	p := &OIDCProvider{
+		cfg:         cfg,
+		redirectURL: redirectURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s: %w", cfg.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery for %s: status %d", cfg.Name, resp.StatusCode)
+	}
+
+	var doc discoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s: %w", cfg.Name, err)
+	}
+
+	// The issuer in the metadata must match exactly (OpenID Discovery 1.0 §4.3)
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery for %s: issuer mismatch %q", cfg.Name, doc.Issuer)
+	}
+	if doc.AuthEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery for %s: incomplete provider metadata", cfg.Name)
+	}
+	if len(doc.ChallengeMethods) > 0 && !slices.Contains(doc.ChallengeMethods, "S256") {
+		return nil, fmt.Errorf("OIDC provider %s does not support PKCE S256", cfg.Name)
+	}
+
+	p.cfg.Issuer = doc.Issuer
+	p.authEndpoint = doc.AuthEndpoint
+	p.tokenEndpoint = doc.TokenEndpoint
+	p.userinfoEndpoint = doc.UserinfoEndpoint
+	p.authMethods = doc.TokenAuthMethods
+	p.keys = newKeySet(doc.JWKSURI, p.client)
+	return p, nil
+}
+
+// Name returns the provider's URL slug
+func (p *OIDCProvider) Name() string { return p.cfg.Name }
+
+// DisplayName returns the label shown on the login page
+func (p *OIDCProvider) DisplayName() string { return p.cfg.DisplayName }
+
+// DefaultRole returns the role given to users created on first login
+func (p *OIDCProvider) DefaultRole() string { return p.cfg.DefaultRole }
+
+// AllowsEmail reports whether the email's domain may sign in
+func (p *OIDCProvider) AllowsEmail(email string) bool {
+	if len(p.cfg.AllowedDomains) == 0 {
+		return true
+	}
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, d := range p.cfg.AllowedDomains {
+		if strings.EqualFold(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthURL builds the authorization request URL
+func (p *OIDCProvider) AuthURL(state, nonce, codeChallenge string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.redirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + q.Encode()
+}
+
+// Exchange redeems the authorization code and validates the returned ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	useBasic := p.cfg.ClientSecret != "" &&
+		(len(p.authMethods) == 0 || slices.Contains(p.authMethods, "client_secret_basic"))
+	if !useBasic {
+		form.Set("client_id", p.cfg.ClientID)
+		if p.cfg.ClientSecret != "" {
+			form.Set("client_secret", p.cfg.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: %s %s", tokens.Error, tokens.Description)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &Identity{
+		Provider:      p.cfg.Name,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(claims.Email),
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+
+	// Some IdPs only return the email from the userinfo endpoint
+	if id.Email == "" && p.userinfoEndpoint != "" && tokens.AccessToken != "" {
+		if err := p.fillFromUserinfo(ctx, tokens.AccessToken, id); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// verifyIDToken checks the signature and standard claims of an ID token
+// (OpenID Connect Core 1.0 §3.1.3.7)
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, token, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed id_token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed id_token header")
+	}
+
+	key, err := p.keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id_token signature")
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("id_token signature: %w", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed id_token payload")
+	}
+	var claims idTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed id_token payload")
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return nil, fmt.Errorf("id_token issuer %q does not match", claims.Issuer)
+	case !slices.Contains(claims.Audience, p.cfg.ClientID):
+		return nil, errors.New("id_token audience does not include this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.cfg.ClientID:
+		return nil, errors.New("id_token authorized party does not match")
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, errors.New("id_token expired")
+	case claims.IssuedAt > now.Add(clockSkew).Unix():
+		return nil, errors.New("id_token issued in the future")
+	case claims.Nonce != nonce:
+		return nil, errors.New("id_token nonce mismatch")
+	case claims.Subject == "":
+		return nil, errors.New("id_token has no subject")
+	}
+	return &claims, nil
+}
+
+func (p *OIDCProvider) fillFromUserinfo(ctx context.Context, accessToken string, id *Identity) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userinfoEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("userinfo request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("userinfo request: status %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return fmt.Errorf("decode userinfo: %w", err)
+	}
+	// The userinfo subject must match the ID token (Core §5.3.2)
+	if info.Subject != id.Subject {
+		return errors.New("userinfo subject does not match id_token")
+	}
+	id.Email = strings.ToLower(info.Email)
+	id.EmailVerified = isTrue(info.EmailVerified)
+	if id.Name == "" {
+		id.Name = info.Name
+	}
+	return nil
+}
+
+func isTrue(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
@@ -0,0 +1,463 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/config"
+)
+
+const (
+	testClientID     = "zero-zta"
+	testClientSecret = "s3cret"
+	testCode         = "auth-code"
+	testVerifier     = "code-verifier"
+	testNonce        = "nonce-123"
+	testAccessToken  = "access-token"
+)
+
+// testIdP is a local stand-in for an OpenID Connect provider. It serves
+// discovery, JWKS, token and userinfo endpoints and signs ID tokens with an
+// RSA key (kid "rsa") and a P-256 key (kid "ec").
+type testIdP struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	discovery func(doc map[string]any) // edits the discovery document
+	idToken   string                   // returned by the token endpoint
+	userinfo  map[string]any
+	tokenForm url.Values // last token request
+	basicAuth bool       // whether it authenticated with HTTP basic auth
+}
+
+var (
+	testKeysOnce sync.Once
+	testRSAKey   *rsa.PrivateKey
+	testECKey    *ecdsa.PrivateKey
+)
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	testKeysOnce.Do(func() {
+		var err error
+		if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+		if testECKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			panic(err)
+		}
+	})
+
+	idp := &testIdP{rsaKey: testRSAKey, ecKey: testECKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.serveDiscovery)
+	mux.HandleFunc("GET /jwks", idp.serveJWKS)
+	mux.HandleFunc("POST /token", idp.serveToken)
+	mux.HandleFunc("GET /userinfo", idp.serveUserinfo)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdP) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	doc := map[string]any{
+		"issuer":                                idp.URL,
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"userinfo_endpoint":                     idp.URL + "/userinfo",
+		"jwks_uri":                              idp.URL + "/jwks",
+		"code_challenge_methods_supported":      []string{"plain", "S256"},
+		"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+	}
+	idp.mu.Lock()
+	if idp.discovery != nil {
+		idp.discovery(doc)
+	}
+	idp.mu.Unlock()
+	json.NewEncoder(w).Encode(doc)
+}
+
+func (idp *testIdP) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	pad := func(n *big.Int) string { return b64(n.FillBytes(make([]byte, 32))) }
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(idp.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(idp.rsaKey.E)).Bytes())},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": pad(idp.ecKey.X), "y": pad(idp.ecKey.Y)},
+		{"kid": "enc", "kty": "RSA", "use": "enc", "n": b64(idp.rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kid": "okp", "kty": "OKP", "crv": "Ed25519", "x": "AAAA"},
+	}})
+}
+
+func (idp *testIdP) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.tokenForm = r.PostForm
+	id, secret, basic := r.BasicAuth()
+	idp.basicAuth = basic
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("code") != testCode || r.PostForm.Get("code_verifier") != testVerifier {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "bad code"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": testAccessToken, "id_token": idp.idToken})
+}
+
+func (idp *testIdP) serveUserinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testAccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	json.NewEncoder(w).Encode(idp.userinfo)
+}
+
+// claims returns valid ID token claims for this IdP
+func (idp *testIdP) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            idp.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "Alice@Example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+// sign creates a compact JWS over claims
+func (idp *testIdP) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		sig = []byte("not a signature")
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (idp *testIdP) provider(t *testing.T) *OIDCProvider {
+	t.Helper()
+	p, err := NewOIDCProvider(context.Background(), config.OIDCConfig{
+		Name:         "test",
+		Issuer:       idp.URL + "/",
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+	}, "https://zta.example.com/api/v1/auth/oidc/test/callback")
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	return p
+}
+
+func TestNewOIDCProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		discovery func(doc map[string]any)
+		cfg       func(cfg *config.OIDCConfig)
+		wantErr   bool
+	}{
+		{name: "discovered"},
+		{name: "pkce support not advertised", discovery: func(doc map[string]any) { delete(doc, "code_challenge_methods_supported") }},
+		{name: "issuer mismatch", discovery: func(doc map[string]any) { doc["issuer"] = "https://evil.example.com" }, wantErr: true},
+		{name: "no token endpoint", discovery: func(doc map[string]any) { delete(doc, "token_endpoint") }, wantErr: true},
+		{name: "no jwks", discovery: func(doc map[string]any) { delete(doc, "jwks_uri") }, wantErr: true},
+		{name: "no S256", discovery: func(doc map[string]any) { doc["code_challenge_methods_supported"] = []string{"plain"} }, wantErr: true},
+		{name: "owner default role", cfg: func(cfg *config.OIDCConfig) { cfg.DefaultRole = RoleOwner }, wantErr: true},
+		{name: "unknown default role", cfg: func(cfg *config.OIDCConfig) { cfg.DefaultRole = "root" }, wantErr: true},
+		{name: "no discovery document", cfg: func(cfg *config.OIDCConfig) { cfg.Issuer += "/missing" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			idp.discovery = tt.discovery
+			cfg := config.OIDCConfig{Name: "test", Issuer: idp.URL, ClientID: testClientID, Scopes: []string{"email"}}
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+
+			p, err := NewOIDCProvider(context.Background(), cfg, "https://zta.example.com/callback")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewOIDCProvider succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewOIDCProvider: %v", err)
+			}
+			if p.DisplayName() != "test" || p.DefaultRole() != RoleMember {
+				t.Errorf("defaults: display name %q, role %q", p.DisplayName(), p.DefaultRole())
+			}
+			if scope := strings.Join(p.cfg.Scopes, " "); scope != "openid email" {
+				t.Errorf("scopes = %q, want openid added first", scope)
+			}
+		})
+	}
+}
+
+func TestOIDCAuthURL(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider(t)
+
+	u, err := url.Parse(p.AuthURL("state-1", testNonce, "challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != idp.URL+"/authorize" {
+		t.Errorf("endpoint = %s", got)
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "https://zta.example.com/api/v1/auth/oidc/test/callback",
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 testNonce,
+		"code_challenge":        "challenge",
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if got := u.Query().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := newTestIdP(t)
+	p := idp.provider(t)
+
+	tests := []struct {
+		name     string
+		alg, kid string
+		claims   func(c map[string]any)
+		token    string         // sent instead of a signed token when set
+		tamper   bool           // replace the payload after signing
+		userinfo map[string]any // served by the userinfo endpoint
+		code     string
+		want     Identity
+		wantErr  string
+	}{
+		{
+			name: "rsa signed",
+			want: Identity{Provider: "test", Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"},
+		},
+		{
+			name: "ec signed",
+			alg:  "ES256", kid: "ec",
+			want: Identity{Provider: "test", Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"},
+		},
+		{
+			name:   "audience list with authorized party",
+			claims: func(c map[string]any) { c["aud"] = []string{testClientID, "other"}; c["azp"] = testClientID },
+			want:   Identity{Provider: "test", Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"},
+		},
+		{
+			name:   "email verified as a string",
+			claims: func(c map[string]any) { c["email_verified"] = "true" },
+			want:   Identity{Provider: "test", Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"},
+		},
+		{
+			name:     "email from userinfo",
+			claims:   func(c map[string]any) { delete(c, "email"); delete(c, "email_verified"); delete(c, "name") },
+			userinfo: map[string]any{"sub": "user-1", "email": "Bob@Example.com", "email_verified": true, "name": "Bob"},
+			want:     Identity{Provider: "test", Subject: "user-1", Email: "bob@example.com", EmailVerified: true, Name: "Bob"},
+		},
+		{
+			name:     "userinfo for another subject",
+			claims:   func(c map[string]any) { delete(c, "email") },
+			userinfo: map[string]any{"sub": "user-2", "email": "mallory@example.com"},
+			wantErr:  "userinfo subject does not match",
+		},
+		{name: "wrong code", code: "stolen", wantErr: "token request failed: invalid_grant"},
+		{name: "wrong issuer", claims: func(c map[string]any) { c["iss"] = "https://evil.example.com" }, wantErr: "issuer"},
+		{name: "wrong audience", claims: func(c map[string]any) { c["aud"] = "other" }, wantErr: "audience"},
+		{
+			name:    "audience list without authorized party",
+			claims:  func(c map[string]any) { c["aud"] = []string{testClientID, "other"} },
+			wantErr: "authorized party",
+		},
+		{name: "expired", claims: func(c map[string]any) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }, wantErr: "expired"},
+		{name: "expired within clock skew", claims: func(c map[string]any) { c["exp"] = time.Now().Add(-clockSkew / 2).Unix() },
+			want: Identity{Provider: "test", Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}},
+		{name: "no expiry", claims: func(c map[string]any) { delete(c, "exp") }, wantErr: "expired"},
+		{name: "issued in the future", claims: func(c map[string]any) { c["iat"] = time.Now().Add(2 * clockSkew).Unix() }, wantErr: "future"},
+		{name: "nonce mismatch", claims: func(c map[string]any) { c["nonce"] = "replayed" }, wantErr: "nonce"},
+		{name: "no subject", claims: func(c map[string]any) { delete(c, "sub") }, wantErr: "subject"},
+		{name: "unknown key", kid: "rotated", wantErr: "unknown signing key"},
+		{name: "encryption key", kid: "enc", wantErr: "unknown signing key"},
+		{name: "key of another type", alg: "ES256", kid: "rsa", wantErr: "does not match algorithm"},
+		{name: "tampered payload", tamper: true, wantErr: "signature"},
+		{name: "tampered ec payload", alg: "ES256", kid: "ec", tamper: true, wantErr: "signature"},
+		{name: "unsigned", alg: "none", wantErr: "unsupported signing algorithm"},
+		{name: "symmetric", alg: "HS256", wantErr: "unsupported signing algorithm"},
+		{name: "malformed", token: "not.a-token", wantErr: "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg, kid := tt.alg, tt.kid
+			if alg == "" {
+				alg = "RS256"
+			}
+			if kid == "" {
+				kid = "rsa"
+			}
+			claims := idp.claims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			token := tt.token
+			if token == "" {
+				token = idp.sign(t, alg, kid, claims)
+			}
+			if tt.tamper {
+				parts := strings.Split(token, ".")
+				claims["sub"] = "admin"
+				payload, _ := json.Marshal(claims)
+				parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+				token = strings.Join(parts, ".")
+			}
+
+			idp.mu.Lock()
+			idp.idToken = token
+			idp.userinfo = tt.userinfo
+			idp.mu.Unlock()
+
+			code := tt.code
+			if code == "" {
+				code = testCode
+			}
+			got, err := p.Exchange(context.Background(), code, testVerifier, testNonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCClientAuthentication(t *testing.T) {
+	tests := []struct {
+		name      string
+		methods   []string
+		wantBasic bool
+	}{
+		{"basic by default", nil, true},
+		{"basic when advertised", []string{"client_secret_post", "client_secret_basic"}, true},
+		{"post when basic is not supported", []string{"client_secret_post"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			idp.discovery = func(doc map[string]any) {
+				if tt.methods != nil {
+					doc["token_endpoint_auth_methods_supported"] = tt.methods
+				}
+			}
+			p := idp.provider(t)
+			idp.idToken = idp.sign(t, "RS256", "rsa", idp.claims())
+
+			if _, err := p.Exchange(context.Background(), testCode, testVerifier, testNonce); err != nil {
+				t.Fatalf("Exchange() error: %v", err)
+			}
+			if idp.basicAuth != tt.wantBasic {
+				t.Errorf("basic auth = %t, want %t", idp.basicAuth, tt.wantBasic)
+			}
+			if form := idp.tokenForm; form.Get("grant_type") != "authorization_code" ||
+				form.Get("redirect_uri") != "https://zta.example.com/api/v1/auth/oidc/test/callback" {
+				t.Errorf("token request form = %v", form)
+			}
+			if tt.wantBasic && idp.tokenForm.Has("client_secret") {
+				t.Error("client secret sent in the form as well as in basic auth")
+			}
+		})
+	}
+}
+
+func TestOIDCAllowsEmail(t *testing.T) {
+	tests := []struct {
+		domains []string
+		email   string
+		want    bool
+	}{
+		{nil, "anyone@anywhere.test", true},
+		{[]string{"example.com"}, "alice@example.com", true},
+		{[]string{"example.com"}, "alice@EXAMPLE.com", true},
+		{[]string{"example.com"}, "alice@sub.example.com", false},
+		{[]string{"example.com"}, "alice@example.com.evil.test", false},
+		{[]string{"example.com", "example.org"}, "bob@example.org", true},
+		{[]string{"example.com"}, "not-an-email", false},
+	}
+	for _, tt := range tests {
+		p := &OIDCProvider{cfg: config.OIDCConfig{AllowedDomains: tt.domains}}
+		if got := p.AllowsEmail(tt.email); got != tt.want {
+			t.Errorf("AllowsEmail(%q) with %v = %t, want %t", tt.email, tt.domains, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword returns a bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches a bcrypt hash. Users
+// without a local password (e.g. SSO-only) never match.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// EnsureAdmin creates the initial owner account when the users table is
+// empty. If no password is given a random one is generated and logged once.
+func EnsureAdmin(email, password string) error {
+	var count int64
+	if err := db.DB.Model(&models.User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if email == "" {
+		return errors.New("admin email is required to bootstrap the first user")
+	}
+
+	generated := password == ""
+	if generated {
+		buf := make([]byte, 18)
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Errorf("generate admin password: %w", err)
+		}
+		password = base64.RawURLEncoding.EncodeToString(buf)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user := models.User{
+		Email:        strings.ToLower(email),
+		Provider:     "local",
+		Role:         RoleOwner,
+		PasswordHash: hash,
+	}
+	if err := db.DB.Create(&user).Error; err != nil {
+		return fmt.Errorf("create admin user: %w", err)
+	}
+
+	if generated {
+		log.Printf("Created initial admin %s with password: %s (change it after first login)", email, password)
+	} else {
+		log.Printf("Created initial admin %s", email)
+	}
+	return nil
+}
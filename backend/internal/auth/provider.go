@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Identity is a user identity asserted by an external identity provider
+type Identity struct {
+	Provider      string
+	Subject       string // stable user ID at the provider
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdentityProvider authenticates dashboard users against an external IdP
+// using the authorization code flow
+type IdentityProvider interface {
+	// Name is the URL slug the provider is registered under
+	Name() string
+	// DisplayName is shown on the login button
+	DisplayName() string
+	// AuthURL returns the IdP URL the browser is sent to
+	AuthURL(state, nonce, codeChallenge string) string
+	// Exchange redeems an authorization code and returns the verified identity
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+	// AllowsEmail reports whether a user with this email may sign in
+	AllowsEmail(email string) bool
+	// DefaultRole is the role given to users created on first login
+	DefaultRole() string
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]IdentityProvider)
+)
+
+// RegisterProvider makes an identity provider available for login
+func RegisterProvider(p IdentityProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// Provider returns the registered provider with the given name
+func Provider(name string) (IdentityProvider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Providers returns all registered providers sorted by name
+func Providers() []IdentityProvider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	list := make([]IdentityProvider, 0, len(providers))
+	for _, p := range providers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
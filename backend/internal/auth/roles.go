@@ -0,0 +1,72 @@
+package auth
+
+// Dashboard user roles, from most to least privileged
+const (
+	RoleOwner        = "owner"
+	RoleAdmin        = "admin"
+	RoleNetworkAdmin = "network-admin"
+	RoleAuditor      = "auditor"
+	RoleMember       = "member"
+)
+
+// Permission is an action a role may perform through the management API
+type Permission string
+
+const (
+	PermOwnAgents     Permission = "agents:own"    // enroll and manage agents the user owns
+	PermViewAgents    Permission = "agents:read"   // see every agent
+	PermManageAgents  Permission = "agents:write"  // manage every agent
+	PermViewNetwork   Permission = "network:read"  // see groups, policies and IPAM
+	PermManageNetwork Permission = "network:write" // edit groups and policies
+	PermManageIPAM    Permission = "ipam:write"    // reserve overlay addresses
+	PermViewLogs      Permission = "logs:read"     // read audit and access logs
+	PermDebug         Permission = "debug"         // run connectivity tests and the debug proxy
+	PermManageUsers   Permission = "users:write"   // invite users and change roles
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermOwnAgents, PermViewAgents, PermManageAgents, PermViewNetwork, PermManageNetwork,
+		PermManageIPAM, PermViewLogs, PermDebug, PermManageUsers,
+	},
+	RoleAdmin: {
+		PermOwnAgents, PermViewAgents, PermManageAgents, PermViewNetwork, PermManageNetwork,
+		PermManageIPAM, PermViewLogs, PermDebug, PermManageUsers,
+	},
+	RoleNetworkAdmin: {
+		PermOwnAgents, PermViewAgents, PermManageAgents, PermViewNetwork,
+		PermManageIPAM, PermViewLogs, PermDebug,
+	},
+	RoleAuditor: {PermViewLogs},
+	RoleMember:  {PermOwnAgents},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// NormalizeRole maps legacy and empty role values onto the current roles.
+// Accounts created before RBAC carry the old "user" role.
+func NormalizeRole(role string) string {
+	if role == "" || role == "user" {
+		return RoleMember
+	}
+	return role
+}
+
+// HasPermission reports whether role grants perm
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions granted to role
+func Permissions(role string) []Permission {
+	return rolePermissions[NormalizeRole(role)]
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/config"
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidState    = errors.New("login request expired or invalid")
+	ErrSSODenied       = errors.New("account not allowed to sign in")
+	ErrTooManyLogins   = errors.New("too many logins in progress")
+)
+
+// loginStateTTL bounds how long a user may take at the IdP
+const loginStateTTL = 10 * time.Minute
+
+// maxPendingLogins caps the logins waiting for the IdP, since anyone can
+// start one
+const maxPendingLogins = 10000
+
+// pendingLogin holds the secrets of an authorization request until the IdP
+// redirects back, keyed by the OAuth state parameter
+type pendingLogin struct {
+	provider string
+	nonce    string
+	verifier string
+	returnTo string
+	expires  time.Time
+}
+
+var (
+	pendingMu     sync.Mutex
+	pendingLogins = make(map[string]pendingLogin)
+)
+
+// BeginLogin starts an authorization code flow with PKCE. It returns the
+// state value, which the caller should also bind to the browser, and the
+// URL to redirect the user to.
+func BeginLogin(p IdentityProvider, returnTo string) (string, string, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	pendingMu.Lock()
+	if len(pendingLogins) >= maxPendingLogins {
+		pendingMu.Unlock()
+		return "", "", ErrTooManyLogins
+	}
+	pendingLogins[state] = pendingLogin{
+		provider: p.Name(),
+		nonce:    nonce,
+		verifier: verifier,
+		returnTo: returnTo,
+		expires:  time.Now().Add(loginStateTTL),
+	}
+	pendingMu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	return state, p.AuthURL(state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:])), nil
+}
+
+// StartLoginExpiry periodically drops logins the IdP never redirected back
+func StartLoginExpiry() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		expirePendingLogins(now)
+	}
+}
+
+func expirePendingLogins(now time.Time) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for state, pending := range pendingLogins {
+		if now.After(pending.expires) {
+			delete(pendingLogins, state)
+		}
+	}
+}
+
+// CompleteLogin redeems the authorization code for a pending login and
+// returns the verified identity and the path the user started from
+func CompleteLogin(ctx context.Context, providerName, state, code string) (*Identity, string, error) {
+	pendingMu.Lock()
+	pending, ok := pendingLogins[state]
+	delete(pendingLogins, state) // states are single use
+	pendingMu.Unlock()
+
+	if !ok || time.Now().After(pending.expires) || pending.provider != providerName {
+		return nil, "", ErrInvalidState
+	}
+
+	p, ok := Provider(providerName)
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+
+	id, err := p.Exchange(ctx, code, pending.verifier, pending.nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	return id, pending.returnTo, nil
+}
+
+// UserForIdentity finds or creates the dashboard user for an SSO identity.
+// Users are matched by provider subject first, then by verified email so
+// existing local accounts are linked on their first SSO login.
+func UserForIdentity(p IdentityProvider, id *Identity) (*models.User, error) {
+	if id.Email != "" && !p.AllowsEmail(id.Email) {
+		return nil, ErrSSODenied
+	}
+
+	var user models.User
+	err := db.DB.Where("provider = ? AND subject = ?", id.Provider, id.Subject).First(&user).Error
+	if err == nil {
+		return &user, nil
+	}
+
+	if id.Email == "" || !id.EmailVerified {
+		return nil, fmt.Errorf("%w: provider did not return a verified email", ErrSSODenied)
+	}
+
+	if err := db.DB.Where("email = ?", id.Email).First(&user).Error; err == nil {
+		// Never move an account already bound to a different IdP identity
+		if user.Subject != "" {
+			return nil, fmt.Errorf("%w: email is linked to another identity", ErrSSODenied)
+		}
+		user.Provider = id.Provider
+		user.Subject = id.Subject
+		if err := db.DB.Save(&user).Error; err != nil {
+			return nil, err
+		}
+		log.Printf("Linked user %s to %s identity", user.Email, id.Provider)
+		return &user, nil
+	}
+
+	user = models.User{
+		Email:    id.Email,
+		Provider: id.Provider,
+		Subject:  id.Subject,
+		Role:     p.DefaultRole(),
+	}
+	if err := db.DB.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+	log.Printf("Created user %s from %s login", user.Email, id.Provider)
+	return &user, nil
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// oidcRetryInterval is how often unreachable providers are rediscovered
+const oidcRetryInterval = 30 * time.Second
+
+// InitOIDCProviders discovers and registers the configured OIDC providers.
+// Providers that cannot be reached are retried in the background so an IdP
+// outage does not block startup.
+func InitOIDCProviders(configs []config.OIDCConfig, publicURL string) {
+	for _, cfg := range configs {
+		redirectURL := strings.TrimSuffix(publicURL, "/") + "/api/v1/auth/oidc/" + cfg.Name + "/callback"
+		go func() {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+				p, err := NewOIDCProvider(ctx, cfg, redirectURL)
+				cancel()
+				if err == nil {
+					RegisterProvider(p)
+					log.Printf("SSO provider %s ready (callback %s)", cfg.Name, redirectURL)
+					return
+				}
+				log.Printf("SSO provider %s unavailable, retrying: %v", cfg.Name, err)
+				time.Sleep(oidcRetryInterval)
+			}
+		}()
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPendingLogins(t *testing.T) {
+	p := newTestIdP(t).provider(t)
+	t.Cleanup(func() {
+		pendingMu.Lock()
+		pendingLogins = make(map[string]pendingLogin)
+		pendingMu.Unlock()
+	})
+
+	now := time.Now()
+	pendingMu.Lock()
+	pendingLogins = make(map[string]pendingLogin, maxPendingLogins)
+	for i := 0; i < maxPendingLogins; i++ {
+		expires := now.Add(loginStateTTL)
+		if i%2 == 0 {
+			expires = now.Add(-time.Second)
+		}
+		pendingLogins[fmt.Sprint(i)] = pendingLogin{provider: p.Name(), expires: expires}
+	}
+	pendingMu.Unlock()
+
+	if _, _, err := BeginLogin(p, "/"); !errors.Is(err, ErrTooManyLogins) {
+		t.Fatalf("BeginLogin with a full table = %v, want ErrTooManyLogins", err)
+	}
+
+	expirePendingLogins(now)
+	if n := len(pendingLogins); n != maxPendingLogins/2 {
+		t.Fatalf("%d logins left after expiry, want %d", n, maxPendingLogins/2)
+	}
+	state, _, err := BeginLogin(p, "/agents")
+	if err != nil {
+		t.Fatalf("BeginLogin after expiry: %v", err)
+	}
+	if pending := pendingLogins[state]; pending.returnTo != "/agents" || !pending.expires.After(now) {
+		t.Errorf("pending login = %+v", pending)
+	}
+
+	// Expired logins cannot be completed even before they are swept
+	pendingLogins["late"] = pendingLogin{provider: p.Name(), expires: time.Now().Add(-time.Second)}
+	if _, _, err := CompleteLogin(t.Context(), p.Name(), "late", "code"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("CompleteLogin with an expired state = %v, want ErrInvalidState", err)
+	}
+}
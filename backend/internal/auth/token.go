@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Claims are the fields carried in a dashboard access token
+type Claims struct {
+	UserID    uint   `json:"sub"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	signingKey []byte
+	tokenTTL   time.Duration
+)
+
+// jwtHeader is the fixed JOSE header for HS256 tokens
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Init configures the key and lifetime used for access tokens
+func Init(secret []byte, ttl time.Duration) {
+	signingKey = secret
+	tokenTTL = ttl
+}
+
+// IssueToken creates a signed HS256 JWT for a user
+func IssueToken(userID uint, email, role string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(tokenTTL)
+	claims := Claims{
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(unsigned), expires, nil
+}
+
+// ParseToken verifies a token's signature and expiry and returns its claims
+func ParseToken(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+func sign(data string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings. Values are layered in this order, later
+// sources overriding earlier ones: built-in defaults, JSON config file,
+// ZTA_* environment variables, command line flags.
+type Config struct {
+	ListenAddr       string `json:"listen_addr"`        // management API
+	TunnelListenAddr string `json:"tunnel_listen_addr"` // HTTPS/WebSocket tunnel
+	DatabaseDSN      string `json:"database_dsn"`
+	TLSCertFile      string `json:"tls_cert_file"`
+	TLSKeyFile       string `json:"tls_key_file"`
+	DashboardURL     string `json:"dashboard_url"`
+	PublicURL        string `json:"public_url"`  // external base URL of the management API, used for SSO callbacks
+	PolicyMode       string `json:"policy_mode"` // how overlapping policies combine: deny-overrides or first-match
+
+	WireGuard WireGuardConfig `json:"wireguard"`
+	Auth      AuthConfig      `json:"auth"`
+	GeoIP     GeoIPConfig     `json:"geoip"`
+	DNS       DNSConfig       `json:"dns"`
+}
+
+// DNSConfig holds the overlay DNS server run on the hub's overlay address
+type DNSConfig struct {
+	Domain   string   `json:"domain"`   // agents resolve as <agent>.<domain>
+	Upstream []string `json:"upstream"` // resolvers other names are forwarded to, host:port
+}
+
+// GeoIPConfig holds the sources agent countries are resolved from, used by
+// policies restricted to regions. Either, both or neither can be set.
+type GeoIPConfig struct {
+	DatabaseFile string `json:"database_file"` // MaxMind DB (.mmdb) in the GeoIP2/GeoLite2 Country or City layout
+	CIDRFile     string `json:"cidr_file"`     // "network,country" lines, consulted before the database
+}
+
+// AuthConfig holds dashboard session settings
+type AuthConfig struct {
+	SecretFile string `json:"secret_file"` // HMAC key for access tokens, generated if missing
+	TokenTTL   string `json:"token_ttl"`   // Go duration, e.g. "12h"
+	ClaimTTL   string `json:"claim_ttl"`   // how long a device claim can wait for approval and pickup
+
+	// Initial admin account, created when no users exist yet. A random
+	// password is generated and logged if none is configured.
+	AdminEmail    string `json:"admin_email"`
+	AdminPassword string `json:"admin_password"`
+
+	// External identity providers offered on the login page
+	OIDC []OIDCConfig `json:"oidc"`
+
+	// Loaded from SecretFile at startup, never serialized
+	Secret []byte `json:"-"`
+}
+
+// OIDCConfig describes an OpenID Connect identity provider
+type OIDCConfig struct {
+	Name           string   `json:"name"` // URL slug, e.g. "google"
+	DisplayName    string   `json:"display_name"`
+	Issuer         string   `json:"issuer"` // discovery is done against <issuer>/.well-known/openid-configuration
+	ClientID       string   `json:"client_id"`
+	ClientSecret   string   `json:"client_secret"`
+	Scopes         []string `json:"scopes"`          // defaults to openid, email, profile
+	AllowedDomains []string `json:"allowed_domains"` // email domains allowed to sign in, empty allows all
+	DefaultRole    string   `json:"default_role"`    // role for users created on first login, defaults to member
+}
+
+// WireGuardConfig holds the hub's WireGuard identity and overlay settings
+type WireGuardConfig struct {
+	ListenPort     int    `json:"listen_port"`
+	PublicEndpoint string `json:"public_endpoint"` // host:port advertised to agents
+	OverlayCIDR    string `json:"overlay_cidr"`
+	PrivateKeyFile string `json:"private_key_file"`
+
+	// Loaded from PrivateKeyFile at startup, never serialized
+	PrivateKey string `json:"-"`
+	PublicKey  string `json:"-"`
+}
+
+var current *Config
+
+// Get returns the active configuration. It panics if Load has not run.
+func Get() *Config {
+	if current == nil {
+		panic("config: Get called before Load")
+	}
+	return current
+}
+
+// Default returns the built-in settings matching a local development setup
+func Default() *Config {
+	return &Config{
+		ListenAddr:       ":3000",
+		TunnelListenAddr: ":443",
+		DatabaseDSN:      "zero-zta.db",
+		TLSCertFile:      "server.crt",
+		TLSKeyFile:       "server.key",
+		DashboardURL:     "http://localhost:3001",
+		PublicURL:        "http://localhost:3000",
+		PolicyMode:       "deny-overrides",
+		WireGuard: WireGuardConfig{
+			ListenPort:     51820,
+			PublicEndpoint: "127.0.0.1:51820",
+			OverlayCIDR:    "10.0.0.0/24",
+			PrivateKeyFile: "wireguard.key",
+		},
+		Auth: AuthConfig{
+			SecretFile: "auth.secret",
+			TokenTTL:   "12h",
+			ClaimTTL:   "10m",
+			AdminEmail: "admin@localhost",
+		},
+		DNS: DNSConfig{
+			Domain:   "zta.internal",
+			Upstream: []string{"8.8.8.8:53"},
+		},
+	}
+}
+
+// Load builds the configuration from file, environment and command line
+// arguments, loads (or generates on first boot) the WireGuard keypair and
+// token signing secret, and makes the result available through Get.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", envOr("ZTA_CONFIG", "zero-zta.json"), "Path to JSON config file")
+	listenAddr := fs.String("listen", "", "Management API listen address")
+	tunnelAddr := fs.String("tunnel-listen", "", "HTTPS/WebSocket tunnel listen address")
+	dsn := fs.String("db", "", "Database DSN (SQLite path)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	dashboardURL := fs.String("dashboard-url", "", "Dashboard base URL used in claim links")
+	publicURL := fs.String("public-url", "", "External management API base URL used for SSO callbacks")
+	wgPort := fs.Int("wg-port", 0, "WireGuard UDP listen port")
+	wgEndpoint := fs.String("wg-endpoint", "", "Public WireGuard endpoint advertised to agents (host:port)")
+	wgCIDR := fs.String("wg-cidr", "", "Overlay network CIDR")
+	wgKeyFile := fs.String("wg-key-file", "", "WireGuard private key file (generated if missing)")
+	geoIPDatabase := fs.String("geoip-db", "", "MaxMind-format geo-IP database file")
+	geoIPTable := fs.String("geoip-cidr", "", "CIDR-to-country table file")
+	dnsDomain := fs.String("dns-domain", "", "Domain overlay DNS names live under")
+	dnsUpstream := fs.String("dns-upstream", "", "Comma-separated resolvers other names are forwarded to")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.loadFile(*configPath); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Only flags given explicitly override file and environment values
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.ListenAddr = *listenAddr
+		case "tunnel-listen":
+			cfg.TunnelListenAddr = *tunnelAddr
+		case "db":
+			cfg.DatabaseDSN = *dsn
+		case "tls-cert":
+			cfg.TLSCertFile = *tlsCert
+		case "tls-key":
+			cfg.TLSKeyFile = *tlsKey
+		case "dashboard-url":
+			cfg.DashboardURL = *dashboardURL
+		case "public-url":
+			cfg.PublicURL = *publicURL
+		case "wg-port":
+			cfg.WireGuard.ListenPort = *wgPort
+		case "wg-endpoint":
+			cfg.WireGuard.PublicEndpoint = *wgEndpoint
+		case "wg-cidr":
+			cfg.WireGuard.OverlayCIDR = *wgCIDR
+		case "wg-key-file":
+			cfg.WireGuard.PrivateKeyFile = *wgKeyFile
+		case "geoip-db":
+			cfg.GeoIP.DatabaseFile = *geoIPDatabase
+		case "geoip-cidr":
+			cfg.GeoIP.CIDRFile = *geoIPTable
+		case "dns-domain":
+			cfg.DNS.Domain = *dnsDomain
+		case "dns-upstream":
+			cfg.DNS.Upstream = splitList(*dnsUpstream)
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	priv, pub, err := loadOrCreateKey(cfg.WireGuard.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.WireGuard.PrivateKey = priv
+	cfg.WireGuard.PublicKey = pub
+
+	secret, err := loadOrCreateSecret(cfg.Auth.SecretFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Auth.Secret = secret
+
+	current = cfg
+	return cfg, nil
+}
+
+// TokenTTL returns the lifetime of dashboard access tokens
+func (c *Config) TokenTTL() time.Duration {
+	d, _ := time.ParseDuration(c.Auth.TokenTTL)
+	return d
+}
+
+// ClaimTTL returns how long a device claim stays valid
+func (c *Config) ClaimTTL() time.Duration {
+	d, _ := time.ParseDuration(c.Auth.ClaimTTL)
+	return d
+}
+
+// OverlayPrefix returns the parsed overlay network
+func (c *Config) OverlayPrefix() netip.Prefix {
+	return netip.MustParsePrefix(c.WireGuard.OverlayCIDR).Masked()
+}
+
+// HubAddr returns the hub's own overlay address, the first host of the overlay network
+func (c *Config) HubAddr() netip.Addr {
+	return c.OverlayPrefix().Addr().Next()
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	log.Printf("Loaded config from %s", path)
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	setString(&c.ListenAddr, "ZTA_LISTEN_ADDR")
+	setString(&c.TunnelListenAddr, "ZTA_TUNNEL_LISTEN_ADDR")
+	setString(&c.DatabaseDSN, "ZTA_DATABASE_DSN")
+	setString(&c.TLSCertFile, "ZTA_TLS_CERT_FILE")
+	setString(&c.TLSKeyFile, "ZTA_TLS_KEY_FILE")
+	setString(&c.DashboardURL, "ZTA_DASHBOARD_URL")
+	setString(&c.PublicURL, "ZTA_PUBLIC_URL")
+	setString(&c.PolicyMode, "ZTA_POLICY_MODE")
+	setString(&c.WireGuard.PublicEndpoint, "ZTA_WG_ENDPOINT")
+	setString(&c.WireGuard.OverlayCIDR, "ZTA_WG_OVERLAY_CIDR")
+	setString(&c.WireGuard.PrivateKeyFile, "ZTA_WG_PRIVATE_KEY_FILE")
+	setString(&c.Auth.SecretFile, "ZTA_AUTH_SECRET_FILE")
+	setString(&c.Auth.TokenTTL, "ZTA_TOKEN_TTL")
+	setString(&c.Auth.ClaimTTL, "ZTA_CLAIM_TTL")
+	setString(&c.Auth.AdminEmail, "ZTA_ADMIN_EMAIL")
+	setString(&c.Auth.AdminPassword, "ZTA_ADMIN_PASSWORD")
+	setString(&c.GeoIP.DatabaseFile, "ZTA_GEOIP_DATABASE_FILE")
+	setString(&c.GeoIP.CIDRFile, "ZTA_GEOIP_CIDR_FILE")
+	setString(&c.DNS.Domain, "ZTA_DNS_DOMAIN")
+	if v, ok := os.LookupEnv("ZTA_DNS_UPSTREAM"); ok {
+		c.DNS.Upstream = splitList(v)
+	}
+
+	// A single OIDC provider can be configured without a config file
+	if issuer := os.Getenv("ZTA_OIDC_ISSUER"); issuer != "" {
+		c.Auth.OIDC = append(c.Auth.OIDC, OIDCConfig{
+			Name:         envOr("ZTA_OIDC_NAME", "oidc"),
+			DisplayName:  envOr("ZTA_OIDC_DISPLAY_NAME", "Single Sign-On"),
+			Issuer:       issuer,
+			ClientID:     os.Getenv("ZTA_OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("ZTA_OIDC_CLIENT_SECRET"),
+		})
+	}
+
+	if v := os.Getenv("ZTA_WG_LISTEN_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid ZTA_WG_LISTEN_PORT %q: %w", v, err)
+		}
+		c.WireGuard.ListenPort = port
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.WireGuard.ListenPort <= 0 || c.WireGuard.ListenPort > 65535 {
+		return fmt.Errorf("invalid WireGuard listen port %d", c.WireGuard.ListenPort)
+	}
+	prefix, err := netip.ParsePrefix(c.WireGuard.OverlayCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid overlay CIDR %q: %w", c.WireGuard.OverlayCIDR, err)
+	}
+	if prefix.Bits() > prefix.Addr().BitLen()-2 {
+		return fmt.Errorf("overlay CIDR %q is too small", c.WireGuard.OverlayCIDR)
+	}
+	if c.WireGuard.PublicEndpoint == "" {
+		return errors.New("WireGuard public endpoint is required")
+	}
+	if c.WireGuard.PrivateKeyFile == "" {
+		return errors.New("WireGuard private key file is required")
+	}
+	if d, err := time.ParseDuration(c.Auth.TokenTTL); err != nil || d <= 0 {
+		return fmt.Errorf("invalid token TTL %q", c.Auth.TokenTTL)
+	}
+	if d, err := time.ParseDuration(c.Auth.ClaimTTL); err != nil || d <= 0 {
+		return fmt.Errorf("invalid claim TTL %q", c.Auth.ClaimTTL)
+	}
+	if c.PolicyMode != "deny-overrides" && c.PolicyMode != "first-match" {
+		return fmt.Errorf("invalid policy mode %q, expected deny-overrides or first-match", c.PolicyMode)
+	}
+	if c.Auth.SecretFile == "" {
+		return errors.New("auth secret file is required")
+	}
+	seen := make(map[string]bool)
+	for _, p := range c.Auth.OIDC {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return errors.New("OIDC providers need a name, issuer and client_id")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate OIDC provider %q", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	if len(c.Auth.OIDC) > 0 && c.PublicURL == "" {
+		return errors.New("public URL is required for SSO callbacks")
+	}
+	if err := c.DNS.normalize(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// normalize checks the DNS settings, lower-casing the domain and adding the
+// default port to upstream resolvers given without one
+func (d *DNSConfig) normalize() error {
+	d.Domain = strings.ToLower(strings.Trim(d.Domain, "."))
+	if d.Domain == "" {
+		return errors.New("DNS domain is required")
+	}
+	for _, label := range strings.Split(d.Domain, ".") {
+		if !dnsLabel.MatchString(label) {
+			return fmt.Errorf("invalid DNS domain %q", d.Domain)
+		}
+	}
+	for i, upstream := range d.Upstream {
+		if _, err := netip.ParseAddr(upstream); err == nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		if _, err := netip.ParseAddrPort(upstream); err != nil {
+			return fmt.Errorf("invalid DNS upstream %q, expected an IP address and optional port", d.Upstream[i])
+		}
+		d.Upstream[i] = upstream
+	}
+	return nil
+}
+
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// splitList splits a comma-separated setting, dropping empty entries
+func splitList(v string) []string {
+	list := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func setString(dst *string, key string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// loadOrCreateKey reads a base64 WireGuard private key from path, generating
+// and persisting a new one (mode 0600) if the file does not exist yet.
+// It returns the private and public keys, both base64 encoded.
+func loadOrCreateKey(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		priv := strings.TrimSpace(string(data))
+		pub, err := PublicKey(priv)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid WireGuard key in %s: %w", path, err)
+		}
+		return priv, pub, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", "", fmt.Errorf("read WireGuard key %s: %w", path, err)
+	}
+
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := writeSecretFile(path, priv); err != nil {
+		return "", "", err
+	}
+
+	log.Printf("Generated new WireGuard keypair in %s (public key %s)", path, pub)
+	return priv, pub, nil
+}
+
+// GenerateKeyPair creates a new clamped Curve25519 keypair, base64 encoded
+func GenerateKeyPair() (string, string, error) {
+	var privateKey [32]byte
+	if _, err := rand.Read(privateKey[:]); err != nil {
+		return "", "", fmt.Errorf("generate key: %w", err)
+	}
+
+	// Clamp the private key (WireGuard requirement)
+	privateKey[0] &= 248
+	privateKey[31] &= 127
+	privateKey[31] |= 64
+
+	var publicKey [32]byte
+	curve25519.ScalarBaseMult(&publicKey, &privateKey)
+
+	return base64.StdEncoding.EncodeToString(privateKey[:]), base64.StdEncoding.EncodeToString(publicKey[:]), nil
+}
+
+// PublicKey derives the base64 public key for a base64 private key
+func PublicKey(privB64 string) (string, error) {
+	priv, err := base64.StdEncoding.DecodeString(privB64)
+	if err != nil {
+		return "", err
+	}
+	if len(priv) != 32 {
+		return "", fmt.Errorf("expected 32 byte key, got %d", len(priv))
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// ValidPublicKey reports whether s is a base64 encoded 32 byte WireGuard key
+func ValidPublicKey(s string) bool {
+	k, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(k) == 32
+}
+
+// loadOrCreateSecret reads a base64 token signing secret from path,
+// generating and persisting a random 32 byte secret if the file is missing
+func loadOrCreateSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(secret) < 32 {
+			return nil, fmt.Errorf("invalid auth secret in %s", path)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read auth secret %s: %w", path, err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate auth secret: %w", err)
+	}
+	if err := writeSecretFile(path, base64.StdEncoding.EncodeToString(secret)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Generated new auth secret in %s", path)
+	return secret, nil
+}
+
+// writeSecretFile creates path with mode 0600, refusing to overwrite an existing file
+func writeSecretFile(path, content string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("create directory for %s: %w", path, err)
+		}
+	}
+	// O_EXCL so two instances sharing a directory never overwrite each other's secrets
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content + "\n"); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
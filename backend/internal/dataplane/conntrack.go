@@ -0,0 +1,203 @@
+package dataplane
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
+)
+
+// flowKey identifies one direction of a connection
+type flowKey struct {
+	Proto   uint8
+	Src     netip.Addr
+	Dst     netip.Addr
+	SrcPort uint16
+	DstPort uint16
+}
+
+func keyOf(p *Packet) flowKey {
+	return flowKey{Proto: p.Proto, Src: p.Src, Dst: p.Dst, SrcPort: p.SrcPort, DstPort: p.DstPort}
+}
+
+func (k flowKey) reverse() flowKey {
+	return flowKey{Proto: k.Proto, Src: k.Dst, Dst: k.Src, SrcPort: k.DstPort, DstPort: k.SrcPort}
+}
+
+func (k flowKey) protocol() string {
+	return (&Packet{Proto: k.Proto}).Protocol()
+}
+
+func (k flowKey) flow() policy.Flow {
+	proto := k.protocol()
+	port := k.DstPort
+	if proto == "icmp" {
+		port = 0
+	}
+	return policy.Flow{Src: k.Src, Dst: k.Dst, Protocol: proto, Port: port}
+}
+
+// connEntry is the state of a connection, keyed by its initiating direction
+type connEntry struct {
+	decision policy.Decision
+	hub      bool // originated or served by the hub itself, always allowed
+
+	started  time.Time
+	lastSeen time.Time
+
+	bytesSent     int64 // initiator -> responder
+	bytesReceived int64 // responder -> initiator
+
+	finInitiator bool
+	finResponder bool
+	closed       bool // RST seen or both sides sent FIN
+}
+
+// account updates counters and TCP state for a packet of this connection
+func (e *connEntry) account(pkt *Packet, reply bool, now time.Time) {
+	e.lastSeen = now
+	if reply {
+		e.bytesReceived += int64(pkt.Length)
+	} else {
+		e.bytesSent += int64(pkt.Length)
+	}
+
+	if pkt.Proto != protoTCP {
+		return
+	}
+	if pkt.TCPFlags&tcpRST != 0 {
+		e.closed = true
+	}
+	if pkt.TCPFlags&tcpFIN != 0 {
+		if reply {
+			e.finResponder = true
+		} else {
+			e.finInitiator = true
+		}
+		if e.finInitiator && e.finResponder {
+			e.closed = true
+		}
+	}
+}
+
+// connState is a snapshot of the entry a packet belongs to
+type connState struct {
+	found     bool
+	hub       bool
+	decision  policy.Decision
+	initiator flowKey
+}
+
+// finishedConn is a connection removed from the table, ready to be logged
+type finishedConn struct {
+	key   flowKey
+	entry connEntry
+}
+
+// evictionSample is how many entries a full table looks at to pick the one
+// to evict
+const evictionSample = 8
+
+// connTable tracks connections so replies pass, established flows skip
+// re-evaluation, and traffic can be accounted per flow. It holds at most max
+// entries; opening a connection in a full table evicts an old one.
+type connTable struct {
+	mu      sync.Mutex
+	entries map[flowKey]*connEntry
+	ttl     time.Duration // idle timeout
+	linger  time.Duration // how long closed connections are kept for late packets
+	max     int
+}
+
+func newConnTable(ttl, linger time.Duration, max int) *connTable {
+	return &connTable{
+		entries: make(map[flowKey]*connEntry),
+		ttl:     ttl,
+		linger:  linger,
+		max:     max,
+	}
+}
+
+// observe finds the connection a packet belongs to in either direction and
+// accounts for it. When nothing matches, initiator is the packet's own key.
+func (t *connTable) observe(pkt *Packet, now time.Time) connState {
+	k := keyOf(pkt)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[k]; ok {
+		e.account(pkt, false, now)
+		return connState{found: true, hub: e.hub, decision: e.decision, initiator: k}
+	}
+	r := k.reverse()
+	if e, ok := t.entries[r]; ok {
+		e.account(pkt, true, now)
+		return connState{found: true, hub: e.hub, decision: e.decision, initiator: r}
+	}
+	return connState{initiator: k}
+}
+
+// open starts tracking a connection initiated by pkt. If the table was full
+// it returns the connection evicted to make room.
+func (t *connTable) open(pkt *Packet, decision policy.Decision, hub bool, now time.Time) (finishedConn, bool) {
+	e := &connEntry{decision: decision, hub: hub, started: now}
+	e.account(pkt, false, now)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var evicted finishedConn
+	full := len(t.entries) >= t.max
+	if full {
+		evicted = t.evict()
+	}
+	t.entries[keyOf(pkt)] = e
+	return evicted, full
+}
+
+// evict removes the closed or longest idle of a few entries picked by map
+// iteration order, which is random, so filling the table with new flows
+// cannot target particular connections. Callers hold t.mu.
+func (t *connTable) evict() finishedConn {
+	var victim flowKey
+	var oldest *connEntry
+	n := 0
+	for k, e := range t.entries {
+		if oldest == nil || (e.closed && !oldest.closed) ||
+			(e.closed == oldest.closed && e.lastSeen.Before(oldest.lastSeen)) {
+			victim, oldest = k, e
+		}
+		if n++; n == evictionSample {
+			break
+		}
+	}
+	delete(t.entries, victim)
+	return finishedConn{key: victim, entry: *oldest}
+}
+
+// update replaces the verdict of a tracked connection after re-evaluation
+func (t *connTable) update(k flowKey, decision policy.Decision) {
+	t.mu.Lock()
+	if e, ok := t.entries[k]; ok {
+		e.decision = decision
+	}
+	t.mu.Unlock()
+}
+
+// expire removes closed connections past their linger time and connections
+// idle for longer than the TTL, returning them for logging
+func (t *connTable) expire(now time.Time) []finishedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var done []finishedConn
+	for k, e := range t.entries {
+		idle := now.Sub(e.lastSeen)
+		if idle > t.ttl || (e.closed && idle > t.linger) {
+			done = append(done, finishedConn{key: k, entry: *e})
+			delete(t.entries, k)
+		}
+	}
+	return done
+}
@@ -0,0 +1,119 @@
+package dataplane
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
+)
+
+// denyKey groups denied packets by everything but the source port, so a peer
+// retrying or spraying source ports at one target shares a single entry
+type denyKey struct {
+	Proto   uint8
+	Src     netip.Addr
+	Dst     netip.Addr
+	DstPort uint16
+}
+
+func denyKeyOf(p *Packet) denyKey {
+	k := denyKey{Proto: p.Proto, Src: p.Src, Dst: p.Dst, DstPort: p.DstPort}
+	if p.Proto == protoICMP || p.Proto == protoICMPv6 {
+		k.DstPort = 0
+	}
+	return k
+}
+
+func (k denyKey) flow() policy.Flow {
+	return flowKey{Proto: k.Proto, Src: k.Src, Dst: k.Dst, DstPort: k.DstPort}.flow()
+}
+
+// denyEntry counts the packets denied for a key since it was cached
+type denyEntry struct {
+	decision policy.Decision
+	first    time.Time
+	last     time.Time
+	packets  int64
+	bytes    int64
+}
+
+// deniedFlow is a deny cache entry removed for logging
+type deniedFlow struct {
+	key   denyKey
+	entry denyEntry
+}
+
+// denyCache remembers recent denials so further packets towards a denied
+// target are dropped without evaluating policy again and without taking a
+// connection table entry. It holds at most max entries; once full, denials
+// are evaluated per packet and only counted until the next drain.
+type denyCache struct {
+	mu       sync.Mutex
+	entries  map[denyKey]*denyEntry
+	max      int
+	overflow int64 // denied packets not cached since the last drain
+}
+
+func newDenyCache(max int) *denyCache {
+	return &denyCache{
+		entries: make(map[denyKey]*denyEntry),
+		max:     max,
+	}
+}
+
+// check counts pkt against a cached denial and reports whether it is still
+// valid according to current
+func (c *denyCache) check(pkt *Packet, now time.Time, current func(policy.Decision) bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[denyKeyOf(pkt)]
+	if !ok || !current(e.decision) {
+		return false
+	}
+	e.count(pkt, now)
+	return true
+}
+
+// add caches a denial for the target of pkt
+func (c *denyCache) add(pkt *Packet, decision policy.Decision, now time.Time) {
+	k := denyKeyOf(pkt)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[k]
+	if !ok {
+		if len(c.entries) >= c.max {
+			c.overflow++
+			return
+		}
+		e = &denyEntry{first: now}
+		c.entries[k] = e
+	}
+	e.decision = decision
+	e.count(pkt, now)
+}
+
+func (e *denyEntry) count(pkt *Packet, now time.Time) {
+	e.last = now
+	e.packets++
+	e.bytes += int64(pkt.Length)
+}
+
+// drain empties the cache, returning its entries and how many denied packets
+// did not fit
+func (c *denyCache) drain() ([]deniedFlow, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flows := make([]deniedFlow, 0, len(c.entries))
+	for k, e := range c.entries {
+		flows = append(flows, deniedFlow{key: k, entry: *e})
+	}
+	overflow := c.overflow
+	c.entries = make(map[denyKey]*denyEntry)
+	c.overflow = 0
+	return flows, overflow
+}
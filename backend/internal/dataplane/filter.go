@@ -0,0 +1,310 @@
+package dataplane
+
+import (
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// Filter wraps the hub's netstack TUN device, enforces policy on every
+// packet crossing between the WireGuard device and the netstack, and routes
+// traffic between peers.
+//
+// Write carries decrypted packets from peers; these are evaluated against the
+// policy engine unless they belong to an already tracked connection. Allowed
+// packets addressed to the hub enter the netstack, packets addressed to
+// another agent are handed back to WireGuard for that peer.
+// Read carries packets the hub itself originates (debug tools, proxies) plus
+// forwarded peer traffic; hub traffic is always allowed and tracked so its
+// replies can come back. Queries to the hub's overlay DNS server are hub
+// traffic too: every agent may resolve names.
+//
+// Allowed connections are tracked in a bounded connection table. Denied
+// packets never get an entry there; a small deny cache keyed by target
+// drops repeats without evaluating policy again.
+//
+// Every peer-initiated connection is written to the access log once it
+// closes (FIN/RST) or goes idle, and each denied target once per sweep.
+type Filter struct {
+	tun.Device
+	engine   *policy.Engine
+	flowLog  *FlowLogger
+	conns    *connTable
+	denies   *denyCache
+	local    map[netip.Addr]struct{}
+	outbound chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Limits on tracked state, so a peer spraying ports cannot grow it unbounded
+const (
+	maxConns  = 65536
+	maxDenies = 4096
+)
+
+// NewFilter wraps dev with policy enforcement backed by engine. local lists
+// the hub's own overlay addresses; everything else is routed to peers.
+// flowLog may be nil to disable access logging.
+func NewFilter(dev tun.Device, engine *policy.Engine, local []netip.Addr, flowLog *FlowLogger) *Filter {
+	f := &Filter{
+		Device:   dev,
+		engine:   engine,
+		flowLog:  flowLog,
+		conns:    newConnTable(2*time.Minute, 5*time.Second, maxConns),
+		denies:   newDenyCache(maxDenies),
+		local:    make(map[netip.Addr]struct{}, len(local)),
+		outbound: make(chan []byte, 256),
+		done:     make(chan struct{}),
+	}
+	for _, addr := range local {
+		f.local[addr] = struct{}{}
+	}
+	go f.pumpNetstack()
+	go f.sweepLoop()
+	return f
+}
+
+// Write passes allowed packets from peers into the netstack or on to the
+// destination peer, and drops the rest
+func (f *Filter) Write(bufs [][]byte, offset int) (int, error) {
+	now := time.Now()
+	toNetstack := bufs[:0:0]
+	for _, buf := range bufs {
+		packet := buf[offset:]
+		pkt, err := ParsePacket(packet)
+		if err != nil || !f.allowInbound(&pkt, now) {
+			continue
+		}
+
+		if _, ok := f.local[pkt.Dst]; ok {
+			toNetstack = append(toNetstack, buf)
+			continue
+		}
+		f.forward(&pkt, packet)
+	}
+
+	if len(toNetstack) > 0 {
+		if _, err := f.Device.Write(toNetstack, offset); err != nil {
+			return 0, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// Read returns the next packet leaving the hub towards a peer
+func (f *Filter) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	for {
+		select {
+		case packet := <-f.outbound:
+			if len(packet) > len(bufs[0])-offset {
+				continue // larger than the device MTU, drop
+			}
+			sizes[0] = copy(bufs[0][offset:], packet)
+			return 1, nil
+		case <-f.done:
+			return 0, os.ErrClosed
+		}
+	}
+}
+
+// BatchSize is one since Read hands out a single packet at a time
+func (f *Filter) BatchSize() int {
+	return 1
+}
+
+// Close stops the background loops and closes the underlying device
+func (f *Filter) Close() error {
+	f.stop()
+	return f.Device.Close()
+}
+
+func (f *Filter) stop() {
+	f.stopOnce.Do(func() { close(f.done) })
+}
+
+func (f *Filter) allowInbound(pkt *Packet, now time.Time) bool {
+	state := f.conns.observe(pkt, now)
+	if state.found && (state.hub || f.current(state.decision, now)) {
+		return state.decision.Allowed
+	}
+	if !state.found && f.isDNSQuery(pkt) {
+		f.open(pkt, policy.Decision{Allowed: true, Reason: "overlay DNS"}, true, now)
+		return true
+	}
+	current := func(d policy.Decision) bool { return f.current(d, now) }
+	if !state.found && f.denies.check(pkt, now, current) {
+		return false
+	}
+
+	// New connection, or policies changed or a time window passed since it was evaluated
+	decision := f.engine.Evaluate(state.initiator.flow())
+	switch {
+	case state.found:
+		f.conns.update(state.initiator, decision)
+	case decision.Allowed:
+		f.open(pkt, decision, false, now)
+	default:
+		f.denies.add(pkt, decision, now)
+	}
+	return decision.Allowed
+}
+
+// open tracks a new connection, logging the one evicted if the table is full
+func (f *Filter) open(pkt *Packet, decision policy.Decision, hub bool, now time.Time) {
+	if evicted, ok := f.conns.open(pkt, decision, hub, now); ok {
+		f.logFlow(evicted)
+	}
+}
+
+// isDNSQuery reports whether a packet is addressed to the hub's DNS server
+func (f *Filter) isDNSQuery(pkt *Packet) bool {
+	if _, ok := f.local[pkt.Dst]; !ok {
+		return false
+	}
+	return (pkt.Proto == protoUDP || pkt.Proto == protoTCP) && pkt.DstPort == 53
+}
+
+// current reports whether a cached decision still reflects the policy set
+func (f *Filter) current(d policy.Decision, now time.Time) bool {
+	if d.Generation != f.engine.Generation() {
+		return false
+	}
+	return d.RecheckAt.IsZero() || now.Before(d.RecheckAt)
+}
+
+// forward routes a peer packet to the peer owning its destination address
+// or routing the subnet it is in
+func (f *Filter) forward(pkt *Packet, packet []byte) {
+	if _, ok := f.engine.AgentFor(pkt.Dst); !ok {
+		return
+	}
+	if !decrementTTL(packet) {
+		return
+	}
+
+	out := make([]byte, len(packet))
+	copy(out, packet)
+	select {
+	case f.outbound <- out:
+	case <-f.done:
+	default:
+		// Queue full, drop like a congested router would
+	}
+}
+
+func (f *Filter) trackOutbound(pkt *Packet, now time.Time) {
+	if state := f.conns.observe(pkt, now); state.found {
+		return
+	}
+	f.open(pkt, policy.Decision{Allowed: true, Reason: "hub originated"}, true, now)
+}
+
+// pumpNetstack moves packets originated by the hub's netstack onto the outbound queue
+func (f *Filter) pumpNetstack() {
+	defer f.stop()
+
+	bufs := [][]byte{make([]byte, 65535)}
+	sizes := make([]int, 1)
+	for {
+		n, err := f.Device.Read(bufs, sizes, 0)
+		if err != nil {
+			return
+		}
+		if n < 1 || sizes[0] < 1 {
+			continue
+		}
+
+		packet := make([]byte, sizes[0])
+		copy(packet, bufs[0][:sizes[0]])
+		if pkt, err := ParsePacket(packet); err == nil {
+			f.trackOutbound(&pkt, time.Now())
+		}
+
+		select {
+		case f.outbound <- packet:
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *Filter) sweepLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case now := <-ticker.C:
+			for _, conn := range f.conns.expire(now) {
+				f.logFlow(conn)
+			}
+			denied, overflow := f.denies.drain()
+			for _, flow := range denied {
+				f.logDenied(flow)
+			}
+			if overflow > 0 {
+				log.Printf("Deny cache full: %d denied packets were dropped without being logged", overflow)
+			}
+		}
+	}
+}
+
+// logFlow writes an access log entry for a finished peer connection
+func (f *Filter) logFlow(conn finishedConn) {
+	if f.flowLog == nil || conn.entry.hub {
+		return
+	}
+
+	d := conn.entry.decision
+	action := "denied"
+	if d.Allowed {
+		action = "allowed"
+	}
+
+	flow := conn.key.flow()
+	f.flowLog.Record(models.AccessLog{
+		CreatedAt:     conn.entry.started,
+		SourceAgentID: d.SourceAgentID,
+		DestAgentID:   d.DestAgentID,
+		ServiceID:     f.engine.ServiceFor(d.DestAgentID, flow.Protocol, flow.Port),
+		Action:        action,
+		Port:          int(flow.Port),
+		Protocol:      flow.Protocol,
+		BytesSent:     conn.entry.bytesSent,
+		BytesReceived: conn.entry.bytesReceived,
+		Duration:      conn.entry.lastSeen.Sub(conn.entry.started).Milliseconds(),
+		Count:         1,
+	})
+}
+
+// logDenied writes one access log entry for the packets denied towards a
+// target since the last sweep, however many source ports they came from
+func (f *Filter) logDenied(flow deniedFlow) {
+	if f.flowLog == nil {
+		return
+	}
+
+	d := flow.entry.decision
+	target := flow.key.flow()
+	f.flowLog.Record(models.AccessLog{
+		CreatedAt:     flow.entry.first,
+		SourceAgentID: d.SourceAgentID,
+		DestAgentID:   d.DestAgentID,
+		ServiceID:     f.engine.ServiceFor(d.DestAgentID, target.Protocol, target.Port),
+		Action:        "denied",
+		Port:          int(target.Port),
+		Protocol:      target.Protocol,
+		BytesSent:     flow.entry.bytes,
+		Duration:      flow.entry.last.Sub(flow.entry.first).Milliseconds(),
+		Count:         flow.entry.packets,
+	})
+}
@@ -0,0 +1,72 @@
+package dataplane
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+const (
+	flowLogBatchSize     = 100
+	flowLogFlushInterval = 5 * time.Second
+)
+
+// FlowLogger writes finished flows to the access_logs table in batches
+// so the packet path never waits on the database
+type FlowLogger struct {
+	records chan models.AccessLog
+	dropped atomic.Int64 // records dropped since the last flush
+}
+
+// NewFlowLogger starts a background writer for access log records
+func NewFlowLogger() *FlowLogger {
+	l := &FlowLogger{
+		records: make(chan models.AccessLog, 4096),
+	}
+	go l.run()
+	return l
+}
+
+// Record queues an access log entry, dropping it if the writer is backed
+// up. Drops are reported once per flush rather than per record.
+func (l *FlowLogger) Record(entry models.AccessLog) {
+	select {
+	case l.records <- entry:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *FlowLogger) run() {
+	ticker := time.NewTicker(flowLogFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AccessLog, 0, flowLogBatchSize)
+	flush := func() {
+		if n := l.dropped.Swap(0); n > 0 {
+			log.Printf("Access log queue full, dropped %d records", n)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := db.DB.CreateInBatches(batch, flowLogBatchSize).Error; err != nil {
+			log.Printf("Failed to write %d access logs: %v", len(batch), err)
+		}
+		batch = make([]models.AccessLog, 0, flowLogBatchSize)
+	}
+
+	for {
+		select {
+		case entry := <-l.records:
+			batch = append(batch, entry)
+			if len(batch) >= flowLogBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package dataplane
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// IP protocol numbers we care about
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// TCP flag bits
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+)
+
+var errShortPacket = errors.New("packet too short")
+
+// Packet holds the parsed headers of an IP packet
+type Packet struct {
+	Src      netip.Addr
+	Dst      netip.Addr
+	Proto    uint8
+	SrcPort  uint16
+	DstPort  uint16
+	TCPFlags uint8
+	Length   int
+}
+
+// Protocol returns the policy-level protocol name
+func (p *Packet) Protocol() string {
+	switch p.Proto {
+	case protoTCP:
+		return "tcp"
+	case protoUDP:
+		return "udp"
+	case protoICMP, protoICMPv6:
+		return "icmp"
+	default:
+		return "ip"
+	}
+}
+
+// ParsePacket decodes IPv4/IPv6 headers and the TCP/UDP/ICMP header that follows.
+// For ICMP echo messages the identifier is reported as both ports so replies
+// can be matched to requests.
+func ParsePacket(b []byte) (Packet, error) {
+	var p Packet
+	if len(b) < 1 {
+		return p, errShortPacket
+	}
+	p.Length = len(b)
+
+	var payload []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return p, errShortPacket
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return p, errShortPacket
+		}
+		p.Src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.Dst = netip.AddrFrom4([4]byte(b[16:20]))
+		p.Proto = b[9]
+		// Only the first fragment carries the transport header
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+			return p, nil
+		}
+		payload = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return p, errShortPacket
+		}
+		p.Src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.Dst = netip.AddrFrom16([16]byte(b[24:40]))
+		p.Proto = b[6]
+		payload = b[40:]
+	default:
+		return p, errors.New("unsupported IP version")
+	}
+
+	switch p.Proto {
+	case protoTCP:
+		if len(payload) < 14 {
+			return p, errShortPacket
+		}
+		p.SrcPort = binary.BigEndian.Uint16(payload[0:2])
+		p.DstPort = binary.BigEndian.Uint16(payload[2:4])
+		p.TCPFlags = payload[13]
+	case protoUDP:
+		if len(payload) < 8 {
+			return p, errShortPacket
+		}
+		p.SrcPort = binary.BigEndian.Uint16(payload[0:2])
+		p.DstPort = binary.BigEndian.Uint16(payload[2:4])
+	case protoICMP, protoICMPv6:
+		if len(payload) < 8 {
+			return p, errShortPacket
+		}
+		if isEcho(p.Proto, payload[0]) {
+			id := binary.BigEndian.Uint16(payload[4:6])
+			p.SrcPort, p.DstPort = id, id
+		}
+	}
+
+	return p, nil
+}
+
+// isEcho reports whether an ICMP type is an echo request or reply
+func isEcho(proto, typ uint8) bool {
+	if proto == protoICMP {
+		return typ == 8 || typ == 0
+	}
+	return typ == 128 || typ == 129
+}
+
+// decrementTTL lowers the IPv4 TTL or IPv6 hop limit of a packet being routed,
+// fixing up the IPv4 header checksum. It returns false when the packet has
+// expired and must be dropped.
+func decrementTTL(b []byte) bool {
+	switch b[0] >> 4 {
+	case 4:
+		if b[8] <= 1 {
+			return false
+		}
+		b[8]--
+		ihl := int(b[0]&0x0f) * 4
+		b[10], b[11] = 0, 0
+		binary.BigEndian.PutUint16(b[10:12], ipChecksum(b[:ihl]))
+	case 6:
+		if b[7] <= 1 {
+			return false
+		}
+		b[7]--
+	}
+	return true
+}
+
+// ipChecksum computes the Internet checksum of an IPv4 header
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,211 @@
+package dataplane
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+var (
+	src4 = netip.MustParseAddr("10.0.0.2")
+	dst4 = netip.MustParseAddr("10.0.0.3")
+	src6 = netip.MustParseAddr("fd00::2")
+	dst6 = netip.MustParseAddr("fd00::3")
+)
+
+// ipv4 builds an IPv4 packet with a 20 byte header around transport
+func ipv4(proto uint8, fragOffset uint16, transport []byte) []byte {
+	b := make([]byte, 20+len(transport))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	binary.BigEndian.PutUint16(b[6:8], fragOffset)
+	b[8] = 64
+	b[9] = proto
+	copy(b[12:16], src4.AsSlice())
+	copy(b[16:20], dst4.AsSlice())
+	binary.BigEndian.PutUint16(b[10:12], ipChecksum(b[:20]))
+	copy(b[20:], transport)
+	return b
+}
+
+// ipv6 builds an IPv6 packet without extension headers around transport
+func ipv6(proto uint8, transport []byte) []byte {
+	b := make([]byte, 40+len(transport))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(transport)))
+	b[6] = proto
+	b[7] = 64
+	copy(b[8:24], src6.AsSlice())
+	copy(b[24:40], dst6.AsSlice())
+	copy(b[40:], transport)
+	return b
+}
+
+// ports builds a TCP (20 bytes) or UDP (8 bytes) header
+func ports(proto uint8, srcPort, dstPort uint16, flags uint8) []byte {
+	n := 8
+	if proto == protoTCP {
+		n = 20
+	}
+	h := make([]byte, n)
+	binary.BigEndian.PutUint16(h[0:2], srcPort)
+	binary.BigEndian.PutUint16(h[2:4], dstPort)
+	if proto == protoTCP {
+		h[12] = 5 << 4
+		h[13] = flags
+	}
+	return h
+}
+
+// icmp builds an ICMP header with the given type and echo identifier
+func icmp(typ uint8, id uint16) []byte {
+	h := make([]byte, 8)
+	h[0] = typ
+	binary.BigEndian.PutUint16(h[4:6], id)
+	return h
+}
+
+func TestParsePacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		want    Packet
+		wantErr bool
+	}{
+		{
+			name:   "tcp syn",
+			packet: ipv4(protoTCP, 0, ports(protoTCP, 40000, 22, tcpSYN)),
+			want:   Packet{Src: src4, Dst: dst4, Proto: protoTCP, SrcPort: 40000, DstPort: 22, TCPFlags: tcpSYN, Length: 40},
+		},
+		{
+			name:   "udp",
+			packet: ipv4(protoUDP, 0, ports(protoUDP, 5353, 53, 0)),
+			want:   Packet{Src: src4, Dst: dst4, Proto: protoUDP, SrcPort: 5353, DstPort: 53, Length: 28},
+		},
+		{
+			name:   "icmp echo request reports its identifier as ports",
+			packet: ipv4(protoICMP, 0, icmp(8, 777)),
+			want:   Packet{Src: src4, Dst: dst4, Proto: protoICMP, SrcPort: 777, DstPort: 777, Length: 28},
+		},
+		{
+			name:   "icmp echo reply",
+			packet: ipv4(protoICMP, 0, icmp(0, 777)),
+			want:   Packet{Src: src4, Dst: dst4, Proto: protoICMP, SrcPort: 777, DstPort: 777, Length: 28},
+		},
+		{
+			name:   "icmp unreachable has no ports",
+			packet: ipv4(protoICMP, 0, icmp(3, 777)),
+			want:   Packet{Src: src4, Dst: dst4, Proto: protoICMP, Length: 28},
+		},
+		{
+			name:   "later fragments carry no transport header",
+			packet: ipv4(protoUDP, 185, []byte{1, 2, 3}),
+			want:   Packet{Src: src4, Dst: dst4, Proto: protoUDP, Length: 23},
+		},
+		{
+			name:   "other protocols",
+			packet: ipv4(47, 0, []byte{0, 0, 0, 0}),
+			want:   Packet{Src: src4, Dst: dst4, Proto: 47, Length: 24},
+		},
+		{
+			name:   "ipv6 tcp",
+			packet: ipv6(protoTCP, ports(protoTCP, 40000, 443, tcpFIN)),
+			want:   Packet{Src: src6, Dst: dst6, Proto: protoTCP, SrcPort: 40000, DstPort: 443, TCPFlags: tcpFIN, Length: 60},
+		},
+		{
+			name:   "icmpv6 echo request",
+			packet: ipv6(protoICMPv6, icmp(128, 9)),
+			want:   Packet{Src: src6, Dst: dst6, Proto: protoICMPv6, SrcPort: 9, DstPort: 9, Length: 48},
+		},
+		{
+			name:   "icmpv6 echo type numbers differ from icmp",
+			packet: ipv6(protoICMPv6, icmp(8, 9)),
+			want:   Packet{Src: src6, Dst: dst6, Proto: protoICMPv6, Length: 48},
+		},
+		{name: "empty", packet: nil, wantErr: true},
+		{name: "short ipv4 header", packet: ipv4(protoTCP, 0, nil)[:19], wantErr: true},
+		{name: "short ipv6 header", packet: ipv6(protoUDP, nil)[:39], wantErr: true},
+		{name: "truncated tcp header", packet: ipv4(protoTCP, 0, make([]byte, 13)), wantErr: true},
+		{name: "truncated udp header", packet: ipv4(protoUDP, 0, make([]byte, 7)), wantErr: true},
+		{name: "truncated icmp header", packet: ipv6(protoICMPv6, make([]byte, 4)), wantErr: true},
+		{name: "ihl past the end", packet: append([]byte{0x4f}, make([]byte, 30)...), wantErr: true},
+		{name: "unsupported version", packet: make([]byte, 40), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePacket(tt.packet)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePacket() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePacket() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePacket() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacketProtocol(t *testing.T) {
+	tests := []struct {
+		proto uint8
+		want  string
+	}{
+		{protoTCP, "tcp"},
+		{protoUDP, "udp"},
+		{protoICMP, "icmp"},
+		{protoICMPv6, "icmp"},
+		{47, "ip"},
+	}
+	for _, tt := range tests {
+		if got := (&Packet{Proto: tt.proto}).Protocol(); got != tt.want {
+			t.Errorf("Protocol() for %d = %q, want %q", tt.proto, got, tt.want)
+		}
+	}
+}
+
+func TestDecrementTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		ttl     byte
+		want    bool
+		wantTTL byte
+	}{
+		{"ipv4", ipv4(protoUDP, 0, ports(protoUDP, 1, 2, 0)), 64, true, 63},
+		{"ipv4 last hop", ipv4(protoUDP, 0, ports(protoUDP, 1, 2, 0)), 2, true, 1},
+		{"ipv4 expired", ipv4(protoUDP, 0, ports(protoUDP, 1, 2, 0)), 1, false, 1},
+		{"ipv6", ipv6(protoUDP, ports(protoUDP, 1, 2, 0)), 64, true, 63},
+		{"ipv6 expired", ipv6(protoUDP, ports(protoUDP, 1, 2, 0)), 1, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v4 := tt.packet[0]>>4 == 4
+			ttlAt := 7
+			if v4 {
+				ttlAt = 8
+			}
+			tt.packet[ttlAt] = tt.ttl
+			if v4 {
+				binary.BigEndian.PutUint16(tt.packet[10:12], 0)
+				binary.BigEndian.PutUint16(tt.packet[10:12], ipChecksum(tt.packet[:20]))
+			}
+
+			if got := decrementTTL(tt.packet); got != tt.want {
+				t.Fatalf("decrementTTL() = %t, want %t", got, tt.want)
+			}
+			if tt.packet[ttlAt] != tt.wantTTL {
+				t.Errorf("TTL = %d, want %d", tt.packet[ttlAt], tt.wantTTL)
+			}
+			// A valid header sums to zero including its checksum
+			if v4 && ipChecksum(tt.packet[:20]) != 0 {
+				t.Errorf("IPv4 header checksum is wrong after decrementing")
+			}
+		})
+	}
+}
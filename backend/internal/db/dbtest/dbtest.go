@@ -0,0 +1,44 @@
+// Package dbtest points the global database at a throwaway in-memory
+// SQLite database for tests
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open points db.DB at an empty in-memory database with models migrated and
+// restores the previous database when the test ends
+func Open(t testing.TB, models ...interface{}) *gorm.DB {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1) // every connection to :memory: is a separate database
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := conn.AutoMigrate(models...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() { db.DB = previous })
+	return conn
+}
+
+// Create inserts value, failing the test if it cannot
+func Create(t testing.TB, value interface{}) {
+	t.Helper()
+	if err := db.DB.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
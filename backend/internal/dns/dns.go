@@ -0,0 +1,546 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// recordTTL is the TTL of overlay answers, kept short so renames and new
+// agents are picked up quickly
+const recordTTL = 30
+
+// upstreamTimeout bounds a single exchange with an upstream resolver
+const upstreamTimeout = 3 * time.Second
+
+// maxCNAMEChain limits how many CNAMEs an answer follows
+const maxCNAMEChain = 8
+
+// maxUDPQueries and maxTCPConns bound the queries and connections handled
+// at once; a forwarded query can take upstreamTimeout, so a flood would
+// otherwise pile up goroutines. UDP queries over the limit are dropped and
+// left to the client to retry.
+const (
+	maxUDPQueries = 256
+	maxTCPConns   = 64
+)
+
+// DialFunc opens connections to resolvers
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Server is the overlay's DNS server. It answers <agent>.<domain> and
+// <service>.<agent>.<domain> with the agent's overlay address, serves the
+// custom records admins manage, sends queries for split domains to their
+// forwarders and forwards every other query to the upstream resolvers.
+// Records and forwarders scoped to a group are only visible to the agents
+// in it, identified by the query's source address. Everything is a
+// snapshot of the database refreshed by Reload.
+type Server struct {
+	domain   string   // lower-case, without a trailing dot
+	upstream []string // host:port
+	dial     DialFunc
+
+	udpSlots chan struct{}
+	tcpSlots chan struct{}
+
+	mu   sync.RWMutex
+	zone *zone
+}
+
+// zone is the snapshot a query is answered from. Names are lower-case
+// without a trailing dot.
+type zone struct {
+	names      map[string]netip.Addr
+	records    map[string][]models.DNSRecord
+	forwarders []models.DNSForwarder // longest domain first
+	groups     map[netip.Addr]uint   // agent overlay address to its group
+}
+
+// NewServer creates a server for domain with no records until Reload is
+// called. dial opens connections to forwarders and upstream resolvers; nil
+// uses the host network.
+func NewServer(domain string, upstream []string, dial DialFunc) *Server {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return &Server{
+		domain:   strings.ToLower(strings.Trim(domain, ".")),
+		upstream: upstream,
+		dial:     dial,
+		udpSlots: make(chan struct{}, maxUDPQueries),
+		tcpSlots: make(chan struct{}, maxTCPConns),
+		zone:     &zone{},
+	}
+}
+
+// Domain returns the domain overlay names live under
+func (s *Server) Domain() string {
+	return s.domain
+}
+
+// Reload rebuilds the snapshot from the database. Names are derived from
+// agent and service names with Label; when two agents share a name the
+// oldest one gets it.
+func (s *Server) Reload() error {
+	var agents []models.Agent
+	if err := db.DB.Select("id", "name", "ip", "group_id").Where("ip <> '' AND disabled = ?", false).
+		Order("id ASC").Find(&agents).Error; err != nil {
+		return fmt.Errorf("load agents: %w", err)
+	}
+	var services []models.Service
+	if err := db.DB.Select("id", "agent_id", "name").Where("enabled = ?", true).
+		Order("id ASC").Find(&services).Error; err != nil {
+		return fmt.Errorf("load services: %w", err)
+	}
+	var records []models.DNSRecord
+	if err := db.DB.Order("id ASC").Find(&records).Error; err != nil {
+		return fmt.Errorf("load DNS records: %w", err)
+	}
+	var forwarders []models.DNSForwarder
+	if err := db.DB.Order("id ASC").Find(&forwarders).Error; err != nil {
+		return fmt.Errorf("load DNS forwarders: %w", err)
+	}
+
+	z := &zone{
+		names:      make(map[string]netip.Addr, len(agents)+len(services)),
+		records:    make(map[string][]models.DNSRecord, len(records)),
+		forwarders: forwarders,
+		groups:     make(map[netip.Addr]uint, len(agents)),
+	}
+	hosts := make(map[uint]string, len(agents)) // agent ID to its name
+	for _, agent := range agents {
+		addr, err := netip.ParseAddr(agent.IP)
+		if err != nil {
+			continue
+		}
+		if agent.GroupID != nil {
+			z.groups[addr] = *agent.GroupID
+		}
+		label := Label(agent.Name)
+		if label == "" {
+			continue
+		}
+		name := label + "." + s.domain
+		if _, taken := z.names[name]; taken {
+			continue
+		}
+		z.names[name] = addr
+		hosts[agent.ID] = name
+	}
+	for _, svc := range services {
+		host, ok := hosts[svc.AgentID]
+		label := Label(svc.Name)
+		if !ok || label == "" {
+			continue
+		}
+		name := label + "." + host
+		if _, taken := z.names[name]; !taken {
+			z.names[name] = z.names[host]
+		}
+	}
+	for _, r := range records {
+		z.records[r.Name] = append(z.records[r.Name], r)
+	}
+	// The most specific forwarder wins, a group's own before a global one
+	sort.SliceStable(z.forwarders, func(i, j int) bool {
+		a, b := z.forwarders[i], z.forwarders[j]
+		if len(a.Domain) != len(b.Domain) {
+			return len(a.Domain) > len(b.Domain)
+		}
+		return a.GroupID != nil && b.GroupID == nil
+	})
+
+	s.mu.Lock()
+	s.zone = z
+	s.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the address of an overlay name
+func (s *Server) Lookup(name string) (netip.Addr, bool) {
+	addr, ok := s.current().names[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return addr, ok
+}
+
+func (s *Server) current() *zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.zone
+}
+
+// Label turns an agent or service name into a DNS label: lower-case
+// letters, digits and single hyphens, at most 63 characters. It returns ""
+// if nothing usable is left.
+func Label(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+	label := strings.TrimRight(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// ServeUDP answers queries arriving on conn until it is closed
+func (s *Server) ServeUDP(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case s.udpSlots <- struct{}{}:
+		default:
+			continue // saturated
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			defer func() { <-s.udpSlots }()
+			if reply := s.answer(query, "udp", clientAddr(addr)); reply != nil {
+				conn.WriteTo(reply, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP answers queries on connections accepted from listener until it
+// is closed
+func (s *Server) ServeTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case s.tcpSlots <- struct{}{}:
+		default:
+			conn.Close() // saturated
+			continue
+		}
+		go func() {
+			defer func() { <-s.tcpSlots }()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers length-prefixed queries until the client stops sending
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	client := clientAddr(conn.RemoteAddr())
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		reply := s.answer(query, "tcp", client)
+		if reply == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// clientAddr returns the address a query came from
+func clientAddr(addr net.Addr) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+// view is the zone as seen by one client
+type view struct {
+	*zone
+	group  uint
+	member bool // whether the client is in a group
+}
+
+// visible reports whether a record or forwarder scoped to group applies
+func (v view) visible(group *uint) bool {
+	return group == nil || (v.member && *group == v.group)
+}
+
+// forwarder returns the servers of the most specific split domain name
+// belongs to
+func (v view) forwarder(name string) ([]string, bool) {
+	for _, f := range v.forwarders {
+		if InDomain(name, f.Domain) && v.visible(f.GroupID) {
+			return f.Servers, true
+		}
+	}
+	return nil, false
+}
+
+// local answers name from the overlay names and custom records. found is
+// false when neither knows the name. A CNAME is returned as the only answer
+// together with its target, which the caller resolves next.
+func (v view) local(name string, qtype dnsmessage.Type) (answers []dnsmessage.Resource, cname string, found bool) {
+	owner, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, "", false
+	}
+	if addr, ok := v.names[name]; ok {
+		return addressAnswers(owner, addr, qtype, recordTTL), "", true
+	}
+
+	for _, r := range v.records[name] {
+		if !v.visible(r.GroupID) {
+			continue
+		}
+		found = true
+		rh := dnsmessage.ResourceHeader{Name: owner, Class: dnsmessage.ClassINET, TTL: r.TTL}
+		switch r.Type {
+		case TypeCNAME:
+			target, err := dnsmessage.NewName(r.Value + ".")
+			if err != nil {
+				continue
+			}
+			rh.Type = dnsmessage.TypeCNAME
+			answer := dnsmessage.Resource{Header: rh, Body: &dnsmessage.CNAMEResource{CNAME: target}}
+			if qtype == dnsmessage.TypeCNAME {
+				return []dnsmessage.Resource{answer}, "", true
+			}
+			return []dnsmessage.Resource{answer}, r.Value, true
+		case TypeA:
+			if addr, err := netip.ParseAddr(r.Value); err == nil {
+				answers = append(answers, addressAnswers(owner, addr, qtype, r.TTL)...)
+			}
+		case TypeSRV:
+			target, err := dnsmessage.NewName(r.Value + ".")
+			if err != nil || (qtype != dnsmessage.TypeSRV && qtype != dnsmessage.TypeALL) {
+				continue
+			}
+			rh.Type = dnsmessage.TypeSRV
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.SRVResource{
+				Priority: r.Priority, Weight: r.Weight, Port: r.Port, Target: target,
+			}})
+		}
+	}
+	return answers, "", found
+}
+
+// addressAnswers answers a query for an A or AAAA record with addr; other
+// record types get an empty answer since the name exists
+func addressAnswers(owner dnsmessage.Name, addr netip.Addr, qtype dnsmessage.Type, ttl uint32) []dnsmessage.Resource {
+	rh := dnsmessage.ResourceHeader{Name: owner, Class: dnsmessage.ClassINET, TTL: ttl}
+	switch {
+	case addr.Is4() && (qtype == dnsmessage.TypeA || qtype == dnsmessage.TypeALL):
+		rh.Type = dnsmessage.TypeA
+		return []dnsmessage.Resource{{Header: rh, Body: &dnsmessage.AResource{A: addr.As4()}}}
+	case addr.Is6() && (qtype == dnsmessage.TypeAAAA || qtype == dnsmessage.TypeALL):
+		rh.Type = dnsmessage.TypeAAAA
+		return []dnsmessage.Resource{{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}}}
+	}
+	return nil
+}
+
+// answer handles one query from client, sent over network. Overlay names
+// and custom records are answered locally, following CNAMEs; split domains
+// go to their forwarders and anything else outside the overlay domain
+// upstream. It returns nil for messages that are not worth a reply.
+func (s *Server) answer(query []byte, network string, client netip.Addr) []byte {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil || header.Response {
+		return nil
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return reply(header, nil, dnsmessage.RCodeFormatError, nil)
+	}
+	if header.OpCode != 0 {
+		return reply(header, &question, dnsmessage.RCodeNotImplemented, nil)
+	}
+
+	z := s.current()
+	group, member := z.groups[client]
+	v := view{zone: z, group: group, member: member}
+
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	answers, cname, found := v.local(name, question.Type)
+	if !found {
+		if InDomain(name, s.domain) {
+			if name == s.domain {
+				return reply(header, &question, dnsmessage.RCodeSuccess, nil)
+			}
+			return reply(header, &question, dnsmessage.RCodeNameError, nil)
+		}
+		if answer, err := s.forward(v, name, query, network); err == nil {
+			return answer
+		}
+		return reply(header, &question, dnsmessage.RCodeServerFailure, nil)
+	}
+
+	for i := 0; cname != "" && i < maxCNAMEChain; i++ {
+		var more []dnsmessage.Resource
+		name = cname
+		more, cname, found = v.local(name, question.Type)
+		answers = append(answers, more...)
+		if found {
+			continue
+		}
+		if InDomain(name, s.domain) {
+			return reply(header, &question, dnsmessage.RCodeNameError, answers)
+		}
+		// The target is somebody else's name
+		more, rcode, err := s.resolve(v, name, question.Type, network)
+		if err != nil {
+			return reply(header, &question, dnsmessage.RCodeServerFailure, answers)
+		}
+		return reply(header, &question, rcode, append(answers, more...))
+	}
+	return reply(header, &question, dnsmessage.RCodeSuccess, answers)
+}
+
+// reply builds a response to a query; answers from the records are
+// authoritative
+func reply(query dnsmessage.Header, question *dnsmessage.Question, rcode dnsmessage.RCode, answers []dnsmessage.Resource) []byte {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.ID,
+			Response:           true,
+			Authoritative:      rcode == dnsmessage.RCodeSuccess || rcode == dnsmessage.RCodeNameError,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Answers: answers,
+	}
+	if question != nil {
+		msg.Questions = []dnsmessage.Question{*question}
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		log.Printf("DNS: failed to pack reply: %v", err)
+		return nil
+	}
+	return packed
+}
+
+// forward relays a query for name to the forwarder of its split domain or
+// to the upstream resolvers, trying each server in turn. It uses the same
+// transport as the client so truncated UDP answers make it retry over TCP.
+func (s *Server) forward(v view, name string, query []byte, network string) ([]byte, error) {
+	servers, ok := v.forwarder(name)
+	if !ok {
+		servers = s.upstream
+	}
+	err := fmt.Errorf("no upstream resolvers configured")
+	for _, server := range servers {
+		var answer []byte
+		answer, err = s.exchange(query, network, server)
+		if err == nil {
+			return answer, nil
+		}
+	}
+	return nil, err
+}
+
+// resolve asks the forwarders or upstream resolvers about name on behalf of
+// a client, returning the answers and response code
+func (s *Server) resolve(v view, name string, qtype dnsmessage.Type, network string) ([]dnsmessage.Resource, dnsmessage.RCode, error) {
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	answer, err := s.forward(v, name, query, network)
+	if err != nil {
+		return nil, 0, err
+	}
+	var response dnsmessage.Message
+	if err := response.Unpack(answer); err != nil {
+		return nil, 0, err
+	}
+	return response.Answers, response.RCode, nil
+}
+
+func (s *Server) exchange(query []byte, network, server string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+	conn, err := s.dial(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Skip stray datagrams that do not answer this query
+		if n >= 2 && binary.BigEndian.Uint16(buf[:2]) == binary.BigEndian.Uint16(query[:2]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+// readTCPMessage reads a DNS message preceded by its two-byte length
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,259 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testDomain = "zta.internal"
+
+// openTestDB migrates the tables Reload reads into a fresh database
+func openTestDB(t *testing.T) {
+	dbtest.Open(t, &models.Group{}, &models.Agent{}, &models.Service{},
+		&models.DNSRecord{}, &models.DNSForwarder{})
+}
+
+// startResolver runs a UDP resolver on localhost answering every A query
+// with addr, and returns its address
+func startResolver(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a := netip.MustParseAddr(addr).As4()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			msg.Header.Response = true
+			if q.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: a},
+				}}
+			}
+			if packed, err := msg.Pack(); err == nil {
+				conn.WriteTo(packed, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// packQuery builds a query for name
+func packQuery(t *testing.T, id uint16, name string, qtype dnsmessage.Type) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name + "."), Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("pack query: %v", err)
+	}
+	return packed
+}
+
+// ask sends a query for name from client through the server's answer path
+func ask(t *testing.T, s *Server, client, name string, qtype dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+	raw := s.answer(packQuery(t, 42, name, qtype), "udp", netip.MustParseAddr(client))
+	var msg dnsmessage.Message
+	if err := msg.Unpack(raw); err != nil {
+		t.Fatalf("unpack reply for %s: %v", name, err)
+	}
+	if msg.Header.ID != 42 || !msg.Header.Response {
+		t.Fatalf("reply header for %s = %+v", name, msg.Header)
+	}
+	return msg
+}
+
+// answerStrings renders the answer section as "TYPE value" entries
+func answerStrings(msg dnsmessage.Message) []string {
+	var out []string
+	for _, a := range msg.Answers {
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			out = append(out, "A "+netip.AddrFrom4(body.A).String())
+		case *dnsmessage.AAAAResource:
+			out = append(out, "AAAA "+netip.AddrFrom16(body.AAAA).String())
+		case *dnsmessage.CNAMEResource:
+			out = append(out, "CNAME "+body.CNAME.String())
+		case *dnsmessage.SRVResource:
+			out = append(out, "SRV "+body.Target.String())
+		}
+	}
+	return out
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"web", "web"},
+		{"Web Server", "web-server"},
+		{"  db--primary!! ", "db-primary"},
+		{"Alice's MacBook Pro", "alice-s-macbook-pro"},
+		{"-leading", "leading"},
+		{"ünïcode", "n-code"},
+		{"!!!", ""},
+		{"", ""},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+		{strings.Repeat("a", 62) + " host", strings.Repeat("a", 62)}, // no trailing hyphen after the cut
+	}
+	for _, tt := range tests {
+		if got := Label(tt.name); got != tt.want {
+			t.Errorf("Label(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// overlayFixture creates agents and services for the overlay name tests
+func overlayFixture(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+
+	web := models.Agent{Name: "Web Server", IP: "10.0.0.2"}
+	db1 := models.Agent{Name: "db", IP: "10.0.0.3"}
+	db2 := models.Agent{Name: "DB", IP: "10.0.0.4"} // same label, created later
+	v6 := models.Agent{Name: "v6", IP: "fd00::5"}
+	pending := models.Agent{Name: "pending"} // no address yet
+	for _, a := range []*models.Agent{&web, &db1, &db2, &v6, &pending} {
+		dbtest.Create(t, a)
+	}
+	off := models.Agent{Name: "off", IP: "10.0.0.6"}
+	dbtest.Create(t, &off)
+	db.DB.Model(&off).Update("disabled", true)
+
+	dbtest.Create(t, &models.Service{AgentID: web.ID, Name: "API", Port: 8080})
+	stopped := models.Service{AgentID: web.ID, Name: "old", Port: 8081}
+	dbtest.Create(t, &stopped)
+	db.DB.Model(&stopped).Update("enabled", false)
+}
+
+func TestServerOverlayNames(t *testing.T) {
+	overlayFixture(t)
+	s := NewServer(testDomain+".", []string{startResolver(t, "192.0.2.1")}, nil)
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		qtype   dnsmessage.Type
+		rcode   dnsmessage.RCode
+		answers []string
+	}{
+		{"web-server.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.0.0.2"}},
+		{"WEB-Server.ZTA.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.0.0.2"}},
+		{"api.web-server.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.0.0.2"}},
+		{"db.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.0.0.3"}}, // oldest agent wins
+		{"v6.zta.internal", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, []string{"AAAA fd00::5"}},
+		{"web-server.zta.internal", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, nil}, // name exists, no IPv6
+		{"web-server.zta.internal", dnsmessage.TypeMX, dnsmessage.RCodeSuccess, nil},
+		{"zta.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, nil},
+		{"missing.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},
+		{"off.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},            // disabled agent
+		{"pending.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},        // no address
+		{"old.web-server.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil}, // disabled service
+		{"example.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.qtype.String(), func(t *testing.T) {
+			msg := ask(t, s, "10.0.0.2", tt.name, tt.qtype)
+			if msg.Header.RCode != tt.rcode {
+				t.Errorf("rcode = %v, want %v", msg.Header.RCode, tt.rcode)
+			}
+			if got := answerStrings(msg); !slices.Equal(got, tt.answers) {
+				t.Errorf("answers = %q, want %q", got, tt.answers)
+			}
+		})
+	}
+
+	if addr, ok := s.Lookup("API.web-server.zta.internal."); !ok || addr != netip.MustParseAddr("10.0.0.2") {
+		t.Errorf("Lookup = %v, %t", addr, ok)
+	}
+}
+
+func TestServerMalformedQueries(t *testing.T) {
+	openTestDB(t)
+	s := NewServer(testDomain, nil, nil)
+	client := netip.MustParseAddr("10.0.0.2")
+
+	// Responses and garbage get no reply at all
+	response := packQuery(t, 1, "web.zta.internal", dnsmessage.TypeA)
+	response[2] |= 0x80
+	for name, query := range map[string][]byte{"response": response, "garbage": {1, 2, 3}} {
+		if reply := s.answer(query, "udp", client); reply != nil {
+			t.Errorf("%s: got a reply", name)
+		}
+	}
+
+	// Without upstream resolvers outside names fail rather than hang
+	if msg := ask(t, s, "10.0.0.2", "example.com", dnsmessage.TypeA); msg.Header.RCode != dnsmessage.RCodeServerFailure {
+		t.Errorf("rcode = %v, want SERVFAIL", msg.Header.RCode)
+	}
+}
+
+func TestServeUDPDropsWhenSaturated(t *testing.T) {
+	overlayFixture(t)
+	s := NewServer(testDomain, nil, nil)
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.ServeUDP(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	exchange := func(id uint16, wait time.Duration) bool {
+		client.Write(packQuery(t, id, "web-server.zta.internal", dnsmessage.TypeA))
+		client.SetReadDeadline(time.Now().Add(wait))
+		buf := make([]byte, 512)
+		n, err := client.Read(buf)
+		return err == nil && n > 0
+	}
+
+	// Occupy every slot as if that many queries were in flight
+	for i := 0; i < cap(s.udpSlots); i++ {
+		s.udpSlots <- struct{}{}
+	}
+	if exchange(1, 200*time.Millisecond) {
+		t.Fatal("query answered while the server was saturated")
+	}
+	for i := 0; i < cap(s.udpSlots); i++ {
+		<-s.udpSlots
+	}
+	if !exchange(2, 2*time.Second) {
+		t.Fatal("query not answered once slots were free")
+	}
+}
@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// Record types admins can create
+const (
+	TypeA     = "A"
+	TypeCNAME = "CNAME"
+	TypeSRV   = "SRV"
+)
+
+// defaultRecordTTL applies to custom records created without a TTL
+const defaultRecordTTL = 300
+
+// maxSearchDomains matches the limit of common stub resolvers
+const maxSearchDomains = 6
+
+// NormalizeName validates a domain name and returns it lower-case without a
+// trailing dot. Labels may contain letters, digits, hyphens and underscores
+// (as in SRV names like _ldap._tcp).
+func NormalizeName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("%q is longer than 253 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", fmt.Errorf("%q has an empty or too long label", name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("%q has a label starting or ending with a hyphen", name)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return "", fmt.Errorf("%q contains invalid character %q", name, r)
+			}
+		}
+	}
+	return name, nil
+}
+
+// InDomain reports whether name is domain or one of its subdomains
+func InDomain(name, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// NormalizeRecord validates a custom record and rewrites its fields in
+// their canonical form. Names under the overlay domain are allowed, but
+// agent and service names take precedence over them.
+func NormalizeRecord(r *models.DNSRecord) error {
+	name, err := NormalizeName(r.Name)
+	if err != nil {
+		return err
+	}
+	r.Name = name
+	r.Type = strings.ToUpper(strings.TrimSpace(r.Type))
+	r.Value = strings.TrimSpace(r.Value)
+	if r.TTL == 0 {
+		r.TTL = defaultRecordTTL
+	}
+	if r.TTL > 86400 {
+		return errors.New("ttl must be at most 86400 seconds")
+	}
+
+	switch r.Type {
+	case TypeA:
+		addr, err := netip.ParseAddr(r.Value)
+		if err != nil || !addr.Is4() {
+			return fmt.Errorf("%q is not an IPv4 address", r.Value)
+		}
+		r.Value = addr.String()
+		r.Priority, r.Weight, r.Port = 0, 0, 0
+	case TypeCNAME, TypeSRV:
+		target, err := NormalizeName(r.Value)
+		if err != nil {
+			return fmt.Errorf("invalid target: %w", err)
+		}
+		r.Value = target
+		if r.Type == TypeCNAME {
+			if target == r.Name {
+				return errors.New("a CNAME cannot point to itself")
+			}
+			r.Priority, r.Weight, r.Port = 0, 0, 0
+		} else if r.Port == 0 {
+			return errors.New("port is required for SRV records")
+		}
+	default:
+		return fmt.Errorf("unsupported type %q, expected A, CNAME or SRV", r.Type)
+	}
+	return nil
+}
+
+// NormalizeForwarder validates a split DNS forwarder. overlayDomain is
+// answered by the hub itself, so it cannot be forwarded.
+func NormalizeForwarder(f *models.DNSForwarder, overlayDomain string) error {
+	domain, err := NormalizeName(f.Domain)
+	if err != nil {
+		return err
+	}
+	if InDomain(domain, overlayDomain) {
+		return fmt.Errorf("%q is part of the overlay domain %q", domain, overlayDomain)
+	}
+	f.Domain = domain
+	if len(f.Servers) == 0 {
+		return errors.New("at least one server is required")
+	}
+	for i, s := range f.Servers {
+		server, err := NormalizeServer(s)
+		if err != nil {
+			return err
+		}
+		f.Servers[i] = server
+	}
+	return nil
+}
+
+// NormalizeServer validates a resolver address given as ip or ip:port and
+// returns it as ip:port, defaulting to port 53. Host names are rejected since
+// resolving them would need DNS.
+func NormalizeServer(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.AddrPortFrom(addr, 53).String(), nil
+	}
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil || addrPort.Port() == 0 {
+		return "", fmt.Errorf("%q is not an IP address with an optional port", s)
+	}
+	return addrPort.String(), nil
+}
+
+// NormalizeSearchDomains validates search domains, dropping duplicates and
+// the overlay domain, which agents always search first
+func NormalizeSearchDomains(domains []string, overlayDomain string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{overlayDomain: true}
+	for _, d := range domains {
+		domain, err := NormalizeName(d)
+		if err != nil {
+			return nil, err
+		}
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		normalized = append(normalized, domain)
+	}
+	if len(normalized) > maxSearchDomains-1 {
+		return nil, fmt.Errorf("at most %d search domains besides %q", maxSearchDomains-1, overlayDomain)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,288 @@
+package dns
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "Intranet.Corp.", want: "intranet.corp"},
+		{name: " _ldap._tcp.corp ", want: "_ldap._tcp.corp"},
+		{name: "a-1.example", want: "a-1.example"},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "a..corp", wantErr: true},
+		{name: "-a.corp", wantErr: true},
+		{name: "a-.corp", wantErr: true},
+		{name: "a b.corp", wantErr: true},
+		{name: "*.corp", wantErr: true},
+		{name: strings.Repeat("a", 64) + ".corp", wantErr: true},
+		{name: strings.Repeat("abcdefghi.", 26) + "corp", wantErr: true}, // 264 characters
+	}
+	for _, tt := range tests {
+		got, err := NormalizeName(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeName(%q) = %q, %v, want %q, error %t", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestInDomain(t *testing.T) {
+	tests := []struct {
+		name, domain string
+		want         bool
+	}{
+		{"zta.internal", "zta.internal", true},
+		{"web.zta.internal", "zta.internal", true},
+		{"a.b.zta.internal", "zta.internal", true},
+		{"notzta.internal", "zta.internal", false},
+		{"internal", "zta.internal", false},
+	}
+	for _, tt := range tests {
+		if got := InDomain(tt.name, tt.domain); got != tt.want {
+			t.Errorf("InDomain(%q, %q) = %t, want %t", tt.name, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  models.DNSRecord
+		want    models.DNSRecord
+		wantErr bool
+	}{
+		{
+			name:   "a record",
+			record: models.DNSRecord{Name: "Intranet.Corp.", Type: " a ", Value: " 10.1.0.5 ", Port: 80},
+			want:   models.DNSRecord{Name: "intranet.corp", Type: "A", Value: "10.1.0.5", TTL: 300},
+		},
+		{
+			name:   "cname",
+			record: models.DNSRecord{Name: "www.corp", Type: "cname", Value: "Intranet.Corp.", TTL: 60, Priority: 5},
+			want:   models.DNSRecord{Name: "www.corp", Type: "CNAME", Value: "intranet.corp", TTL: 60},
+		},
+		{
+			name:   "srv",
+			record: models.DNSRecord{Name: "_ldap._tcp.corp", Type: "SRV", Value: "dc.corp", Priority: 10, Weight: 5, Port: 389, TTL: 86400},
+			want:   models.DNSRecord{Name: "_ldap._tcp.corp", Type: "SRV", Value: "dc.corp", Priority: 10, Weight: 5, Port: 389, TTL: 86400},
+		},
+		{name: "bad name", record: models.DNSRecord{Name: "bad name", Type: "A", Value: "10.1.0.5"}, wantErr: true},
+		{name: "ipv6 value", record: models.DNSRecord{Name: "a.corp", Type: "A", Value: "fd00::1"}, wantErr: true},
+		{name: "hostname value", record: models.DNSRecord{Name: "a.corp", Type: "A", Value: "intranet.corp"}, wantErr: true},
+		{name: "cname to itself", record: models.DNSRecord{Name: "a.corp", Type: "CNAME", Value: "A.corp."}, wantErr: true},
+		{name: "bad target", record: models.DNSRecord{Name: "a.corp", Type: "CNAME", Value: "-b.corp"}, wantErr: true},
+		{name: "srv without port", record: models.DNSRecord{Name: "_x._tcp.corp", Type: "SRV", Value: "dc.corp"}, wantErr: true},
+		{name: "ttl too long", record: models.DNSRecord{Name: "a.corp", Type: "A", Value: "10.1.0.5", TTL: 86401}, wantErr: true},
+		{name: "unsupported type", record: models.DNSRecord{Name: "a.corp", Type: "MX", Value: "mail.corp"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.record
+			err := NormalizeRecord(&r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NormalizeRecord() accepted %+v", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeRecord() error: %v", err)
+			}
+			if r != tt.want {
+				t.Errorf("NormalizeRecord() = %+v, want %+v", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeServer(t *testing.T) {
+	tests := []struct {
+		server  string
+		want    string
+		wantErr bool
+	}{
+		{server: "10.1.0.53", want: "10.1.0.53:53"},
+		{server: " 10.1.0.53:5353 ", want: "10.1.0.53:5353"},
+		{server: "fd00::53", want: "[fd00::53]:53"},
+		{server: "[fd00::53]:5353", want: "[fd00::53]:5353"},
+		{server: "10.1.0.53:0", wantErr: true},
+		{server: "dns.corp", wantErr: true},
+		{server: "dns.corp:53", wantErr: true},
+		{server: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeServer(tt.server)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeServer(%q) = %q, %v, want %q, error %t", tt.server, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeForwarder(t *testing.T) {
+	tests := []struct {
+		name    string
+		domain  string
+		servers []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "valid", domain: "Corp.Example.", servers: []string{"10.1.0.53", "10.1.0.54:5353"}, want: []string{"10.1.0.53:53", "10.1.0.54:5353"}},
+		{name: "overlay domain", domain: "zta.internal", servers: []string{"10.1.0.53"}, wantErr: true},
+		{name: "inside the overlay domain", domain: "lab.zta.internal", servers: []string{"10.1.0.53"}, wantErr: true},
+		{name: "no servers", domain: "corp.example", wantErr: true},
+		{name: "bad server", domain: "corp.example", servers: []string{"dns.corp.example"}, wantErr: true},
+		{name: "bad domain", domain: "corp..example", servers: []string{"10.1.0.53"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := models.DNSForwarder{Domain: tt.domain, Servers: tt.servers}
+			err := NormalizeForwarder(&f, testDomain)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NormalizeForwarder() accepted %+v", f)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeForwarder() error: %v", err)
+			}
+			if f.Domain != "corp.example" || !slices.Equal(f.Servers, tt.want) {
+				t.Errorf("NormalizeForwarder() = %q %q, want corp.example %q", f.Domain, f.Servers, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSearchDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", domains: nil, want: []string{}},
+		{name: "duplicates and overlay dropped", domains: []string{"Corp.Example", "zta.internal", "corp.example."}, want: []string{"corp.example"}},
+		{name: "order kept", domains: []string{"b.example", "a.example"}, want: []string{"b.example", "a.example"}},
+		{name: "five is the limit", domains: []string{"a.x", "b.x", "c.x", "d.x", "e.x", "zta.internal"}, want: []string{"a.x", "b.x", "c.x", "d.x", "e.x"}},
+		{name: "too many", domains: []string{"a.x", "b.x", "c.x", "d.x", "e.x", "f.x"}, wantErr: true},
+		{name: "invalid", domains: []string{"corp example"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeSearchDomains(tt.domains, testDomain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeSearchDomains(%q) error = %v, want error %t", tt.domains, err, tt.wantErr)
+			}
+			if !tt.wantErr && (got == nil || !slices.Equal(got, tt.want)) {
+				t.Errorf("NormalizeSearchDomains(%q) = %#v, want %q", tt.domains, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerCustomRecords(t *testing.T) {
+	openTestDB(t)
+	upstream := startResolver(t, "192.0.2.1")
+	globalDNS := startResolver(t, "198.51.100.1")
+	engDNS := startResolver(t, "198.51.100.2")
+	devDNS := startResolver(t, "198.51.100.3")
+
+	eng := models.Group{Name: "eng"}
+	ops := models.Group{Name: "ops"}
+	dbtest.Create(t, &eng)
+	dbtest.Create(t, &ops)
+	dbtest.Create(t, &models.Agent{Name: "laptop", IP: "10.0.0.2", GroupID: &eng.ID})
+	dbtest.Create(t, &models.Agent{Name: "server", IP: "10.0.0.3", GroupID: &ops.ID})
+	dbtest.Create(t, &models.Agent{Name: "kiosk", IP: "10.0.0.4"})
+
+	for _, r := range []models.DNSRecord{
+		{Name: "intranet.corp", Type: TypeA, Value: "10.1.0.5", TTL: 300},
+		{Name: "intranet.corp", Type: TypeA, Value: "10.1.0.6", TTL: 300},
+		{Name: "www.corp", Type: TypeCNAME, Value: "intranet.corp", TTL: 300},
+		{Name: "docs.corp", Type: TypeCNAME, Value: "www.corp", TTL: 300},
+		{Name: "cdn.corp", Type: TypeCNAME, Value: "cdn.example.net", TTL: 300},
+		{Name: "_ldap._tcp.corp", Type: TypeSRV, Value: "dc.corp", Port: 389, TTL: 300},
+		{Name: "wiki.zta.internal", Type: TypeA, Value: "10.1.0.7", TTL: 300},
+		{Name: "laptop.zta.internal", Type: TypeA, Value: "10.1.0.8", TTL: 300}, // shadowed by the agent
+		{Name: "dangling.zta.internal", Type: TypeCNAME, Value: "gone.zta.internal", TTL: 300},
+		{Name: "ping.zta.internal", Type: TypeCNAME, Value: "pong.zta.internal", TTL: 300},
+		{Name: "pong.zta.internal", Type: TypeCNAME, Value: "ping.zta.internal", TTL: 300},
+		{Name: "build.corp", Type: TypeA, Value: "10.1.0.9", TTL: 300, GroupID: &eng.ID},
+	} {
+		dbtest.Create(t, &r)
+	}
+	for _, f := range []models.DNSForwarder{
+		{Domain: "corp.example", Servers: []string{globalDNS}},
+		{Domain: "corp.example", Servers: []string{engDNS}, GroupID: &eng.ID},
+		{Domain: "dev.corp.example", Servers: []string{devDNS}},
+	} {
+		dbtest.Create(t, &f)
+	}
+
+	s := NewServer(testDomain, []string{upstream}, nil)
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	const laptop, server, kiosk = "10.0.0.2", "10.0.0.3", "10.0.0.4"
+	tests := []struct {
+		name    string
+		client  string
+		qname   string
+		qtype   dnsmessage.Type
+		rcode   dnsmessage.RCode
+		answers []string
+	}{
+		{"a records", kiosk, "intranet.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.1.0.5", "A 10.1.0.6"}},
+		{"no aaaa", kiosk, "intranet.corp", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, nil},
+		{"cname followed", kiosk, "www.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess,
+			[]string{"CNAME intranet.corp.", "A 10.1.0.5", "A 10.1.0.6"}},
+		{"cname chain", kiosk, "docs.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess,
+			[]string{"CNAME www.corp.", "CNAME intranet.corp.", "A 10.1.0.5", "A 10.1.0.6"}},
+		{"cname query not followed", kiosk, "www.corp", dnsmessage.TypeCNAME, dnsmessage.RCodeSuccess, []string{"CNAME intranet.corp."}},
+		{"cname to an outside name", kiosk, "cdn.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess,
+			[]string{"CNAME cdn.example.net.", "A 192.0.2.1"}},
+		{"srv", kiosk, "_ldap._tcp.corp", dnsmessage.TypeSRV, dnsmessage.RCodeSuccess, []string{"SRV dc.corp."}},
+		{"srv only for srv queries", kiosk, "_ldap._tcp.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess, nil},
+		{"record in the overlay domain", kiosk, "wiki.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.1.0.7"}},
+		{"agent names take precedence", kiosk, "laptop.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.0.0.2"}},
+		{"dangling cname", kiosk, "dangling.zta.internal", dnsmessage.TypeA, dnsmessage.RCodeNameError, []string{"CNAME gone.zta.internal."}},
+		{"group record for a member", laptop, "build.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 10.1.0.9"}},
+		{"group record for another group", server, "build.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 192.0.2.1"}},
+		{"group record without a group", kiosk, "build.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 192.0.2.1"}},
+		{"group record for an unknown client", "10.0.0.99", "build.corp", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 192.0.2.1"}},
+		{"global forwarder", server, "git.corp.example", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 198.51.100.1"}},
+		{"group forwarder first", laptop, "git.corp.example", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 198.51.100.2"}},
+		{"forwarder apex", kiosk, "corp.example", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 198.51.100.1"}},
+		{"most specific forwarder", laptop, "ci.dev.corp.example", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 198.51.100.3"}},
+		{"outside every forwarder", laptop, "notcorp.example", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"A 192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := ask(t, s, tt.client, tt.qname, tt.qtype)
+			if msg.Header.RCode != tt.rcode {
+				t.Errorf("rcode = %v, want %v", msg.Header.RCode, tt.rcode)
+			}
+			if got := answerStrings(msg); !slices.Equal(got, tt.answers) {
+				t.Errorf("answers = %q, want %q", got, tt.answers)
+			}
+		})
+	}
+
+	// A CNAME loop stops after maxCNAMEChain hops instead of spinning
+	msg := ask(t, s, kiosk, "ping.zta.internal", dnsmessage.TypeA)
+	if msg.Header.RCode != dnsmessage.RCodeSuccess || len(msg.Answers) != maxCNAMEChain+1 {
+		t.Errorf("loop: rcode = %v with %d answers, want success with %d", msg.Header.RCode, len(msg.Answers), maxCNAMEChain+1)
+	}
+}
@@ -0,0 +1,214 @@
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Resolver maps a public address to an ISO 3166-1 alpha-2 country code
+type Resolver interface {
+	Country(addr netip.Addr) (string, bool)
+}
+
+// current is the resolver set by Init; nil when no source is configured
+var current Resolver
+
+// Init sets up geo-IP lookups from a MaxMind-format database, a
+// CIDR-to-country table, or both. The table is consulted first so it can
+// cover private ranges or correct the database. With neither configured
+// every address resolves to an unknown country.
+func Init(databaseFile, cidrFile string) error {
+	var chain resolverChain
+	if cidrFile != "" {
+		table, err := LoadTable(cidrFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded %d geo-IP networks from %s", table.Len(), cidrFile)
+		chain = append(chain, table)
+	}
+	if databaseFile != "" {
+		database, err := OpenDatabase(databaseFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("Using geo-IP database %s", databaseFile)
+		chain = append(chain, database)
+	}
+
+	current = nil
+	if len(chain) > 0 {
+		current = chain
+	}
+	return nil
+}
+
+// Enabled reports whether a geo-IP source is configured
+func Enabled() bool {
+	return current != nil
+}
+
+// Lookup returns the country code of an address, or an empty string if it
+// is unknown or cannot be parsed
+func Lookup(ip string) string {
+	if current == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	country, _ := current.Country(addr.Unmap())
+	return country
+}
+
+// resolverChain asks each resolver in turn until one knows the address
+type resolverChain []Resolver
+
+func (c resolverChain) Country(addr netip.Addr) (string, bool) {
+	for _, r := range c {
+		if country, ok := r.Country(addr); ok {
+			return country, true
+		}
+	}
+	return "", false
+}
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// NormalizeCountry upper-cases a country code and reports whether it is a
+// well-formed ISO 3166-1 alpha-2 code
+func NormalizeCountry(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return code, countryPattern.MatchString(code)
+}
+
+// Database resolves addresses with a MaxMind DB file in the GeoIP2/GeoLite2
+// Country or City layout, which DB-IP and others also publish
+type Database struct {
+	reader *maxminddb.Reader
+}
+
+// OpenDatabase opens a MaxMind DB file
+func OpenDatabase(path string) (*Database, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geo-IP database %s: %w", path, err)
+	}
+	return &Database{reader: reader}, nil
+}
+
+func (d *Database) Country(addr netip.Addr) (string, bool) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		RegisteredCountry struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"registered_country"`
+	}
+	result := d.reader.Lookup(addr)
+	if !result.Found() {
+		return "", false
+	}
+	if err := result.Decode(&record); err != nil {
+		log.Printf("Geo-IP lookup of %s failed: %v", addr, err)
+		return "", false
+	}
+
+	// Anycast and satellite networks only have a registered country
+	country := record.Country.ISOCode
+	if country == "" {
+		country = record.RegisteredCountry.ISOCode
+	}
+	return country, country != ""
+}
+
+// Table resolves addresses with a list of networks and their countries,
+// matching the most specific network
+type Table struct {
+	networks map[netip.Prefix]string
+	lengths  []int // prefix lengths present, longest first
+}
+
+// LoadTable reads a CIDR-to-country table. Each line holds a network and a
+// country code separated by a comma or whitespace, e.g. "203.0.113.0/24,AU".
+// Blank lines and lines starting with # are ignored.
+func LoadTable(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geo-IP table: %w", err)
+	}
+	defer f.Close()
+
+	t := &Table{networks: make(map[netip.Prefix]string)}
+	seen := make(map[int]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("geo-IP table %s line %d: expected a network and a country code", path, line)
+		}
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("geo-IP table %s line %d: %w", path, line, err)
+		}
+		country, ok := NormalizeCountry(fields[1])
+		if !ok {
+			return nil, fmt.Errorf("geo-IP table %s line %d: invalid country code %q", path, line, fields[1])
+		}
+
+		// Lookups unmap addresses, so IPv4-mapped networks are stored as IPv4
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefix = prefix.Masked()
+		t.networks[prefix] = country
+		if !seen[prefix.Bits()] {
+			seen[prefix.Bits()] = true
+			t.lengths = append(t.lengths, prefix.Bits())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read geo-IP table %s: %w", path, err)
+	}
+
+	slices.Sort(t.lengths)
+	slices.Reverse(t.lengths)
+	return t, nil
+}
+
+// Len returns the number of networks in the table
+func (t *Table) Len() int {
+	return len(t.networks)
+}
+
+func (t *Table) Country(addr netip.Addr) (string, bool) {
+	for _, bits := range t.lengths {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if country, ok := t.networks[prefix]; ok {
+			return country, true
+		}
+	}
+	return "", false
+}
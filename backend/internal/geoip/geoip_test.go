@@ -0,0 +1,171 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTable(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "countries.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNormalizeCountry(t *testing.T) {
+	tests := []struct {
+		code   string
+		want   string
+		wantOK bool
+	}{
+		{"de", "DE", true},
+		{" Us ", "US", true},
+		{"KH", "KH", true},
+		{"", "", false},
+		{"D", "D", false},
+		{"DEU", "DEU", false},
+		{"D1", "D1", false},
+		{"É", "É", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeCountry(tt.code)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("NormalizeCountry(%q) = %q, %t, want %q, %t", tt.code, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+const testTable = `# network,country
+203.0.113.0/24,AU
+203.0.113.128/25 nz
+198.51.100.7/32	us
+
+2001:db8::/32,DE
+::ffff:192.0.2.0/120,JP
+10.1.2.3/8,KH
+`
+
+func TestTableCountry(t *testing.T) {
+	table, err := LoadTable(writeTable(t, testTable))
+	if err != nil {
+		t.Fatalf("LoadTable: %v", err)
+	}
+	if table.Len() != 6 {
+		t.Errorf("Len() = %d, want 6", table.Len())
+	}
+
+	tests := []struct {
+		addr   string
+		want   string
+		wantOK bool
+	}{
+		{"203.0.113.1", "AU", true},
+		{"203.0.113.200", "NZ", true}, // most specific network wins
+		{"198.51.100.7", "US", true},
+		{"198.51.100.8", "", false},
+		{"2001:db8::1", "DE", true},
+		{"2001:db9::1", "", false},
+		{"192.0.2.44", "JP", true}, // mapped network stored as IPv4
+		{"10.200.0.1", "KH", true}, // host bits are masked off
+		{"8.8.8.8", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := table.Country(netip.MustParseAddr(tt.addr))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Country(%s) = %q, %t, want %q, %t", tt.addr, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLoadTableErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing country", "203.0.113.0/24\n"},
+		{"extra field", "203.0.113.0/24,AU,extra\n"},
+		{"bad network", "203.0.113.0,AU\n"},
+		{"bad country", "203.0.113.0/24,Australia\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadTable(writeTable(t, tt.content)); err == nil {
+				t.Errorf("LoadTable accepted %q", tt.content)
+			}
+		})
+	}
+
+	if _, err := LoadTable(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("LoadTable accepted a missing file")
+	}
+}
+
+// staticResolver knows a fixed set of addresses
+type staticResolver map[string]string
+
+func (r staticResolver) Country(addr netip.Addr) (string, bool) {
+	country, ok := r[addr.String()]
+	return country, ok
+}
+
+func TestResolverChain(t *testing.T) {
+	chain := resolverChain{
+		staticResolver{"192.0.2.1": "JP"},
+		staticResolver{"192.0.2.1": "US", "192.0.2.2": "FR"},
+	}
+	tests := []struct {
+		addr   string
+		want   string
+		wantOK bool
+	}{
+		{"192.0.2.1", "JP", true}, // earlier resolvers win
+		{"192.0.2.2", "FR", true},
+		{"192.0.2.3", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := chain.Country(netip.MustParseAddr(tt.addr))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Country(%s) = %q, %t, want %q, %t", tt.addr, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Cleanup(func() { current = nil })
+
+	if err := Init("", ""); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if Enabled() || Lookup("203.0.113.1") != "" {
+		t.Fatal("lookups without a source must resolve to an unknown country")
+	}
+
+	if err := Init("", writeTable(t, testTable)); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if !Enabled() {
+		t.Fatal("Enabled() = false with a table configured")
+	}
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.1", "AU"},
+		{"::ffff:203.0.113.1", "AU"}, // mapped addresses are unmapped
+		{"8.8.8.8", ""},
+		{"not an address", ""},
+	}
+	for _, tt := range tests {
+		if got := Lookup(tt.ip); got != tt.want {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	if err := Init("", filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("Init accepted a missing table")
+	}
+}
@@ -0,0 +1,270 @@
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"sync"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExhausted    = errors.New("address pool exhausted")
+	ErrOutOfRange   = errors.New("address outside overlay network")
+	ErrUnavailable  = errors.New("address not available")
+	ErrInUse        = errors.New("address already allocated")
+	ErrNotFound     = errors.New("allocation not found")
+	ErrAgentBinding = errors.New("reservation is bound to an agent")
+)
+
+// Pool is the allocator for the overlay network, set by Init
+var Pool *Allocator
+
+// Allocator hands out overlay addresses from a CIDR. The ip_allocations table
+// is the source of truth and its unique index guards against duplicates
+// across processes; the mutex serializes allocation within this one.
+type Allocator struct {
+	mu       sync.Mutex
+	prefix   netip.Prefix
+	excluded map[netip.Addr]struct{}
+}
+
+// Stats summarizes pool usage
+type Stats struct {
+	CIDR      string `json:"cidr"`
+	Capacity  uint64 `json:"capacity"` // saturates for very large (IPv6) networks
+	Allocated int64  `json:"allocated"`
+	Static    int64  `json:"static"`
+}
+
+// Init creates the global pool for prefix, never handing out the excluded
+// addresses (e.g. the hub), and imports addresses already held by agents
+func Init(prefix netip.Prefix, excluded ...netip.Addr) error {
+	a := &Allocator{
+		prefix:   prefix.Masked(),
+		excluded: make(map[netip.Addr]struct{}),
+	}
+	for _, addr := range excluded {
+		a.excluded[addr] = struct{}{}
+	}
+	if err := a.importAgents(); err != nil {
+		return err
+	}
+	Pool = a
+	log.Printf("IPAM initialized for %s", a.prefix)
+	return nil
+}
+
+// Prefix returns the managed network
+func (a *Allocator) Prefix() netip.Prefix {
+	return a.prefix
+}
+
+// Allocate returns the address for an agent, handing out the agent's static
+// reservation or the lowest free address if it has none yet
+func (a *Allocator) Allocate(agentID uint) (netip.Addr, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var existing models.IPAllocation
+	err := db.DB.Where("agent_id = ?", agentID).First(&existing).Error
+	if err == nil {
+		return netip.ParseAddr(existing.Address)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return netip.Addr{}, err
+	}
+
+	used, err := a.usedAddrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	for addr := a.prefix.Addr(); a.prefix.Contains(addr); addr = addr.Next() {
+		if !a.usable(addr) {
+			continue
+		}
+		if _, taken := used[addr]; taken {
+			continue
+		}
+
+		alloc := models.IPAllocation{Address: addr.String(), AgentID: &agentID}
+		if err := db.DB.Create(&alloc).Error; err != nil {
+			// Lost a race with another writer; try the next address
+			used[addr] = struct{}{}
+			continue
+		}
+		return addr, nil
+	}
+	return netip.Addr{}, ErrExhausted
+}
+
+// Reserve creates a static reservation, optionally bound to an agent
+func (a *Allocator) Reserve(addr netip.Addr, agentID *uint, description string) (*models.IPAllocation, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.prefix.Contains(addr) {
+		return nil, ErrOutOfRange
+	}
+	if !a.usable(addr) {
+		return nil, ErrUnavailable
+	}
+
+	var count int64
+	db.DB.Model(&models.IPAllocation{}).Where("address = ?", addr.String()).Count(&count)
+	if count > 0 {
+		return nil, ErrInUse
+	}
+	if agentID != nil {
+		db.DB.Model(&models.IPAllocation{}).Where("agent_id = ?", *agentID).Count(&count)
+		if count > 0 {
+			return nil, fmt.Errorf("agent %d already has an address", *agentID)
+		}
+	}
+
+	alloc := models.IPAllocation{
+		Address:     addr.String(),
+		AgentID:     agentID,
+		Static:      true,
+		Description: description,
+	}
+	if err := db.DB.Create(&alloc).Error; err != nil {
+		return nil, ErrInUse
+	}
+	return &alloc, nil
+}
+
+// Unreserve deletes a static reservation that is not bound to an agent
+func (a *Allocator) Unreserve(id uint) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var alloc models.IPAllocation
+	if err := db.DB.Where("id = ? AND static = ?", id, true).First(&alloc).Error; err != nil {
+		return ErrNotFound
+	}
+	if alloc.AgentID != nil {
+		return ErrAgentBinding
+	}
+	return db.DB.Delete(&alloc).Error
+}
+
+// Release frees an agent's dynamic address and unbinds any static
+// reservation so it can be reused; called when an agent is hard deleted
+func (a *Allocator) Release(agentID uint) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("agent_id = ? AND static = ?", agentID, false).Delete(&models.IPAllocation{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.IPAllocation{}).
+			Where("agent_id = ? AND static = ?", agentID, true).
+			Update("agent_id", nil).Error
+	})
+}
+
+// Stats reports pool capacity and usage
+func (a *Allocator) Stats() Stats {
+	s := Stats{CIDR: a.prefix.String(), Capacity: a.capacity()}
+	db.DB.Model(&models.IPAllocation{}).Count(&s.Allocated)
+	db.DB.Model(&models.IPAllocation{}).Where("static = ?", true).Count(&s.Static)
+	return s
+}
+
+// usable reports whether an address may be handed out at all
+func (a *Allocator) usable(addr netip.Addr) bool {
+	if addr == a.prefix.Addr() {
+		return false // network address
+	}
+	if _, ok := a.excluded[addr]; ok {
+		return false
+	}
+	if addr.Is4() && addr == lastAddr(a.prefix) {
+		return false // broadcast address
+	}
+	return true
+}
+
+func (a *Allocator) capacity() uint64 {
+	hostBits := a.prefix.Addr().BitLen() - a.prefix.Bits()
+	if hostBits >= 64 {
+		return math.MaxUint64
+	}
+	total := uint64(1) << hostBits
+	reserved := uint64(1 + len(a.excluded)) // network + excluded
+	if a.prefix.Addr().Is4() {
+		reserved++ // broadcast
+	}
+	if total <= reserved {
+		return 0
+	}
+	return total - reserved
+}
+
+func (a *Allocator) usedAddrs() (map[netip.Addr]struct{}, error) {
+	var addrs []string
+	if err := db.DB.Model(&models.IPAllocation{}).Pluck("address", &addrs).Error; err != nil {
+		return nil, err
+	}
+	used := make(map[netip.Addr]struct{}, len(addrs))
+	for _, s := range addrs {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			used[addr] = struct{}{}
+		}
+	}
+	return used, nil
+}
+
+// importAgents records addresses already assigned to agents (including soft
+// deleted ones) so they are never handed out twice
+func (a *Allocator) importAgents() error {
+	var agents []models.Agent
+	if err := db.DB.Unscoped().Where("ip <> ''").Find(&agents).Error; err != nil {
+		return fmt.Errorf("load agents: %w", err)
+	}
+
+	for _, agent := range agents {
+		addr, err := netip.ParseAddr(agent.IP)
+		if err != nil {
+			continue
+		}
+		if !a.prefix.Contains(addr) {
+			log.Printf("IPAM: agent %d address %s is outside %s", agent.ID, addr, a.prefix)
+		}
+
+		var count int64
+		db.DB.Model(&models.IPAllocation{}).Where("address = ?", addr.String()).Count(&count)
+		if count > 0 {
+			continue
+		}
+		agentID := agent.ID
+		if err := db.DB.Create(&models.IPAllocation{Address: addr.String(), AgentID: &agentID}).Error; err != nil {
+			return fmt.Errorf("import allocation for agent %d: %w", agent.ID, err)
+		}
+	}
+	return nil
+}
+
+// lastAddr returns the highest address in a prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := range b {
+		bit := i * 8
+		switch {
+		case bit >= p.Bits():
+			b[i] = 0xff
+		case bit+8 > p.Bits():
+			b[i] |= 0xff >> (p.Bits() - bit)
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,110 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// ParseRoutes decodes a JSON list of subnets as stored in Agent.Routes and
+// Agent.ApprovedRoutes. Entries that do not parse are skipped; values are
+// validated on save.
+func ParseRoutes(stored string) []netip.Prefix {
+	if strings.TrimSpace(stored) == "" {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(stored), &list); err != nil {
+		return nil
+	}
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		}
+	}
+	return prefixes
+}
+
+// EncodeRoutes is the inverse of ParseRoutes; no routes are stored as an
+// empty string
+func EncodeRoutes(prefixes []netip.Prefix) string {
+	if len(prefixes) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(RouteStrings(prefixes))
+	return string(data)
+}
+
+// RouteStrings formats prefixes for API responses, never returning nil
+func RouteStrings(prefixes []netip.Prefix) []string {
+	list := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		list = append(list, p.String())
+	}
+	return list
+}
+
+// NormalizeRoutes parses the subnets an agent routes to, masks them to their
+// network address and sorts them. Default routes, subnets overlapping the
+// overlay network and subnets overlapping each other are rejected.
+func NormalizeRoutes(routes []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(routes))
+	for _, s := range routes {
+		p, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a subnet like 192.168.1.0/24", s)
+		}
+		p = p.Masked()
+		if p.Bits() == 0 {
+			return nil, fmt.Errorf("%s is a default route", p)
+		}
+		if Pool != nil && p.Overlaps(Pool.Prefix()) {
+			return nil, fmt.Errorf("%s overlaps the overlay network %s", p, Pool.Prefix())
+		}
+		if !slices.Contains(prefixes, p) {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	for i := 1; i < len(prefixes); i++ {
+		for _, earlier := range prefixes[:i] {
+			if earlier.Overlaps(prefixes[i]) {
+				return nil, fmt.Errorf("%s overlaps %s", prefixes[i], earlier)
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// CheckRouteConflicts returns an error if one of the prefixes overlaps a
+// route approved for an agent other than agentID, since traffic for it
+// could only go to one of them
+func CheckRouteConflicts(agentID uint, prefixes []netip.Prefix) error {
+	var agents []models.Agent
+	if err := db.DB.Select("id", "name", "approved_routes").
+		Where("id <> ? AND approved_routes <> ''", agentID).Find(&agents).Error; err != nil {
+		return err
+	}
+	for _, agent := range agents {
+		for _, approved := range ParseRoutes(agent.ApprovedRoutes) {
+			for _, p := range prefixes {
+				if p.Overlaps(approved) {
+					return fmt.Errorf("%s overlaps %s, routed by agent %q", p, approved, agent.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package ipam
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// withPool sets the global pool to an allocator for prefix for one test
+func withPool(t *testing.T, prefix string) {
+	t.Helper()
+	previous := Pool
+	Pool = &Allocator{prefix: netip.MustParsePrefix(prefix), excluded: map[netip.Addr]struct{}{}}
+	t.Cleanup(func() { Pool = previous })
+}
+
+func TestNormalizeRoutes(t *testing.T) {
+	withPool(t, "10.0.0.0/24")
+
+	tests := []struct {
+		name    string
+		routes  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", routes: nil, want: []string{}},
+		{name: "masked to the network address", routes: []string{"192.168.1.17/24"}, want: []string{"192.168.1.0/24"}},
+		{
+			name:   "sorted and deduplicated",
+			routes: []string{" 172.16.0.0/16", "192.168.1.0/24", "172.16.0.0/16", "fd10::/64"},
+			want:   []string{"172.16.0.0/16", "192.168.1.0/24", "fd10::/64"},
+		},
+		{name: "single host", routes: []string{"192.168.1.10/32"}, want: []string{"192.168.1.10/32"}},
+		{name: "not a subnet", routes: []string{"192.168.1.0"}, wantErr: true},
+		{name: "garbage", routes: []string{"lan"}, wantErr: true},
+		{name: "default route", routes: []string{"0.0.0.0/0"}, wantErr: true},
+		{name: "ipv6 default route", routes: []string{"::/0"}, wantErr: true},
+		{name: "inside the overlay", routes: []string{"10.0.0.128/25"}, wantErr: true},
+		{name: "containing the overlay", routes: []string{"10.0.0.0/8"}, wantErr: true},
+		{name: "overlapping each other", routes: []string{"192.168.0.0/16", "192.168.1.0/24"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeRoutes(tt.routes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NormalizeRoutes(%q) = %v, want an error", tt.routes, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeRoutes(%q) error: %v", tt.routes, err)
+			}
+			if strs := RouteStrings(got); !slices.Equal(strs, tt.want) {
+				t.Errorf("NormalizeRoutes(%q) = %q, want %q", tt.routes, strs, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		stored string
+		want   []string
+	}{
+		{"", []string{}},
+		{"  ", []string{}},
+		{"not json", []string{}},
+		{`["192.168.1.0/24","fd10::/64"]`, []string{"192.168.1.0/24", "fd10::/64"}},
+		{`["192.168.1.9/24"]`, []string{"192.168.1.0/24"}},
+		{`["192.168.1.0/24","bogus"]`, []string{"192.168.1.0/24"}},
+	}
+	for _, tt := range tests {
+		if got := RouteStrings(ParseRoutes(tt.stored)); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseRoutes(%q) = %q, want %q", tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeRoutes(t *testing.T) {
+	tests := []struct {
+		routes []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"192.168.1.0/24"}, `["192.168.1.0/24"]`},
+		{[]string{"172.16.0.0/16", "fd10::/64"}, `["172.16.0.0/16","fd10::/64"]`},
+	}
+	for _, tt := range tests {
+		var prefixes []netip.Prefix
+		for _, r := range tt.routes {
+			prefixes = append(prefixes, netip.MustParsePrefix(r))
+		}
+		got := EncodeRoutes(prefixes)
+		if got != tt.want {
+			t.Errorf("EncodeRoutes(%q) = %q, want %q", tt.routes, got, tt.want)
+		}
+		if back := ParseRoutes(got); !slices.Equal(back, prefixes) {
+			t.Errorf("ParseRoutes(EncodeRoutes(%q)) = %v", tt.routes, back)
+		}
+	}
+}
+
+func TestCheckRouteConflicts(t *testing.T) {
+	dbtest.Open(t, &models.Agent{})
+
+	office := models.Agent{Name: "office", ApprovedRoutes: `["192.168.1.0/24"]`}
+	lab := models.Agent{Name: "lab", Routes: `["172.16.0.0/16"]`} // advertised, not approved
+	for _, a := range []*models.Agent{&office, &lab} {
+		dbtest.Create(t, a)
+	}
+
+	tests := []struct {
+		name    string
+		agentID uint
+		routes  []string
+		wantErr bool
+	}{
+		{"disjoint", lab.ID, []string{"192.168.2.0/24"}, false},
+		{"same subnet", lab.ID, []string{"192.168.1.0/24"}, true},
+		{"inside an approved route", lab.ID, []string{"192.168.1.128/25"}, true},
+		{"containing an approved route", lab.ID, []string{"192.168.0.0/16"}, true},
+		{"own routes do not conflict", office.ID, []string{"192.168.1.0/24"}, false},
+		{"unapproved routes do not conflict", office.ID, []string{"172.16.0.0/16"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefixes []netip.Prefix
+			for _, r := range tt.routes {
+				prefixes = append(prefixes, netip.MustParsePrefix(r))
+			}
+			err := CheckRouteConflicts(tt.agentID, prefixes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckRouteConflicts(%d, %v) = %v, want error %t", tt.agentID, tt.routes, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLastAddr(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"10.0.0.0/24", "10.0.0.255"},
+		{"10.0.0.0/22", "10.0.3.255"},
+		{"10.0.0.0/31", "10.0.0.1"},
+		{"10.0.0.7/32", "10.0.0.7"},
+		{"fd00::/120", "fd00::ff"},
+	}
+	for _, tt := range tests {
+		if got := lastAddr(netip.MustParsePrefix(tt.prefix)); got.String() != tt.want {
+			t.Errorf("lastAddr(%s) = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -14,19 +14,55 @@ type Agent struct {
 
 	Name        string     `gorm:"size:255" json:"name"`
 	Description string     `gorm:"size:1024" json:"description,omitempty"`
-	APIKey      string     `gorm:"uniqueIndex;size:64" json:"api_key,omitempty"`
 	PublicKey   string     `gorm:"size:64" json:"public_key,omitempty"`
-	IP          string     `gorm:"size:32" json:"ip"`
+	IP          string     `gorm:"size:64" json:"ip"`
 	Status      string     `gorm:"size:32;default:'offline'" json:"status"` // online, offline
+	Disabled    bool       `gorm:"default:false" json:"disabled"`
 	LastSeen    *time.Time `json:"last_seen,omitempty"`
 	GroupID     *uint      `json:"group_id,omitempty"`
 	Group       *Group     `gorm:"foreignKey:GroupID" json:"group,omitempty"`
 
+	// Where the agent last connected from; Country is the ISO code resolved
+	// from PublicIP and is empty if unknown
+	PublicIP string `gorm:"size:64" json:"public_ip,omitempty"`
+	Country  string `gorm:"size:2" json:"country,omitempty"`
+
 	// Enhanced fields
 	Routes   string    `gorm:"size:1024" json:"routes,omitempty"` // JSON array of local subnets
+	Tags     string    `gorm:"size:1024" json:"tags,omitempty"`   // JSON array of labels
 	Services []Service `gorm:"foreignKey:AgentID" json:"services,omitempty"`
 	UserID   *uint     `json:"user_id,omitempty"`
 	User     *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+
+	// Subnets of Routes an admin allowed the agent to route for the overlay;
+	// JSON array like Routes
+	ApprovedRoutes string `gorm:"size:1024" json:"approved_routes,omitempty"`
+
+	// An exit node forwards the internet-bound traffic of agents that use
+	// it. The agent offers to be one and an admin approves it; only one agent
+	// at a time can be the exit node.
+	AdvertiseExitNode bool `gorm:"default:false" json:"advertise_exit_node"`
+	ExitNode          bool `gorm:"default:false" json:"exit_node"`
+	UseExitNode       bool `gorm:"default:false" json:"use_exit_node"` // send internet-bound traffic through the exit node
+
+	EnrollmentKeyID *uint `json:"enrollment_key_id,omitempty"` // set for self-enrolled agents
+}
+
+// AgentCredential is an API key an agent authenticates with. Only a salted
+// hash of the key is stored; the plaintext is shown once when issued.
+type AgentCredential struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	AgentID    uint       `gorm:"index" json:"agent_id"`
+	Name       string     `gorm:"size:255" json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;size:16" json:"prefix"` // non-secret lookup prefix, shown in the UI
+	Salt       string     `gorm:"size:32" json:"-"`
+	Hash       string     `gorm:"size:64" json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
 }
 
 type User struct {
@@ -35,22 +71,59 @@ type User struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
-	Email    string  `gorm:"uniqueIndex;size:255" json:"email"`
-	Provider string  `gorm:"size:64" json:"provider"`            // google, github, local
-	Role     string  `gorm:"size:32;default:'user'" json:"role"` // admin, user
-	Agents   []Agent `gorm:"foreignKey:UserID" json:"agents,omitempty"`
+	Email        string     `gorm:"uniqueIndex;size:255" json:"email"`
+	Provider     string     `gorm:"size:64" json:"provider"`              // local, or the SSO provider name
+	Subject      string     `gorm:"size:255;index" json:"-"`              // user ID at the SSO provider
+	Role         string     `gorm:"size:32;default:'member'" json:"role"` // owner, admin, network-admin, auditor, member
+	PasswordHash string     `gorm:"size:255" json:"-"`                    // empty for SSO-only users
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	Agents       []Agent    `gorm:"foreignKey:UserID" json:"agents,omitempty"`
+}
+
+// EnrollmentKey lets agents register themselves without an interactive
+// claim. As with agent credentials only a salted hash of the key is stored.
+type EnrollmentKey struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name       string     `gorm:"size:255" json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;size:16" json:"prefix"`
+	Salt       string     `gorm:"size:32" json:"-"`
+	Hash       string     `gorm:"size:64" json:"-"`
+	Reusable   bool       `json:"reusable"`
+	MaxUses    int        `json:"max_uses"` // 0 means unlimited; single-use keys have 1
+	Uses       int        `json:"uses"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	// Applied to every agent enrolled with the key
+	GroupID *uint  `json:"group_id,omitempty"`
+	Group   *Group `gorm:"foreignKey:GroupID" json:"group,omitempty"`
+	Tags    string `gorm:"size:1024" json:"tags,omitempty"` // JSON array
+
+	CreatedByID *uint `json:"created_by_id,omitempty"`
 }
 
 type DeviceClaim struct {
 	ID        uint      `gorm:"primarykey" json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 
-	Token     string `gorm:"uniqueIndex;size:64" json:"token"`
-	PublicKey string `gorm:"size:64" json:"public_key"`
-	Status    string `gorm:"size:32;default:'pending'" json:"status"` // pending, approved, rejected
-	IP        string `gorm:"size:64" json:"ip"`
-	Hostname  string `gorm:"size:64" json:"hostname"`
-	UserID    *uint  `json:"user_id,omitempty"`
+	Token     string    `gorm:"uniqueIndex;size:64" json:"token"`
+	UserCode  string    `gorm:"size:16" json:"-"` // shown by the agent, typed in by the approver
+	PublicKey string    `gorm:"size:64" json:"public_key"`
+	Status    string    `gorm:"size:32;default:'pending'" json:"status"` // pending, approved, completed, rejected, expired, failed
+	IP        string    `gorm:"size:64" json:"ip"`
+	Hostname  string    `gorm:"size:64" json:"hostname"`
+	UserID    *uint     `json:"user_id,omitempty"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+
+	FailedAttempts int `json:"-"` // wrong user codes entered on approval
+
+	// Set once the agent has picked up its API key
+	AgentID     *uint      `json:"agent_id,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
 type Service struct {
@@ -75,6 +148,8 @@ type AuditLog struct {
 
 	AgentID   *uint  `gorm:"index" json:"agent_id,omitempty"`
 	Agent     *Agent `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
+	UserID    *uint  `gorm:"index" json:"user_id,omitempty"` // dashboard user who performed the action
+	User      *User  `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Action    string `gorm:"size:64;index" json:"action"`
 	Details   string `gorm:"type:text" json:"details"`
 	IPAddress string `gorm:"size:64" json:"ip_address,omitempty"`
@@ -98,6 +173,10 @@ type AccessLog struct {
 	BytesSent     int64    `json:"bytes_sent"`
 	BytesReceived int64    `json:"bytes_received"`
 	Duration      int64    `json:"duration_ms"` // milliseconds
+
+	// Denied packets towards one target are logged once per sweep interval;
+	// Count is how many this entry stands for. Connections count as one.
+	Count int64 `gorm:"default:1" json:"count"`
 }
 
 // AgentMetrics stores health and traffic metrics
@@ -116,6 +195,19 @@ type AgentMetrics struct {
 	MemoryUsage       float64 `json:"memory_usage,omitempty"`
 }
 
+// IPAllocation records an overlay address handed out or reserved by IPAM
+type IPAllocation struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Address     string `gorm:"uniqueIndex;size:64" json:"address"`
+	AgentID     *uint  `gorm:"index" json:"agent_id,omitempty"`
+	Agent       *Agent `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
+	Static      bool   `json:"static"` // admin reservation, survives agent deletion
+	Description string `gorm:"size:255" json:"description,omitempty"`
+}
+
 type Group struct {
 	ID        uint           `gorm:"primarykey" json:"id"`
 	CreatedAt time.Time      `json:"created_at"`
@@ -125,6 +217,14 @@ type Group struct {
 	Name        string  `gorm:"size:255;uniqueIndex" json:"name"`
 	Description string  `gorm:"size:1024" json:"description"`
 	Agents      []Agent `gorm:"foreignKey:GroupID" json:"agents,omitempty"`
+
+	// Members must meet this profile to reach anything through policies
+	PostureProfileID *uint           `json:"posture_profile_id,omitempty"`
+	PostureProfile   *PostureProfile `gorm:"foreignKey:PostureProfileID" json:"posture_profile,omitempty"`
+
+	// Domains members try, in order, for names without a dot; the overlay
+	// domain always comes first
+	DNSSearchDomains []string `gorm:"serializer:json;type:text" json:"dns_search_domains"`
 }
 
 type Policy struct {
@@ -140,18 +240,50 @@ type Policy struct {
 	DestGroupID   uint   `json:"dest_group_id"`
 	DestGroup     Group  `gorm:"foreignKey:DestGroupID" json:"dest_group,omitempty"`
 	AllowedPorts  string `gorm:"size:512" json:"allowed_ports"`
-	Action        string `gorm:"size:32;default:'allow'" json:"action"`
+	Action        string `gorm:"size:32;default:'allow'" json:"action"`      // allow or deny
+	Priority      int    `gorm:"not null;default:100;index" json:"priority"` // lower values are evaluated first
 	Enabled       bool   `gorm:"default:true" json:"enabled"`
 
 	// Zero Trust: Time-based access control
 	ValidFrom  *time.Time `json:"valid_from,omitempty"`
 	ValidUntil *time.Time `json:"valid_until,omitempty"`
 
+	// Zero Trust: Recurring access windows, e.g. business hours
+	ScheduleID *uint     `json:"schedule_id,omitempty"`
+	Schedule   *Schedule `gorm:"foreignKey:ScheduleID" json:"schedule,omitempty"`
+
 	// Zero Trust: Geo-restriction (comma-separated country codes)
 	AllowedRegions string `gorm:"size:256" json:"allowed_regions,omitempty"`
 
 	// Zero Trust: Require minimum posture score
 	MinPostureScore int `gorm:"default:0" json:"min_posture_score"`
+
+	// Zero Trust: Source agents must meet this posture profile
+	PostureProfileID *uint           `json:"posture_profile_id,omitempty"`
+	PostureProfile   *PostureProfile `gorm:"foreignKey:PostureProfileID" json:"posture_profile,omitempty"`
+}
+
+// Schedule is a named set of weekly time windows in a time zone. Policies
+// with a schedule only apply while one of its windows is open.
+type Schedule struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name        string           `gorm:"size:255;uniqueIndex" json:"name"`
+	Description string           `gorm:"size:1024" json:"description"`
+	Timezone    string           `gorm:"size:64" json:"timezone"` // IANA name, e.g. "Europe/Berlin"; defaults to UTC
+	Windows     []ScheduleWindow `gorm:"serializer:json;type:text" json:"windows"`
+}
+
+// ScheduleWindow is open on the listed weekdays from Start to End, given as
+// "15:04" in the schedule's time zone. End may be "24:00"; an End before
+// Start runs past midnight into the next day.
+type ScheduleWindow struct {
+	Days  []string `json:"days"` // mon, tue, wed, thu, fri, sat, sun
+	Start string   `json:"start"`
+	End   string   `json:"end"`
 }
 
 // DevicePosture stores security posture information for an agent
@@ -177,11 +309,123 @@ type DevicePosture struct {
 
 	// Patch Status
 	LastPatchDate  *time.Time `json:"last_patch_date,omitempty"`
-	PendingPatches int        `json:"pending_patches"`
+	PendingPatches int        `json:"pending_patches"` // -1 if the agent does not report it
+
+	AgentVersion string `gorm:"size:32" json:"agent_version,omitempty"`
 
-	// Computed posture score (0-100)
+	// Posture score (0-100), computed by the server from the facts above
+	// using PostureSettings
 	PostureScore int `json:"posture_score"`
 
+	// compliant, noncompliant (a required check fails) or stale (no recent report)
+	Status       string `gorm:"size:16;index" json:"status"`
+	FailedChecks string `gorm:"size:256" json:"failed_checks,omitempty"` // comma-separated check names
+
 	// Last check timestamp
 	LastChecked *time.Time `json:"last_checked,omitempty"`
 }
+
+// PostureProfile is a named set of posture requirements attached to groups
+// or policies. Empty or nil fields impose no requirement.
+type PostureProfile struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name        string `gorm:"size:255;uniqueIndex" json:"name"`
+	Description string `gorm:"size:1024" json:"description"`
+
+	RequireFirewall       bool `json:"require_firewall"`
+	RequireDiskEncryption bool `json:"require_disk_encryption"`
+	RequireAntivirus      bool `json:"require_antivirus"`
+	RequireScreenLock     bool `json:"require_screen_lock"`
+
+	// Minimum OS version by OS name (darwin, windows, linux), e.g.
+	// {"darwin": "14.0"}. Operating systems not listed have no minimum.
+	MinOSVersions     map[string]string `gorm:"serializer:json;type:text" json:"min_os_versions,omitempty"`
+	MaxPendingPatches *int              `json:"max_pending_patches,omitempty"` // rejected until agents report pending patches
+	MinAgentVersion   string            `gorm:"size:32" json:"min_agent_version,omitempty"`
+}
+
+// PostureCompliance is the result of checking an agent against a posture
+// profile that applies to it, refreshed whenever either changes
+type PostureCompliance struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CheckedAt time.Time `json:"checked_at"`
+
+	AgentID   uint            `gorm:"uniqueIndex:idx_compliance_agent_profile" json:"agent_id"`
+	ProfileID uint            `gorm:"uniqueIndex:idx_compliance_agent_profile" json:"profile_id"`
+	Profile   *PostureProfile `gorm:"foreignKey:ProfileID" json:"profile,omitempty"`
+	Compliant bool            `json:"compliant"`
+	Failures  []string        `gorm:"serializer:json;type:text" json:"failures"` // what the device has to fix
+}
+
+// PostureSettings controls how the server scores device posture. There is a
+// single row, created with defaults on first use.
+type PostureSettings struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Weight of each check; the score is the share of the total weight a
+	// device earns. BaseWeight is earned by every reporting device.
+	BaseWeight           int `json:"base_weight"`
+	FirewallWeight       int `json:"firewall_weight"`
+	DiskEncryptionWeight int `json:"disk_encryption_weight"`
+	AntivirusWeight      int `json:"antivirus_weight"`
+	ScreenLockWeight     int `json:"screen_lock_weight"`
+
+	// Checks a device must pass to be compliant
+	RequireFirewall       bool `json:"require_firewall"`
+	RequireDiskEncryption bool `json:"require_disk_encryption"`
+	RequireAntivirus      bool `json:"require_antivirus"`
+	RequireScreenLock     bool `json:"require_screen_lock"`
+
+	// Go duration after which a device without a new report is stale
+	StaleAfter string `gorm:"size:32" json:"stale_after"`
+
+	// Quarantine cuts all overlay traffic of noncompliant and stale devices,
+	// and of devices that never reported.
+	// Otherwise they only lose access through policies requiring a posture
+	// score.
+	Quarantine bool `json:"quarantine"`
+}
+
+// DNSRecord is an admin-managed record served by the overlay DNS. A record
+// with a group is only visible to that group's agents.
+type DNSRecord struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name        string `gorm:"size:255;index" json:"name"` // lower-case, without trailing dot
+	Type        string `gorm:"size:16" json:"type"`        // A, CNAME or SRV
+	Value       string `gorm:"size:255" json:"value"`      // IPv4 address for A, target name for CNAME and SRV
+	TTL         uint32 `json:"ttl"`
+	Priority    uint16 `json:"priority"` // SRV only
+	Weight      uint16 `json:"weight"`   // SRV only
+	Port        uint16 `json:"port"`     // SRV only
+	Description string `gorm:"size:1024" json:"description"`
+
+	GroupID *uint  `gorm:"index" json:"group_id"`
+	Group   *Group `gorm:"foreignKey:GroupID" json:"group,omitempty"`
+}
+
+// DNSForwarder sends queries for a domain and its subdomains to other
+// resolvers instead of the upstream ones (split DNS), e.g. a corporate
+// resolver behind a subnet router. A forwarder with a group only applies to
+// that group's agents.
+type DNSForwarder struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Domain      string   `gorm:"size:255;index" json:"domain"`             // lower-case, without trailing dot
+	Servers     []string `gorm:"serializer:json;type:text" json:"servers"` // ip:port
+	Description string   `gorm:"size:1024" json:"description"`
+
+	GroupID *uint  `gorm:"index" json:"group_id"`
+	Group   *Group `gorm:"foreignKey:GroupID" json:"group,omitempty"`
+}
@@ -0,0 +1,286 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// Conflict kinds reported by Conflicts
+const (
+	// ConflictShadowed means a policy never takes effect because an earlier
+	// policy with the opposite action covers all of its traffic
+	ConflictShadowed = "shadowed"
+	// ConflictRedundant means an earlier policy with the same action already
+	// covers all of a policy's traffic
+	ConflictRedundant = "redundant"
+	// ConflictContradiction means an allow and a deny policy overlap on part
+	// of their traffic, which the earlier one decides
+	ConflictContradiction = "contradiction"
+)
+
+// Conflict describes two policies between the same groups that interact
+type Conflict struct {
+	Kind          string `json:"kind"`
+	PolicyID      uint   `json:"policy_id"`
+	PolicyName    string `json:"policy_name"`
+	Action        string `json:"action"`
+	OtherPolicyID uint   `json:"other_policy_id"` // the policy that takes precedence
+	OtherName     string `json:"other_policy_name"`
+	OtherAction   string `json:"other_action"`
+	SourceGroupID uint   `json:"source_group_id"`
+	DestGroupID   uint   `json:"dest_group_id"`
+	Ports         string `json:"ports"` // traffic both policies cover
+	Message       string `json:"message"`
+}
+
+// Conflicts analyzes the enforced policies for ones that are shadowed,
+// redundant or contradict each other. Only policies between the same source
+// and destination groups are compared. A policy with conditions (validity
+// window, schedule, posture or regions) may not apply to a flow, so it is never
+// reported as hiding another one, only as contradicting it. Service
+// references are compared by name, not by the ports they resolve to.
+func (e *Engine) Conflicts() []Conflict {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	conflicts := []Conflict{}
+	sets := make([]portSet, len(e.policies))
+	for i, p := range e.policies {
+		sets[i] = newPortSet(e.portRules[p.ID])
+	}
+
+	for j := range e.policies {
+		later := &e.policies[j]
+		if e.portRules[later.ID] == nil {
+			continue // invalid ports match nothing
+		}
+		for i := 0; i < j; i++ {
+			earlier := &e.policies[i]
+			if earlier.SourceGroupID != later.SourceGroupID || earlier.DestGroupID != later.DestGroupID {
+				continue
+			}
+			overlap := sets[i].intersect(sets[j])
+			if overlap.empty() {
+				continue
+			}
+
+			c := Conflict{
+				PolicyID:      later.ID,
+				PolicyName:    later.Name,
+				Action:        later.Action,
+				OtherPolicyID: earlier.ID,
+				OtherName:     earlier.Name,
+				OtherAction:   earlier.Action,
+				SourceGroupID: later.SourceGroupID,
+				DestGroupID:   later.DestGroupID,
+				Ports:         overlap.String(),
+			}
+			sameAction := earlier.Action == later.Action
+			switch {
+			case !hasConditions(earlier) && sets[i].contains(sets[j]) && sameAction:
+				c.Kind = ConflictRedundant
+				c.Message = fmt.Sprintf("%q already covers all traffic of %q with the same action", earlier.Name, later.Name)
+			case !hasConditions(earlier) && sets[i].contains(sets[j]):
+				c.Kind = ConflictShadowed
+				c.Message = fmt.Sprintf("%q never takes effect, %q decides all of its traffic with %s first", later.Name, earlier.Name, earlier.Action)
+			case !sameAction:
+				c.Kind = ConflictContradiction
+				c.Message = fmt.Sprintf("%q and %q disagree on %s; %q takes precedence", earlier.Name, later.Name, c.Ports, earlier.Name)
+			default:
+				continue
+			}
+			conflicts = append(conflicts, c)
+
+			// One report per hidden policy is enough
+			if c.Kind != ConflictContradiction {
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// hasConditions reports whether a policy only applies to some flows
+// between its groups and ports
+func hasConditions(p *models.Policy) bool {
+	return p.ValidFrom != nil || p.ValidUntil != nil || p.ScheduleID != nil || p.MinPostureScore > 0 ||
+		p.PostureProfileID != nil || strings.TrimSpace(p.AllowedRegions) != ""
+}
+
+// portSet is the traffic covered by a list of port rules, in a form that
+// can be compared. Service references are kept by name since the ports
+// they resolve to differ between agents.
+type portSet struct {
+	any      bool
+	tcp, udp []portRange // sorted and merged
+	icmp     bool
+	services map[string]bool
+}
+
+type portRange struct{ from, to uint16 }
+
+var allPorts = []portRange{{1, 65535}}
+
+func newPortSet(rules []PortRule) portSet {
+	s := portSet{services: make(map[string]bool)}
+	for _, r := range rules {
+		switch {
+		case r.Any:
+			return portSet{any: true, tcp: allPorts, udp: allPorts, icmp: true, services: s.services}
+		case r.Service != "":
+			s.services[r.Service] = true
+		case r.Protocol == "icmp":
+			s.icmp = true
+		default:
+			pr := portRange{r.From, r.To}
+			if r.From == 0 {
+				pr = allPorts[0]
+			}
+			if r.Protocol != "udp" {
+				s.tcp = append(s.tcp, pr)
+			}
+			if r.Protocol != "tcp" {
+				s.udp = append(s.udp, pr)
+			}
+		}
+	}
+	s.tcp = mergeRanges(s.tcp)
+	s.udp = mergeRanges(s.udp)
+	return s
+}
+
+func mergeRanges(ranges []portRange) []portRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].from < ranges[j].from })
+	merged := []portRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if int(r.from) <= int(last.to)+1 {
+			if r.to > last.to {
+				last.to = r.to
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func (s portSet) empty() bool {
+	return !s.any && len(s.tcp) == 0 && len(s.udp) == 0 && !s.icmp && len(s.services) == 0
+}
+
+// contains reports whether s covers all traffic of o
+func (s portSet) contains(o portSet) bool {
+	if s.any {
+		return true
+	}
+	if o.any || (o.icmp && !s.icmp) {
+		return false
+	}
+	for name := range o.services {
+		if !s.services[name] {
+			return false
+		}
+	}
+	return rangesContain(s.tcp, o.tcp) && rangesContain(s.udp, o.udp)
+}
+
+// intersect returns the traffic covered by both s and o
+func (s portSet) intersect(o portSet) portSet {
+	if s.any {
+		return o
+	}
+	if o.any {
+		return s
+	}
+	r := portSet{
+		tcp:      intersectRanges(s.tcp, o.tcp),
+		udp:      intersectRanges(s.udp, o.udp),
+		icmp:     s.icmp && o.icmp,
+		services: make(map[string]bool),
+	}
+	for name := range s.services {
+		if o.services[name] {
+			r.services[name] = true
+		}
+	}
+	return r
+}
+
+// rangesContain reports whether the merged ranges a cover every range in b
+func rangesContain(a, b []portRange) bool {
+	for _, r := range b {
+		covered := false
+		for _, c := range a {
+			if c.from <= r.from && r.to <= c.to {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+func intersectRanges(a, b []portRange) []portRange {
+	var out []portRange
+	for _, x := range a {
+		for _, y := range b {
+			from, to := max(x.from, y.from), min(x.to, y.to)
+			if from <= to {
+				out = append(out, portRange{from, to})
+			}
+		}
+	}
+	return mergeRanges(out)
+}
+
+// String renders the set in AllowedPorts syntax
+func (s portSet) String() string {
+	if s.any {
+		return "*"
+	}
+	var terms []string
+	if slices.Equal(s.tcp, s.udp) {
+		terms = append(terms, formatRanges("", s.tcp)...)
+	} else {
+		terms = append(terms, formatRanges("tcp/", s.tcp)...)
+		terms = append(terms, formatRanges("udp/", s.udp)...)
+	}
+	if s.icmp {
+		terms = append(terms, "icmp")
+	}
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, "service:"+name)
+	}
+	sort.Strings(names)
+	return strings.Join(append(terms, names...), ",")
+}
+
+func formatRanges(prefix string, ranges []portRange) []string {
+	terms := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		switch {
+		case r == allPorts[0] && prefix == "":
+			terms = append(terms, "tcp/*", "udp/*")
+		case r == allPorts[0]:
+			terms = append(terms, prefix+"*")
+		case r.from == r.to:
+			terms = append(terms, prefix+strconv.Itoa(int(r.from)))
+		default:
+			terms = append(terms, prefix+strconv.Itoa(int(r.from))+"-"+strconv.Itoa(int(r.to)))
+		}
+	}
+	return terms
+}
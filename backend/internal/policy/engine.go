@@ -0,0 +1,627 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/posture"
+)
+
+// Flow describes a single connection attempt on the overlay network
+type Flow struct {
+	Src      netip.Addr
+	Dst      netip.Addr
+	Protocol string // tcp, udp, icmp
+	Port     uint16 // destination port (0 for icmp)
+}
+
+// Decision is the result of evaluating a flow against the policy set
+type Decision struct {
+	Allowed       bool   `json:"allowed"`
+	PolicyID      *uint  `json:"policy_id,omitempty"`
+	SourceAgentID uint   `json:"source_agent_id,omitempty"`
+	DestAgentID   uint   `json:"dest_agent_id,omitempty"`
+	Reason        string `json:"reason"`
+
+	// Generation of the snapshot used, so callers can cache decisions
+	Generation uint64 `json:"-"`
+	// RecheckAt is when a policy time window next opens or closes for this
+	// flow; cached decisions must be re-evaluated after it. Zero means never.
+	RecheckAt time.Time `json:"-"`
+}
+
+// Mode decides how overlapping allow and deny policies combine
+type Mode string
+
+const (
+	// DenyOverrides lets any matching deny policy win over every allow
+	// policy. Within each action, policies are taken in priority order.
+	DenyOverrides Mode = "deny-overrides"
+	// FirstMatch lets the matching policy with the lowest priority value
+	// decide, whatever its action
+	FirstMatch Mode = "first-match"
+)
+
+// Engine evaluates flows between agents against the stored policies.
+// It works on an in-memory snapshot refreshed by Reload so the packet
+// path never touches the database.
+type Engine struct {
+	mode        Mode
+	mu          sync.RWMutex
+	agentsByIP  map[netip.Addr]models.Agent
+	routes      []route       // approved subnet routes, most specific first
+	exit        *models.Agent // the approved exit node, if any
+	services    map[serviceKey]uint
+	named       map[namedServiceKey]struct{}
+	postures    map[uint]int               // agent ID to effective posture score
+	quarantined map[uint]string            // agent ID to posture status, for agents cut off
+	profiles    map[uint]string            // posture profile ID to name
+	compliance  map[complianceKey][]string // failures of agents against posture profiles
+	schedules   map[uint]*Schedule         // schedule ID to parsed schedule; nil if invalid
+	policies    []models.Policy            // in evaluation order
+	portRules   map[uint][]PortRule        // policy ID to parsed AllowedPorts; nil if invalid
+	generation  uint64
+}
+
+// serviceKey locates a registered service on an agent
+type serviceKey struct {
+	AgentID  uint
+	Protocol string
+	Port     int
+}
+
+// route is an approved subnet and the agent that forwards traffic for it
+type route struct {
+	prefix netip.Prefix
+	agent  models.Agent
+}
+
+// complianceKey locates the failures of an agent against a posture profile
+type complianceKey struct {
+	AgentID   uint
+	ProfileID uint
+}
+
+// namedServiceKey resolves service references in policies
+type namedServiceKey struct {
+	serviceKey
+	Name string // lower-cased
+}
+
+// NewEngine creates an empty engine; everything is denied until Reload is called
+func NewEngine(mode Mode) *Engine {
+	if mode != FirstMatch {
+		mode = DenyOverrides
+	}
+	return &Engine{
+		mode:        mode,
+		agentsByIP:  make(map[netip.Addr]models.Agent),
+		services:    make(map[serviceKey]uint),
+		named:       make(map[namedServiceKey]struct{}),
+		postures:    make(map[uint]int),
+		quarantined: make(map[uint]string),
+		profiles:    make(map[uint]string),
+		compliance:  make(map[complianceKey][]string),
+		schedules:   make(map[uint]*Schedule),
+		portRules:   make(map[uint][]PortRule),
+	}
+}
+
+// Reload refreshes the agent and policy snapshot from the database
+func (e *Engine) Reload() error {
+	var agents []models.Agent
+	if err := db.DB.Where("ip <> '' AND disabled = ?", false).Find(&agents).Error; err != nil {
+		return fmt.Errorf("load agents: %w", err)
+	}
+
+	// Policies referencing deleted groups never match
+	var policies []models.Policy
+	if err := db.DB.Preload("SourceGroup").Preload("DestGroup").Where("enabled = ?", true).
+		Where("source_group_id IN (?) AND dest_group_id IN (?)",
+			db.DB.Model(&models.Group{}).Select("id"), db.DB.Model(&models.Group{}).Select("id")).
+		Order("priority ASC, id ASC").Find(&policies).Error; err != nil {
+		return fmt.Errorf("load policies: %w", err)
+	}
+	orderPolicies(policies, e.mode)
+
+	var services []models.Service
+	if err := db.DB.Where("enabled = ?", true).Find(&services).Error; err != nil {
+		return fmt.Errorf("load services: %w", err)
+	}
+
+	var postures []models.DevicePosture
+	if err := db.DB.Select("agent_id", "posture_score", "status").Find(&postures).Error; err != nil {
+		return fmt.Errorf("load postures: %w", err)
+	}
+	settings, err := posture.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	var profileList []models.PostureProfile
+	if err := db.DB.Select("id", "name").Find(&profileList).Error; err != nil {
+		return fmt.Errorf("load posture profiles: %w", err)
+	}
+	var results []models.PostureCompliance
+	if err := db.DB.Find(&results).Error; err != nil {
+		return fmt.Errorf("load posture compliance: %w", err)
+	}
+
+	var scheduleList []models.Schedule
+	if err := db.DB.Find(&scheduleList).Error; err != nil {
+		return fmt.Errorf("load schedules: %w", err)
+	}
+
+	byIP := make(map[netip.Addr]models.Agent, len(agents))
+	var routes []route
+	var exit *models.Agent
+	for _, agent := range agents {
+		addr, err := netip.ParseAddr(agent.IP)
+		if err != nil {
+			log.Printf("Policy engine: skipping agent %d with invalid IP %q", agent.ID, agent.IP)
+			continue
+		}
+		byIP[addr] = agent
+		for _, prefix := range ipam.ParseRoutes(agent.ApprovedRoutes) {
+			routes = append(routes, route{prefix: prefix, agent: agent})
+		}
+		if agent.ExitNode && exit == nil {
+			exit = &agent
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].prefix.Bits() > routes[j].prefix.Bits()
+	})
+
+	byService := make(map[serviceKey]uint, len(services))
+	named := make(map[namedServiceKey]struct{}, len(services))
+	for _, svc := range services {
+		key := serviceKey{AgentID: svc.AgentID, Protocol: svc.Protocol, Port: svc.Port}
+		byService[key] = svc.ID
+		named[namedServiceKey{serviceKey: key, Name: strings.ToLower(svc.Name)}] = struct{}{}
+	}
+
+	// Values are validated on save; anything that slipped past matches nothing
+	rules := make(map[uint][]PortRule, len(policies))
+	for _, p := range policies {
+		parsed, err := ParsePorts(p.AllowedPorts)
+		if err != nil {
+			log.Printf("Policy engine: policy %d has invalid allowed ports: %v", p.ID, err)
+		}
+		rules[p.ID] = parsed
+	}
+
+	// Agents that never reported have no score, and under quarantine they
+	// are held back like any other noncompliant device
+	scores := make(map[uint]int, len(postures))
+	quarantined := make(map[uint]string)
+	for _, p := range postures {
+		scores[p.AgentID] = posture.EffectiveScore(&p)
+		if settings.Quarantine && p.Status != posture.StatusCompliant {
+			quarantined[p.AgentID] = p.Status
+		}
+	}
+	if settings.Quarantine {
+		for _, agent := range agents {
+			if _, ok := scores[agent.ID]; !ok {
+				quarantined[agent.ID] = posture.StatusUnreported
+			}
+		}
+	}
+
+	profiles := make(map[uint]string, len(profileList))
+	for _, p := range profileList {
+		profiles[p.ID] = p.Name
+	}
+	compliance := make(map[complianceKey][]string, len(results))
+	for _, r := range results {
+		compliance[complianceKey{r.AgentID, r.ProfileID}] = r.Failures
+	}
+
+	// Schedules are validated on save too; an invalid one is never open
+	schedules := make(map[uint]*Schedule, len(scheduleList))
+	for i := range scheduleList {
+		parsed, err := ParseSchedule(&scheduleList[i])
+		if err != nil {
+			log.Printf("Policy engine: schedule %d is invalid: %v", scheduleList[i].ID, err)
+		}
+		schedules[scheduleList[i].ID] = parsed
+	}
+
+	e.mu.Lock()
+	e.agentsByIP = byIP
+	e.routes = routes
+	e.exit = exit
+	e.services = byService
+	e.named = named
+	e.postures = scores
+	e.quarantined = quarantined
+	e.profiles = profiles
+	e.compliance = compliance
+	e.schedules = schedules
+	e.portRules = rules
+	e.policies = policies
+	e.generation++
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Mode returns how the engine combines overlapping policies
+func (e *Engine) Mode() Mode {
+	return e.mode
+}
+
+// Generation returns a counter that changes on every Reload
+func (e *Engine) Generation() uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.generation
+}
+
+// AgentFor resolves a destination address to the agent that receives its
+// traffic: the owner of an overlay address, the router of an approved
+// subnet, or the exit node for public internet addresses
+func (e *Engine) AgentFor(addr netip.Addr) (models.Agent, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	agent, _, ok := e.destination(addr)
+	return agent, ok
+}
+
+// Routed reports whether addr belongs to an agent or one of its approved
+// subnets, so the hub reaches it through the overlay. Internet addresses
+// served by the exit node do not count.
+func (e *Engine) Routed(addr netip.Addr) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, exit, ok := e.destination(addr)
+	return ok && !exit
+}
+
+// destination implements AgentFor, also reporting whether the traffic
+// leaves through the exit node. Callers hold e.mu.
+func (e *Engine) destination(addr netip.Addr) (agent models.Agent, exit bool, ok bool) {
+	if agent, ok := e.agentsByIP[addr]; ok {
+		return agent, false, true
+	}
+	for _, r := range e.routes {
+		if r.prefix.Contains(addr) {
+			return r.agent, false, true
+		}
+	}
+	if e.exit != nil && Exitable(addr) {
+		return *e.exit, true, true
+	}
+	return models.Agent{}, false, false
+}
+
+// Exitable reports whether traffic for addr may leave through an exit node:
+// it must be a public IPv4 unicast address outside the overlay network.
+// Private networks are only reachable as approved subnet routes, and exit
+// nodes are only routed 0.0.0.0/0, so IPv6 never reaches one.
+func Exitable(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.Is4() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	return ipam.Pool == nil || !ipam.Pool.Prefix().Contains(addr)
+}
+
+// ExitNodeFor returns the exit node an agent sends its internet-bound
+// traffic through: the agent must use the exit node and an enabled allow
+// policy must lead from its group to the exit node's group. As with
+// RoutesFor, the policies' other conditions are checked for each flow.
+func (e *Engine) ExitNodeFor(agent *models.Agent) (models.Agent, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !agent.UseExitNode || e.exit == nil || e.exit.ID == agent.ID ||
+		agent.GroupID == nil || e.exit.GroupID == nil {
+		return models.Agent{}, false
+	}
+	for _, p := range e.policies {
+		if p.Action != "deny" && p.SourceGroupID == *agent.GroupID && p.DestGroupID == *e.exit.GroupID {
+			return *e.exit, true
+		}
+	}
+	return models.Agent{}, false
+}
+
+// RoutesFor returns the approved subnets an agent may be sent: those routed
+// by agents in a group that an enabled allow policy from the agent's group
+// leads to. The policies' other conditions are still checked for each flow.
+func (e *Engine) RoutesFor(agent *models.Agent) []netip.Prefix {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var prefixes []netip.Prefix
+	if agent.GroupID == nil {
+		return prefixes
+	}
+	for _, r := range e.routes {
+		if r.agent.ID == agent.ID || r.agent.GroupID == nil {
+			continue
+		}
+		for _, p := range e.policies {
+			if p.Action != "deny" && p.SourceGroupID == *agent.GroupID && p.DestGroupID == *r.agent.GroupID {
+				prefixes = append(prefixes, r.prefix)
+				break
+			}
+		}
+	}
+	return prefixes
+}
+
+// ServiceFor returns the ID of the service an agent exposes on a port, if any
+func (e *Engine) ServiceFor(agentID uint, protocol string, port uint16) *uint {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	id, ok := e.services[serviceKey{AgentID: agentID, Protocol: protocol, Port: int(port)}]
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
+// Evaluate decides whether a flow is allowed. The stance is default-deny:
+// a flow passes only when an enabled allow policy links the source and
+// destination groups and, depending on the mode, no matching deny policy
+// exists or none comes first in priority order. Policies only apply within
+// their validity window and schedule, and when the source agent meets their
+// posture and region requirements. Quarantined agents can neither send nor
+// receive.
+func (e *Engine) Evaluate(f Flow) Decision {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	d, _ := e.evaluate(f, EvalOptions{}, false)
+	return d
+}
+
+// evaluate implements Evaluate and Explain. With explain set it also
+// reports why each policy did or did not match. Callers hold e.mu.
+func (e *Engine) evaluate(f Flow, opts EvalOptions, explain bool) (Decision, []PolicyResult) {
+	d := Decision{Generation: e.generation}
+
+	src, ok := e.agentsByIP[f.Src]
+	if !ok {
+		d.Reason = "unknown source address"
+		return d, nil
+	}
+	d.SourceAgentID = src.ID
+
+	dst, viaExit, ok := e.destination(f.Dst)
+	if !ok {
+		d.Reason = "unknown destination address"
+		return d, nil
+	}
+	d.DestAgentID = dst.ID
+	if viaExit && !src.UseExitNode {
+		d.Reason = "destination is on the internet and the source agent does not use the exit node"
+		return d, nil
+	}
+	if viaExit && src.ID == dst.ID {
+		d.Reason = "source agent is the exit node"
+		return d, nil
+	}
+
+	if status, ok := e.quarantined[src.ID]; ok {
+		d.Reason = fmt.Sprintf("source agent is quarantined, its posture is %s", status)
+		return d, nil
+	}
+	if status, ok := e.quarantined[dst.ID]; ok {
+		d.Reason = fmt.Sprintf("destination agent is quarantined, its posture is %s", status)
+		return d, nil
+	}
+
+	now := opts.At
+	if now.IsZero() {
+		now = time.Now()
+	}
+	facts := sourceFacts{
+		posture: e.postures[src.ID],
+		region:  src.Country,
+	}
+	if opts.SourceRegion != "" {
+		facts.region = opts.SourceRegion
+	}
+
+	var results []PolicyResult
+	var decider *models.Policy
+	for i := range e.policies {
+		p := &e.policies[i]
+		reason, candidate := e.matchPolicy(p, &src, &dst, f, now, facts)
+		if candidate && decider == nil {
+			// Time windows change the outcome without a reload
+			d.RecheckAt = earliestBoundary(d.RecheckAt, p, e.schedule(p), now)
+		}
+		matched := reason == ""
+		if matched && decider == nil {
+			decider = p
+		}
+
+		if !explain {
+			if decider != nil {
+				break
+			}
+			continue
+		}
+		switch {
+		case matched && decider == p:
+			reason = "matches"
+		case matched:
+			reason = fmt.Sprintf("matches, but policy %q takes precedence", decider.Name)
+		}
+		results = append(results, PolicyResult{
+			PolicyID: p.ID,
+			Name:     p.Name,
+			Action:   p.Action,
+			Priority: p.Priority,
+			Matched:  matched,
+			Reason:   reason,
+		})
+	}
+
+	switch {
+	case decider != nil && decider.Action == "deny":
+		d.PolicyID = &decider.ID
+		d.Reason = fmt.Sprintf("denied by policy %q", decider.Name)
+	case decider != nil:
+		d.Allowed = true
+		d.PolicyID = &decider.ID
+		d.Reason = fmt.Sprintf("allowed by policy %q", decider.Name)
+	case src.GroupID == nil || dst.GroupID == nil:
+		d.Reason = "source or destination agent has no group"
+	default:
+		d.Reason = "no matching policy"
+	}
+	return d, results
+}
+
+// orderPolicies puts policies sorted by priority and ID into evaluation
+// order, so the first matching policy decides a flow in either mode
+func orderPolicies(policies []models.Policy, mode Mode) {
+	if mode != DenyOverrides {
+		return
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policies[i].Action == "deny" && policies[j].Action != "deny"
+	})
+}
+
+// sourceFacts are the properties of the source agent policy conditions test
+type sourceFacts struct {
+	posture int
+	region  string // ISO country code, empty if unknown
+}
+
+// matchPolicy checks a policy against a flow and returns an empty reason if
+// it applies, or why it does not. candidate reports whether groups and ports
+// match, i.e. the policy's conditions are all that decide.
+func (e *Engine) matchPolicy(p *models.Policy, src, dst *models.Agent, f Flow, now time.Time, facts sourceFacts) (reason string, candidate bool) {
+	if src.GroupID == nil {
+		return "source agent has no group", false
+	}
+	if p.SourceGroupID != *src.GroupID {
+		return fmt.Sprintf("source agent is not in group %q", p.SourceGroup.Name), false
+	}
+	if dst.GroupID == nil {
+		return "destination agent has no group", false
+	}
+	if p.DestGroupID != *dst.GroupID {
+		return fmt.Sprintf("destination agent is not in group %q", p.DestGroup.Name), false
+	}
+	rules := e.portRules[p.ID]
+	if rules == nil {
+		return "allowed ports are invalid", false
+	}
+	hasService := func(name string) bool {
+		_, ok := e.named[namedServiceKey{serviceKey{AgentID: dst.ID, Protocol: f.Protocol, Port: int(f.Port)}, name}]
+		return ok
+	}
+	if !portsMatch(rules, f.Protocol, f.Port, hasService) {
+		return fmt.Sprintf("%s/%d is not in allowed ports %q", f.Protocol, f.Port, p.AllowedPorts), false
+	}
+
+	if p.ValidFrom != nil && now.Before(*p.ValidFrom) {
+		return fmt.Sprintf("not valid before %s", p.ValidFrom.Format(time.RFC3339)), true
+	}
+	if p.ValidUntil != nil && !now.Before(*p.ValidUntil) {
+		return fmt.Sprintf("expired at %s", p.ValidUntil.Format(time.RFC3339)), true
+	}
+	if p.ScheduleID != nil {
+		// Deleted schedules impose nothing
+		if sched, ok := e.schedules[*p.ScheduleID]; ok {
+			switch {
+			case sched == nil:
+				return "schedule is invalid", true
+			case !sched.Active(now):
+				return fmt.Sprintf("outside schedule %q (%s)", sched.Name, sched), true
+			}
+		}
+	}
+	if p.MinPostureScore > 0 && facts.posture < p.MinPostureScore {
+		return fmt.Sprintf("source posture score %d is below the required %d", facts.posture, p.MinPostureScore), true
+	}
+	if id := p.SourceGroup.PostureProfileID; id != nil {
+		if failure := e.profileFailure(src.ID, *id); failure != "" {
+			return fmt.Sprintf("source agent does not meet the posture profile of group %q: %s", p.SourceGroup.Name, failure), true
+		}
+	}
+	if p.PostureProfileID != nil {
+		if failure := e.profileFailure(src.ID, *p.PostureProfileID); failure != "" {
+			return fmt.Sprintf("source agent does not meet the policy's posture profile: %s", failure), true
+		}
+	}
+	if regions := strings.TrimSpace(p.AllowedRegions); regions != "" {
+		if facts.region == "" {
+			return fmt.Sprintf("source region is unknown, policy requires one of %s", regions), true
+		}
+		if !regionAllowed(regions, facts.region) {
+			return fmt.Sprintf("source region %s is not in allowed regions %s", facts.region, regions), true
+		}
+	}
+	return "", true
+}
+
+// profileFailure describes why an agent fails a posture profile, or returns
+// an empty string if it complies. Deleted profiles impose nothing; agents
+// not evaluated yet fail.
+func (e *Engine) profileFailure(agentID, profileID uint) string {
+	name, ok := e.profiles[profileID]
+	if !ok {
+		return ""
+	}
+	failures, ok := e.compliance[complianceKey{agentID, profileID}]
+	if !ok {
+		return fmt.Sprintf("%q has not been evaluated yet", name)
+	}
+	if len(failures) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%q (%s)", name, strings.Join(failures, "; "))
+}
+
+// regionAllowed reports whether a comma-separated list of country codes contains region
+func regionAllowed(allowed, region string) bool {
+	for _, code := range strings.Split(allowed, ",") {
+		if strings.EqualFold(strings.TrimSpace(code), region) {
+			return true
+		}
+	}
+	return false
+}
+
+// earliestBoundary returns the earlier of current and the next time p's
+// validity window or its schedule sched (nil if none) opens or closes after now
+func earliestBoundary(current time.Time, p *models.Policy, sched *Schedule, now time.Time) time.Time {
+	boundaries := []*time.Time{p.ValidFrom, p.ValidUntil}
+	if sched != nil {
+		if next := sched.Next(now); !next.IsZero() {
+			boundaries = append(boundaries, &next)
+		}
+	}
+	for _, t := range boundaries {
+		if t != nil && t.After(now) && (current.IsZero() || t.Before(current)) {
+			current = *t
+		}
+	}
+	return current
+}
+
+// schedule returns the parsed schedule of p, or nil if it has none
+func (e *Engine) schedule(p *models.Policy) *Schedule {
+	if p.ScheduleID == nil {
+		return nil
+	}
+	return e.schedules[*p.ScheduleID]
+}
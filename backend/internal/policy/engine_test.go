@@ -0,0 +1,258 @@
+package policy
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/db/dbtest"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/posture"
+)
+
+// openTestDB migrates the tables the engine reads into a fresh database
+func openTestDB(t *testing.T) {
+	dbtest.Open(t, &models.Agent{}, &models.Group{}, &models.Policy{}, &models.Service{},
+		&models.DevicePosture{}, &models.PostureSettings{}, &models.PostureProfile{},
+		&models.PostureCompliance{}, &models.Schedule{})
+}
+
+// engineFixture creates three groups with one agent each plus an agent
+// without a group:
+//
+//	eng (10.0.0.2) -> prod (10.0.0.3): allow tcp/22 and service:web, deny tcp/22
+//	contractors (10.0.0.4) -> prod: allow everything, expired
+//	contractors -> eng: allow everything for another hour
+//	10.0.0.5 has no group
+func engineFixture(t *testing.T, now time.Time) {
+	t.Helper()
+	openTestDB(t)
+
+	eng := models.Group{Name: "eng"}
+	prod := models.Group{Name: "prod"}
+	contractors := models.Group{Name: "contractors"}
+	for _, g := range []*models.Group{&eng, &prod, &contractors} {
+		dbtest.Create(t, g)
+	}
+
+	dev := models.Agent{Name: "dev", IP: "10.0.0.2", GroupID: &eng.ID}
+	web := models.Agent{Name: "web", IP: "10.0.0.3", GroupID: &prod.ID}
+	laptop := models.Agent{Name: "laptop", IP: "10.0.0.4", GroupID: &contractors.ID}
+	stray := models.Agent{Name: "stray", IP: "10.0.0.5"}
+	for _, a := range []*models.Agent{&dev, &web, &laptop, &stray} {
+		dbtest.Create(t, a)
+	}
+	dbtest.Create(t, &models.Service{AgentID: web.ID, Name: "Web", Protocol: "tcp", Port: 8080})
+
+	expired := now.Add(-time.Hour)
+	until := now.Add(time.Hour).Truncate(time.Second)
+	for _, p := range []*models.Policy{
+		{Name: "eng-prod", SourceGroupID: eng.ID, DestGroupID: prod.ID, AllowedPorts: "tcp/22,service:web", Action: "allow", Priority: 100},
+		{Name: "no-ssh", SourceGroupID: eng.ID, DestGroupID: prod.ID, AllowedPorts: "tcp/22", Action: "deny", Priority: 200},
+		{Name: "contractors-prod", SourceGroupID: contractors.ID, DestGroupID: prod.ID, Action: "allow", Priority: 100, ValidUntil: &expired},
+		{Name: "contractors-eng", SourceGroupID: contractors.ID, DestGroupID: eng.ID, Action: "allow", Priority: 100, ValidUntil: &until},
+	} {
+		dbtest.Create(t, p)
+	}
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		src, dst string
+		protocol string
+		port     uint16
+		// Expected reasons per mode; allowed is derived from the prefix
+		denyOverrides string
+		firstMatch    string
+	}{
+		{"deny overrides a matching allow", "10.0.0.2", "10.0.0.3", "tcp", 22,
+			`denied by policy "no-ssh"`, `allowed by policy "eng-prod"`},
+		{"named service port", "10.0.0.2", "10.0.0.3", "tcp", 8080,
+			`allowed by policy "eng-prod"`, `allowed by policy "eng-prod"`},
+		{"service name needs the right protocol", "10.0.0.2", "10.0.0.3", "udp", 8080,
+			"no matching policy", "no matching policy"},
+		{"port not allowed", "10.0.0.2", "10.0.0.3", "tcp", 9090,
+			"no matching policy", "no matching policy"},
+		{"icmp not allowed", "10.0.0.2", "10.0.0.3", "icmp", 0,
+			"no matching policy", "no matching policy"},
+		{"no policy in the reverse direction", "10.0.0.3", "10.0.0.2", "tcp", 22,
+			"no matching policy", "no matching policy"},
+		{"expired policy", "10.0.0.4", "10.0.0.3", "tcp", 443,
+			"no matching policy", "no matching policy"},
+		{"policy within its window", "10.0.0.4", "10.0.0.2", "udp", 53,
+			`allowed by policy "contractors-eng"`, `allowed by policy "contractors-eng"`},
+		{"agent without a group", "10.0.0.5", "10.0.0.3", "tcp", 22,
+			"source or destination agent has no group", "source or destination agent has no group"},
+		{"unknown source", "10.0.0.99", "10.0.0.3", "tcp", 22,
+			"unknown source address", "unknown source address"},
+		{"unknown destination", "10.0.0.2", "10.0.0.99", "tcp", 22,
+			"unknown destination address", "unknown destination address"},
+	}
+
+	for _, mode := range []Mode{DenyOverrides, FirstMatch} {
+		engineFixture(t, now)
+		e := NewEngine(mode)
+		if err := e.Reload(); err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+
+		for _, tt := range tests {
+			t.Run(string(mode)+"/"+tt.name, func(t *testing.T) {
+				want := tt.denyOverrides
+				if mode == FirstMatch {
+					want = tt.firstMatch
+				}
+				d := e.Evaluate(Flow{
+					Src:      netip.MustParseAddr(tt.src),
+					Dst:      netip.MustParseAddr(tt.dst),
+					Protocol: tt.protocol,
+					Port:     tt.port,
+				})
+				if d.Reason != want {
+					t.Errorf("reason = %q, want %q", d.Reason, want)
+				}
+				if wantAllowed := strings.HasPrefix(want, "allowed"); d.Allowed != wantAllowed {
+					t.Errorf("allowed = %t, want %t", d.Allowed, wantAllowed)
+				}
+			})
+		}
+	}
+}
+
+func TestEngineRecheckAt(t *testing.T) {
+	now := time.Now()
+	engineFixture(t, now)
+	e := NewEngine(DenyOverrides)
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		src, dst string
+		want     time.Time
+	}{
+		{"window closes", "10.0.0.4", "10.0.0.2", now.Add(time.Hour).Truncate(time.Second)},
+		{"no window", "10.0.0.2", "10.0.0.3", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Evaluate(Flow{
+				Src:      netip.MustParseAddr(tt.src),
+				Dst:      netip.MustParseAddr(tt.dst),
+				Protocol: "tcp",
+				Port:     8080,
+			})
+			if !d.RecheckAt.Equal(tt.want) {
+				t.Errorf("RecheckAt = %v, want %v", d.RecheckAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineGeneration(t *testing.T) {
+	engineFixture(t, time.Now())
+	e := NewEngine(DenyOverrides)
+
+	flow := Flow{Src: netip.MustParseAddr("10.0.0.2"), Dst: netip.MustParseAddr("10.0.0.3"), Protocol: "tcp", Port: 8080}
+	if d := e.Evaluate(flow); d.Allowed {
+		t.Fatalf("engine allowed %v before the first Reload", flow)
+	}
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	first := e.Evaluate(flow)
+	if !first.Allowed || first.Generation != e.Generation() {
+		t.Fatalf("got %+v at generation %d", first, e.Generation())
+	}
+
+	// Decisions made on an older snapshot are recognisable as stale
+	if err := db.DB.Model(&models.Policy{}).Where("name = ?", "eng-prod").Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	second := e.Evaluate(flow)
+	if second.Allowed || second.Generation == first.Generation {
+		t.Errorf("after disabling the policy got %+v, first decision was at generation %d", second, first.Generation)
+	}
+}
+
+func TestEngineQuarantine(t *testing.T) {
+	engineFixture(t, time.Now())
+	// dev reported a compliant posture, web a stale one and laptop none at all
+	var dev, web models.Agent
+	db.DB.Where("name = ?", "dev").First(&dev)
+	db.DB.Where("name = ?", "web").First(&web)
+	dbtest.Create(t, &models.DevicePosture{AgentID: dev.ID, Status: posture.StatusCompliant, PostureScore: 100})
+	dbtest.Create(t, &models.DevicePosture{AgentID: web.ID, Status: posture.StatusStale, PostureScore: 100})
+
+	tests := []struct {
+		name       string
+		quarantine bool
+		src, dst   string
+		protocol   string
+		port       uint16
+		want       string
+	}{
+		{"unreported source", true, "10.0.0.4", "10.0.0.2", "udp", 53,
+			"source agent is quarantined, its posture is unreported"},
+		{"stale destination", true, "10.0.0.2", "10.0.0.3", "tcp", 8080,
+			"destination agent is quarantined, its posture is stale"},
+		{"unreported source without quarantine", false, "10.0.0.4", "10.0.0.2", "udp", 53,
+			`allowed by policy "contractors-eng"`},
+		{"stale destination without quarantine", false, "10.0.0.2", "10.0.0.3", "tcp", 8080,
+			`allowed by policy "eng-prod"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := posture.LoadSettings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.DB.Model(settings).Update("quarantine", tt.quarantine).Error; err != nil {
+				t.Fatal(err)
+			}
+			e := NewEngine(DenyOverrides)
+			if err := e.Reload(); err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+
+			d := e.Evaluate(Flow{Src: netip.MustParseAddr(tt.src), Dst: netip.MustParseAddr(tt.dst), Protocol: tt.protocol, Port: tt.port})
+			if d.Reason != tt.want {
+				t.Errorf("reason = %q, want %q", d.Reason, tt.want)
+			}
+			if wantAllowed := strings.HasPrefix(tt.want, "allowed"); d.Allowed != wantAllowed {
+				t.Errorf("allowed = %t, want %t", d.Allowed, wantAllowed)
+			}
+		})
+	}
+}
+
+func TestExitable(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"203.0.113.10", true},
+		{"::ffff:203.0.113.10", true},
+		{"192.168.1.10", false},
+		{"10.0.0.3", false},
+		{"127.0.0.1", false},
+		{"224.0.0.251", false},
+		{"100.64.0.1", true},   // shared address space is not private
+		{"2001:db8::1", false}, // exit nodes are only routed IPv4
+		{"fd00::1", false},
+	}
+	for _, tt := range tests {
+		if got := Exitable(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Exitable(%s) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package policy
+
+import "time"
+
+// EvalOptions overrides facts used by a dry-run evaluation
+type EvalOptions struct {
+	At           time.Time // evaluation time, zero for now
+	SourceRegion string    // country code assumed for the source agent instead of its resolved one
+}
+
+// PolicyResult tells how a single policy relates to a flow
+type PolicyResult struct {
+	PolicyID uint   `json:"policy_id"`
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+	Priority int    `json:"priority"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason"`
+}
+
+// Explanation is a decision together with the outcome of every enabled
+// policy, listed in evaluation order
+type Explanation struct {
+	Decision
+	Mode     Mode           `json:"mode"`
+	Policies []PolicyResult `json:"policies"`
+}
+
+// Explain evaluates a flow like Evaluate and additionally reports why each
+// policy did or did not match, for troubleshooting access problems
+func (e *Engine) Explain(f Flow, opts EvalOptions) Explanation {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	d, results := e.evaluate(f, opts, true)
+	if results == nil {
+		results = []PolicyResult{}
+	}
+	return Explanation{Decision: d, Mode: e.mode, Policies: results}
+}
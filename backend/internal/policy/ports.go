@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRule is one term of a policy's AllowedPorts value.
+//
+// The value is a comma-separated list of terms:
+//
+//	22, 8000-8100     a port or range over tcp and udp
+//	tcp/443, udp/53   a port or range over one protocol
+//	tcp/*             every port of one protocol
+//	icmp              ping and other icmp traffic
+//	service:web       the port of the destination's service named "web"
+//
+// A lone "*" covers every protocol and port, and an empty value is treated
+// the same way.
+type PortRule struct {
+	Any      bool
+	Protocol string // tcp, udp, icmp; empty for both tcp and udp
+	From, To uint16 // 0-0 means every port of Protocol
+	Service  string // lower-cased service name, matched on the destination agent
+}
+
+// ParsePorts parses an AllowedPorts value
+func ParsePorts(value string) ([]PortRule, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return []PortRule{{Any: true}}, nil
+	}
+
+	var rules []PortRule
+	for i, term := range strings.Split(value, ",") {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" {
+			return nil, fmt.Errorf("term %d is empty", i+1)
+		}
+		rule, err := parseTerm(term)
+		if err != nil {
+			return nil, fmt.Errorf("term %d (%q): %w", i+1, term, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseTerm(term string) (PortRule, error) {
+	if term == "*" {
+		return PortRule{Any: true}, nil
+	}
+	if term == "icmp" {
+		return PortRule{Protocol: "icmp"}, nil
+	}
+	if name, ok := strings.CutPrefix(term, "service:"); ok {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return PortRule{}, fmt.Errorf("service name is missing")
+		}
+		return PortRule{Service: name}, nil
+	}
+
+	rule := PortRule{}
+	ports := term
+	if proto, rest, ok := strings.Cut(term, "/"); ok {
+		switch proto {
+		case "tcp", "udp":
+		case "icmp":
+			return PortRule{}, fmt.Errorf("icmp does not take ports")
+		default:
+			return PortRule{}, fmt.Errorf("unknown protocol %q, expected tcp, udp or icmp", proto)
+		}
+		rule.Protocol = proto
+		ports = strings.TrimSpace(rest)
+		if ports == "*" {
+			return rule, nil
+		}
+	}
+
+	lo, hi, isRange := strings.Cut(ports, "-")
+	from, err := parsePort(lo)
+	if err != nil {
+		return PortRule{}, err
+	}
+	to := from
+	if isRange {
+		if to, err = parsePort(hi); err != nil {
+			return PortRule{}, err
+		}
+		if from > to {
+			return PortRule{}, fmt.Errorf("range start %d is greater than its end %d", from, to)
+		}
+	}
+	rule.From, rule.To = from, to
+	return rule, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	if n < 1 || n > 65535 {
+		return 0, fmt.Errorf("port %d is out of range 1-65535", n)
+	}
+	return uint16(n), nil
+}
+
+// FormatPorts renders rules in the normalized form stored on policies
+func FormatPorts(rules []PortRule) string {
+	terms := make([]string, 0, len(rules))
+	seen := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		term := r.String()
+		if term == "*" {
+			return "*" // covers everything else
+		}
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// ServiceNames returns the services referenced by rules
+func ServiceNames(rules []PortRule) []string {
+	var names []string
+	for _, r := range rules {
+		if r.Service != "" {
+			names = append(names, r.Service)
+		}
+	}
+	return names
+}
+
+func (r PortRule) String() string {
+	switch {
+	case r.Any:
+		return "*"
+	case r.Service != "":
+		return "service:" + r.Service
+	case r.Protocol == "icmp":
+		return "icmp"
+	}
+
+	ports := "*"
+	if r.From != 0 {
+		ports = strconv.Itoa(int(r.From))
+		if r.To != r.From {
+			ports += "-" + strconv.Itoa(int(r.To))
+		}
+	}
+	if r.Protocol == "" {
+		return ports
+	}
+	return r.Protocol + "/" + ports
+}
+
+// matches reports whether the rule covers a flow. hasService tells whether
+// the destination exposes a named service on the flow's protocol and port.
+func (r PortRule) matches(protocol string, port uint16, hasService func(name string) bool) bool {
+	switch {
+	case r.Any:
+		return true
+	case r.Service != "":
+		return protocol != "icmp" && hasService(r.Service)
+	case r.Protocol == "icmp":
+		return protocol == "icmp"
+	case protocol == "icmp":
+		return false
+	case r.Protocol != "" && r.Protocol != protocol:
+		return false
+	case r.From == 0:
+		return true
+	}
+	return port >= r.From && port <= r.To
+}
+
+// portsMatch reports whether any of the rules covers the flow
+func portsMatch(rules []PortRule, protocol string, port uint16, hasService func(name string) bool) bool {
+	for _, r := range rules {
+		if r.matches(protocol, port, hasService) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string // normalized form
+		wantErr bool
+	}{
+		{value: "", want: "*"},
+		{value: "  ", want: "*"},
+		{value: "*", want: "*"},
+		{value: "22", want: "22"},
+		{value: " 22 , 8000-8100 ", want: "22,8000-8100"},
+		{value: "TCP/443, udp/53", want: "tcp/443,udp/53"},
+		{value: "tcp/*", want: "tcp/*"},
+		{value: "tcp/ *", want: "tcp/*"},
+		{value: "ICMP", want: "icmp"},
+		{value: "service: Web", want: "service:web"},
+		{value: "22,tcp/22,22", want: "22,tcp/22"},
+		{value: "80-80", want: "80"},
+		{value: "22,*,icmp", want: "*"},
+
+		{value: "22,", wantErr: true},
+		{value: ",22", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "65536", wantErr: true},
+		{value: "ssh", wantErr: true},
+		{value: "100-90", wantErr: true},
+		{value: "80-", wantErr: true},
+		{value: "sctp/80", wantErr: true},
+		{value: "icmp/8", wantErr: true},
+		{value: "service:", wantErr: true},
+		{value: "tcp/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			rules, err := ParsePorts(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePorts(%q) = %v, want an error", tt.value, rules)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePorts(%q) error: %v", tt.value, err)
+			}
+			got := FormatPorts(rules)
+			if got != tt.want {
+				t.Errorf("FormatPorts(ParsePorts(%q)) = %q, want %q", tt.value, got, tt.want)
+			}
+
+			// The normalized form parses to the same thing
+			again, err := ParsePorts(got)
+			if err != nil || FormatPorts(again) != got {
+				t.Errorf("normalized value %q does not round-trip: %q, %v", got, FormatPorts(again), err)
+			}
+		})
+	}
+}
+
+func TestPortsMatch(t *testing.T) {
+	// The destination exposes "web" on tcp/8080 only
+	hasService := func(protocol string, port uint16) func(string) bool {
+		return func(name string) bool {
+			return name == "web" && protocol == "tcp" && port == 8080
+		}
+	}
+
+	tests := []struct {
+		value    string
+		protocol string
+		port     uint16
+		want     bool
+	}{
+		{"*", "tcp", 22, true},
+		{"*", "icmp", 0, true},
+		{"", "udp", 53, true},
+		{"22", "tcp", 22, true},
+		{"22", "udp", 22, true},
+		{"22", "tcp", 23, false},
+		{"22", "icmp", 0, false},
+		{"8000-8100", "tcp", 8000, true},
+		{"8000-8100", "tcp", 8100, true},
+		{"8000-8100", "tcp", 8101, false},
+		{"tcp/443", "tcp", 443, true},
+		{"tcp/443", "udp", 443, false},
+		{"udp/*", "udp", 5353, true},
+		{"udp/*", "tcp", 5353, false},
+		{"icmp", "icmp", 0, true},
+		{"icmp", "tcp", 0, false},
+		{"service:web", "tcp", 8080, true},
+		{"service:web", "tcp", 8081, false},
+		{"service:web", "udp", 8080, false},
+		{"service:db", "tcp", 8080, false},
+		{"tcp/22,icmp", "icmp", 0, true},
+		{"tcp/22,icmp", "udp", 22, false},
+	}
+
+	for _, tt := range tests {
+		rules, err := ParsePorts(tt.value)
+		if err != nil {
+			t.Fatalf("ParsePorts(%q) error: %v", tt.value, err)
+		}
+		if got := portsMatch(rules, tt.protocol, tt.port, hasService(tt.protocol, tt.port)); got != tt.want {
+			t.Errorf("%q matching %s/%d = %t, want %t", tt.value, tt.protocol, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestServiceNames(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"22,tcp/443", nil},
+		{"service:Web", []string{"web"}},
+		{"service:web,22,service:db", []string{"web", "db"}},
+	}
+	for _, tt := range tests {
+		rules, err := ParsePorts(tt.value)
+		if err != nil {
+			t.Fatalf("ParsePorts(%q) error: %v", tt.value, err)
+		}
+		if got := ServiceNames(rules); !slices.Equal(got, tt.want) {
+			t.Errorf("ServiceNames(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones must resolve on hosts without zoneinfo
+
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// weekdays are the day names used in schedule windows, indexed by time.Weekday
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// weekOrder lists time.Weekday values Monday first, the way schedules are
+// usually written
+var weekOrder = []int{1, 2, 3, 4, 5, 6, 0}
+
+// Schedule is a parsed models.Schedule that can tell whether it is open at
+// a given time
+type Schedule struct {
+	Name     string
+	location *time.Location
+	windows  []window
+}
+
+// window is a ScheduleWindow in minutes since midnight. end is 1440 for
+// "24:00" and below start for windows that run past midnight.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+// NormalizeSchedule validates a schedule and rewrites its fields in their
+// canonical form: trimmed name, lower-case day names in week order and
+// zero-padded times
+func NormalizeSchedule(s *models.Schedule) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	s.Timezone = strings.TrimSpace(s.Timezone)
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("unknown time zone %q", s.Timezone)
+	}
+	if len(s.Windows) == 0 {
+		return errors.New("at least one window is required")
+	}
+
+	for i := range s.Windows {
+		w := &s.Windows[i]
+		parsed, err := parseWindow(*w)
+		if err != nil {
+			return fmt.Errorf("window %d: %w", i+1, err)
+		}
+		w.Days = nil
+		for _, d := range weekOrder {
+			if parsed.days[d] {
+				w.Days = append(w.Days, weekdays[d])
+			}
+		}
+		w.Start, w.End = formatMinutes(parsed.start), formatMinutes(parsed.end)
+	}
+	return nil
+}
+
+// ParseSchedule prepares a stored schedule for evaluation
+func ParseSchedule(s *models.Schedule) (*Schedule, error) {
+	tz := s.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+	parsed := &Schedule{Name: s.Name, location: location}
+	for i, w := range s.Windows {
+		pw, err := parseWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i+1, err)
+		}
+		parsed.windows = append(parsed.windows, pw)
+	}
+	return parsed, nil
+}
+
+func parseWindow(w models.ScheduleWindow) (window, error) {
+	var pw window
+	if len(w.Days) == 0 {
+		return pw, errors.New("no days given")
+	}
+	for _, day := range w.Days {
+		i, ok := parseDay(day)
+		if !ok {
+			return pw, fmt.Errorf("unknown day %q, expected mon, tue, wed, thu, fri, sat or sun", day)
+		}
+		pw.days[i] = true
+	}
+
+	var err error
+	if pw.start, err = parseMinutes(w.Start); err != nil {
+		return pw, fmt.Errorf("start: %w", err)
+	}
+	if pw.start == 24*60 {
+		return pw, errors.New("start: 24:00 is only allowed as an end time")
+	}
+	if pw.end, err = parseMinutes(w.End); err != nil {
+		return pw, fmt.Errorf("end: %w", err)
+	}
+	if pw.start == pw.end {
+		return pw, errors.New("start and end are equal; use 00:00 to 24:00 for whole days")
+	}
+	return pw, nil
+}
+
+// parseDay accepts short and full English day names
+func parseDay(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range weekdays {
+		if s == name || s == strings.ToLower(time.Weekday(i).String()) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// parseMinutes parses "15:04" or "24:00" into minutes since midnight
+func parseMinutes(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day like 09:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatMinutes(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+// Active reports whether one of the schedule's windows is open at t
+func (s *Schedule) Active(t time.Time) bool {
+	local := t.In(s.location)
+	day := int(local.Weekday())
+	minute := local.Hour()*60 + local.Minute()
+	for _, w := range s.windows {
+		switch {
+		case w.start < w.end:
+			if w.days[day] && minute >= w.start && minute < w.end {
+				return true
+			}
+		default:
+			// Past midnight: the evening of a listed day or the morning after
+			if (w.days[day] && minute >= w.start) || (w.days[(day+6)%7] && minute < w.end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Next returns the first time after t at which a window opens or closes,
+// or the zero time if the schedule has no windows
+func (s *Schedule) Next(t time.Time) time.Time {
+	local := t.In(s.location)
+	var next time.Time
+	consider := func(b time.Time) {
+		if b.After(t) && (next.IsZero() || b.Before(next)) {
+			next = b
+		}
+	}
+
+	// Windows that started yesterday may still close today
+	for offset := -1; offset <= 7; offset++ {
+		y, m, d := local.Date()
+		d += offset
+		weekday := time.Date(y, m, d, 12, 0, 0, 0, s.location).Weekday()
+		for _, w := range s.windows {
+			if !w.days[weekday] {
+				continue
+			}
+			end := d
+			if w.end < w.start {
+				end++
+			}
+			consider(time.Date(y, m, d, 0, w.start, 0, 0, s.location))
+			consider(time.Date(y, m, end, 0, w.end, 0, 0, s.location))
+		}
+	}
+	return next
+}
+
+// String describes the schedule's windows, e.g. "mon-fri 09:00-17:00 Europe/Berlin"
+func (s *Schedule) String() string {
+	parts := make([]string, 0, len(s.windows))
+	for _, w := range s.windows {
+		parts = append(parts, describeDays(w.days)+" "+formatMinutes(w.start)+"-"+formatMinutes(w.end))
+	}
+	return strings.Join(parts, ", ") + " " + s.location.String()
+}
+
+// describeDays writes open days Monday first, collapsing runs of three or
+// more into ranges
+func describeDays(days [7]bool) string {
+	var parts []string
+	for i := 0; i < len(weekOrder); {
+		if !days[weekOrder[i]] {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(weekOrder) && days[weekOrder[j+1]] {
+			j++
+		}
+		if j-i >= 2 {
+			parts = append(parts, weekdays[weekOrder[i]]+"-"+weekdays[weekOrder[j]])
+		} else {
+			for k := i; k <= j; k++ {
+				parts = append(parts, weekdays[weekOrder[k]])
+			}
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
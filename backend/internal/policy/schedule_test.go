@@ -0,0 +1,174 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+func TestNormalizeSchedule(t *testing.T) {
+	valid := func() models.Schedule {
+		return models.Schedule{
+			Name:    "office",
+			Windows: []models.ScheduleWindow{{Days: []string{"mon"}, Start: "09:00", End: "17:00"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(s *models.Schedule)
+		want    models.ScheduleWindow
+		wantErr bool
+	}{
+		{
+			name: "canonical form",
+			edit: func(s *models.Schedule) {
+				s.Windows[0] = models.ScheduleWindow{Days: []string{"Friday", " MON", "wed", "mon"}, Start: "9:00", End: "17:30"}
+			},
+			want: models.ScheduleWindow{Days: []string{"mon", "wed", "fri"}, Start: "09:00", End: "17:30"},
+		},
+		{
+			name: "sunday sorts last",
+			edit: func(s *models.Schedule) {
+				s.Windows[0] = models.ScheduleWindow{Days: []string{"sun", "sat"}, Start: "00:00", End: "24:00"}
+			},
+			want: models.ScheduleWindow{Days: []string{"sat", "sun"}, Start: "00:00", End: "24:00"},
+		},
+		{
+			name: "past midnight",
+			edit: func(s *models.Schedule) {
+				s.Windows[0] = models.ScheduleWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"}
+			},
+			want: models.ScheduleWindow{Days: []string{"fri"}, Start: "22:00", End: "06:00"},
+		},
+		{name: "name required", edit: func(s *models.Schedule) { s.Name = "  " }, wantErr: true},
+		{name: "unknown time zone", edit: func(s *models.Schedule) { s.Timezone = "Mars/Olympus" }, wantErr: true},
+		{name: "no windows", edit: func(s *models.Schedule) { s.Windows = nil }, wantErr: true},
+		{name: "no days", edit: func(s *models.Schedule) { s.Windows[0].Days = nil }, wantErr: true},
+		{name: "unknown day", edit: func(s *models.Schedule) { s.Windows[0].Days = []string{"funday"} }, wantErr: true},
+		{name: "bad time", edit: func(s *models.Schedule) { s.Windows[0].End = "25:00" }, wantErr: true},
+		{name: "start at 24:00", edit: func(s *models.Schedule) { s.Windows[0].Start = "24:00" }, wantErr: true},
+		{name: "empty window", edit: func(s *models.Schedule) { s.Windows[0].End = "09:00" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.edit(&s)
+			err := NormalizeSchedule(&s)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NormalizeSchedule() accepted %+v", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeSchedule() error: %v", err)
+			}
+			if s.Timezone != "UTC" {
+				t.Errorf("Timezone = %q, want UTC", s.Timezone)
+			}
+			got := s.Windows[0]
+			if !slices.Equal(got.Days, tt.want.Days) || got.Start != tt.want.Start || got.End != tt.want.End {
+				t.Errorf("window = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testSchedules are parsed for the Active and Next tests. Berlin is on CEST
+// (UTC+2) throughout the week of 12 October 2026, which starts on a Monday.
+var testSchedules = map[string]models.Schedule{
+	"business": {Name: "business", Timezone: "Europe/Berlin", Windows: []models.ScheduleWindow{
+		{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"},
+	}},
+	"night": {Name: "night", Windows: []models.ScheduleWindow{
+		{Days: []string{"fri"}, Start: "22:00", End: "06:00"},
+	}},
+	"weekend": {Name: "weekend", Timezone: "UTC", Windows: []models.ScheduleWindow{
+		{Days: []string{"sat", "sun"}, Start: "00:00", End: "24:00"},
+	}},
+}
+
+func parseTestSchedule(t *testing.T, name string) *Schedule {
+	t.Helper()
+	s := testSchedules[name]
+	parsed, err := ParseSchedule(&s)
+	if err != nil {
+		t.Fatalf("ParseSchedule(%s) error: %v", name, err)
+	}
+	return parsed
+}
+
+func utc(day, hour, minute int) time.Time {
+	return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduleActive(t *testing.T) {
+	tests := []struct {
+		schedule string
+		at       time.Time
+		want     bool
+	}{
+		{"business", utc(12, 6, 59), false},
+		{"business", utc(12, 7, 0), true}, // 09:00 in Berlin
+		{"business", utc(16, 14, 59), true},
+		{"business", utc(16, 15, 0), false}, // 17:00 in Berlin
+		{"business", utc(17, 10, 0), false}, // Saturday
+		{"night", utc(16, 21, 59), false},
+		{"night", utc(16, 22, 0), true},
+		{"night", utc(17, 5, 59), true}, // Saturday morning, carried over from Friday
+		{"night", utc(17, 6, 0), false},
+		{"night", utc(15, 23, 0), false}, // Thursday
+		{"night", utc(17, 23, 0), false}, // Saturday evening
+		{"weekend", utc(17, 0, 0), true},
+		{"weekend", utc(18, 23, 59), true},
+		{"weekend", utc(19, 0, 0), false},
+	}
+	for _, tt := range tests {
+		s := parseTestSchedule(t, tt.schedule)
+		if got := s.Active(tt.at); got != tt.want {
+			t.Errorf("%s.Active(%s %s) = %t, want %t", tt.schedule, tt.at.Weekday(), tt.at.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		schedule string
+		at       time.Time
+		want     time.Time
+	}{
+		{"business", utc(12, 6, 0), utc(12, 7, 0)},
+		{"business", utc(12, 7, 0), utc(12, 15, 0)}, // strictly after
+		{"business", utc(16, 16, 0), utc(19, 7, 0)}, // over the weekend
+		{"night", utc(17, 1, 0), utc(17, 6, 0)},
+		{"night", utc(17, 6, 0), utc(23, 22, 0)},
+		{"weekend", utc(16, 12, 0), utc(17, 0, 0)},
+		{"weekend", utc(18, 12, 0), utc(19, 0, 0)},
+	}
+	for _, tt := range tests {
+		s := parseTestSchedule(t, tt.schedule)
+		if got := s.Next(tt.at); !got.Equal(tt.want) {
+			t.Errorf("%s.Next(%s) = %s, want %s", tt.schedule, tt.at.Format(time.RFC3339), got.UTC().Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestScheduleString(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     string
+	}{
+		{"business", "mon-fri 09:00-17:00 Europe/Berlin"},
+		{"night", "fri 22:00-06:00 UTC"},
+		{"weekend", "sat,sun 00:00-24:00 UTC"},
+	}
+	for _, tt := range tests {
+		if got := parseTestSchedule(t, tt.schedule).String(); got != tt.want {
+			t.Errorf("%s.String() = %q, want %q", tt.schedule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package posture
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// Device posture statuses
+const (
+	StatusCompliant    = "compliant"
+	StatusNoncompliant = "noncompliant"
+	StatusStale        = "stale"
+	// StatusUnreported is never stored; it describes agents with no report
+	StatusUnreported = "unreported"
+)
+
+// Check names used in DevicePosture.FailedChecks
+const (
+	CheckFirewall       = "firewall"
+	CheckDiskEncryption = "disk_encryption"
+	CheckAntivirus      = "antivirus"
+	CheckScreenLock     = "screen_lock"
+)
+
+// DefaultSettings match the score agents used to compute themselves
+func DefaultSettings() models.PostureSettings {
+	return models.PostureSettings{
+		BaseWeight:           20,
+		FirewallWeight:       25,
+		DiskEncryptionWeight: 25,
+		AntivirusWeight:      20,
+		ScreenLockWeight:     10,
+		StaleAfter:           "5m",
+	}
+}
+
+// LoadSettings returns the posture settings, creating them with defaults
+// on first use
+func LoadSettings() (*models.PostureSettings, error) {
+	s := DefaultSettings()
+	if err := db.DB.Attrs(s).FirstOrCreate(&s, models.PostureSettings{ID: 1}).Error; err != nil {
+		return nil, fmt.Errorf("load posture settings: %w", err)
+	}
+	return &s, nil
+}
+
+// Validate checks settings before they are saved
+func Validate(s *models.PostureSettings) error {
+	weights := []int{s.BaseWeight, s.FirewallWeight, s.DiskEncryptionWeight, s.AntivirusWeight, s.ScreenLockWeight}
+	total := 0
+	for _, w := range weights {
+		if w < 0 || w > 100 {
+			return errors.New("weights must be between 0 and 100")
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.New("at least one weight must be positive")
+	}
+	if d, err := time.ParseDuration(s.StaleAfter); err != nil || d < time.Minute {
+		return errors.New("stale_after must be a duration of at least 1m")
+	}
+	return nil
+}
+
+// StaleAfter returns how long a posture report stays current
+func StaleAfter(s *models.PostureSettings) time.Duration {
+	d, err := time.ParseDuration(s.StaleAfter)
+	if err != nil || d <= 0 {
+		d, _ = time.ParseDuration(DefaultSettings().StaleAfter)
+	}
+	return d
+}
+
+// Assess computes the score, status and failed checks of p from the facts
+// it holds
+func Assess(p *models.DevicePosture, s *models.PostureSettings, now time.Time) {
+	checks := []struct {
+		name     string
+		passed   bool
+		weight   int
+		required bool
+	}{
+		{CheckFirewall, p.FirewallEnabled, s.FirewallWeight, s.RequireFirewall},
+		{CheckDiskEncryption, p.DiskEncrypted, s.DiskEncryptionWeight, s.RequireDiskEncryption},
+		{CheckAntivirus, p.AntivirusEnabled, s.AntivirusWeight, s.RequireAntivirus},
+		{CheckScreenLock, p.ScreenLockEnabled, s.ScreenLockWeight, s.RequireScreenLock},
+	}
+
+	earned, total := s.BaseWeight, s.BaseWeight
+	var failed []string
+	for _, c := range checks {
+		total += c.weight
+		if c.passed {
+			earned += c.weight
+		} else if c.required {
+			failed = append(failed, c.name)
+		}
+	}
+
+	p.PostureScore = 0
+	if total > 0 {
+		p.PostureScore = (earned*100 + total/2) / total
+	}
+	p.FailedChecks = strings.Join(failed, ",")
+
+	switch {
+	case p.LastChecked == nil || now.Sub(*p.LastChecked) > StaleAfter(s):
+		p.Status = StatusStale
+	case len(failed) > 0:
+		p.Status = StatusNoncompliant
+	default:
+		p.Status = StatusCompliant
+	}
+}
+
+// Change records a device whose posture status changed on reassessment
+type Change struct {
+	AgentID uint
+	From    string
+	Posture models.DevicePosture
+}
+
+// Reassess recomputes every stored posture under s, saving the ones that
+// changed. It returns the devices whose status changed and whether any
+// score changed.
+func Reassess(s *models.PostureSettings, now time.Time) ([]Change, bool, error) {
+	var postures []models.DevicePosture
+	if err := db.DB.Find(&postures).Error; err != nil {
+		return nil, false, fmt.Errorf("load postures: %w", err)
+	}
+
+	var changes []Change
+	updated := false
+	for _, p := range postures {
+		before := p
+		Assess(&p, s, now)
+		if p.PostureScore == before.PostureScore && p.Status == before.Status && p.FailedChecks == before.FailedChecks {
+			continue
+		}
+		if err := db.DB.Model(&p).Updates(map[string]interface{}{
+			"posture_score": p.PostureScore,
+			"status":        p.Status,
+			"failed_checks": p.FailedChecks,
+		}).Error; err != nil {
+			return changes, updated, fmt.Errorf("save posture of agent %d: %w", p.AgentID, err)
+		}
+		updated = true
+		if p.Status != before.Status {
+			changes = append(changes, Change{AgentID: p.AgentID, From: before.Status, Posture: p})
+		}
+	}
+	return changes, updated, nil
+}
+
+// EffectiveScore is the score policies are checked against. Devices that
+// are not compliant get no credit.
+func EffectiveScore(p *models.DevicePosture) int {
+	if p.Status != StatusCompliant {
+		return 0
+	}
+	return p.PostureScore
+}
@@ -0,0 +1,242 @@
+package posture
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// Check returns why a device fails a profile, or nothing if it complies.
+// report is nil for devices that never reported their posture.
+func Check(report *models.DevicePosture, profile *models.PostureProfile) []string {
+	if report == nil {
+		return []string{"no posture report received yet"}
+	}
+	if report.Status == StatusStale {
+		return []string{"posture report is stale, the agent has not checked in recently"}
+	}
+
+	var failures []string
+	if profile.RequireFirewall && !report.FirewallEnabled {
+		failures = append(failures, "firewall is disabled")
+	}
+	if profile.RequireDiskEncryption && !report.DiskEncrypted {
+		failures = append(failures, "disk is not encrypted")
+	}
+	if profile.RequireAntivirus && !report.AntivirusEnabled {
+		failures = append(failures, "antivirus is not running")
+	}
+	if profile.RequireScreenLock && !report.ScreenLockEnabled {
+		failures = append(failures, "screen lock is disabled")
+	}
+
+	if want := profile.MinOSVersions[strings.ToLower(report.OSName)]; want != "" && !versionAtLeast(report.OSVersion, want) {
+		failures = append(failures, fmt.Sprintf("%s version %q is older than the required %s", report.OSName, report.OSVersion, want))
+	}
+	if limit := profile.MaxPendingPatches; limit != nil {
+		switch {
+		case report.PendingPatches < 0:
+			failures = append(failures, "pending patches are not reported by this agent")
+		case report.PendingPatches > *limit:
+			failures = append(failures, fmt.Sprintf("%d patches are pending, at most %d allowed", report.PendingPatches, *limit))
+		}
+	}
+	if want := profile.MinAgentVersion; want != "" && !versionAtLeast(report.AgentVersion, want) {
+		version := report.AgentVersion
+		if version == "" {
+			version = "unknown"
+		}
+		failures = append(failures, fmt.Sprintf("agent version %s is older than the required %s", version, want))
+	}
+	return failures
+}
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// ValidVersion reports whether s contains a dotted version number
+func ValidVersion(s string) bool {
+	return versionPattern.MatchString(s)
+}
+
+// versionAtLeast compares the first dotted version number found in each
+// string, so "6.8.0-45-generic" and "Microsoft Windows [Version 10.0.22631]"
+// work. Versions that cannot be parsed never satisfy a minimum.
+func versionAtLeast(version, minimum string) bool {
+	have, want := parseVersion(version), parseVersion(minimum)
+	if have == nil || want == nil {
+		return false
+	}
+	for i := 0; i < max(len(have), len(want)); i++ {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+func parseVersion(s string) []int {
+	match := versionPattern.FindString(s)
+	if match == "" {
+		return nil
+	}
+	var parts []int
+	for _, p := range strings.Split(match, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// EvaluateAll checks every agent against the profiles that apply to it and
+// stores the results. It reports whether any result changed.
+func EvaluateAll() (bool, error) {
+	var agents []models.Agent
+	if err := db.DB.Select("id", "group_id").Find(&agents).Error; err != nil {
+		return false, fmt.Errorf("load agents: %w", err)
+	}
+	return evaluate(agents, nil)
+}
+
+// EvaluateAgent checks a single agent against the profiles that apply to it
+// and stores the results. It reports whether any result changed.
+func EvaluateAgent(agentID uint) (bool, error) {
+	var agents []models.Agent
+	if err := db.DB.Select("id", "group_id").Where("id = ?", agentID).Find(&agents).Error; err != nil {
+		return false, fmt.Errorf("load agent: %w", err)
+	}
+	return evaluate(agents, []uint{agentID})
+}
+
+// profilesByGroup returns, for each group, the profiles its members have to
+// meet: the group's own and those of enabled policies it is the source of
+func profilesByGroup() (map[uint][]uint, error) {
+	var groups []models.Group
+	if err := db.DB.Select("id", "posture_profile_id").Where("posture_profile_id IS NOT NULL").Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("load groups: %w", err)
+	}
+	var policies []models.Policy
+	if err := db.DB.Select("source_group_id", "posture_profile_id").
+		Where("posture_profile_id IS NOT NULL AND enabled = ?", true).Find(&policies).Error; err != nil {
+		return nil, fmt.Errorf("load policies: %w", err)
+	}
+
+	byGroup := make(map[uint][]uint)
+	add := func(group, profile uint) {
+		if !slices.Contains(byGroup[group], profile) {
+			byGroup[group] = append(byGroup[group], profile)
+		}
+	}
+	for _, g := range groups {
+		add(g.ID, *g.PostureProfileID)
+	}
+	for _, p := range policies {
+		add(p.SourceGroupID, *p.PostureProfileID)
+	}
+	return byGroup, nil
+}
+
+// evaluate refreshes the stored results of agents. scope limits the loaded
+// postures and results to those agents; nil loads all of them.
+func evaluate(agents []models.Agent, scope []uint) (bool, error) {
+	byGroup, err := profilesByGroup()
+	if err != nil {
+		return false, err
+	}
+
+	var profileList []models.PostureProfile
+	if err := db.DB.Find(&profileList).Error; err != nil {
+		return false, fmt.Errorf("load profiles: %w", err)
+	}
+	profiles := make(map[uint]*models.PostureProfile, len(profileList))
+	for i := range profileList {
+		profiles[profileList[i].ID] = &profileList[i]
+	}
+
+	reportQuery := db.DB.Model(&models.DevicePosture{})
+	resultQuery := db.DB.Model(&models.PostureCompliance{})
+	if scope != nil {
+		reportQuery = reportQuery.Where("agent_id IN ?", scope)
+		resultQuery = resultQuery.Where("agent_id IN ?", scope)
+	}
+	var reportList []models.DevicePosture
+	if err := reportQuery.Find(&reportList).Error; err != nil {
+		return false, fmt.Errorf("load postures: %w", err)
+	}
+	reports := make(map[uint]*models.DevicePosture, len(reportList))
+	for i := range reportList {
+		reports[reportList[i].AgentID] = &reportList[i]
+	}
+	var existing []models.PostureCompliance
+	if err := resultQuery.Find(&existing).Error; err != nil {
+		return false, fmt.Errorf("load compliance results: %w", err)
+	}
+	type key struct{ agent, profile uint }
+	stored := make(map[key]models.PostureCompliance, len(existing))
+	for _, r := range existing {
+		stored[key{r.AgentID, r.ProfileID}] = r
+	}
+
+	now := time.Now()
+	changed := false
+	for _, agent := range agents {
+		if agent.GroupID == nil {
+			continue
+		}
+		for _, profileID := range byGroup[*agent.GroupID] {
+			profile, ok := profiles[profileID]
+			if !ok {
+				continue
+			}
+			failures := Check(reports[agent.ID], profile)
+			if failures == nil {
+				failures = []string{}
+			}
+
+			k := key{agent.ID, profileID}
+			old, found := stored[k]
+			delete(stored, k)
+			if found && slices.Equal(old.Failures, failures) {
+				continue
+			}
+			result := models.PostureCompliance{
+				AgentID:   agent.ID,
+				ProfileID: profileID,
+				Compliant: len(failures) == 0,
+				Failures:  failures,
+				CheckedAt: now,
+			}
+			if found {
+				result.ID = old.ID
+			}
+			if err := db.DB.Save(&result).Error; err != nil {
+				return changed, fmt.Errorf("save compliance of agent %d: %w", agent.ID, err)
+			}
+			changed = true
+		}
+	}
+
+	// Whatever is left no longer applies
+	for _, r := range stored {
+		if err := db.DB.Delete(&r).Error; err != nil {
+			return changed, fmt.Errorf("delete compliance result %d: %w", r.ID, err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
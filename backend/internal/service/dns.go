@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/dns"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// OverlayDNS answers overlay names; nil until StartOverlayDNS runs
+var OverlayDNS *dns.Server
+
+// StartOverlayDNS serves DNS over UDP and TCP on port 53 of the hub's
+// overlay address. Its records follow ReloadPolicies.
+func StartOverlayDNS(tnet *netstack.Net, addr netip.Addr, domain string, upstream []string) error {
+	server := dns.NewServer(domain, upstream, overlayDialer(tnet))
+	if err := server.Reload(); err != nil {
+		return err
+	}
+
+	listen := netip.AddrPortFrom(addr, 53)
+	udp, err := tnet.ListenUDPAddrPort(listen)
+	if err != nil {
+		return fmt.Errorf("listen on udp %s: %w", listen, err)
+	}
+	tcp, err := tnet.ListenTCPAddrPort(listen)
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("listen on tcp %s: %w", listen, err)
+	}
+	go server.ServeUDP(udp)
+	go server.ServeTCP(tcp)
+
+	OverlayDNS = server
+	log.Printf("Overlay DNS serving *.%s on %s", server.Domain(), listen)
+	return nil
+}
+
+// OverlayDomain returns the domain overlay names live under, or "" while
+// overlay DNS is not running
+func OverlayDomain() string {
+	if OverlayDNS == nil {
+		return ""
+	}
+	return OverlayDNS.Domain()
+}
+
+// ReloadDNS refreshes the overlay DNS records and forwarders
+func ReloadDNS() {
+	if OverlayDNS == nil {
+		return
+	}
+	if err := OverlayDNS.Reload(); err != nil {
+		log.Printf("Failed to reload DNS records: %v", err)
+	}
+}
+
+// overlayDialer reaches resolvers on agents and approved subnets, such as a
+// split DNS forwarder behind a subnet router, through the hub's netstack.
+// Everything else, including the upstream resolvers, is dialed from the
+// host.
+func overlayDialer(tnet *netstack.Net) dns.DialFunc {
+	var direct net.Dialer
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		addr, err := netip.ParseAddrPort(address)
+		if err == nil && PolicyEngine != nil && PolicyEngine.Routed(addr.Addr()) {
+			return tnet.DialContext(ctx, network, address)
+		}
+		return direct.DialContext(ctx, network, address)
+	}
+}
+
+// DNSSettingsFor returns the DNS settings an agent gets when connecting:
+// the search domains, starting with the overlay domain, and the split
+// domains the hub forwards to other resolvers for it
+func DNSSettingsFor(agent *models.Agent, overlayDomain string) (search []string, split []string, err error) {
+	search = []string{overlayDomain}
+	var forwarders []models.DNSForwarder
+	query := db.DB.Where("group_id IS NULL")
+	if agent.GroupID != nil {
+		var group models.Group
+		if err := db.DB.First(&group, *agent.GroupID).Error; err != nil {
+			return nil, nil, err
+		}
+		search = append(search, group.DNSSearchDomains...)
+		query = db.DB.Where("group_id IS NULL OR group_id = ?", *agent.GroupID)
+	}
+	if err := query.Order("domain ASC").Find(&forwarders).Error; err != nil {
+		return nil, nil, err
+	}
+	split = []string{}
+	for _, f := range forwarders {
+		if !slices.Contains(split, f.Domain) {
+			split = append(split, f.Domain)
+		}
+	}
+	return search, split, nil
+}
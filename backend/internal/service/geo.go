@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/geoip"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// RecordAgentLocation stores the public address an agent connected from and
+// the country it resolves to, updating agent in place. It reports whether
+// the country changed, in which case the caller must reload policies so
+// region restrictions follow the agent.
+func RecordAgentLocation(agent *models.Agent, publicIP string) bool {
+	country := geoip.Lookup(publicIP)
+	if agent.PublicIP == publicIP && agent.Country == country {
+		return false
+	}
+
+	previous := agent.Country
+	if err := db.DB.Model(agent).Updates(map[string]interface{}{
+		"public_ip": publicIP,
+		"country":   country,
+	}).Error; err != nil {
+		log.Printf("Failed to store location of agent %d: %v", agent.ID, err)
+		return false
+	}
+	agent.PublicIP = publicIP
+	agent.Country = country
+	if previous == country {
+		return false
+	}
+
+	log.Printf("Agent %d now connects from %s (country %q, was %q)", agent.ID, publicIP, country, previous)
+	details, _ := json.Marshal(map[string]interface{}{
+		"public_ip": publicIP,
+		"from":      previous,
+		"to":        country,
+	})
+	db.DB.Create(&models.AuditLog{
+		AgentID:   &agent.ID,
+		Action:    "agent_country_changed",
+		Details:   string(details),
+		IPAddress: publicIP,
+	})
+	return true
+}
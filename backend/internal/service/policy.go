@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/policy"
+	"github.com/cubetiq/zero-zta/backend/internal/posture"
+)
+
+var PolicyEngine *policy.Engine
+
+func SetPolicyEngine(engine *policy.Engine) {
+	PolicyEngine = engine
+	ReloadPolicies()
+	log.Println("Policy engine initialized globally")
+}
+
+// ReloadPolicies refreshes the enforcement snapshot after agents, groups or
+// policies change. Posture profile results are brought up to date first
+// since group and policy changes decide which profiles apply to an agent.
+// The overlay DNS records are derived from the same tables and refreshed
+// along with it.
+func ReloadPolicies() {
+	ReloadDNS()
+	if PolicyEngine == nil {
+		return
+	}
+	if _, err := posture.EvaluateAll(); err != nil {
+		log.Printf("Failed to evaluate posture profiles: %v", err)
+	}
+	if err := PolicyEngine.Reload(); err != nil {
+		log.Printf("Failed to reload policies: %v", err)
+	}
+}
+
+// StartPolicyReloader periodically refreshes the policy snapshot as a safety net
+// for changes made outside the API (e.g. direct database edits)
+func StartPolicyReloader() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ReloadPolicies()
+	}
+}
+
+// StartPolicyExpiry disables policies whose validity window has ended, so
+// expired grants disappear from the dashboard instead of lingering as
+// enabled policies that never match
+func StartPolicyExpiry() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if err := ExpirePolicies(time.Now()); err != nil {
+			log.Printf("Policy expiry failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// ExpirePolicies disables the enabled policies whose ValidUntil is at or
+// before now and records each in the audit log
+func ExpirePolicies(now time.Time) error {
+	// Compared here rather than in SQL since SQLite compares the stored
+	// timestamps as text, which breaks across time zone offsets
+	var timed []models.Policy
+	if err := db.DB.Where("enabled = ? AND valid_until IS NOT NULL", true).Find(&timed).Error; err != nil {
+		return fmt.Errorf("load timed policies: %w", err)
+	}
+
+	disabled := 0
+	for _, p := range timed {
+		if p.ValidUntil.After(now) {
+			continue
+		}
+		result := db.DB.Model(&models.Policy{}).Where("id = ? AND enabled = ?", p.ID, true).Update("enabled", false)
+		if result.Error != nil {
+			return fmt.Errorf("disable policy %d: %w", p.ID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue // disabled meanwhile
+		}
+		disabled++
+
+		log.Printf("Policy %q expired at %s and was disabled", p.Name, p.ValidUntil.Format(time.RFC3339))
+		details, _ := json.Marshal(map[string]interface{}{
+			"policy_id":   p.ID,
+			"name":        p.Name,
+			"valid_until": p.ValidUntil,
+		})
+		db.DB.Create(&models.AuditLog{
+			Action:  "policy_expired",
+			Details: string(details),
+		})
+	}
+	if disabled > 0 {
+		ReloadPolicies()
+	}
+	return nil
+}
+
+// CheckPolicyPorts logs the policies whose stored AllowedPorts no longer
+// parse, e.g. after the syntax changed or a direct database edit. Such
+// policies match no traffic until they are saved with a valid value.
+func CheckPolicyPorts() error {
+	var policies []models.Policy
+	if err := db.DB.Select("id", "name", "allowed_ports", "enabled").Find(&policies).Error; err != nil {
+		return fmt.Errorf("load policies: %w", err)
+	}
+	for _, p := range policies {
+		if _, err := policy.ParsePorts(p.AllowedPorts); err != nil {
+			log.Printf("Policy %d (%q, enabled=%t) has invalid allowed ports %q and matches no traffic: %v",
+				p.ID, p.Name, p.Enabled, p.AllowedPorts, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"github.com/cubetiq/zero-zta/backend/internal/posture"
+)
+
+// StartPostureMonitor periodically marks devices whose posture reports
+// stopped as stale so they lose posture-gated access
+func StartPostureMonitor() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := ReassessPostures(); err != nil {
+			log.Printf("Posture check failed: %v", err)
+		}
+	}
+}
+
+// ReassessPostures recomputes every device's posture under the current
+// settings, records status changes in the audit log and refreshes the
+// policy snapshot if anything changed
+func ReassessPostures() error {
+	settings, err := posture.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	changes, updated, err := posture.Reassess(settings, time.Now())
+	for _, ch := range changes {
+		p := ch.Posture
+		log.Printf("Posture of agent %d changed from %s to %s", ch.AgentID, ch.From, p.Status)
+
+		details, _ := json.Marshal(map[string]interface{}{
+			"from":          ch.From,
+			"to":            p.Status,
+			"posture_score": p.PostureScore,
+			"failed_checks": p.FailedChecks,
+			"quarantined":   settings.Quarantine && p.Status != posture.StatusCompliant,
+		})
+		db.DB.Create(&models.AuditLog{
+			AgentID: &ch.AgentID,
+			Action:  "posture_status_changed",
+			Details: string(details),
+		})
+	}
+	if updated {
+		ReloadPolicies()
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+)
+
+// AdvertiseRoutes stores the subnets an agent offers to route, updating agent
+// in place. Approvals of subnets no longer advertised are withdrawn; new
+// subnets wait for an admin to approve them. It reports whether the approved
+// routes changed, in which case the caller must sync peers and reload
+// policies.
+func AdvertiseRoutes(agent *models.Agent, prefixes []netip.Prefix) (approvedChanged bool, err error) {
+	var approved []netip.Prefix
+	for _, p := range ipam.ParseRoutes(agent.ApprovedRoutes) {
+		if slices.Contains(prefixes, p) {
+			approved = append(approved, p)
+		}
+	}
+
+	routes := ipam.EncodeRoutes(prefixes)
+	approvedRoutes := ipam.EncodeRoutes(approved)
+	if routes == agent.Routes && approvedRoutes == agent.ApprovedRoutes {
+		return false, nil
+	}
+
+	approvedChanged = approvedRoutes != agent.ApprovedRoutes
+	if err := db.DB.Model(agent).Updates(map[string]interface{}{
+		"routes":          routes,
+		"approved_routes": approvedRoutes,
+	}).Error; err != nil {
+		return false, err
+	}
+	return approvedChanged, nil
+}
+
+// SetExitNodeOptions records whether an agent offers to be the exit node and
+// whether it uses the exit node, updating agent in place. Nil options are
+// left as they are. An exit node that stops offering loses its approval. It
+// reports whether anything changed, in which case the caller must sync peers
+// and reload policies.
+func SetExitNodeOptions(agent *models.Agent, advertise, use *bool) (bool, error) {
+	updates := make(map[string]interface{})
+	if advertise != nil && *advertise != agent.AdvertiseExitNode {
+		updates["advertise_exit_node"] = *advertise
+		if !*advertise && agent.ExitNode {
+			updates["exit_node"] = false
+		}
+	}
+	if use != nil && *use != agent.UseExitNode {
+		updates["use_exit_node"] = *use
+	}
+	if len(updates) == 0 {
+		return false, nil
+	}
+	if err := db.DB.Model(agent).Updates(updates).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RoutesFor lists the approved subnets an agent may reach through the hub,
+// plus the default route if it may send internet-bound traffic through the
+// exit node
+func RoutesFor(agent *models.Agent) []string {
+	if PolicyEngine == nil {
+		return []string{}
+	}
+	routes := ipam.RouteStrings(PolicyEngine.RoutesFor(agent))
+	if _, ok := PolicyEngine.ExitNodeFor(agent); ok {
+		routes = append(routes, "0.0.0.0/0")
+	}
+	return routes
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cubetiq/zero-zta/backend/internal/db"
+	"github.com/cubetiq/zero-zta/backend/internal/ipam"
+	"github.com/cubetiq/zero-zta/backend/internal/models"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+var (
+	wgDevice *device.Device
+	wgMu     sync.Mutex
+)
+
+// SetWireGuardDevice registers the hub's WireGuard device and loads its peer
+// table from the database
+func SetWireGuardDevice(dev *device.Device) {
+	wgMu.Lock()
+	wgDevice = dev
+	wgMu.Unlock()
+
+	if err := SyncPeers(); err != nil {
+		log.Printf("Initial peer sync failed: %v", err)
+	}
+}
+
+// SyncPeers reconciles the WireGuard peer table with the database: every
+// enabled agent with a public key and overlay IP becomes a peer, and peers
+// for deleted, disabled or re-keyed agents are removed. Subnet routers are
+// also allowed their approved routes, so traffic for those subnets is sent
+// to them and their replies are accepted. The exit node is allowed the
+// default route, so it receives everything no other peer claims.
+func SyncPeers() error {
+	wgMu.Lock()
+	defer wgMu.Unlock()
+
+	if wgDevice == nil {
+		return nil
+	}
+
+	var agents []models.Agent
+	if err := db.DB.Where("disabled = ? AND public_key <> '' AND ip <> ''", false).Find(&agents).Error; err != nil {
+		return fmt.Errorf("load agents: %w", err)
+	}
+
+	desired := make(map[string][]string, len(agents)) // hex public key -> allowed IPs
+	hasExit := false
+	for _, agent := range agents {
+		pub, err := keyToHex(agent.PublicKey)
+		if err != nil {
+			log.Printf("Skipping agent %d with invalid public key: %v", agent.ID, err)
+			continue
+		}
+		addr, err := netip.ParseAddr(agent.IP)
+		if err != nil {
+			log.Printf("Skipping agent %d with invalid IP %q", agent.ID, agent.IP)
+			continue
+		}
+		desired[pub] = append([]string{netip.PrefixFrom(addr, addr.BitLen()).String()},
+			ipam.RouteStrings(ipam.ParseRoutes(agent.ApprovedRoutes))...)
+		// Like the policy engine, the first exit node wins should there be more
+		if agent.ExitNode && !hasExit {
+			desired[pub] = append(desired[pub], "0.0.0.0/0")
+			hasExit = true
+		}
+	}
+
+	current, err := currentPeers(wgDevice)
+	if err != nil {
+		return err
+	}
+
+	var conf strings.Builder
+	removed := 0
+	for pub := range current {
+		if _, ok := desired[pub]; !ok {
+			fmt.Fprintf(&conf, "public_key=%s\nremove=true\n", pub)
+			removed++
+		}
+	}
+	for pub, allowed := range desired {
+		fmt.Fprintf(&conf, "public_key=%s\nreplace_allowed_ips=true\n", pub)
+		for _, ip := range allowed {
+			fmt.Fprintf(&conf, "allowed_ip=%s\n", ip)
+		}
+	}
+
+	if conf.Len() == 0 {
+		return nil
+	}
+	if err := wgDevice.IpcSet(conf.String()); err != nil {
+		return fmt.Errorf("apply peers: %w", err)
+	}
+
+	if removed > 0 || len(desired) != len(current) {
+		log.Printf("WireGuard peers synced: %d active, %d removed", len(desired), removed)
+	}
+	return nil
+}
+
+// RequestPeerSync reconciles peers and logs failures; used by API handlers
+func RequestPeerSync() {
+	if err := SyncPeers(); err != nil {
+		log.Printf("Failed to sync WireGuard peers: %v", err)
+	}
+}
+
+// StartPeerReconciler periodically re-syncs peers as a safety net for
+// changes made outside the API
+func StartPeerReconciler() {
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		RequestPeerSync()
+	}
+}
+
+// currentPeers lists the hex public keys of peers configured on the device
+func currentPeers(dev *device.Device) (map[string]struct{}, error) {
+	state, err := dev.IpcGet()
+	if err != nil {
+		return nil, fmt.Errorf("read device state: %w", err)
+	}
+
+	peers := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(state))
+	for scanner.Scan() {
+		if key, ok := strings.CutPrefix(scanner.Text(), "public_key="); ok {
+			peers[key] = struct{}{}
+		}
+	}
+	return peers, scanner.Err()
+}
+
+func keyToHex(b64 string) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", err
+	}
+	if len(k) != 32 {
+		return "", fmt.Errorf("expected 32 byte key, got %d", len(k))
+	}
+	return hex.EncodeToString(k), nil
+}
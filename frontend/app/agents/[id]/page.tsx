@@ -32,18 +32,24 @@ import {
     deleteService,
     regenerateAgentKey,
     updateAgentRoutes,
+    approveAgentRoutes,
+    approveExitNode,
     updateAgent,
     getGroups,
     getAgentMetrics,
     getAgentAccessLogs,
     getPolicies,
+    getAgentPosture,
+    getToken,
     Agent,
     Service,
     AuditLog,
     Group,
     AgentMetrics,
     AccessLog,
-    Policy
+    Policy,
+    DevicePosture,
+    PostureCompliance
 } from "@/lib/api";
 import {
     ArrowLeft,
@@ -85,6 +91,8 @@ export default function AgentDetailPage() {
     const [accessLogs, setAccessLogs] = useState<AccessLog[]>([]);
     const [metrics, setMetrics] = useState<AgentMetrics[]>([]);
     const [policies, setPolicies] = useState<Policy[]>([]);
+    const [posture, setPosture] = useState<DevicePosture | null>(null);
+    const [compliance, setCompliance] = useState<PostureCompliance[]>([]);
     const [loading, setLoading] = useState(true);
 
     const [activeTab, setActiveTab] = useState("overview");
@@ -97,8 +105,9 @@ export default function AgentDetailPage() {
     const [showKey, setShowKey] = useState(false);
 
     // Form states
-    const [newService, setNewService] = useState({ name: "", port: "", protocol: "tcp", description: "" });
+    const [newService, setNewService] = useState({ name: "", port: "", protocol: "tcp", local_addr: "", description: "" });
     const [routes, setRoutes] = useState<string[]>([]);
+    const [approvedRoutes, setApprovedRoutes] = useState<string[]>([]);
     const [newRoute, setNewRoute] = useState("");
     const [editForm, setEditForm] = useState({ name: "", description: "", group_id: "0" });
 
@@ -120,19 +129,20 @@ export default function AgentDetailPage() {
             setAccessLogs(accessLogsData);
             setPolicies(policiesData);
 
+            getAgentPosture(agentId)
+                .then(data => {
+                    setPosture(data.posture);
+                    setCompliance(data.compliance);
+                })
+                .catch(() => setPosture(null));
+
             setEditForm({
                 name: agentData.name,
                 description: agentData.description || "",
                 group_id: agentData.group_id ? String(agentData.group_id) : "0"
             });
 
-            if (agentData.routes) {
-                try {
-                    setRoutes(JSON.parse(agentData.routes));
-                } catch {
-                    setRoutes([]);
-                }
-            }
+            applyRoutes(agentData);
         } catch (error) {
             console.error("Failed to fetch agent:", error);
             toast.error("Failed to load agent details");
@@ -167,14 +177,15 @@ export default function AgentDetailPage() {
                 name: newService.name,
                 port: parseInt(newService.port),
                 protocol: newService.protocol,
+                local_addr: newService.local_addr || undefined,
                 description: newService.description,
             });
             toast.success("Service added");
-            setNewService({ name: "", port: "", protocol: "tcp", description: "" });
+            setNewService({ name: "", port: "", protocol: "tcp", local_addr: "", description: "" });
             setServiceDialogOpen(false);
             fetchData();
         } catch (error) {
-            toast.error("Failed to create service");
+            toast.error(error instanceof Error ? error.message : "Failed to create service");
         }
     };
 
@@ -202,30 +213,63 @@ export default function AgentDetailPage() {
         }
     };
 
+    const parseRouteList = (value?: string): string[] => {
+        if (!value) return [];
+        try {
+            return JSON.parse(value);
+        } catch {
+            return [];
+        }
+    };
+
+    const applyRoutes = (data: Agent) => {
+        setRoutes(parseRouteList(data.routes));
+        setApprovedRoutes(parseRouteList(data.approved_routes));
+    };
+
     const handleAddRoute = async () => {
-        if (!newRoute.match(/^\d+\.\d+\.\d+\.\d+\/\d+$/)) {
+        if (!newRoute.match(/^[0-9a-fA-F:.]+\/\d+$/)) {
             toast.error("Invalid CIDR format");
             return;
         }
-        const updatedRoutes = [...routes, newRoute];
         try {
-            await updateAgentRoutes(agentId, updatedRoutes);
-            setRoutes(updatedRoutes);
+            applyRoutes(await updateAgentRoutes(agentId, [...routes, newRoute]));
             setNewRoute("");
-            toast.success("Route added");
+            toast.success("Route added, pending approval");
         } catch (error) {
-            toast.error("Failed to add route");
+            toast.error(error instanceof Error ? error.message : "Failed to add route");
         }
     };
 
     const handleRemoveRoute = async (route: string) => {
-        const updatedRoutes = routes.filter(r => r !== route);
         try {
-            await updateAgentRoutes(agentId, updatedRoutes);
-            setRoutes(updatedRoutes);
+            applyRoutes(await updateAgentRoutes(agentId, routes.filter(r => r !== route)));
             toast.success("Route removed");
         } catch (error) {
-            toast.error("Failed to remove route");
+            toast.error(error instanceof Error ? error.message : "Failed to remove route");
+        }
+    };
+
+    const handleToggleApproval = async (route: string) => {
+        const approved = approvedRoutes.includes(route);
+        const updated = approved ? approvedRoutes.filter(r => r !== route) : [...approvedRoutes, route];
+        try {
+            applyRoutes(await approveAgentRoutes(agentId, updated));
+            toast.success(approved ? "Route approval revoked" : "Route approved");
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to update route approval");
+        }
+    };
+
+    const handleToggleExitNode = async () => {
+        if (!agent) return;
+        const enable = !agent.exit_node;
+        try {
+            const updated = await approveExitNode(agentId, enable);
+            setAgent({ ...agent, ...updated });
+            toast.success(enable ? "Agent is now the exit node" : "Exit node approval revoked");
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to update exit node");
         }
     };
 
@@ -257,6 +301,11 @@ export default function AgentDetailPage() {
             header: "Port/Protocol",
             cell: ({ row }) => <Badge variant="outline" className="font-mono">{row.getValue("port")}/{row.original.protocol}</Badge>
         },
+        {
+            accessorKey: "local_addr",
+            header: "Forwards To",
+            cell: ({ row }) => <span className="font-mono text-xs text-muted-foreground">{row.original.local_addr || `127.0.0.1:${row.original.port}`}</span>
+        },
         {
             id: "test",
             header: "Test Access",
@@ -265,7 +314,7 @@ export default function AgentDetailPage() {
                     variant="outline"
                     size="sm"
                     className="h-8 gap-2"
-                    onClick={() => window.open(`${process.env.NEXT_PUBLIC_API_URL || 'http://localhost:3000'}/api/v1/debug/proxy?ip=${agent?.ip}&port=${row.getValue("port")}`, '_blank')}
+                    onClick={() => window.open(`${process.env.NEXT_PUBLIC_API_URL || 'http://localhost:3000'}/api/v1/debug/proxy?ip=${agent?.ip}&port=${row.getValue("port")}&access_token=${encodeURIComponent(getToken() ?? "")}`, '_blank')}
                 >
                     <Globe className="h-3.5 w-3.5" /> Proxy Open
                 </Button>
@@ -359,6 +408,14 @@ export default function AgentDetailPage() {
                             <span>•</span>
                             <span className="font-mono">{agent.ip}</span>
                             <span>•</span>
+                            {agent.public_ip && (
+                                <>
+                                    <span title="Public address and the country it resolves to">
+                                        {agent.public_ip} ({agent.country || "unknown country"})
+                                    </span>
+                                    <span>•</span>
+                                </>
+                            )}
                             <span>{agent.version || "v0.0.1"}</span>
                         </div>
                     </div>
@@ -468,6 +525,42 @@ export default function AgentDetailPage() {
                                 </div>
                             </CardContent>
                         </Card>
+
+                        <Card className="md:col-span-3">
+                            <CardHeader>
+                                <CardTitle className="text-lg flex items-center gap-2">
+                                    <Shield className="h-5 w-5 text-primary" /> Device Posture
+                                </CardTitle>
+                                <CardDescription>
+                                    {posture
+                                        ? `Score ${posture.posture_score}/100, last reported ${posture.last_checked ? new Date(posture.last_checked).toLocaleString() : "never"}`
+                                        : "This agent has not reported its posture yet."}
+                                </CardDescription>
+                            </CardHeader>
+                            <CardContent className="space-y-3">
+                                {posture && (
+                                    <Badge variant={posture.status === "compliant" ? "default" : "destructive"}>{posture.status}</Badge>
+                                )}
+                                {compliance.length === 0 && (
+                                    <p className="text-sm text-muted-foreground">No posture profiles apply to this agent.</p>
+                                )}
+                                {compliance.map(result => (
+                                    <div key={result.id} className="text-sm">
+                                        <div className="flex items-center gap-2 font-medium">
+                                            {result.compliant
+                                                ? <CheckCircle2 className="h-4 w-4 text-green-500" />
+                                                : <XCircle className="h-4 w-4 text-destructive" />}
+                                            {result.profile?.name ?? `Profile ${result.profile_id}`}
+                                        </div>
+                                        {result.failures.length > 0 && (
+                                            <ul className="ml-6 mt-1 list-disc text-muted-foreground">
+                                                {result.failures.map((failure, i) => <li key={i}>{failure}</li>)}
+                                            </ul>
+                                        )}
+                                    </div>
+                                ))}
+                            </CardContent>
+                        </Card>
                     </motion.div>
                 )}
 
@@ -485,7 +578,7 @@ export default function AgentDetailPage() {
                                 <DialogContent>
                                     <DialogHeader>
                                         <DialogTitle>Add Service</DialogTitle>
-                                        <DialogDescription>Expose a local port on this agent.</DialogDescription>
+                                        <DialogDescription>Expose a local port on this agent. The agent listens on the port at its overlay address and forwards connections to the local address.</DialogDescription>
                                     </DialogHeader>
                                     <div className="grid gap-4 py-4">
                                         <div className="grid gap-2">
@@ -508,6 +601,11 @@ export default function AgentDetailPage() {
                                                 </Select>
                                             </div>
                                         </div>
+                                        <div className="grid gap-2">
+                                            <Label>Local Address</Label>
+                                            <Input value={newService.local_addr} onChange={e => setNewService({ ...newService, local_addr: e.target.value })} placeholder={`127.0.0.1:${newService.port || "80"}`} className="font-mono" />
+                                            <p className="text-xs text-muted-foreground">Where the agent forwards connections. Defaults to the same port on localhost.</p>
+                                        </div>
                                         <div className="grid gap-2">
                                             <Label>Description</Label>
                                             <Input value={newService.description} onChange={e => setNewService({ ...newService, description: e.target.value })} placeholder="Optional" />
@@ -576,7 +674,9 @@ export default function AgentDetailPage() {
                             <CardHeader className="flex flex-row items-center justify-between">
                                 <div>
                                     <CardTitle>Advertised Routes</CardTitle>
-                                    <CardDescription>Subnets directed to this agent.</CardDescription>
+                                    <CardDescription>
+                                        Subnets this agent forwards for the overlay. Agents advertise them with <code>-advertise-routes</code>; each one carries traffic once approved.
+                                    </CardDescription>
                                 </div>
                             </CardHeader>
                             <CardContent className="space-y-4">
@@ -590,14 +690,51 @@ export default function AgentDetailPage() {
                                             <div className="flex items-center gap-3">
                                                 <Network className="h-4 w-4 text-blue-500" />
                                                 <span className="font-mono text-sm">{r}</span>
+                                                {approvedRoutes.includes(r) ? (
+                                                    <Badge className="bg-green-500/15 text-green-600 border-green-500/30" variant="outline">Approved</Badge>
+                                                ) : (
+                                                    <Badge variant="outline" className="text-muted-foreground">Pending approval</Badge>
+                                                )}
+                                            </div>
+                                            <div className="flex items-center gap-1">
+                                                <Button variant="outline" size="sm" onClick={() => handleToggleApproval(r)}>
+                                                    {approvedRoutes.includes(r) ? "Revoke" : "Approve"}
+                                                </Button>
+                                                <Button variant="ghost" size="icon" onClick={() => handleRemoveRoute(r)}><Trash2 className="h-4 w-4 text-destructive" /></Button>
                                             </div>
-                                            <Button variant="ghost" size="icon" onClick={() => handleRemoveRoute(r)}><Trash2 className="h-4 w-4 text-destructive" /></Button>
                                         </div>
                                     ))}
                                     {routes.length === 0 && <p className="text-sm text-muted-foreground py-4">No advertised routes.</p>}
                                 </div>
                             </CardContent>
                         </Card>
+                        <Card className="mt-6">
+                            <CardHeader className="flex flex-row items-center justify-between">
+                                <div>
+                                    <CardTitle>Exit Node</CardTitle>
+                                    <CardDescription>
+                                        The exit node forwards internet traffic for agents started with <code>-use-exit-node</code>, if a policy allows their group to reach its group. Agents offer to be one with <code>-advertise-exit-node</code>.
+                                    </CardDescription>
+                                </div>
+                                <Button
+                                    variant={agent.exit_node ? "outline" : "default"}
+                                    disabled={!agent.exit_node && !agent.advertise_exit_node}
+                                    onClick={handleToggleExitNode}
+                                >
+                                    {agent.exit_node ? "Revoke" : "Approve as Exit Node"}
+                                </Button>
+                            </CardHeader>
+                            <CardContent className="flex flex-wrap gap-2">
+                                {agent.exit_node ? (
+                                    <Badge className="bg-green-500/15 text-green-600 border-green-500/30" variant="outline">Exit node</Badge>
+                                ) : agent.advertise_exit_node ? (
+                                    <Badge variant="outline" className="text-muted-foreground">Offered, pending approval</Badge>
+                                ) : (
+                                    <Badge variant="outline" className="text-muted-foreground">Not offered</Badge>
+                                )}
+                                {agent.use_exit_node && <Badge variant="outline">Uses the exit node</Badge>}
+                            </CardContent>
+                        </Card>
                     </motion.div>
                 )}
 
@@ -14,7 +14,8 @@ import {
     Plus,
     Minus,
     Clock,
-    Search
+    Search,
+    Globe
 } from "lucide-react";
 import { toast } from "sonner";
 import Link from "next/link";
@@ -27,6 +28,18 @@ const actionIcons: Record<string, any> = {
     service_added: Plus,
     service_removed: Minus,
     routes_updated: Network,
+    routes_advertised: Network,
+    routes_approved: Network,
+    exit_node_updated: Globe,
+    exit_node_approved: Globe,
+    exit_node_revoked: Globe,
+    dns_record_created: Globe,
+    dns_record_updated: Globe,
+    dns_record_deleted: Globe,
+    dns_forwarder_created: Globe,
+    dns_forwarder_updated: Globe,
+    dns_forwarder_deleted: Globe,
+    group_dns_updated: Globe,
     policy_created: Shield,
     policy_updated: Shield,
     policy_deleted: Shield,
@@ -39,6 +52,18 @@ const actionColors: Record<string, string> = {
     service_added: "bg-blue-500 text-white",
     service_removed: "bg-red-500 text-white",
     routes_updated: "bg-purple-500 text-white",
+    routes_advertised: "bg-purple-500 text-white",
+    routes_approved: "bg-purple-600 text-white",
+    exit_node_updated: "bg-indigo-500 text-white",
+    exit_node_approved: "bg-indigo-600 text-white",
+    exit_node_revoked: "bg-red-500 text-white",
+    dns_record_created: "bg-teal-500 text-white",
+    dns_record_updated: "bg-teal-600 text-white",
+    dns_record_deleted: "bg-red-500 text-white",
+    dns_forwarder_created: "bg-teal-500 text-white",
+    dns_forwarder_updated: "bg-teal-600 text-white",
+    dns_forwarder_deleted: "bg-red-500 text-white",
+    group_dns_updated: "bg-teal-600 text-white",
     policy_created: "bg-green-600 text-white",
     policy_updated: "bg-blue-600 text-white",
     policy_deleted: "bg-red-600 text-white",
@@ -5,7 +5,9 @@ import { useSearchParams, useRouter } from "next/navigation";
 import { Button } from "@/components/ui/button";
 import { Card, CardContent, CardDescription, CardHeader, CardTitle, CardFooter } from "@/components/ui/card";
 import { toast } from "sonner";
-import { getClaimDetails, approveClaim, ClaimDetails } from "@/lib/api";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { getClaimDetails, approveClaim, rejectClaim, getToken, ClaimDetails } from "@/lib/api";
 import { ShieldCheck, Laptop, AlertCircle, CheckCircle2 } from "lucide-react";
 import { Skeleton } from "@/components/ui/skeleton";
 
@@ -17,6 +19,8 @@ function ClaimContent() {
     const [details, setDetails] = useState<ClaimDetails | null>(null);
     const [loading, setLoading] = useState(true);
     const [approving, setApproving] = useState(false);
+    const [rejecting, setRejecting] = useState(false);
+    const [userCode, setUserCode] = useState("");
     const [error, setError] = useState("");
     const [success, setSuccess] = useState(false);
 
@@ -29,8 +33,7 @@ function ClaimContent() {
         }
 
         // Check auth (simple check)
-        const userEmail = localStorage.getItem("user_email");
-        if (!userEmail) {
+        if (!getToken()) {
             // Redirect to login with return URL
             const returnUrl = encodeURIComponent(`/claim?token=${token}`);
             router.push(`/login?returnUrl=${returnUrl}`);
@@ -38,31 +41,53 @@ function ClaimContent() {
         }
 
         getClaimDetails(token)
-            .then(setDetails)
+            .then((claim) => {
+                if (claim.status === "expired") {
+                    setError("This claim request has expired. Restart the agent to get a new link.");
+                } else if (claim.status !== "pending") {
+                    setError(`This claim request was already ${claim.status}.`);
+                } else {
+                    setDetails(claim);
+                }
+            })
             .catch(() => setError("Invalid or expired claim request"))
             .finally(() => setLoading(false));
     }, [token, router]);
 
     const handleApprove = async () => {
         if (!token) return;
-        const userEmail = localStorage.getItem("user_email");
-        if (!userEmail) {
+        if (!getToken()) {
             router.push("/login");
             return;
         }
 
         setApproving(true);
         try {
-            await approveClaim(token, userEmail);
+            await approveClaim(token, userCode);
             setSuccess(true);
             toast.success("Device approved successfully");
         } catch (err) {
-            toast.error("Failed to approve device");
+            toast.error(err instanceof Error ? err.message : "Failed to approve device");
         } finally {
             setApproving(false);
         }
     };
 
+    const handleReject = async () => {
+        if (!token) return;
+
+        setRejecting(true);
+        try {
+            await rejectClaim(token);
+            toast.success("Device request rejected");
+            router.push("/");
+        } catch (err) {
+            toast.error("Failed to reject device");
+        } finally {
+            setRejecting(false);
+        }
+    };
+
     if (loading) {
         return (
             <Card className="w-full max-w-md">
@@ -147,14 +172,32 @@ function ClaimContent() {
                         <span className="capitalize">{details?.status}</span>
                     </div>
                     <div className="pt-2 border-t text-xs text-muted-foreground text-center">
-                        Request ID: {details?.token.substring(0, 8)}...
+                        Request ID: {details?.token.substring(0, 8)}... · Expires{" "}
+                        {details && new Date(details.expires_at).toLocaleTimeString()}
                     </div>
                 </div>
+                <div className="mt-4 space-y-2">
+                    <Label htmlFor="user-code">Code shown on the device</Label>
+                    <Input
+                        id="user-code"
+                        placeholder="XXXX-XXXX"
+                        className="font-mono uppercase tracking-widest"
+                        autoComplete="off"
+                        value={userCode}
+                        onChange={(e) => setUserCode(e.target.value)}
+                    />
+                    <p className="text-xs text-muted-foreground">
+                        Only approve if you started this request yourself. The agent prints this code next to the link.
+                    </p>
+                </div>
             </CardContent>
             <CardFooter className="flex flex-col gap-3">
-                <Button className="w-full" onClick={handleApprove} disabled={approving}>
+                <Button className="w-full" onClick={handleApprove} disabled={approving || rejecting || userCode.trim() === ""}>
                     {approving ? "Approving..." : "Approve Device"}
                 </Button>
+                <Button variant="outline" className="w-full text-destructive" onClick={handleReject} disabled={approving || rejecting}>
+                    {rejecting ? "Rejecting..." : "Reject"}
+                </Button>
                 <Button variant="ghost" className="w-full" onClick={() => router.push("/")}>
                     Cancel
                 </Button>
@@ -0,0 +1,427 @@
+"use client";
+
+import { useEffect, useState } from "react";
+import { Button } from "@/components/ui/button";
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/components/ui/card";
+import { Badge } from "@/components/ui/badge";
+import {
+    Dialog,
+    DialogContent,
+    DialogDescription,
+    DialogFooter,
+    DialogHeader,
+    DialogTitle,
+} from "@/components/ui/dialog";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import {
+    Select,
+    SelectContent,
+    SelectItem,
+    SelectTrigger,
+    SelectValue
+} from "@/components/ui/select";
+import {
+    getDNSRecords,
+    createDNSRecord,
+    deleteDNSRecord,
+    getDNSForwarders,
+    createDNSForwarder,
+    deleteDNSForwarder,
+    getGroups,
+    setGroupDNS,
+    DNSRecord,
+    DNSRecordType,
+    DNSForwarder,
+    Group
+} from "@/lib/api";
+import { Plus, Trash2, Globe, Split, FolderTree, Save } from "lucide-react";
+import { toast } from "sonner";
+
+// Splits a comma or whitespace separated list as typed into an input
+const parseList = (value: string) => value.split(/[\s,]+/).map(s => s.trim()).filter(Boolean);
+
+const emptyRecordForm = {
+    name: "",
+    type: "A" as DNSRecordType,
+    value: "",
+    ttl: 300,
+    priority: 0,
+    weight: 0,
+    port: 0,
+    group_id: 0
+};
+
+const emptyForwarderForm = { domain: "", servers: "", description: "", group_id: 0 };
+
+export default function DNSPage() {
+    const [records, setRecords] = useState<DNSRecord[]>([]);
+    const [forwarders, setForwarders] = useState<DNSForwarder[]>([]);
+    const [groups, setGroups] = useState<Group[]>([]);
+    const [searchDomains, setSearchDomains] = useState<Record<number, string>>({});
+    const [loading, setLoading] = useState(true);
+
+    const [recordDialogOpen, setRecordDialogOpen] = useState(false);
+    const [recordForm, setRecordForm] = useState(emptyRecordForm);
+    const [forwarderDialogOpen, setForwarderDialogOpen] = useState(false);
+    const [forwarderForm, setForwarderForm] = useState(emptyForwarderForm);
+
+    const fetchData = async () => {
+        try {
+            const [recordsData, forwardersData, groupsData] = await Promise.all([
+                getDNSRecords(),
+                getDNSForwarders(),
+                getGroups()
+            ]);
+            setRecords(recordsData);
+            setForwarders(forwardersData);
+            setGroups(groupsData);
+            setSearchDomains(Object.fromEntries(
+                groupsData.map(g => [g.id, (g.dns_search_domains || []).join(", ")])
+            ));
+        } catch (error) {
+            toast.error("Failed to fetch DNS settings");
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    useEffect(() => {
+        fetchData();
+    }, []);
+
+    const groupName = (id?: number | null) =>
+        id ? (groups.find(g => g.id === id)?.name || `Group ${id}`) : "All agents";
+
+    const handleCreateRecord = async () => {
+        try {
+            await createDNSRecord({
+                name: recordForm.name,
+                type: recordForm.type,
+                value: recordForm.value,
+                ttl: recordForm.ttl,
+                priority: recordForm.priority,
+                weight: recordForm.weight,
+                port: recordForm.port,
+                group_id: recordForm.group_id || null
+            });
+            setRecordDialogOpen(false);
+            setRecordForm(emptyRecordForm);
+            toast.success("DNS record created");
+            fetchData();
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to create DNS record");
+        }
+    };
+
+    const handleDeleteRecord = async (record: DNSRecord) => {
+        if (!confirm(`Delete the ${record.type} record for ${record.name}?`)) return;
+
+        try {
+            await deleteDNSRecord(record.id);
+            toast.success("DNS record deleted");
+            fetchData();
+        } catch (error) {
+            toast.error("Failed to delete DNS record");
+        }
+    };
+
+    const handleCreateForwarder = async () => {
+        try {
+            await createDNSForwarder({
+                domain: forwarderForm.domain,
+                servers: parseList(forwarderForm.servers),
+                description: forwarderForm.description,
+                group_id: forwarderForm.group_id || null
+            });
+            setForwarderDialogOpen(false);
+            setForwarderForm(emptyForwarderForm);
+            toast.success("DNS forwarder created");
+            fetchData();
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to create DNS forwarder");
+        }
+    };
+
+    const handleDeleteForwarder = async (forwarder: DNSForwarder) => {
+        if (!confirm(`Delete the forwarder for ${forwarder.domain}? Its names will be resolved upstream again.`)) return;
+
+        try {
+            await deleteDNSForwarder(forwarder.id);
+            toast.success("DNS forwarder deleted");
+            fetchData();
+        } catch (error) {
+            toast.error("Failed to delete DNS forwarder");
+        }
+    };
+
+    const handleSaveSearchDomains = async (group: Group) => {
+        try {
+            const updated = await setGroupDNS(group.id, parseList(searchDomains[group.id] || ""));
+            setSearchDomains({ ...searchDomains, [group.id]: (updated.dns_search_domains || []).join(", ") });
+            toast.success(`Search domains for ${group.name} saved; agents pick them up when they reconnect`);
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to save search domains");
+        }
+    };
+
+    const describeRecord = (record: DNSRecord) =>
+        record.type === "SRV"
+            ? `${record.priority} ${record.weight} ${record.port} ${record.value}`
+            : record.value;
+
+    if (loading) {
+        return <div className="text-muted-foreground">Loading DNS settings...</div>;
+    }
+
+    return (
+        <div className="space-y-8 animate-in fade-in duration-500">
+            <div>
+                <h1 className="text-3xl font-bold tracking-tight">DNS</h1>
+                <p className="text-muted-foreground">Custom records, split DNS forwarders and per-group search domains served by the hub</p>
+            </div>
+
+            <Card>
+                <CardHeader className="flex flex-row items-center justify-between">
+                    <div>
+                        <CardTitle className="flex items-center gap-2 text-base">
+                            <Globe className="h-4 w-4" /> Records
+                        </CardTitle>
+                        <CardDescription>A, CNAME and SRV records answered by the overlay DNS. Agent and service names take precedence.</CardDescription>
+                    </div>
+                    <Button variant="outline" size="sm" onClick={() => setRecordDialogOpen(true)}>
+                        <Plus className="mr-2 h-4 w-4" /> New Record
+                    </Button>
+                </CardHeader>
+                <CardContent className="space-y-2">
+                    {records.length === 0 && (
+                        <p className="text-sm text-muted-foreground">No custom records yet.</p>
+                    )}
+                    {records.map(r => (
+                        <div key={r.id} className="flex items-center justify-between text-sm">
+                            <div className="flex items-center gap-2">
+                                <Badge variant="outline">{r.type}</Badge>
+                                <span className="font-mono font-medium">{r.name}</span>
+                                <span className="text-muted-foreground font-mono">{describeRecord(r)}</span>
+                                <span className="text-xs text-muted-foreground">TTL {r.ttl}</span>
+                                {r.group_id && <Badge variant="secondary">{groupName(r.group_id)}</Badge>}
+                            </div>
+                            <Button variant="ghost" size="icon" onClick={() => handleDeleteRecord(r)}>
+                                <Trash2 className="h-4 w-4 text-destructive" />
+                            </Button>
+                        </div>
+                    ))}
+                </CardContent>
+            </Card>
+
+            <Card>
+                <CardHeader className="flex flex-row items-center justify-between">
+                    <div>
+                        <CardTitle className="flex items-center gap-2 text-base">
+                            <Split className="h-4 w-4" /> Split DNS Forwarders
+                        </CardTitle>
+                        <CardDescription>
+                            Queries for these domains go to their own resolvers instead of upstream. Resolvers on an agent or an approved subnet are reached through the overlay.
+                        </CardDescription>
+                    </div>
+                    <Button variant="outline" size="sm" onClick={() => setForwarderDialogOpen(true)}>
+                        <Plus className="mr-2 h-4 w-4" /> New Forwarder
+                    </Button>
+                </CardHeader>
+                <CardContent className="space-y-2">
+                    {forwarders.length === 0 && (
+                        <p className="text-sm text-muted-foreground">No forwarders yet; all other names are resolved upstream.</p>
+                    )}
+                    {forwarders.map(f => (
+                        <div key={f.id} className="flex items-center justify-between text-sm">
+                            <div className="flex items-center gap-2">
+                                <span className="font-mono font-medium">{f.domain}</span>
+                                <span className="text-muted-foreground font-mono">→ {f.servers.join(", ")}</span>
+                                {f.group_id && <Badge variant="secondary">{groupName(f.group_id)}</Badge>}
+                                {f.description && <span className="text-xs text-muted-foreground">{f.description}</span>}
+                            </div>
+                            <Button variant="ghost" size="icon" onClick={() => handleDeleteForwarder(f)}>
+                                <Trash2 className="h-4 w-4 text-destructive" />
+                            </Button>
+                        </div>
+                    ))}
+                </CardContent>
+            </Card>
+
+            <Card>
+                <CardHeader>
+                    <CardTitle className="flex items-center gap-2 text-base">
+                        <FolderTree className="h-4 w-4" /> Group Search Domains
+                    </CardTitle>
+                    <CardDescription>
+                        Domains tried for names without a dot, after the overlay domain. Agents receive them when they connect.
+                    </CardDescription>
+                </CardHeader>
+                <CardContent className="space-y-3">
+                    {groups.length === 0 && (
+                        <p className="text-sm text-muted-foreground">No groups yet.</p>
+                    )}
+                    {groups.map(g => (
+                        <div key={g.id} className="flex items-center gap-3">
+                            <span className="w-40 text-sm font-medium truncate">{g.name}</span>
+                            <Input
+                                className="font-mono"
+                                value={searchDomains[g.id] || ""}
+                                onChange={e => setSearchDomains({ ...searchDomains, [g.id]: e.target.value })}
+                                placeholder="corp.internal, eng.corp.internal"
+                            />
+                            <Button variant="outline" size="icon" onClick={() => handleSaveSearchDomains(g)}>
+                                <Save className="h-4 w-4" />
+                            </Button>
+                        </div>
+                    ))}
+                </CardContent>
+            </Card>
+
+            <Dialog open={recordDialogOpen} onOpenChange={setRecordDialogOpen}>
+                <DialogContent>
+                    <DialogHeader>
+                        <DialogTitle>New DNS Record</DialogTitle>
+                        <DialogDescription>Served to every agent, or only to the agents of one group.</DialogDescription>
+                    </DialogHeader>
+                    <div className="space-y-4 py-2">
+                        <div className="grid grid-cols-3 gap-4">
+                            <div className="space-y-2">
+                                <Label>Type</Label>
+                                <Select
+                                    value={recordForm.type}
+                                    onValueChange={val => setRecordForm({ ...recordForm, type: val as DNSRecordType })}
+                                >
+                                    <SelectTrigger><SelectValue /></SelectTrigger>
+                                    <SelectContent>
+                                        <SelectItem value="A">A</SelectItem>
+                                        <SelectItem value="CNAME">CNAME</SelectItem>
+                                        <SelectItem value="SRV">SRV</SelectItem>
+                                    </SelectContent>
+                                </Select>
+                            </div>
+                            <div className="space-y-2 col-span-2">
+                                <Label>Name</Label>
+                                <Input
+                                    value={recordForm.name}
+                                    onChange={e => setRecordForm({ ...recordForm, name: e.target.value })}
+                                    placeholder={recordForm.type === "SRV" ? "_ldap._tcp.corp.internal" : "intranet.corp.internal"}
+                                />
+                            </div>
+                        </div>
+                        <div className="space-y-2">
+                            <Label>{recordForm.type === "A" ? "IPv4 Address" : "Target"}</Label>
+                            <Input
+                                value={recordForm.value}
+                                onChange={e => setRecordForm({ ...recordForm, value: e.target.value })}
+                                placeholder={recordForm.type === "A" ? "10.1.0.20" : "dc1.corp.internal"}
+                            />
+                        </div>
+                        {recordForm.type === "SRV" && (
+                            <div className="grid grid-cols-3 gap-4">
+                                <div className="space-y-2">
+                                    <Label>Priority</Label>
+                                    <Input type="number" value={recordForm.priority} onChange={e => setRecordForm({ ...recordForm, priority: parseInt(e.target.value) || 0 })} />
+                                </div>
+                                <div className="space-y-2">
+                                    <Label>Weight</Label>
+                                    <Input type="number" value={recordForm.weight} onChange={e => setRecordForm({ ...recordForm, weight: parseInt(e.target.value) || 0 })} />
+                                </div>
+                                <div className="space-y-2">
+                                    <Label>Port</Label>
+                                    <Input type="number" value={recordForm.port} onChange={e => setRecordForm({ ...recordForm, port: parseInt(e.target.value) || 0 })} />
+                                </div>
+                            </div>
+                        )}
+                        <div className="grid grid-cols-2 gap-4">
+                            <div className="space-y-2">
+                                <Label>TTL (seconds)</Label>
+                                <Input type="number" value={recordForm.ttl} onChange={e => setRecordForm({ ...recordForm, ttl: parseInt(e.target.value) || 0 })} />
+                            </div>
+                            <div className="space-y-2">
+                                <Label>Visible To</Label>
+                                <Select
+                                    value={recordForm.group_id.toString()}
+                                    onValueChange={val => setRecordForm({ ...recordForm, group_id: parseInt(val) })}
+                                >
+                                    <SelectTrigger><SelectValue /></SelectTrigger>
+                                    <SelectContent>
+                                        <SelectItem value="0">All agents</SelectItem>
+                                        {groups.map(g => (
+                                            <SelectItem key={g.id} value={g.id.toString()}>{g.name}</SelectItem>
+                                        ))}
+                                    </SelectContent>
+                                </Select>
+                            </div>
+                        </div>
+                    </div>
+                    <DialogFooter>
+                        <Button variant="outline" onClick={() => setRecordDialogOpen(false)}>Cancel</Button>
+                        <Button onClick={handleCreateRecord} disabled={!recordForm.name.trim() || !recordForm.value.trim()}>Create Record</Button>
+                    </DialogFooter>
+                </DialogContent>
+            </Dialog>
+
+            <Dialog open={forwarderDialogOpen} onOpenChange={setForwarderDialogOpen}>
+                <DialogContent>
+                    <DialogHeader>
+                        <DialogTitle>New Split DNS Forwarder</DialogTitle>
+                        <DialogDescription>Names in this domain and its subdomains are resolved by the servers below.</DialogDescription>
+                    </DialogHeader>
+                    <div className="space-y-4 py-2">
+                        <div className="space-y-2">
+                            <Label>Domain</Label>
+                            <Input
+                                value={forwarderForm.domain}
+                                onChange={e => setForwarderForm({ ...forwarderForm, domain: e.target.value })}
+                                placeholder="corp.internal"
+                            />
+                        </div>
+                        <div className="space-y-2">
+                            <Label>Servers</Label>
+                            <Input
+                                className="font-mono"
+                                value={forwarderForm.servers}
+                                onChange={e => setForwarderForm({ ...forwarderForm, servers: e.target.value })}
+                                placeholder="10.1.0.53, 10.1.0.54:5353"
+                            />
+                            <p className="text-xs text-muted-foreground">IP addresses, port 53 unless given.</p>
+                        </div>
+                        <div className="space-y-2">
+                            <Label>Description</Label>
+                            <Input
+                                value={forwarderForm.description}
+                                onChange={e => setForwarderForm({ ...forwarderForm, description: e.target.value })}
+                                placeholder="Office domain controllers"
+                            />
+                        </div>
+                        <div className="space-y-2">
+                            <Label>Applies To</Label>
+                            <Select
+                                value={forwarderForm.group_id.toString()}
+                                onValueChange={val => setForwarderForm({ ...forwarderForm, group_id: parseInt(val) })}
+                            >
+                                <SelectTrigger><SelectValue /></SelectTrigger>
+                                <SelectContent>
+                                    <SelectItem value="0">All agents</SelectItem>
+                                    {groups.map(g => (
+                                        <SelectItem key={g.id} value={g.id.toString()}>{g.name}</SelectItem>
+                                    ))}
+                                </SelectContent>
+                            </Select>
+                        </div>
+                    </div>
+                    <DialogFooter>
+                        <Button variant="outline" onClick={() => setForwarderDialogOpen(false)}>Cancel</Button>
+                        <Button
+                            onClick={handleCreateForwarder}
+                            disabled={!forwarderForm.domain.trim() || parseList(forwarderForm.servers).length === 0}
+                        >
+                            Create Forwarder
+                        </Button>
+                    </DialogFooter>
+                </DialogContent>
+            </Dialog>
+        </div>
+    );
+}
@@ -0,0 +1,42 @@
+"use client";
+
+import { useEffect } from "react";
+import { useRouter } from "next/navigation";
+import { toast } from "sonner";
+import { getMe } from "@/lib/api";
+
+// Landing page for SSO logins. The server passes the access token in the URL
+// fragment so it never reaches server logs or Referer headers.
+export default function LoginCallbackPage() {
+    const router = useRouter();
+
+    useEffect(() => {
+        const params = new URLSearchParams(window.location.hash.slice(1));
+        const token = params.get("token");
+        const returnTo = params.get("return_to") || "/";
+        window.history.replaceState(null, "", window.location.pathname);
+
+        if (!token) {
+            router.replace("/login");
+            return;
+        }
+
+        localStorage.setItem("user_token", token);
+        getMe()
+            .then(({ user }) => {
+                localStorage.setItem("user_email", user.email);
+                toast.success("Logged in successfully");
+                router.replace(returnTo.startsWith("/") && !returnTo.startsWith("//") ? returnTo : "/");
+            })
+            .catch(() => {
+                localStorage.removeItem("user_token");
+                router.replace("/login");
+            });
+    }, [router]);
+
+    return (
+        <div className="min-h-screen flex items-center justify-center bg-muted/40 p-4 text-sm text-muted-foreground">
+            Signing you in...
+        </div>
+    );
+}
@@ -1,76 +1,109 @@
 "use client";
 
-import { useState } from "react";
+import { useEffect, useState, Suspense } from "react";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Card, CardContent, CardDescription, CardHeader, CardTitle, CardFooter } from "@/components/ui/card";
 import { Label } from "@/components/ui/label";
 import { toast } from "sonner";
-import { useRouter } from "next/navigation";
-import { login } from "@/lib/api";
+import { useRouter, useSearchParams } from "next/navigation";
+import { login, getAuthProviders, ssoLoginUrl, AuthProvider } from "@/lib/api";
 import { Shield } from "lucide-react";
 
-export default function LoginPage() {
+function LoginForm() {
     const [email, setEmail] = useState("");
+    const [password, setPassword] = useState("");
     const [loading, setLoading] = useState(false);
     const router = useRouter();
+    const searchParams = useSearchParams();
+    const [providers, setProviders] = useState<AuthProvider[]>([]);
+
+    // Only follow same-site return paths
+    const returnParam = searchParams.get("returnUrl");
+    const returnUrl = returnParam && returnParam.startsWith("/") && !returnParam.startsWith("//") ? returnParam : "/";
+
+    useEffect(() => {
+        getAuthProviders().then(setProviders).catch(() => setProviders([]));
+
+        const error = searchParams.get("error");
+        if (error) toast.error(error);
+    }, [searchParams]);
 
     const handleLogin = async (e: React.FormEvent) => {
         e.preventDefault();
-        if (!email) return;
+        if (!email || !password) return;
 
         setLoading(true);
         try {
-            const data = await login(email);
-            // Store simple session for demo
+            const data = await login(email, password);
             localStorage.setItem("user_email", data.user.email);
             localStorage.setItem("user_token", data.token);
 
             toast.success("Logged in successfully");
-            router.push("/");
+            router.push(returnUrl);
         } catch (error) {
-            toast.error("Login failed");
+            toast.error("Invalid email or password");
         } finally {
             setLoading(false);
         }
     };
 
     return (
-        <div className="min-h-screen flex items-center justify-center bg-muted/40 p-4">
-            <Card className="w-full max-w-md">
-                <CardHeader className="text-center space-y-2">
-                    <div className="mx-auto bg-primary/10 w-12 h-12 rounded-full flex items-center justify-center mb-2">
-                        <Shield className="w-6 h-6 text-primary" />
+        <Card className="w-full max-w-md">
+            <CardHeader className="text-center space-y-2">
+                <div className="mx-auto bg-primary/10 w-12 h-12 rounded-full flex items-center justify-center mb-2">
+                    <Shield className="w-6 h-6 text-primary" />
+                </div>
+                <CardTitle className="text-2xl">Sign in to Zero ZTA</CardTitle>
+                <CardDescription>
+                    Enter your credentials to access your secure network
+                </CardDescription>
+            </CardHeader>
+            <form onSubmit={handleLogin}>
+                <CardContent className="space-y-4">
+                    <div className="space-y-2">
+                        <Label htmlFor="email">Email</Label>
+                        <Input
+                            id="email"
+                            type="email"
+                            placeholder="name@company.com"
+                            value={email}
+                            onChange={(e) => setEmail(e.target.value)}
+                            required
+                        />
+                    </div>
+                    <div className="space-y-2">
+                        <Label htmlFor="password">Password</Label>
+                        <Input
+                            id="password"
+                            type="password"
+                            value={password}
+                            onChange={(e) => setPassword(e.target.value)}
+                            required
+                        />
                     </div>
-                    <CardTitle className="text-2xl">Sign in to Zero ZTA</CardTitle>
-                    <CardDescription>
-                        Enter your email to access your secure network
-                    </CardDescription>
-                </CardHeader>
-                <form onSubmit={handleLogin}>
-                    <CardContent className="space-y-4">
-                        <div className="space-y-2">
-                            <Label htmlFor="email">Email</Label>
-                            <Input
-                                id="email"
-                                type="email"
-                                placeholder="name@company.com"
-                                value={email}
-                                onChange={(e) => setEmail(e.target.value)}
-                                required
-                            />
-                        </div>
-                    </CardContent>
-                    <CardFooter>
-                        <Button className="w-full" type="submit" disabled={loading}>
-                            {loading ? "Signing in..." : "Sign In with Email"}
+                </CardContent>
+                <CardFooter className="flex flex-col gap-2">
+                    <Button className="w-full" type="submit" disabled={loading}>
+                        {loading ? "Signing in..." : "Sign In"}
+                    </Button>
+                    {providers.map((provider) => (
+                        <Button key={provider.name} className="w-full" variant="outline" type="button" asChild>
+                            <a href={ssoLoginUrl(provider, returnUrl)}>Continue with {provider.display_name}</a>
                         </Button>
-                    </CardFooter>
-                </form>
-                <div className="px-6 pb-6 text-center text-xs text-muted-foreground">
-                    <p>Demo Mode: Any email will be auto-approved.</p>
-                </div>
-            </Card>
+                    ))}
+                </CardFooter>
+            </form>
+        </Card>
+    );
+}
+
+export default function LoginPage() {
+    return (
+        <div className="min-h-screen flex items-center justify-center bg-muted/40 p-4">
+            <Suspense fallback={<div>Loading...</div>}>
+                <LoginForm />
+            </Suspense>
         </div>
     );
 }
@@ -22,8 +22,8 @@ import {
     SelectTrigger,
     SelectValue
 } from "@/components/ui/select";
-import { getPolicies, createPolicy, updatePolicy, deletePolicy, getGroups, getAgents, Policy, Group, Agent } from "@/lib/api";
-import { Plus, Trash2, ArrowRight, Edit, Shield, ShieldCheck, ShieldX, Users, Clock, Globe, Lock, AlertTriangle } from "lucide-react";
+import { getPolicies, createPolicy, updatePolicy, deletePolicy, getGroups, getAgents, getPolicyConflicts, getPostureProfiles, setPolicyPostureProfile, getSchedules, createSchedule, deleteSchedule, setPolicySchedule, Policy, PolicyConflict, PolicyMode, PostureProfile, Schedule, Weekday, Group, Agent } from "@/lib/api";
+import { Plus, Trash2, ArrowRight, Edit, Shield, ShieldCheck, ShieldX, Users, Clock, Globe, Lock, AlertTriangle, CalendarClock } from "lucide-react";
 import { toast } from "sonner";
 import { DataTable } from "@/components/ui/data-table";
 import { ColumnDef } from "@tanstack/react-table";
@@ -33,12 +33,24 @@ export default function PoliciesPage() {
     const [policies, setPolicies] = useState<Policy[]>([]);
     const [groups, setGroups] = useState<Group[]>([]);
     const [agents, setAgents] = useState<Agent[]>([]);
+    const [conflicts, setConflicts] = useState<PolicyConflict[]>([]);
+    const [postureProfiles, setPostureProfiles] = useState<PostureProfile[]>([]);
+    const [schedules, setSchedules] = useState<Schedule[]>([]);
+    const [policyMode, setPolicyMode] = useState<PolicyMode>("deny-overrides");
     const [loading, setLoading] = useState(true);
 
     // Dialog states
     const [createDialogOpen, setCreateDialogOpen] = useState(false);
     const [editDialogOpen, setEditDialogOpen] = useState(false);
     const [selectedPolicy, setSelectedPolicy] = useState<Policy | null>(null);
+    const [scheduleDialogOpen, setScheduleDialogOpen] = useState(false);
+    const [scheduleForm, setScheduleForm] = useState({
+        name: "",
+        timezone: Intl.DateTimeFormat().resolvedOptions().timeZone || "UTC",
+        days: ["mon", "tue", "wed", "thu", "fri"] as Weekday[],
+        start: "09:00",
+        end: "17:00"
+    });
 
     // Form state
     const [formData, setFormData] = useState({
@@ -48,11 +60,14 @@ export default function PoliciesPage() {
         dest_group_id: 0,
         allowed_ports: "*",
         action: "allow",
+        priority: 100,
         enabled: true,
         valid_from: "",
         valid_until: "",
         allowed_regions: "",
-        min_posture_score: 0
+        min_posture_score: 0,
+        posture_profile_id: 0,
+        schedule_id: 0
     });
 
     const fetchData = async () => {
@@ -65,6 +80,18 @@ export default function PoliciesPage() {
             setPolicies(policiesData);
             setGroups(groupsData);
             setAgents(agentsData);
+            getPolicyConflicts()
+                .then(data => {
+                    setConflicts(data.conflicts);
+                    setPolicyMode(data.mode);
+                })
+                .catch(() => setConflicts([]));
+            getPostureProfiles()
+                .then(setPostureProfiles)
+                .catch(() => setPostureProfiles([]));
+            getSchedules()
+                .then(setSchedules)
+                .catch(() => setSchedules([]));
         } catch (error) {
             toast.error("Failed to fetch data");
         } finally {
@@ -84,11 +111,14 @@ export default function PoliciesPage() {
             dest_group_id: 0,
             allowed_ports: "*",
             action: "allow",
+            priority: 100,
             enabled: true,
             valid_from: "",
             valid_until: "",
             allowed_regions: "",
-            min_posture_score: 0
+            min_posture_score: 0,
+            posture_profile_id: 0,
+            schedule_id: 0
         });
     };
 
@@ -101,14 +131,16 @@ export default function PoliciesPage() {
                 valid_from: formData.valid_from ? new Date(formData.valid_from).toISOString() : undefined,
                 valid_until: formData.valid_until ? new Date(formData.valid_until).toISOString() : undefined,
                 allowed_regions: formData.allowed_regions || undefined,
-                min_posture_score: formData.min_posture_score > 0 ? formData.min_posture_score : undefined
+                min_posture_score: formData.min_posture_score > 0 ? formData.min_posture_score : undefined,
+                posture_profile_id: formData.posture_profile_id || undefined,
+                schedule_id: formData.schedule_id || undefined
             });
             resetForm();
             setCreateDialogOpen(false);
             toast.success("Policy created successfully");
             fetchData();
         } catch (error) {
-            toast.error("Failed to create policy");
+            toast.error(error instanceof Error ? error.message : "Failed to create policy");
         }
     };
 
@@ -118,21 +150,71 @@ export default function PoliciesPage() {
         try {
             await updatePolicy(selectedPolicy.id, {
                 ...formData,
-                valid_from: formData.valid_from ? new Date(formData.valid_from).toISOString() : undefined,
-                valid_until: formData.valid_until ? new Date(formData.valid_until).toISOString() : undefined,
-                allowed_regions: formData.allowed_regions || undefined,
-                min_posture_score: formData.min_posture_score > 0 ? formData.min_posture_score : undefined
+                // Sent fields are stored as given, so empty ones clear the restriction
+                valid_from: formData.valid_from ? new Date(formData.valid_from).toISOString() : null,
+                valid_until: formData.valid_until ? new Date(formData.valid_until).toISOString() : null,
+                allowed_regions: formData.allowed_regions,
+                min_posture_score: formData.min_posture_score,
+                posture_profile_id: undefined,
+                schedule_id: undefined
             });
+            // The profile and schedule have their own audited endpoints
+            if (formData.posture_profile_id !== (selectedPolicy.posture_profile_id || 0)) {
+                await setPolicyPostureProfile(selectedPolicy.id, formData.posture_profile_id || null);
+            }
+            if (formData.schedule_id !== (selectedPolicy.schedule_id || 0)) {
+                await setPolicySchedule(selectedPolicy.id, formData.schedule_id || null);
+            }
             resetForm();
             setSelectedPolicy(null);
             setEditDialogOpen(false);
             toast.success("Policy updated successfully");
             fetchData();
         } catch (error) {
-            toast.error("Failed to update policy");
+            toast.error(error instanceof Error ? error.message : "Failed to update policy");
         }
     };
 
+    const handleCreateSchedule = async () => {
+        if (!scheduleForm.name.trim()) return;
+
+        try {
+            await createSchedule({
+                name: scheduleForm.name,
+                timezone: scheduleForm.timezone,
+                windows: [{ days: scheduleForm.days, start: scheduleForm.start, end: scheduleForm.end }]
+            });
+            setScheduleDialogOpen(false);
+            setScheduleForm({ ...scheduleForm, name: "" });
+            toast.success("Schedule created");
+            fetchData();
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to create schedule");
+        }
+    };
+
+    const handleDeleteSchedule = async (schedule: Schedule) => {
+        if (!confirm(`Delete schedule "${schedule.name}"?`)) return;
+
+        try {
+            await deleteSchedule(schedule.id);
+            toast.success("Schedule deleted");
+            fetchData();
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to delete schedule");
+        }
+    };
+
+    const toggleScheduleDay = (day: Weekday) => {
+        setScheduleForm({
+            ...scheduleForm,
+            days: scheduleForm.days.includes(day) ? scheduleForm.days.filter(d => d !== day) : [...scheduleForm.days, day]
+        });
+    };
+
+    const describeSchedule = (schedule: Schedule) =>
+        schedule.windows.map(w => `${w.days.join(", ")} ${w.start}-${w.end}`).join("; ") + ` (${schedule.timezone})`;
+
     const handleDelete = async (id: number) => {
         if (!confirm("Are you sure you want to delete this policy?")) return;
 
@@ -154,11 +236,14 @@ export default function PoliciesPage() {
             dest_group_id: policy.dest_group_id,
             allowed_ports: policy.allowed_ports,
             action: policy.action,
+            priority: policy.priority,
             enabled: policy.enabled,
             valid_from: policy.valid_from ? new Date(policy.valid_from).toISOString().slice(0, 16) : "",
             valid_until: policy.valid_until ? new Date(policy.valid_until).toISOString().slice(0, 16) : "",
             allowed_regions: policy.allowed_regions || "",
-            min_posture_score: policy.min_posture_score || 0
+            min_posture_score: policy.min_posture_score || 0,
+            posture_profile_id: policy.posture_profile_id || 0,
+            schedule_id: policy.schedule_id || 0
         });
         setEditDialogOpen(true);
     };
@@ -174,6 +259,13 @@ export default function PoliciesPage() {
     };
 
     const columns: ColumnDef<Policy>[] = [
+        {
+            accessorKey: "priority",
+            header: "Priority",
+            cell: ({ row }) => (
+                <span className="font-mono text-sm text-muted-foreground">{row.original.priority}</span>
+            )
+        },
         {
             accessorKey: "name",
             header: "Policy",
@@ -207,7 +299,8 @@ export default function PoliciesPage() {
             id: "controls",
             cell: ({ row }) => (
                 <div className="flex items-center gap-2">
-                    {row.original.valid_from && <Clock className="h-3 w-3 text-blue-500" />}
+                    {(row.original.valid_from || row.original.valid_until) && <Clock className="h-3 w-3 text-blue-500" />}
+                    {row.original.schedule_id && <CalendarClock className="h-3 w-3 text-blue-500" />}
                     {row.original.allowed_regions && <Globe className="h-3 w-3 text-purple-500" />}
                     {row.original.min_posture_score && row.original.min_posture_score > 0 && (
                         <Badge variant="secondary" className="px-1 py-0 text-[10px] h-4">
@@ -294,9 +387,15 @@ export default function PoliciesPage() {
                         </Select>
                     </div>
                 </div>
-                <div className="space-y-2">
-                    <Label>Allowed Ports (comma separated)</Label>
-                    <Input value={formData.allowed_ports} onChange={e => setFormData({ ...formData, allowed_ports: e.target.value })} placeholder="80, 443, 22 or *" className="font-mono" />
+                <div className="grid grid-cols-4 gap-4">
+                    <div className="col-span-3 space-y-2">
+                        <Label>Allowed Ports (comma separated)</Label>
+                        <Input value={formData.allowed_ports} onChange={e => setFormData({ ...formData, allowed_ports: e.target.value })} placeholder="22, tcp/443, udp/53, 8000-8100, icmp, service:web or *" className="font-mono" />
+                    </div>
+                    <div className="space-y-2">
+                        <Label>Priority</Label>
+                        <Input type="number" min={1} max={10000} value={formData.priority} onChange={e => setFormData({ ...formData, priority: parseInt(e.target.value) || 100 })} />
+                    </div>
                 </div>
             </div>
 
@@ -315,13 +414,37 @@ export default function PoliciesPage() {
                         <Input type="datetime-local" value={formData.valid_until} onChange={e => setFormData({ ...formData, valid_until: e.target.value })} />
                     </div>
                 </div>
+                <p className="text-[10px] text-muted-foreground">
+                    Policies are disabled automatically once their validity window has ended.
+                </p>
+
+                <div className="space-y-2">
+                    <Label className="text-xs text-muted-foreground">Recurring Schedule</Label>
+                    <Select
+                        value={formData.schedule_id.toString()}
+                        onValueChange={val => setFormData({ ...formData, schedule_id: parseInt(val) })}
+                    >
+                        <SelectTrigger>
+                            <SelectValue placeholder="Always" />
+                        </SelectTrigger>
+                        <SelectContent>
+                            <SelectItem value="0">Always</SelectItem>
+                            {schedules.map(s => (
+                                <SelectItem key={s.id} value={s.id.toString()}>{s.name}</SelectItem>
+                            ))}
+                        </SelectContent>
+                    </Select>
+                </div>
 
                 <div className="space-y-2">
                     <Label className="text-xs text-muted-foreground">Allowed Regions (Country Codes)</Label>
                     <div className="flex gap-2">
                         <Globe className="h-8 w-8 p-2 bg-background rounded border text-muted-foreground" />
-                        <Input value={formData.allowed_regions} onChange={e => setFormData({ ...formData, allowed_regions: e.target.value })} placeholder="US, UK, DE (Comma separated)" />
+                        <Input value={formData.allowed_regions} onChange={e => setFormData({ ...formData, allowed_regions: e.target.value })} placeholder="US, GB, DE (Comma separated)" />
                     </div>
+                    <p className="text-[10px] text-muted-foreground">
+                        Checked against the country of the address the source agent connects from. Agents in an unknown country are denied.
+                    </p>
                 </div>
 
                 <div className="space-y-4 pt-2">
@@ -340,6 +463,27 @@ export default function PoliciesPage() {
                         Devices with a score lower than {formData.min_posture_score} will be denied access even if authenticated.
                     </p>
                 </div>
+
+                <div className="space-y-2">
+                    <Label className="text-xs text-muted-foreground">Required Posture Profile</Label>
+                    <Select
+                        value={formData.posture_profile_id.toString()}
+                        onValueChange={val => setFormData({ ...formData, posture_profile_id: parseInt(val) })}
+                    >
+                        <SelectTrigger>
+                            <SelectValue placeholder="None" />
+                        </SelectTrigger>
+                        <SelectContent>
+                            <SelectItem value="0">None</SelectItem>
+                            {postureProfiles.map(p => (
+                                <SelectItem key={p.id} value={p.id.toString()}>{p.name}</SelectItem>
+                            ))}
+                        </SelectContent>
+                    </Select>
+                    <p className="text-[10px] text-muted-foreground">
+                        Source devices must pass every check of this profile for the policy to match.
+                    </p>
+                </div>
             </div>
 
             <div className="flex items-center justify-between pt-2">
@@ -364,12 +508,115 @@ export default function PoliciesPage() {
                 </Button>
             </div>
 
+            {conflicts.length > 0 && (
+                <Card className="border-amber-200 dark:border-amber-800/50">
+                    <CardHeader>
+                        <CardTitle className="flex items-center gap-2 text-base">
+                            <AlertTriangle className="h-4 w-4 text-amber-500" /> Policy Conflicts
+                        </CardTitle>
+                        <CardDescription>
+                            {policyMode === "deny-overrides"
+                                ? "Deny policies override allow policies; within each action lower priority values are evaluated first."
+                                : "The first matching policy in priority order decides, whatever its action."}
+                        </CardDescription>
+                    </CardHeader>
+                    <CardContent className="space-y-2">
+                        {conflicts.map((c, i) => (
+                            <div key={i} className="flex items-center gap-3 text-sm">
+                                <Badge variant={c.kind === "contradiction" ? "secondary" : "destructive"}>{c.kind}</Badge>
+                                <span>{c.message}</span>
+                            </div>
+                        ))}
+                    </CardContent>
+                </Card>
+            )}
+
             <Card>
                 <CardContent className="p-0">
                     <DataTable columns={columns} data={policies} filterPlaceholder="Filter policies..." filterColumn="name" />
                 </CardContent>
             </Card>
 
+            <Card>
+                <CardHeader className="flex flex-row items-center justify-between">
+                    <div>
+                        <CardTitle className="flex items-center gap-2 text-base">
+                            <CalendarClock className="h-4 w-4" /> Schedules
+                        </CardTitle>
+                        <CardDescription>Recurring time windows, such as business hours, that policies can be limited to.</CardDescription>
+                    </div>
+                    <Button variant="outline" size="sm" onClick={() => setScheduleDialogOpen(true)}>
+                        <Plus className="mr-2 h-4 w-4" /> New Schedule
+                    </Button>
+                </CardHeader>
+                <CardContent className="space-y-2">
+                    {schedules.length === 0 && (
+                        <p className="text-sm text-muted-foreground">No schedules yet.</p>
+                    )}
+                    {schedules.map(s => (
+                        <div key={s.id} className="flex items-center justify-between text-sm">
+                            <div>
+                                <span className="font-medium">{s.name}</span>
+                                <span className="text-muted-foreground ml-2">{describeSchedule(s)}</span>
+                            </div>
+                            <Button variant="ghost" size="icon" onClick={() => handleDeleteSchedule(s)}>
+                                <Trash2 className="h-4 w-4 text-destructive" />
+                            </Button>
+                        </div>
+                    ))}
+                </CardContent>
+            </Card>
+
+            <Dialog open={scheduleDialogOpen} onOpenChange={setScheduleDialogOpen}>
+                <DialogContent>
+                    <DialogHeader>
+                        <DialogTitle>New Schedule</DialogTitle>
+                        <DialogDescription>Policies with this schedule only apply during the window below.</DialogDescription>
+                    </DialogHeader>
+                    <div className="space-y-4 py-2">
+                        <div className="space-y-2">
+                            <Label>Name</Label>
+                            <Input value={scheduleForm.name} onChange={e => setScheduleForm({ ...scheduleForm, name: e.target.value })} placeholder="Business hours" />
+                        </div>
+                        <div className="space-y-2">
+                            <Label>Time Zone</Label>
+                            <Input value={scheduleForm.timezone} onChange={e => setScheduleForm({ ...scheduleForm, timezone: e.target.value })} placeholder="Europe/Berlin" />
+                        </div>
+                        <div className="space-y-2">
+                            <Label>Days</Label>
+                            <div className="flex gap-1">
+                                {(["mon", "tue", "wed", "thu", "fri", "sat", "sun"] as Weekday[]).map(day => (
+                                    <Button
+                                        key={day}
+                                        type="button"
+                                        size="sm"
+                                        variant={scheduleForm.days.includes(day) ? "default" : "outline"}
+                                        onClick={() => toggleScheduleDay(day)}
+                                    >
+                                        {day}
+                                    </Button>
+                                ))}
+                            </div>
+                        </div>
+                        <div className="grid grid-cols-2 gap-4">
+                            <div className="space-y-2">
+                                <Label>From</Label>
+                                <Input type="time" value={scheduleForm.start} onChange={e => setScheduleForm({ ...scheduleForm, start: e.target.value })} />
+                            </div>
+                            <div className="space-y-2">
+                                <Label>Until</Label>
+                                <Input type="time" value={scheduleForm.end} onChange={e => setScheduleForm({ ...scheduleForm, end: e.target.value })} />
+                            </div>
+                        </div>
+                        <p className="text-xs text-muted-foreground">An end time before the start time runs past midnight.</p>
+                    </div>
+                    <DialogFooter>
+                        <Button variant="outline" onClick={() => setScheduleDialogOpen(false)}>Cancel</Button>
+                        <Button onClick={handleCreateSchedule} disabled={!scheduleForm.name.trim() || scheduleForm.days.length === 0}>Create Schedule</Button>
+                    </DialogFooter>
+                </DialogContent>
+            </Dialog>
+
             <Dialog open={createDialogOpen} onOpenChange={setCreateDialogOpen}>
                 <DialogContent className="max-w-2xl">
                     <DialogHeader>
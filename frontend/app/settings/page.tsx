@@ -1,23 +1,41 @@
 "use client";
 
+import { useEffect, useState } from "react";
 import { useTheme } from "next-themes";
 import { Button } from "@/components/ui/button";
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/components/ui/card";
+import { Input } from "@/components/ui/input";
 import { Label } from "@/components/ui/label";
 import { Switch } from "@/components/ui/switch";
-import {
-    Select,
-    SelectContent,
-    SelectItem,
-    SelectTrigger,
-    SelectValue,
-} from "@/components/ui/select";
-import { Moon, Sun, Monitor, Bell, Shield, Globe, HardDrive } from "lucide-react";
+import { Moon, Sun, Monitor, Bell, Shield, ShieldAlert, Globe, HardDrive } from "lucide-react";
 import { toast } from "sonner";
 import { Separator } from "@/components/ui/separator";
+import { getPostureSettings, updatePostureSettings, PostureSettings } from "@/lib/api";
+
+const postureChecks = [
+    { key: "firewall", label: "Firewall" },
+    { key: "disk_encryption", label: "Disk encryption" },
+    { key: "antivirus", label: "Antivirus" },
+    { key: "screen_lock", label: "Screen lock" },
+] as const;
 
 export default function SettingsPage() {
     const { setTheme, theme } = useTheme();
+    const [posture, setPosture] = useState<PostureSettings | null>(null);
+
+    useEffect(() => {
+        getPostureSettings().then(setPosture).catch(() => setPosture(null));
+    }, []);
+
+    const savePosture = async () => {
+        if (!posture) return;
+        try {
+            setPosture(await updatePostureSettings(posture));
+            toast.success("Posture settings saved, devices rescored");
+        } catch (error) {
+            toast.error(error instanceof Error ? error.message : "Failed to save posture settings");
+        }
+    };
 
     return (
         <div className="space-y-8 animate-in fade-in duration-500 max-w-4xl mx-auto">
@@ -140,6 +158,58 @@ export default function SettingsPage() {
                     </CardContent>
                 </Card>
 
+                {/* Device Posture */}
+                {posture && (
+                    <Card>
+                        <CardHeader>
+                            <CardTitle className="flex items-center gap-2">
+                                <ShieldAlert className="h-5 w-5" /> Device Posture
+                            </CardTitle>
+                            <CardDescription>
+                                How the server scores device security. Each passing check earns its weight; the score is the share of the total weight.
+                            </CardDescription>
+                        </CardHeader>
+                        <CardContent className="space-y-4">
+                            <div className="grid grid-cols-[1fr_100px_auto] items-center gap-x-4 gap-y-3 text-sm">
+                                <span className="font-medium">Check</span>
+                                <span className="font-medium">Weight</span>
+                                <span className="font-medium">Required</span>
+                                <span>Reporting device (base)</span>
+                                <Input type="number" min={0} max={100} value={posture.base_weight}
+                                    onChange={e => setPosture({ ...posture, base_weight: parseInt(e.target.value) || 0 })} />
+                                <span />
+                                {postureChecks.map(check => (
+                                    <div key={check.key} className="contents">
+                                        <span>{check.label}</span>
+                                        <Input type="number" min={0} max={100} value={posture[`${check.key}_weight`]}
+                                            onChange={e => setPosture({ ...posture, [`${check.key}_weight`]: parseInt(e.target.value) || 0 })} />
+                                        <Switch checked={posture[`require_${check.key}`]}
+                                            onCheckedChange={checked => setPosture({ ...posture, [`require_${check.key}`]: checked })} />
+                                    </div>
+                                ))}
+                            </div>
+                            <Separator />
+                            <div className="grid grid-cols-2 gap-4">
+                                <div className="space-y-2">
+                                    <Label>Stale after</Label>
+                                    <Input value={posture.stale_after} placeholder="5m"
+                                        onChange={e => setPosture({ ...posture, stale_after: e.target.value })} />
+                                    <p className="text-xs text-muted-foreground">Devices without a report for this long count as stale.</p>
+                                </div>
+                                <div className="flex items-center justify-between">
+                                    <div className="space-y-0.5">
+                                        <Label>Quarantine</Label>
+                                        <p className="text-xs text-muted-foreground">Cut all traffic of noncompliant, stale and never-reported devices.</p>
+                                    </div>
+                                    <Switch checked={posture.quarantine}
+                                        onCheckedChange={checked => setPosture({ ...posture, quarantine: checked })} />
+                                </div>
+                            </div>
+                            <Button onClick={savePosture}>Save Posture Settings</Button>
+                        </CardContent>
+                    </Card>
+                )}
+
                 {/* API & Security */}
                 <Card>
                     <CardHeader>
@@ -151,23 +221,7 @@ export default function SettingsPage() {
                         </CardDescription>
                     </CardHeader>
                     <CardContent className="space-y-4">
-                        <div className="space-y-2">
-                            <Label>Minimum Global Posture Score</Label>
-                            <Select defaultValue="0">
-                                <SelectTrigger>
-                                    <SelectValue placeholder="Select score" />
-                                </SelectTrigger>
-                                <SelectContent>
-                                    <SelectItem value="0">Disabled (0)</SelectItem>
-                                    <SelectItem value="25">Low (25)</SelectItem>
-                                    <SelectItem value="50">Medium (50)</SelectItem>
-                                    <SelectItem value="75">High (75)</SelectItem>
-                                    <SelectItem value="90">Strict (90)</SelectItem>
-                                </SelectContent>
-                            </Select>
-                            <p className="text-xs text-muted-foreground">Enforce a baseline security score for all agents.</p>
-                        </div>
-                        <div className="pt-4">
+                        <div>
                             <Button variant="destructive" onClick={() => toast.error("Not implemented in demo")}>
                                 Revoke All API Keys
                             </Button>
@@ -14,7 +14,8 @@ import {
     Menu,
     X,
     FileText,
-    Bug
+    Bug,
+    Globe
 } from "lucide-react";
 import { useState } from "react";
 import { Button } from "@/components/ui/button";
@@ -25,6 +26,7 @@ const navItems = [
     { href: "/agents", label: "Agents", icon: Users },
     { href: "/groups", label: "Groups", icon: FolderTree },
     { href: "/policies", label: "Policies", icon: Shield },
+    { href: "/dns", label: "DNS", icon: Globe },
     { href: "/audit-logs", label: "Audit Logs", icon: FileText },
     { href: "/debug", label: "Debug Tools", icon: Bug },
 ];
@@ -1,5 +1,26 @@
 const API_BASE = process.env.NEXT_PUBLIC_API_URL || 'http://127.0.0.1:3000';
 
+// Token issued by /auth/login, sent as a bearer token on every API call
+export function getToken(): string | null {
+  if (typeof window === 'undefined') return null;
+  return localStorage.getItem('user_token');
+}
+
+async function apiFetch(input: string, init: RequestInit = {}): Promise<Response> {
+  const headers = new Headers(init.headers);
+  const token = getToken();
+  if (token) headers.set('Authorization', `Bearer ${token}`);
+
+  const res = await fetch(input, { ...init, headers });
+  if (res.status === 401 && typeof window !== 'undefined' && !window.location.pathname.startsWith('/login')) {
+    localStorage.removeItem('user_token');
+    localStorage.removeItem('user_email');
+    const returnUrl = encodeURIComponent(window.location.pathname + window.location.search);
+    window.location.href = `/login?returnUrl=${returnUrl}`;
+  }
+  return res;
+}
+
 export interface Agent {
   id: number;
   name: string;
@@ -8,10 +29,19 @@ export interface Agent {
   public_key?: string;
   ip: string;
   status: string;
+  disabled?: boolean;
   last_seen?: string;
   group_id?: number;
   group?: Group;
+  public_ip?: string;
+  country?: string;
   routes?: string;
+  approved_routes?: string;
+  advertise_exit_node?: boolean;
+  exit_node?: boolean;
+  use_exit_node?: boolean;
+  tags?: string;
+  enrollment_key_id?: number;
   version?: string;
   services?: Service[];
   created_at: string;
@@ -56,6 +86,7 @@ export interface AccessLog {
   bytes_sent: number;
   bytes_received: number;
   duration_ms: number;
+  count: number; // denied packets aggregated into this entry, 1 for connections
   created_at: string;
 }
 
@@ -77,6 +108,8 @@ export interface Group {
   name: string;
   description: string;
   agents?: Agent[];
+  posture_profile_id?: number;
+  dns_search_domains?: string[];
   created_at: string;
   updated_at: string;
 }
@@ -91,12 +124,15 @@ export interface Policy {
   dest_group?: Group;
   allowed_ports: string;
   action: string;
+  priority: number; // lower values are evaluated first
   enabled: boolean;
   // Zero Trust fields
-  valid_from?: string;
-  valid_until?: string;
+  valid_from?: string | null;
+  valid_until?: string | null;
   allowed_regions?: string;
   min_posture_score?: number;
+  posture_profile_id?: number;
+  schedule_id?: number;
   created_at: string;
   updated_at: string;
 }
@@ -112,7 +148,11 @@ export interface DevicePosture {
   firewall_enabled: boolean;
   disk_encrypted: boolean;
   screen_lock_enabled: boolean;
-  posture_score: number;
+  posture_score: number; // computed by the server
+  status: 'compliant' | 'noncompliant' | 'stale';
+  failed_checks?: string;
+  pending_patches: number; // -1 if not reported
+  agent_version?: string;
   last_checked?: string;
   created_at: string;
   updated_at: string;
@@ -141,19 +181,19 @@ export interface PortCheckResult {
 
 // Agents API
 export async function getAgents(): Promise<Agent[]> {
-  const res = await fetch(`${API_BASE}/api/v1/agents`);
+  const res = await apiFetch(`${API_BASE}/api/v1/agents`);
   if (!res.ok) throw new Error('Failed to fetch agents');
   return res.json();
 }
 
 export async function getAgent(id: number): Promise<Agent> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${id}`);
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}`);
   if (!res.ok) throw new Error('Failed to fetch agent');
   return res.json();
 }
 
 export async function createAgent(data: { name: string; description?: string; group_id?: number }): Promise<Agent> {
-  const res = await fetch(`${API_BASE}/api/v1/agents`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
@@ -162,8 +202,8 @@ export async function createAgent(data: { name: string; description?: string; gr
   return res.json();
 }
 
-export async function updateAgent(id: number, data: { name?: string; description?: string; group_id?: number }): Promise<Agent> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${id}`, {
+export async function updateAgent(id: number, data: { name?: string; description?: string; group_id?: number; disabled?: boolean }): Promise<Agent> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}`, {
     method: 'PUT',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
@@ -173,7 +213,7 @@ export async function updateAgent(id: number, data: { name?: string; description
 }
 
 export async function assignAgentGroup(id: number, groupId: number | null): Promise<Agent> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${id}/group`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}/group`, {
     method: 'PUT',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ group_id: groupId }),
@@ -183,58 +223,160 @@ export async function assignAgentGroup(id: number, groupId: number | null): Prom
 }
 
 export async function deleteAgent(id: number): Promise<void> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${id}`, { method: 'DELETE' });
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}`, { method: 'DELETE' });
   if (!res.ok) throw new Error('Failed to delete agent');
 }
 
-export async function regenerateAgentKey(id: number): Promise<{ api_key: string }> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${id}/regenerate-key`, { method: 'POST' });
+export async function regenerateAgentKey(id: number, grace?: string): Promise<{ api_key: string; old_keys_expire_at?: string }> {
+  const query = grace !== undefined ? `?grace=${encodeURIComponent(grace)}` : '';
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}/regenerate-key${query}`, { method: 'POST' });
   if (!res.ok) throw new Error('Failed to regenerate key');
   return res.json();
 }
 
+// Credentials API
+export interface AgentCredential {
+  id: number;
+  agent_id: number;
+  name: string;
+  prefix: string;
+  expires_at?: string;
+  last_used_at?: string;
+  created_at: string;
+}
+
+export async function getAgentCredentials(agentId: number): Promise<AgentCredential[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/credentials`);
+  if (!res.ok) throw new Error('Failed to fetch credentials');
+  return res.json();
+}
+
+export async function createAgentCredential(agentId: number, data: { name: string; expires_in?: string }): Promise<{ api_key: string; credential: AgentCredential }> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/credentials`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) throw new Error('Failed to create credential');
+  return res.json();
+}
+
+export async function revokeAgentCredential(agentId: number, credentialId: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/credentials/${credentialId}`, { method: 'DELETE' });
+  if (!res.ok) throw new Error('Failed to revoke credential');
+}
+
+// Enrollment keys API
+export interface EnrollmentKey {
+  id: number;
+  name: string;
+  prefix: string;
+  reusable: boolean;
+  max_uses: number;
+  uses: number;
+  expires_at?: string;
+  last_used_at?: string;
+  group_id?: number;
+  group?: Group;
+  tags?: string;
+  created_at: string;
+}
+
+export async function getEnrollmentKeys(): Promise<EnrollmentKey[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/enrollment-keys`);
+  if (!res.ok) throw new Error('Failed to fetch enrollment keys');
+  return res.json();
+}
+
+export async function createEnrollmentKey(data: { name: string; reusable?: boolean; max_uses?: number; expires_in?: string; group_id?: number; tags?: string[] }): Promise<{ enroll_key: string; enrollment_key: EnrollmentKey }> {
+  const res = await apiFetch(`${API_BASE}/api/v1/enrollment-keys`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) throw new Error('Failed to create enrollment key');
+  return res.json();
+}
+
+export async function revokeEnrollmentKey(id: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/enrollment-keys/${id}`, { method: 'DELETE' });
+  if (!res.ok) throw new Error('Failed to revoke enrollment key');
+}
+
 export async function updateAgentRoutes(id: number, routes: string[]): Promise<Agent> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${id}/routes`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}/routes`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ routes }),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update routes');
+  }
+  return res.json();
+}
+
+export async function approveAgentRoutes(id: number, routes: string[]): Promise<Agent> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}/routes/approved`, {
     method: 'PUT',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ routes }),
   });
-  if (!res.ok) throw new Error('Failed to update routes');
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to approve routes');
+  }
+  return res.json();
+}
+
+export async function approveExitNode(id: number, enabled: boolean): Promise<Agent> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}/exit-node`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ enabled }),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update exit node');
+  }
   return res.json();
 }
 
 // Metrics API
 export async function getAgentMetrics(agentId: number, limit = 100): Promise<AgentMetrics[]> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${agentId}/metrics?limit=${limit}`);
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/metrics?limit=${limit}`);
   if (!res.ok) throw new Error('Failed to fetch metrics');
   return res.json();
 }
 
 export async function getAgentAccessLogs(agentId: number, limit = 100): Promise<AccessLog[]> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${agentId}/access-logs?limit=${limit}`);
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/access-logs?limit=${limit}`);
   if (!res.ok) throw new Error('Failed to fetch access logs');
   return res.json();
 }
 
 // Services API
 export async function getAgentServices(agentId: number): Promise<Service[]> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${agentId}/services`);
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/services`);
   if (!res.ok) throw new Error('Failed to fetch services');
   return res.json();
 }
 
 export async function createService(agentId: number, data: Partial<Service>): Promise<Service> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${agentId}/services`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/services`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
   });
-  if (!res.ok) throw new Error('Failed to create service');
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to create service');
+  }
   return res.json();
 }
 
 export async function deleteService(agentId: number, serviceId: number): Promise<void> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${agentId}/services/${serviceId}`, { method: 'DELETE' });
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/services/${serviceId}`, { method: 'DELETE' });
   if (!res.ok) throw new Error('Failed to delete service');
 }
 
@@ -245,20 +387,20 @@ export async function getAuditLogs(params?: { agent_id?: number; action?: string
   if (params?.action) query.set('action', params.action);
   if (params?.limit) query.set('limit', String(params.limit));
 
-  const res = await fetch(`${API_BASE}/api/v1/audit-logs?${query.toString()}`);
+  const res = await apiFetch(`${API_BASE}/api/v1/audit-logs?${query.toString()}`);
   if (!res.ok) throw new Error('Failed to fetch audit logs');
   return res.json();
 }
 
 export async function getAgentAuditLogs(agentId: number, limit = 50): Promise<AuditLog[]> {
-  const res = await fetch(`${API_BASE}/api/v1/agents/${agentId}/audit-logs?limit=${limit}`);
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${agentId}/audit-logs?limit=${limit}`);
   if (!res.ok) throw new Error('Failed to fetch agent audit logs');
   return res.json();
 }
 
 // Debug Tools API
 export async function pingAgent(sourceId: number, destId: number, count = 4): Promise<PingResult> {
-  const res = await fetch(`${API_BASE}/api/v1/debug/ping`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/debug/ping`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ source_agent_id: sourceId, dest_agent_id: destId, count }),
@@ -268,7 +410,7 @@ export async function pingAgent(sourceId: number, destId: number, count = 4): Pr
 }
 
 export async function checkPort(sourceId: number, destId: number, port: number, protocol = 'tcp'): Promise<PortCheckResult> {
-  const res = await fetch(`${API_BASE}/api/v1/debug/port-check`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/debug/port-check`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ source_agent_id: sourceId, dest_agent_id: destId, port, protocol }),
@@ -278,7 +420,7 @@ export async function checkPort(sourceId: number, destId: number, port: number,
 }
 
 export async function traceroute(sourceId: number, destId: number): Promise<any> {
-  const res = await fetch(`${API_BASE}/api/v1/debug/traceroute`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/debug/traceroute`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ source_agent_id: sourceId, dest_agent_id: destId }),
@@ -288,7 +430,7 @@ export async function traceroute(sourceId: number, destId: number): Promise<any>
 }
 
 export async function dnsLookup(sourceId: number, domain: string, recordType = "A"): Promise<any> {
-  const res = await fetch(`${API_BASE}/api/v1/debug/dns`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/debug/dns`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ source_agent_id: sourceId, domain, record_type: recordType }),
@@ -298,7 +440,7 @@ export async function dnsLookup(sourceId: number, domain: string, recordType = "
 }
 
 export async function httpCheck(sourceId: number, url: string, method = "GET"): Promise<any> {
-  const res = await fetch(`${API_BASE}/api/v1/debug/http`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/debug/http`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ source_agent_id: sourceId, url, method }),
@@ -309,13 +451,13 @@ export async function httpCheck(sourceId: number, url: string, method = "GET"):
 
 // Groups API
 export async function getGroups(): Promise<Group[]> {
-  const res = await fetch(`${API_BASE}/api/v1/groups`);
+  const res = await apiFetch(`${API_BASE}/api/v1/groups`);
   if (!res.ok) throw new Error('Failed to fetch groups');
   return res.json();
 }
 
 export async function createGroup(data: { name: string; description?: string }): Promise<Group> {
-  const res = await fetch(`${API_BASE}/api/v1/groups`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/groups`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
@@ -325,7 +467,7 @@ export async function createGroup(data: { name: string; description?: string }):
 }
 
 export async function updateGroup(id: number, data: { name?: string; description?: string }): Promise<Group> {
-  const res = await fetch(`${API_BASE}/api/v1/groups/${id}`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/groups/${id}`, {
     method: 'PUT',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
@@ -335,47 +477,410 @@ export async function updateGroup(id: number, data: { name?: string; description
 }
 
 export async function deleteGroup(id: number): Promise<void> {
-  const res = await fetch(`${API_BASE}/api/v1/groups/${id}`, { method: 'DELETE' });
+  const res = await apiFetch(`${API_BASE}/api/v1/groups/${id}`, { method: 'DELETE' });
   if (!res.ok) throw new Error('Failed to delete group');
 }
 
 // Policies API
 export async function getPolicies(): Promise<Policy[]> {
-  const res = await fetch(`${API_BASE}/api/v1/policies`);
+  const res = await apiFetch(`${API_BASE}/api/v1/policies`);
   if (!res.ok) throw new Error('Failed to fetch policies');
   return res.json();
 }
 
 export async function createPolicy(data: Partial<Policy>): Promise<Policy> {
-  const res = await fetch(`${API_BASE}/api/v1/policies`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/policies`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
   });
-  if (!res.ok) throw new Error('Failed to create policy');
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to create policy');
+  }
   return res.json();
 }
 
 export async function updatePolicy(id: number, data: Partial<Policy>): Promise<Policy> {
-  const res = await fetch(`${API_BASE}/api/v1/policies/${id}`, {
+  const res = await apiFetch(`${API_BASE}/api/v1/policies/${id}`, {
     method: 'PUT',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(data),
   });
-  if (!res.ok) throw new Error('Failed to update policy');
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update policy');
+  }
   return res.json();
 }
 
 export async function deletePolicy(id: number): Promise<void> {
-  const res = await fetch(`${API_BASE}/api/v1/policies/${id}`, { method: 'DELETE' });
+  const res = await apiFetch(`${API_BASE}/api/v1/policies/${id}`, { method: 'DELETE' });
   if (!res.ok) throw new Error('Failed to delete policy');
 }
 
+export interface PolicyEvaluation {
+  allowed: boolean;
+  policy_id?: number;
+  source_agent_id?: number;
+  dest_agent_id?: number;
+  reason: string;
+  mode: PolicyMode;
+  policies: { policy_id: number; name: string; action: string; priority: number; matched: boolean; reason: string }[];
+}
+
+export async function evaluatePolicy(data: { source_agent_id: number; dest_agent_id?: number; dest_ip?: string; port?: number; protocol?: string; at?: string; source_region?: string }): Promise<PolicyEvaluation> {
+  const res = await apiFetch(`${API_BASE}/api/v1/policies/evaluate`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) throw new Error('Failed to evaluate policy');
+  return res.json();
+}
+
+export interface PostureSettings {
+  base_weight: number;
+  firewall_weight: number;
+  disk_encryption_weight: number;
+  antivirus_weight: number;
+  screen_lock_weight: number;
+  require_firewall: boolean;
+  require_disk_encryption: boolean;
+  require_antivirus: boolean;
+  require_screen_lock: boolean;
+  stale_after: string;
+  quarantine: boolean;
+  updated_at?: string;
+}
+
+export async function getPostureSettings(): Promise<PostureSettings> {
+  const res = await apiFetch(`${API_BASE}/api/v1/posture/settings`);
+  if (!res.ok) throw new Error('Failed to fetch posture settings');
+  return res.json();
+}
+
+export async function updatePostureSettings(data: Partial<PostureSettings>): Promise<PostureSettings> {
+  const res = await apiFetch(`${API_BASE}/api/v1/posture/settings`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update posture settings');
+  }
+  return res.json();
+}
+
+export interface PostureProfile {
+  id: number;
+  name: string;
+  description: string;
+  require_firewall: boolean;
+  require_disk_encryption: boolean;
+  require_antivirus: boolean;
+  require_screen_lock: boolean;
+  min_os_versions?: Record<string, string>; // by OS name: darwin, windows, linux
+  max_pending_patches?: number;
+  min_agent_version?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface PostureCompliance {
+  id: number;
+  agent_id: number;
+  profile_id: number;
+  profile?: PostureProfile;
+  compliant: boolean;
+  failures: string[];
+  checked_at: string;
+}
+
+export async function getAgentPosture(id: number): Promise<{ posture: DevicePosture | null; compliance: PostureCompliance[] }> {
+  const res = await apiFetch(`${API_BASE}/api/v1/agents/${id}/posture`);
+  if (!res.ok) throw new Error('Failed to fetch agent posture');
+  return res.json();
+}
+
+export async function getPostureProfiles(): Promise<PostureProfile[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/posture/profiles`);
+  if (!res.ok) throw new Error('Failed to fetch posture profiles');
+  return res.json();
+}
+
+export async function createPostureProfile(data: Partial<PostureProfile>): Promise<PostureProfile> {
+  const res = await apiFetch(`${API_BASE}/api/v1/posture/profiles`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to create posture profile');
+  }
+  return res.json();
+}
+
+// The body replaces the whole profile; omitted requirements are removed
+export async function updatePostureProfile(id: number, data: Partial<PostureProfile>): Promise<PostureProfile> {
+  const res = await apiFetch(`${API_BASE}/api/v1/posture/profiles/${id}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update posture profile');
+  }
+  return res.json();
+}
+
+export async function deletePostureProfile(id: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/posture/profiles/${id}`, { method: 'DELETE' });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to delete posture profile');
+  }
+}
+
+export async function setGroupPostureProfile(groupId: number, profileId: number | null): Promise<Group> {
+  const res = await apiFetch(`${API_BASE}/api/v1/groups/${groupId}/posture-profile`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ posture_profile_id: profileId }),
+  });
+  if (!res.ok) throw new Error('Failed to set group posture profile');
+  return res.json();
+}
+
+export async function setPolicyPostureProfile(policyId: number, profileId: number | null): Promise<Policy> {
+  const res = await apiFetch(`${API_BASE}/api/v1/policies/${policyId}/posture-profile`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ posture_profile_id: profileId }),
+  });
+  if (!res.ok) throw new Error('Failed to set policy posture profile');
+  return res.json();
+}
+
+export type Weekday = 'mon' | 'tue' | 'wed' | 'thu' | 'fri' | 'sat' | 'sun';
+
+export interface ScheduleWindow {
+  days: Weekday[];
+  start: string; // "09:00" in the schedule's time zone
+  end: string; // may be "24:00"; before start means past midnight
+}
+
+export interface Schedule {
+  id: number;
+  name: string;
+  description: string;
+  timezone: string; // IANA name, e.g. "Europe/Berlin"
+  windows: ScheduleWindow[];
+  created_at: string;
+  updated_at: string;
+}
+
+export async function getSchedules(): Promise<Schedule[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/schedules`);
+  if (!res.ok) throw new Error('Failed to fetch schedules');
+  return res.json();
+}
+
+export async function createSchedule(data: Partial<Schedule>): Promise<Schedule> {
+  const res = await apiFetch(`${API_BASE}/api/v1/schedules`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to create schedule');
+  }
+  return res.json();
+}
+
+// The body replaces the whole schedule
+export async function updateSchedule(id: number, data: Partial<Schedule>): Promise<Schedule> {
+  const res = await apiFetch(`${API_BASE}/api/v1/schedules/${id}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update schedule');
+  }
+  return res.json();
+}
+
+export async function deleteSchedule(id: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/schedules/${id}`, { method: 'DELETE' });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to delete schedule');
+  }
+}
+
+export async function setPolicySchedule(policyId: number, scheduleId: number | null): Promise<Policy> {
+  const res = await apiFetch(`${API_BASE}/api/v1/policies/${policyId}/schedule`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ schedule_id: scheduleId }),
+  });
+  if (!res.ok) throw new Error('Failed to set policy schedule');
+  return res.json();
+}
+
+export type DNSRecordType = 'A' | 'CNAME' | 'SRV';
+
+export interface DNSRecord {
+  id: number;
+  name: string; // fully qualified, e.g. "intranet.corp.internal"
+  type: DNSRecordType;
+  value: string; // IPv4 address for A, target name for CNAME and SRV
+  ttl: number;
+  priority: number; // SRV only
+  weight: number; // SRV only
+  port: number; // SRV only
+  description: string;
+  group_id?: number | null; // only visible to this group's agents
+  created_at: string;
+  updated_at: string;
+}
+
+export interface DNSForwarder {
+  id: number;
+  domain: string; // queries for it and its subdomains go to servers
+  servers: string[]; // ip or ip:port
+  description: string;
+  group_id?: number | null;
+  created_at: string;
+  updated_at: string;
+}
+
+export async function getDNSRecords(): Promise<DNSRecord[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/records`);
+  if (!res.ok) throw new Error('Failed to fetch DNS records');
+  return res.json();
+}
+
+export async function createDNSRecord(data: Partial<DNSRecord>): Promise<DNSRecord> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/records`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to create DNS record');
+  }
+  return res.json();
+}
+
+// The body replaces the whole record
+export async function updateDNSRecord(id: number, data: Partial<DNSRecord>): Promise<DNSRecord> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/records/${id}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update DNS record');
+  }
+  return res.json();
+}
+
+export async function deleteDNSRecord(id: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/records/${id}`, { method: 'DELETE' });
+  if (!res.ok) throw new Error('Failed to delete DNS record');
+}
+
+export async function getDNSForwarders(): Promise<DNSForwarder[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/forwarders`);
+  if (!res.ok) throw new Error('Failed to fetch DNS forwarders');
+  return res.json();
+}
+
+export async function createDNSForwarder(data: Partial<DNSForwarder>): Promise<DNSForwarder> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/forwarders`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to create DNS forwarder');
+  }
+  return res.json();
+}
+
+// The body replaces the whole forwarder
+export async function updateDNSForwarder(id: number, data: Partial<DNSForwarder>): Promise<DNSForwarder> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/forwarders/${id}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update DNS forwarder');
+  }
+  return res.json();
+}
+
+export async function deleteDNSForwarder(id: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/dns/forwarders/${id}`, { method: 'DELETE' });
+  if (!res.ok) throw new Error('Failed to delete DNS forwarder');
+}
+
+export async function setGroupDNS(groupId: number, searchDomains: string[]): Promise<Group> {
+  const res = await apiFetch(`${API_BASE}/api/v1/groups/${groupId}/dns`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ search_domains: searchDomains }),
+  });
+  if (!res.ok) {
+    const body = await res.json().catch(() => ({}));
+    throw new Error(body.error || 'Failed to update group DNS settings');
+  }
+  return res.json();
+}
+
+export type PolicyMode = 'deny-overrides' | 'first-match';
+
+export interface PolicyConflict {
+  kind: 'shadowed' | 'redundant' | 'contradiction';
+  policy_id: number;
+  policy_name: string;
+  action: string;
+  other_policy_id: number;
+  other_policy_name: string;
+  other_action: string;
+  source_group_id: number;
+  dest_group_id: number;
+  ports: string;
+  message: string;
+}
+
+export async function getPolicyConflicts(): Promise<{ mode: PolicyMode; conflicts: PolicyConflict[] }> {
+  const res = await apiFetch(`${API_BASE}/api/v1/policies/conflicts`);
+  if (!res.ok) throw new Error('Failed to fetch policy conflicts');
+  return res.json();
+}
+
 // Auth & Claiming
+export type Role = 'owner' | 'admin' | 'network-admin' | 'auditor' | 'member';
+
 export interface User {
   id: number;
   email: string;
-  role: string;
+  role: Role;
+  provider?: string;
+  last_login_at?: string;
 }
 
 export interface ClaimDetails {
@@ -385,29 +890,98 @@ export interface ClaimDetails {
   ip: string;
   status: string;
   created_at: string;
+  expires_at: string;
 }
 
-export async function login(email: string): Promise<{ token: string, user: User }> {
+export async function login(email: string, password: string): Promise<{ token: string, expires_at: string, user: User }> {
   const res = await fetch(`${API_BASE}/api/v1/auth/login`, {
     method: "POST",
     headers: { "Content-Type": "application/json" },
-    body: JSON.stringify({ email }),
+    body: JSON.stringify({ email, password }),
   });
   if (!res.ok) throw new Error("Login failed");
   return res.json();
 }
 
+export interface AuthProvider {
+  name: string;
+  display_name: string;
+  login_url: string;
+}
+
+export async function getAuthProviders(): Promise<AuthProvider[]> {
+  const res = await fetch(`${API_BASE}/api/v1/auth/providers`);
+  if (!res.ok) throw new Error("Failed to fetch sign-in providers");
+  const data = await res.json();
+  return data.providers;
+}
+
+// ssoLoginUrl is opened as a full page navigation, not fetched
+export function ssoLoginUrl(provider: AuthProvider, returnTo: string): string {
+  return `${API_BASE}${provider.login_url}?return_to=${encodeURIComponent(returnTo)}`;
+}
+
 export async function getClaimDetails(token: string): Promise<ClaimDetails> {
-  const res = await fetch(`${API_BASE}/api/v1/claim-details?token=${token}`);
+  const res = await apiFetch(`${API_BASE}/api/v1/claim-details?token=${token}`);
   if (!res.ok) throw new Error("Failed to fetch claim details");
   return res.json();
 }
 
-export async function approveClaim(token: string, email: string): Promise<void> {
-  const res = await fetch(`${API_BASE}/api/v1/approve-claim`, {
+export async function approveClaim(token: string, userCode: string): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/approve-claim`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token, user_code: userCode }),
+  });
+  if (!res.ok) {
+    const data = await res.json().catch(() => ({}));
+    throw new Error(data.error || "Failed to approve claim");
+  }
+}
+
+export async function rejectClaim(token: string): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/reject-claim`, {
     method: "POST",
     headers: { "Content-Type": "application/json" },
-    body: JSON.stringify({ token, email }),
+    body: JSON.stringify({ token }),
   });
-  if (!res.ok) throw new Error("Failed to approve claim");
+  if (!res.ok) throw new Error("Failed to reject claim");
+}
+
+export async function getMe(): Promise<{ user: User; permissions: string[] }> {
+  const res = await apiFetch(`${API_BASE}/api/v1/auth/me`);
+  if (!res.ok) throw new Error("Failed to fetch current user");
+  return res.json();
+}
+
+// User management
+export async function getUsers(): Promise<User[]> {
+  const res = await apiFetch(`${API_BASE}/api/v1/users`);
+  if (!res.ok) throw new Error('Failed to fetch users');
+  return res.json();
+}
+
+export async function createUser(data: { email: string; password: string; role?: Role }): Promise<User> {
+  const res = await apiFetch(`${API_BASE}/api/v1/users`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) throw new Error('Failed to create user');
+  return res.json();
+}
+
+export async function updateUser(id: number, data: { role?: Role; password?: string }): Promise<User> {
+  const res = await apiFetch(`${API_BASE}/api/v1/users/${id}`, {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(data),
+  });
+  if (!res.ok) throw new Error('Failed to update user');
+  return res.json();
+}
+
+export async function deleteUser(id: number): Promise<void> {
+  const res = await apiFetch(`${API_BASE}/api/v1/users/${id}`, { method: 'DELETE' });
+  if (!res.ok) throw new Error('Failed to delete user');
 }